@@ -1,25 +1,62 @@
 package web
 
 import (
+	"net"
 	"net/http"
+	"time"
 
 	"golang.org/x/crypto/acme/autocert"
 )
 
+// DefaultHTTPAddr is the address plain HTTP is served on when no other is set.
+const DefaultHTTPAddr = ":80"
+
+// DefaultHTTPSAddr is the address HTTPS is served on when no other is set.
+const DefaultHTTPSAddr = ":443"
+
 type ACMEConfig struct {
 	Hosts      []string
 	AdminEmail string
 	CertDir    string
+	// HTTPAddr is the address of the plain HTTP listener, which answers ACME HTTP-01 challenges
+	// and redirects everything else to HTTPS. If it is empty, DefaultHTTPAddr is used.
+	HTTPAddr string
+	// HTTPSAddr is the address of the HTTPS listener. If it is empty, DefaultHTTPSAddr is used.
+	HTTPSAddr string
+	// HSTSMaxAge is the max-age of the Strict-Transport-Security header sent over HTTPS.
+	// If it is zero, the header is not sent.
+	HSTSMaxAge time.Duration
 }
 
+// HTTPSServer returns the HTTPS server, which gets its certificates from a copy of certManager.
+// The copy cannot use the HTTP-01 challenges answered by HTTPServer; use NewHTTPSServer for that.
 func (c *ACMEConfig) HTTPSServer(mux http.Handler, certManager autocert.Manager) http.Server {
 	return http.Server{
 		Handler:   mux,
-		Addr:      ":443",
+		Addr:      c.httpsAddr(),
+		TLSConfig: certManager.TLSConfig(),
+	}
+}
+
+// NewHTTPSServer returns the HTTPS server, which gets its certificates from certManager.
+// Pass it the manager given to HTTPServer, so that the manager knows it may use HTTP-01 challenges.
+func (c *ACMEConfig) NewHTTPSServer(mux http.Handler, certManager *autocert.Manager) *http.Server {
+	return &http.Server{
+		Handler:   mux,
+		Addr:      c.httpsAddr(),
 		TLSConfig: certManager.TLSConfig(),
 	}
 }
 
+// HTTPServer returns the plain HTTP server, which answers the ACME HTTP-01 challenges of certManager
+// and redirects other requests to HTTPS.
+func (c *ACMEConfig) HTTPServer(certManager *autocert.Manager) *http.Server {
+	return &http.Server{
+		Handler: certManager.HTTPHandler(&HTTPSRedirect{Port: addrPort(c.httpsAddr())}),
+		Addr:    c.httpAddr(),
+	}
+}
+
 func (c *ACMEConfig) CertManager() autocert.Manager {
 	return autocert.Manager{
 		Cache:      autocert.DirCache(c.CertDir),
@@ -28,3 +65,26 @@ func (c *ACMEConfig) CertManager() autocert.Manager {
 		HostPolicy: autocert.HostWhitelist(c.Hosts...),
 	}
 }
+
+func (c *ACMEConfig) httpAddr() string {
+	if c.HTTPAddr == "" {
+		return DefaultHTTPAddr
+	}
+	return c.HTTPAddr
+}
+
+func (c *ACMEConfig) httpsAddr() string {
+	if c.HTTPSAddr == "" {
+		return DefaultHTTPSAddr
+	}
+	return c.HTTPSAddr
+}
+
+// addrPort returns the port of a listen address such as ":443".
+func addrPort(addr string) string {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return ""
+	}
+	return port
+}
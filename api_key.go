@@ -0,0 +1,58 @@
+package web
+
+import (
+	"net/http"
+	"path"
+	"time"
+)
+
+// APIKey lets a program act as a user without the user's password.
+// The key itself is only shown once, when it is created; only its hash is stored.
+type APIKey struct {
+	// ID identifies the key.
+	ID string `json:"id"`
+	// Name describes what the key is used for.
+	Name string `json:"name"`
+	// ReadOnly limits the key to GET, HEAD and OPTIONS requests.
+	ReadOnly bool `json:"read_only"`
+	// PathPrefixes limits the key to requests for paths under one of the prefixes.
+	// Paths are matched by whole elements after cleaning, so "/api" covers "/api/users" but not "/apiadmin".
+	// If it is empty, any path is allowed.
+	PathPrefixes []string `json:"path_prefixes"`
+	// CreatedAt is the Unix timestamp of when the key was created.
+	CreatedAt int64 `json:"created_at"`
+	// ExpiresAt is the Unix timestamp after which the key stops working.
+	// Zero means the key does not expire.
+	ExpiresAt int64 `json:"expires_at"`
+	// LastUsedAt is the Unix timestamp of when the key was last accepted.
+	LastUsedAt int64 `json:"last_used_at"`
+	// Hash is the SHA256 hash of the key's secret.
+	Hash string `json:"hash,omitempty"`
+}
+
+// Expired returns true if the key has expired at the given time.
+func (k *APIKey) Expired(now time.Time) bool {
+	return k.ExpiresAt != 0 && now.Unix() >= k.ExpiresAt
+}
+
+// Allows returns true if the key's scopes cover the given request.
+func (k *APIKey) Allows(r *http.Request) bool {
+	if k.ReadOnly {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			return false
+		}
+	}
+	if len(k.PathPrefixes) == 0 {
+		return true
+	}
+	// Dot elements are resolved first, so that /api/../admin does not pass for /api.
+	p := path.Clean("/" + r.URL.Path)
+	for _, prefix := range k.PathPrefixes {
+		if hasPathPrefix(p, path.Clean("/"+prefix)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,42 @@
+package web
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAPIKeyAllowsPaths(t *testing.T) {
+	k := &APIKey{PathPrefixes: []string{"/api", "/files/"}}
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"/api", true},
+		{"/api/users", true},
+		{"/api/./users", true},
+		{"/apiadmin", false},
+		{"/api/../admin", false},
+		{"/api/..", false},
+		{"/files", true},
+		{"/files/a/b", true},
+		{"/filesystem", false},
+		{"/admin", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.URL.Path = tt.path
+		if got := k.Allows(r); got != tt.want {
+			t.Errorf("Allows(%s) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestAPIKeyReadOnly(t *testing.T) {
+	k := &APIKey{ReadOnly: true}
+	if !k.Allows(httptest.NewRequest("GET", "/x", nil)) {
+		t.Error("read-only key refused a GET")
+	}
+	if k.Allows(httptest.NewRequest("POST", "/x", nil)) {
+		t.Error("read-only key allowed a POST")
+	}
+}
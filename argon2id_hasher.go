@@ -0,0 +1,112 @@
+package web
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Argon2idHasher hashes passwords with Argon2id and encodes them as PHC strings,
+// such as "$argon2id$v=19$m=19456,t=2,p=1$<salt>$<hash>".
+// Zero fields use the parameters recommended by OWASP.
+type Argon2idHasher struct {
+	// Memory is the memory used by each hash in KiB.
+	Memory uint32
+	// Time is the number of passes over the memory.
+	Time uint32
+	// Threads is the degree of parallelism.
+	Threads uint8
+}
+
+const (
+	argon2idMemory  = 19 * 1024
+	argon2idTime    = 2
+	argon2idThreads = 1
+	argon2idSaltLen = 16
+	argon2idKeyLen  = 32
+)
+
+// argon2idParams are the parameters recorded in an Argon2id PHC string.
+type argon2idParams struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+	salt    []byte
+	key     []byte
+}
+
+func (h *Argon2idHasher) Hash(password string) (string, error) {
+	p := h.params()
+	p.salt = make([]byte, argon2idSaltLen)
+	_, err := rand.Read(p.salt)
+	if err != nil {
+		return "", err
+	}
+	p.key = argon2.IDKey([]byte(password), p.salt, p.time, p.memory, p.threads, argon2idKeyLen)
+	return p.String(), nil
+}
+
+func (h *Argon2idHasher) Verify(hash, password string) bool {
+	p, ok := parseArgon2idHash(hash)
+	if !ok {
+		return false
+	}
+	key := argon2.IDKey([]byte(password), p.salt, p.time, p.memory, p.threads, uint32(len(p.key)))
+	return subtle.ConstantTimeCompare(key, p.key) == 1
+}
+
+func (h *Argon2idHasher) NeedsRehash(hash string) bool {
+	p, ok := parseArgon2idHash(hash)
+	if !ok {
+		return true
+	}
+	want := h.params()
+	return p.memory != want.memory || p.time != want.time || p.threads != want.threads || len(p.key) != argon2idKeyLen
+}
+
+// params returns the parameters of h with zero fields set to their defaults.
+func (h *Argon2idHasher) params() *argon2idParams {
+	p := &argon2idParams{memory: h.Memory, time: h.Time, threads: h.Threads}
+	if p.memory == 0 {
+		p.memory = argon2idMemory
+	}
+	if p.time == 0 {
+		p.time = argon2idTime
+	}
+	if p.threads == 0 {
+		p.threads = argon2idThreads
+	}
+	return p
+}
+
+func (p *argon2idParams) String() string {
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, p.memory, p.time, p.threads,
+		base64.RawStdEncoding.EncodeToString(p.salt), base64.RawStdEncoding.EncodeToString(p.key))
+}
+
+// parseArgon2idHash parses an Argon2id PHC string.
+// It returns false if hash is not one or was made with another version of Argon2.
+func parseArgon2idHash(hash string) (*argon2idParams, bool) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != "argon2id" || parts[2] != fmt.Sprintf("v=%d", argon2.Version) {
+		return nil, false
+	}
+	p := &argon2idParams{}
+	_, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memory, &p.time, &p.threads)
+	if err != nil || p.time == 0 || p.threads == 0 {
+		return nil, false
+	}
+	p.salt, err = base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return nil, false
+	}
+	p.key, err = base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(p.key) == 0 {
+		return nil, false
+	}
+	return p, true
+}
@@ -0,0 +1,134 @@
+package web
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
+// Types of AuditEvent.
+const (
+	AuditRegister           = "register"
+	AuditLogin              = "login"
+	AuditSecondFactor       = "second_factor"
+	AuditLogout             = "logout"
+	AuditRevokeSession      = "revoke_session"
+	AuditRevokeSessions     = "revoke_sessions"
+	AuditPasskeyLogin       = "passkey_login"
+	AuditPasskeyAdded       = "passkey_added"
+	AuditPasskeyRemoved     = "passkey_removed"
+	AuditExternalLogin      = "external_login"
+	AuditIdentityLinked     = "identity_linked"
+	AuditIdentityUnlinked   = "identity_unlinked"
+	AuditTOTPEnabled        = "totp_enabled"
+	AuditTOTPDisabled       = "totp_disabled"
+	AuditAPIKeyCreated      = "api_key_created"
+	AuditAPIKeyRevoked      = "api_key_revoked"
+	AuditEmailAdded         = "email_added"
+	AuditEmailRemoved       = "email_removed"
+	AuditEmailVerified      = "email_verified"
+	AuditPasswordForgotten  = "password_forgotten"
+	AuditPasswordReset      = "password_reset"
+	AuditPasswordSet        = "password_set"
+	AuditInviteCreated      = "invite_created"
+	AuditInviteRevoked      = "invite_revoked"
+	AuditUnlock             = "unlock"
+	AuditUserDisabled       = "user_disabled"
+	AuditUserEnabled        = "user_enabled"
+	AuditUserDeleted        = "user_deleted"
+	AuditRolesSet           = "roles_set"
+	AuditOAuthClientCreated = "oauth_client_created"
+	AuditOAuthClientDeleted = "oauth_client_deleted"
+	AuditSigningKeyRotated  = "signing_key_rotated"
+	AuditOrgCreated         = "org_created"
+	AuditOrgMemberInvited   = "org_member_invited"
+	AuditOrgInviteAccepted  = "org_invite_accepted"
+	AuditOrgInviteDeclined  = "org_invite_declined"
+	AuditOrgInviteRevoked   = "org_invite_revoked"
+	AuditOrgRoleSet         = "org_role_set"
+	AuditOrgMemberRemoved   = "org_member_removed"
+	AuditOrgOwnerChanged    = "org_owner_changed"
+)
+
+// Outcomes of AuditEvent.
+const (
+	AuditSuccess = "success"
+	AuditFailure = "failure"
+	// AuditChallenged means the password was right and a second factor was asked for.
+	AuditChallenged = "challenged"
+)
+
+// AuditEvent is an entry in the audit log of an AuthDB.
+// Each event holds the hash of the one before it, so removing or changing an event breaks the chain
+// from that point on; see AuthDB.VerifyAuditEvents.
+// The hashes are keyed with the AuditKey of the AuthDB, so the chain cannot be rewritten without it.
+type AuditEvent struct {
+	// Seq numbers the events in the log from 1.
+	Seq int64 `json:"seq"`
+	// Time is the Unix timestamp of the event.
+	Time int64 `json:"time"`
+	// Type says what happened, such as AuditLogin.
+	Type string `json:"type"`
+	// ActorID is the user who acted, if known.
+	// For admin actions it is the admin and UserID is the user acted on.
+	ActorID string `json:"actor_id,omitempty"`
+	// UserID is the user the event is about, if known.
+	UserID string `json:"user_id,omitempty"`
+	// IP is the address of the client.
+	IP string `json:"ip,omitempty"`
+	// UserAgent is the User-Agent header of the client.
+	UserAgent string `json:"user_agent,omitempty"`
+	// Outcome is AuditSuccess, AuditFailure or AuditChallenged.
+	Outcome string `json:"outcome"`
+	// Detail adds context, such as the reason for a failure.
+	Detail string `json:"detail,omitempty"`
+	// PrevHash is the Hash of the previous event, or "" for the first event.
+	PrevHash string `json:"prev_hash"`
+	// Hash is the hex encoded HMAC-SHA256 of the event without Hash.
+	Hash string `json:"hash"`
+}
+
+// AuditHead is the last event of the audit log, as recorded by the AuthDB.
+type AuditHead struct {
+	// Seq is the Seq of the event.
+	Seq int64 `json:"seq"`
+	// Hash is the Hash of the event.
+	Hash string `json:"hash"`
+}
+
+// hash returns the HMAC of the event under key, computed over all fields but Hash.
+func (e *AuditEvent) hash(key string) string {
+	c := *e
+	c.Hash = ""
+	b, err := json.Marshal(&c)
+	if err != nil {
+		panic(err)
+	}
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write(b)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifyAuditEvents returns nil if events are an unbroken chain hashed with key, oldest first.
+// The chain may start after the beginning of the log, as long as it has no gaps.
+// Otherwise the error names the first event that does not fit.
+func verifyAuditEvents(key string, events []*AuditEvent) error {
+	for i, e := range events {
+		if !hmac.Equal([]byte(e.Hash), []byte(e.hash(key))) {
+			return fmt.Errorf("audit event %d has been altered", e.Seq)
+		}
+		if i == 0 {
+			if e.Seq == 1 && e.PrevHash != "" {
+				return fmt.Errorf("audit event %d does not follow the start of the log", e.Seq)
+			}
+			continue
+		}
+		prev := events[i-1]
+		if e.Seq != prev.Seq+1 || e.PrevHash != prev.Hash {
+			return fmt.Errorf("audit event %d does not follow event %d", e.Seq, prev.Seq)
+		}
+	}
+	return nil
+}
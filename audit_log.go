@@ -0,0 +1,40 @@
+package web
+
+import "sync"
+
+// AuditLog stores the audit events of an AuthDB.
+// Events are only ever appended; an AuthDB never changes or removes them.
+type AuditLog interface {
+	// Append durably adds an event to the end of the log.
+	Append(e *AuditEvent) error
+	// Events returns every event in the log, oldest first.
+	Events() ([]*AuditEvent, error)
+}
+
+// MemoryAuditLog is an AuditLog that keeps events in memory.
+// It is used by an AuthDB that has no AuditLog set.
+type MemoryAuditLog struct {
+	lock   sync.Mutex
+	events []*AuditEvent
+}
+
+// Append adds an event to the end of the log.
+func (l *MemoryAuditLog) Append(e *AuditEvent) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	c := *e
+	l.events = append(l.events, &c)
+	return nil
+}
+
+// Events returns copies of every event in the log, oldest first.
+func (l *MemoryAuditLog) Events() ([]*AuditEvent, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	events := make([]*AuditEvent, len(l.events))
+	for i, e := range l.events {
+		c := *e
+		events[i] = &c
+	}
+	return events, nil
+}
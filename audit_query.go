@@ -0,0 +1,47 @@
+package web
+
+// AuditQuery selects events from the audit log.
+// Zero fields match every event.
+type AuditQuery struct {
+	// UserID matches events acted by or about the given user.
+	UserID string `json:"user_id"`
+	// Type matches events of the given type.
+	Type string `json:"type"`
+	// Since matches events at or after the given Unix timestamp.
+	Since int64 `json:"since"`
+	// Until matches events before the given Unix timestamp.
+	Until int64 `json:"until"`
+	// Limit keeps only the given number of most recent matches.
+	Limit int `json:"limit"`
+}
+
+// matches returns true if e is selected by the query, not counting Limit.
+func (q *AuditQuery) matches(e *AuditEvent) bool {
+	if q.UserID != "" && e.UserID != q.UserID && e.ActorID != q.UserID {
+		return false
+	}
+	if q.Type != "" && e.Type != q.Type {
+		return false
+	}
+	if q.Since != 0 && e.Time < q.Since {
+		return false
+	}
+	if q.Until != 0 && e.Time >= q.Until {
+		return false
+	}
+	return true
+}
+
+// filter returns the events selected by the query, oldest first.
+func (q *AuditQuery) filter(events []*AuditEvent) []*AuditEvent {
+	matches := []*AuditEvent{}
+	for _, e := range events {
+		if q.matches(e) {
+			matches = append(matches, e)
+		}
+	}
+	if q.Limit > 0 && len(matches) > q.Limit {
+		matches = matches[len(matches)-q.Limit:]
+	}
+	return matches
+}
@@ -0,0 +1,220 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// HasRole returns true if the given user exists, is not disabled and has the given role.
+// The user named by AdminID is always an admin.
+func (s *AuthDB) HasRole(userID, role string) bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.hasRole(userID, role)
+}
+
+// hasRole is like HasRole.
+// The caller must hold s.lock.
+func (s *AuthDB) hasRole(userID, role string) bool {
+	user, ok := s.Users[userID]
+	if !ok || user.Disabled {
+		return false
+	}
+	return userID == s.AdminID || user.HasRole(role)
+}
+
+// authorize returns nil if session is valid and its user has the given role.
+// If it returns an error, it will be of type ErrInvalidSession or ErrForbidden.
+func (s *AuthDB) authorize(session *Session, role string) error {
+	if !s.checkSession(session.UserID, session.Token) {
+		return ErrInvalidSession
+	}
+	if !s.HasRole(session.UserID, role) {
+		return ErrForbidden
+	}
+	return nil
+}
+
+// FindUsers returns the users whose ID or email address contains query, keyed by user ID.
+// An empty query matches every user.
+// The users are copies without passwords, sessions or other secrets.
+func (s *AuthDB) FindUsers(query string) map[string]*User {
+	query = strings.ToLower(query)
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	users := map[string]*User{}
+	for id, user := range s.Users {
+		if userMatches(id, user, query) {
+			users[id] = user.redacted()
+		}
+	}
+	return users
+}
+
+func userMatches(id string, user *User, query string) bool {
+	if strings.Contains(strings.ToLower(id), query) {
+		return true
+	}
+	for _, email := range user.Emails {
+		if strings.Contains(strings.ToLower(email), query) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetDisabled disables or re-enables the given user.
+// Disabling a user also ends all of their sessions.
+// If it returns an error, it will be of type ErrUserNotFound.
+func (s *AuthDB) SetDisabled(userID string, disabled bool) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	user, ok := s.Users[userID]
+	if !ok {
+		return ErrUserNotFound
+	}
+	user = user.clone()
+	user.Disabled = disabled
+	if disabled {
+		err := s.revokeUserSessionTokens(userID)
+		if err != nil {
+			return err
+		}
+		user.Sessions = map[string]*SessionInfo{}
+	}
+	return s.commit("users", userID, user)
+}
+
+// DeleteUser deletes the given user and removes them from every organization.
+// If it returns an error, it will be of type ErrUserNotFound.
+func (s *AuthDB) DeleteUser(userID string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.Users[userID]; !ok {
+		return ErrUserNotFound
+	}
+	err := s.revokeUserSessionTokens(userID)
+	if err != nil {
+		return err
+	}
+	err = s.forgetOrgUser(userID)
+	if err != nil {
+		return err
+	}
+	return s.commit("users", userID, nil)
+}
+
+// SetPassword sets the password of the given user and ends all of their sessions.
+// If it returns an error, it may be of type ErrUserNotFound, ErrPasswordTooShort, ErrPasswordTooLong or ErrPasswordTooWeak.
+func (s *AuthDB) SetPassword(userID, password string) error {
+	hash, err := s.hashPassword(password)
+	if err != nil {
+		return err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	user, ok := s.Users[userID]
+	if !ok {
+		return ErrUserNotFound
+	}
+	err = s.revokeUserSessionTokens(userID)
+	if err != nil {
+		return err
+	}
+	user = user.clone()
+	user.PasswordHash = hash
+	user.Sessions = map[string]*SessionInfo{}
+	return s.commit("users", userID, user)
+}
+
+// SetRoles replaces the roles of the given user.
+// If it returns an error, it will be of type ErrUserNotFound.
+func (s *AuthDB) SetRoles(userID string, roles []string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	user, ok := s.Users[userID]
+	if !ok {
+		return ErrUserNotFound
+	}
+	user = user.clone()
+	user.Roles = roles
+	return s.commit("users", userID, user)
+}
+
+// adminRequest is the body of the admin endpoints.
+// The session must belong to an admin; the other fields name the user acted on and the change.
+type adminRequest struct {
+	Session
+	TargetUserID string   `json:"target_user_id"`
+	Query        string   `json:"query"`
+	Disabled     bool     `json:"disabled"`
+	Password     string   `json:"password"`
+	Roles        []string `json:"roles"`
+}
+
+// admin handles the admin endpoints.
+// Every request must come from a session of a user with RoleAdmin.
+// Admins cannot disable or delete themselves.
+func (s *AuthDB) admin(w http.ResponseWriter, r *http.Request) {
+	var req adminRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	err = s.authorize(&req.Session, RoleAdmin)
+	if err == ErrInvalidSession {
+		writeError(w, http.StatusUnauthorized, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusForbidden, err)
+		return
+	}
+	switch r.URL.Path {
+	case "/users":
+		json.NewEncoder(w).Encode(s.FindUsers(req.Query))
+		return
+	case "/disable-user":
+		if req.TargetUserID == req.UserID {
+			writeError(w, http.StatusForbidden, ErrForbidden)
+			return
+		}
+		err = s.SetDisabled(req.TargetUserID, req.Disabled)
+		typ := AuditUserEnabled
+		if req.Disabled {
+			typ = AuditUserDisabled
+		}
+		s.audit(r, typ, req.UserID, req.TargetUserID, err)
+	case "/delete-user":
+		if req.TargetUserID == req.UserID {
+			writeError(w, http.StatusForbidden, ErrForbidden)
+			return
+		}
+		err = s.DeleteUser(req.TargetUserID)
+		s.audit(r, AuditUserDeleted, req.UserID, req.TargetUserID, err)
+	case "/logout-user":
+		err = s.RevokeSessions(req.TargetUserID)
+		s.audit(r, AuditRevokeSessions, req.UserID, req.TargetUserID, err)
+	case "/set-password":
+		err = s.SetPassword(req.TargetUserID, req.Password)
+		s.audit(r, AuditPasswordSet, req.UserID, req.TargetUserID, err)
+	case "/set-roles":
+		err = s.SetRoles(req.TargetUserID, req.Roles)
+		s.audit(r, AuditRolesSet, req.UserID, req.TargetUserID, err)
+	}
+	if err == ErrUserNotFound {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	if isPasswordPolicyError(err) {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err != nil {
+		ServeInternalServerError(w, r)
+		return
+	}
+}
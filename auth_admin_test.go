@@ -0,0 +1,144 @@
+package web
+
+import (
+	"context"
+	"testing"
+)
+
+// loginAs logs the given user in through c.
+func loginAs(t *testing.T, c *AuthClient, userID, password string) *Session {
+	login, err := c.Login(context.Background(), userID, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &login.Session
+}
+
+func TestAdminEndpointsNeedAdmin(t *testing.T) {
+	ctx := context.Background()
+	db, c := newAuthClient(t)
+	bob := registerUser(t, db)
+	_, err := c.FindUsers(ctx, bob, "")
+	if err != ErrForbidden {
+		t.Fatalf("member listed users: %v", err)
+	}
+	err = c.SetRoles(ctx, bob, bob.UserID, []string{RoleAdmin})
+	if err != ErrForbidden {
+		t.Fatalf("member made themselves admin: %v", err)
+	}
+	_, err = c.FindUsers(ctx, &Session{UserID: "admin", Token: "forged"}, "")
+	if err != ErrInvalidSession {
+		t.Fatalf("forged session: %v", err)
+	}
+	_, err = c.InviteAs(ctx, bob)
+	if err != ErrForbidden {
+		t.Fatalf("member minted an invite: %v", err)
+	}
+	admin := loginAs(t, c, "admin", "correct horse")
+	err = c.SetRoles(ctx, admin, bob.UserID, []string{RoleRegistrar})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.InviteAs(ctx, bob)
+	if err != nil {
+		t.Fatalf("registrar could not mint an invite: %v", err)
+	}
+	_, err = c.FindUsers(ctx, bob, "")
+	if err != ErrForbidden {
+		t.Fatalf("registrar listed users: %v", err)
+	}
+	err = c.SetRoles(ctx, admin, "nobody", []string{RoleRegistrar})
+	if err != ErrUserNotFound {
+		t.Fatalf("unknown user: %v", err)
+	}
+}
+
+func TestFindUsers(t *testing.T) {
+	ctx := context.Background()
+	db, c := newAuthClient(t)
+	bob := registerUser(t, db)
+	// Addresses stored before they were lowercased keep their case.
+	db.lock.Lock()
+	user := db.Users[bob.UserID].clone()
+	user.Emails = []string{"Bob@Example.com"}
+	db.commit("users", bob.UserID, user)
+	db.lock.Unlock()
+	admin := loginAs(t, c, "admin", "correct horse")
+	for _, query := range []string{"bob@example", "BOB@EXAMPLE.COM", bob.UserID} {
+		users, err := c.FindUsers(ctx, admin, query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		found, ok := users[bob.UserID]
+		if len(users) != 1 || !ok {
+			t.Fatalf("%q found %d users", query, len(users))
+		}
+		if found.PasswordHash != "" || len(found.Sessions) != 0 {
+			t.Fatal("FindUsers returned secrets")
+		}
+	}
+	users, err := c.FindUsers(ctx, admin, "")
+	if err != nil || len(users) != 2 {
+		t.Fatalf("%d users: %v", len(users), err)
+	}
+}
+
+func TestAdminManagesUsers(t *testing.T) {
+	ctx := context.Background()
+	db, c := newAuthClient(t)
+	bob := registerUser(t, db)
+	admin := loginAs(t, c, "admin", "correct horse")
+	for _, err := range []error{
+		c.SetDisabled(ctx, admin, "admin", true),
+		c.DeleteUser(ctx, admin, "admin"),
+	} {
+		if err != ErrForbidden {
+			t.Fatalf("admin acted on themselves: %v", err)
+		}
+	}
+
+	err := c.SetDisabled(ctx, admin, bob.UserID, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.ValidateSession(ctx, bob) != ErrInvalidSession {
+		t.Fatal("session of a disabled user is valid")
+	}
+	_, err = c.Login(ctx, bob.UserID, "battery staple")
+	if err != ErrUserDisabled {
+		t.Fatalf("disabled user logged in: %v", err)
+	}
+	err = c.SetDisabled(ctx, admin, bob.UserID, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.SetPassword(ctx, admin, bob.UserID, "short")
+	if err != ErrPasswordTooShort {
+		t.Fatalf("short password: %v", err)
+	}
+	err = c.SetPassword(ctx, admin, bob.UserID, "horse battery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob = loginAs(t, c, bob.UserID, "horse battery")
+	err = c.LogoutUser(ctx, admin, bob.UserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.ValidateSession(ctx, bob) != ErrInvalidSession {
+		t.Fatal("session survived LogoutUser")
+	}
+
+	err = c.DeleteUser(ctx, admin, bob.UserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := db.User(bob.UserID); ok {
+		t.Fatal("deleted user still exists")
+	}
+	err = c.DeleteUser(ctx, admin, bob.UserID)
+	if err != ErrUserNotFound {
+		t.Fatalf("deleted twice: %v", err)
+	}
+}
@@ -0,0 +1,230 @@
+package web
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// apiKeyTouchInterval is how much time must pass before an API key's last-used time is saved again.
+const apiKeyTouchInterval = time.Minute
+
+// CreateAPIKey adds an API key to the given user and returns the key.
+// Name, ReadOnly, PathPrefixes and ExpiresAt are taken from key; the rest is filled in.
+// If it returns an error, it may be of type ErrUserNotFound.
+func (s *AuthDB) CreateAPIKey(userID string, key *APIKey) (string, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	user, ok := s.Users[userID]
+	if !ok {
+		return "", ErrUserNotFound
+	}
+	secret := randomToken(32)
+	k := &APIKey{
+		ID:           s.newID("api_key"),
+		Name:         key.Name,
+		ReadOnly:     key.ReadOnly,
+		PathPrefixes: key.PathPrefixes,
+		CreatedAt:    s.now().Unix(),
+		ExpiresAt:    key.ExpiresAt,
+		Hash:         apiKeyHash(secret),
+	}
+	user = user.clone()
+	if user.APIKeys == nil {
+		user.APIKeys = map[string]*APIKey{}
+	}
+	user.APIKeys[k.ID] = k
+	err := s.commit("users", userID, user)
+	if err != nil {
+		return "", err
+	}
+	return userID + "." + k.ID + "." + secret, nil
+}
+
+// APIKeys returns the API keys of the given user, oldest first, without their hashes.
+// If it returns an error, it will be of type ErrUserNotFound.
+func (s *AuthDB) APIKeys(userID string) ([]*APIKey, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	user, ok := s.Users[userID]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	keys := []*APIKey{}
+	for _, k := range user.APIKeys {
+		c := *k
+		c.Hash = ""
+		keys = append(keys, &c)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt < keys[j].CreatedAt
+	})
+	return keys, nil
+}
+
+// RevokeAPIKey deletes the API key with the given ID.
+// If it returns an error, it may be of type ErrUserNotFound or ErrAPIKeyNotFound.
+func (s *AuthDB) RevokeAPIKey(userID, keyID string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	user, ok := s.Users[userID]
+	if !ok {
+		return ErrUserNotFound
+	}
+	_, ok = user.APIKeys[keyID]
+	if !ok {
+		return ErrAPIKeyNotFound
+	}
+	user = user.clone()
+	delete(user.APIKeys, keyID)
+	return s.commit("users", userID, user)
+}
+
+// checkAPIKey returns the ID of the user owning the API key in the X-API-Key header of r.
+// It returns "" if the key is unknown, expired or not scoped for r.
+func (s *AuthDB) checkAPIKey(r *http.Request) string {
+	parts := strings.Split(r.Header.Get("X-API-Key"), ".")
+	if len(parts) != 3 {
+		return ""
+	}
+	userID, keyID, secret := parts[0], parts[1], parts[2]
+	s.lock.RLock()
+	user, ok := s.Users[userID]
+	if !ok || user.Disabled {
+		s.lock.RUnlock()
+		return ""
+	}
+	k, ok := user.APIKeys[keyID]
+	if !ok {
+		s.lock.RUnlock()
+		return ""
+	}
+	if subtle.ConstantTimeCompare([]byte(k.Hash), []byte(apiKeyHash(secret))) != 1 {
+		s.lock.RUnlock()
+		return ""
+	}
+	now := s.now()
+	if k.Expired(now) || !k.Allows(r) {
+		s.lock.RUnlock()
+		return ""
+	}
+	touch := now.Unix()-k.LastUsedAt >= int64(apiKeyTouchInterval/time.Second)
+	s.lock.RUnlock()
+	if touch {
+		s.touchAPIKey(userID, keyID, now)
+	}
+	return userID
+}
+
+// touchAPIKey records that the given API key was used at now.
+// Only the occasional update takes the write lock, so that API requests do not wait for each other.
+func (s *AuthDB) touchAPIKey(userID, keyID string, now time.Time) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	user, ok := s.Users[userID]
+	if !ok {
+		return
+	}
+	k, ok := user.APIKeys[keyID]
+	if !ok || now.Unix()-k.LastUsedAt < int64(apiKeyTouchInterval/time.Second) {
+		return
+	}
+	user = user.clone()
+	user.APIKeys[keyID].LastUsedAt = now.Unix()
+	s.commit("users", userID, user)
+}
+
+// apiKeyHash returns the hash under which an API key's secret is stored.
+// Secrets are random, so a fast hash is enough.
+func apiKeyHash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// createAPIKey handles requests to create an API key.
+// It returns the key, which cannot be retrieved again.
+func (s *AuthDB) createAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Session
+		Name         string   `json:"name"`
+		ReadOnly     bool     `json:"read_only"`
+		PathPrefixes []string `json:"path_prefixes"`
+		ExpiresAt    int64    `json:"expires_at"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if !s.checkSession(req.UserID, req.Token) {
+		writeError(w, http.StatusUnauthorized, ErrInvalidSession)
+		return
+	}
+	key, err := s.CreateAPIKey(req.UserID, &APIKey{
+		Name:         req.Name,
+		ReadOnly:     req.ReadOnly,
+		PathPrefixes: req.PathPrefixes,
+		ExpiresAt:    req.ExpiresAt,
+	})
+	s.audit(r, AuditAPIKeyCreated, req.UserID, req.UserID, err)
+	if err != nil {
+		ServeInternalServerError(w, r)
+		return
+	}
+	json.NewEncoder(w).Encode(key)
+}
+
+// apiKeys handles requests to list the API keys of a user.
+func (s *AuthDB) apiKeys(w http.ResponseWriter, r *http.Request) {
+	var session Session
+	err := json.NewDecoder(r.Body).Decode(&session)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if !s.checkSession(session.UserID, session.Token) {
+		writeError(w, http.StatusUnauthorized, ErrInvalidSession)
+		return
+	}
+	keys, err := s.APIKeys(session.UserID)
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, ErrInvalidSession)
+		return
+	}
+	json.NewEncoder(w).Encode(keys)
+}
+
+// revokeAPIKey handles requests to delete an API key.
+func (s *AuthDB) revokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Session
+		KeyID string `json:"key_id"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if !s.checkSession(req.UserID, req.Token) {
+		writeError(w, http.StatusUnauthorized, ErrInvalidSession)
+		return
+	}
+	err = s.RevokeAPIKey(req.UserID, req.KeyID)
+	s.audit(r, AuditAPIKeyRevoked, req.UserID, req.UserID, err)
+	if err == ErrAPIKeyNotFound {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		ServeInternalServerError(w, r)
+		return
+	}
+}
@@ -0,0 +1,234 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Audit appends an event to the audit log and records it as the AuditHead.
+// Seq, PrevHash and Hash are filled in, and Time if it is zero.
+// Apps can use it to record their own events next to those of the AuthDB.
+// It must not be called with the AuthDB locked.
+func (s *AuthDB) Audit(e *AuditEvent) error {
+	s.auditLock.Lock()
+	defer s.auditLock.Unlock()
+	err := s.loadAuditHead()
+	if err != nil {
+		return err
+	}
+	c := *e
+	if c.Time == 0 {
+		c.Time = s.now().Unix()
+	}
+	c.Seq = s.auditHead.Seq + 1
+	c.PrevHash = s.auditHead.Hash
+	c.Hash = c.hash(s.auditKey())
+	err = s.auditLog().Append(&c)
+	if err != nil {
+		return err
+	}
+	head := &AuditHead{Seq: c.Seq, Hash: c.Hash}
+	s.auditHead = head
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.commit("audit_head", "", head)
+}
+
+// auditKey returns the AuditKey.
+func (s *AuthDB) auditKey() string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.AuditKey
+}
+
+// recordedAuditHead returns the AuditHead, or nil if no event has been recorded.
+func (s *AuthDB) recordedAuditHead() *AuditHead {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.AuditHead
+}
+
+// audit records an event about the given user, caused by the request r of the given actor.
+// A nil err is recorded as AuditSuccess and any other as AuditFailure with the error as detail.
+func (s *AuthDB) audit(r *http.Request, typ, actorID, userID string, err error) {
+	s.auditEvent(r, &AuditEvent{Type: typ, ActorID: actorID, UserID: userID}, err)
+}
+
+// auditEvent records e with the client of r filled in.
+// If err is not nil, the outcome is AuditFailure and the error becomes the detail;
+// otherwise the outcome is AuditSuccess unless e has one.
+// Failing to record the event does not fail the request, which has already taken effect.
+func (s *AuthDB) auditEvent(r *http.Request, e *AuditEvent, err error) {
+	e.IP = s.clientIP(r)
+	e.UserAgent = r.UserAgent()
+	if e.Outcome == "" {
+		e.Outcome = AuditSuccess
+	}
+	if err != nil {
+		e.Outcome = AuditFailure
+		e.Detail = err.Error()
+	}
+	s.Audit(e)
+}
+
+// auditLogin records a password login that returned resp and err.
+func (s *AuthDB) auditLogin(r *http.Request, userID string, resp *LoginResponse, err error) {
+	e := &AuditEvent{Type: AuditLogin, ActorID: userID, UserID: userID}
+	if resp != nil && resp.Challenge != "" {
+		e.Outcome = AuditChallenged
+	}
+	s.auditEvent(r, e, err)
+}
+
+// challengeUser returns the ID of the user a login challenge is for, or "" if there is no such challenge.
+func (s *AuthDB) challengeUser(challenge string) string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	c, ok := s.challenges[challenge]
+	if !ok {
+		return ""
+	}
+	return c.userID
+}
+
+// tokenUser returns the ID of the user a one-time token was mailed to, or "" if the token is not valid.
+func (s *AuthDB) tokenUser(token string) string {
+	t, err := parseToken(s.TokenSecret, token)
+	if err != nil {
+		return ""
+	}
+	return t.UserID
+}
+
+// auditLog returns the AuditLog, starting an in-memory one if none is set.
+// The caller must hold s.auditLock.
+func (s *AuthDB) auditLog() AuditLog {
+	if s.AuditLog == nil {
+		s.AuditLog = &MemoryAuditLog{}
+	}
+	return s.AuditLog
+}
+
+// loadAuditHead reads the last event of the audit log, if it has not been read yet.
+// If events have been cut from the end of the log, new events are chained to the AuditHead instead,
+// so that the gap stays visible to VerifyAuditLog.
+// The caller must hold s.auditLock.
+func (s *AuthDB) loadAuditHead() error {
+	if s.auditHead != nil {
+		return nil
+	}
+	events, err := s.auditLog().Events()
+	if err != nil {
+		return err
+	}
+	head := &AuditHead{}
+	if len(events) != 0 {
+		last := events[len(events)-1]
+		head = &AuditHead{Seq: last.Seq, Hash: last.Hash}
+	}
+	recorded := s.recordedAuditHead()
+	if recorded != nil && recorded.Seq > head.Seq {
+		head = recorded
+	}
+	s.auditHead = head
+	return nil
+}
+
+// AuditEvents returns the events of the audit log selected by q, oldest first.
+func (s *AuthDB) AuditEvents(q *AuditQuery) ([]*AuditEvent, error) {
+	s.auditLock.Lock()
+	events, err := s.auditLog().Events()
+	s.auditLock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return q.filter(events), nil
+}
+
+// ExportAuditLog writes the events of the audit log selected by q to w as JSON Lines, oldest first.
+// An export of the whole log can be checked with VerifyAuditEvents.
+func (s *AuthDB) ExportAuditLog(w io.Writer, q *AuditQuery) error {
+	events, err := s.AuditEvents(q)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	for _, e := range events {
+		err = enc.Encode(e)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// VerifyAuditEvents returns nil if events are an unbroken chain hashed with the AuditKey, oldest first.
+// The chain may start after the beginning of the log, as long as it has no gaps.
+// Otherwise the error names the first event that does not fit.
+func (s *AuthDB) VerifyAuditEvents(events []*AuditEvent) error {
+	return verifyAuditEvents(s.auditKey(), events)
+}
+
+// VerifyAuditLog returns nil if the audit log is an unbroken chain that reaches the AuditHead.
+func (s *AuthDB) VerifyAuditLog() error {
+	s.auditLock.Lock()
+	defer s.auditLock.Unlock()
+	events, err := s.auditLog().Events()
+	if err != nil {
+		return err
+	}
+	if len(events) != 0 && events[0].Seq != 1 {
+		return fmt.Errorf("audit log starts at event %d", events[0].Seq)
+	}
+	err = s.VerifyAuditEvents(events)
+	if err != nil {
+		return err
+	}
+	// Events after the AuditHead are those whose head was not saved before a crash.
+	// They are chained with the AuditKey like the rest, so they cannot have been forged.
+	head := s.recordedAuditHead()
+	if head != nil && (int64(len(events)) < head.Seq || events[head.Seq-1].Hash != head.Hash) {
+		return fmt.Errorf("audit log does not reach event %d", head.Seq)
+	}
+	return nil
+}
+
+// auditEvents handles the admin requests to query and export the audit log.
+// /audit-log returns a JSON array; /export-audit-log returns JSON Lines.
+func (s *AuthDB) auditEvents(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Session
+		Query AuditQuery `json:"query"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	err = s.authorize(&req.Session, RoleAdmin)
+	if err == ErrInvalidSession {
+		writeError(w, http.StatusUnauthorized, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusForbidden, err)
+		return
+	}
+	events, err := s.AuditEvents(&req.Query)
+	if err != nil {
+		ServeInternalServerError(w, r)
+		return
+	}
+	if r.URL.Path == "/audit-log" {
+		json.NewEncoder(w).Encode(events)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	enc := json.NewEncoder(w)
+	for _, e := range events {
+		enc.Encode(e)
+	}
+}
@@ -0,0 +1,173 @@
+package web
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// auditSome appends n events about the given user to the audit log of db.
+func auditSome(t *testing.T, db *AuthDB, userID string, n int) {
+	for i := 0; i < n; i++ {
+		err := db.Audit(&AuditEvent{Type: AuditLogin, UserID: userID, Outcome: AuditSuccess})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// copyEvents returns copies of events that can be changed without changing the log.
+func copyEvents(events []*AuditEvent) []*AuditEvent {
+	var c []*AuditEvent
+	for _, e := range events {
+		copied := *e
+		c = append(c, &copied)
+	}
+	return c
+}
+
+func TestAuditChain(t *testing.T) {
+	db := NewAuthDB("correct horse", "")
+	auditSome(t, db, "alice", 4)
+	err := db.VerifyAuditLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, err := db.AuditEvents(&AuditQuery{})
+	if err != nil || len(events) != 4 {
+		t.Fatalf("%d events: %v", len(events), err)
+	}
+	for i, e := range events {
+		if e.Seq != int64(i+1) || e.Time == 0 || e.Hash == "" {
+			t.Fatalf("event %d: %+v", i, e)
+		}
+	}
+	err = db.VerifyAuditEvents(events[1:])
+	if err != nil {
+		t.Fatalf("chain from the middle of the log: %v", err)
+	}
+
+	modified := copyEvents(events)
+	modified[1].UserID = "mallory"
+	err = db.VerifyAuditEvents(modified)
+	if err == nil || !strings.Contains(err.Error(), "event 2 has been altered") {
+		t.Fatalf("modified event: %v", err)
+	}
+	// Rehashing the chain without the AuditKey does not help.
+	for i, e := range modified {
+		if i > 0 {
+			e.PrevHash = modified[i-1].Hash
+		}
+		e.Hash = e.hash("")
+	}
+	err = db.VerifyAuditEvents(modified)
+	if err == nil || !strings.Contains(err.Error(), "event 1 has been altered") {
+		t.Fatalf("rehashed events: %v", err)
+	}
+
+	reordered := copyEvents(events)
+	reordered[1], reordered[2] = reordered[2], reordered[1]
+	err = db.VerifyAuditEvents(reordered)
+	if err == nil || !strings.Contains(err.Error(), "does not follow") {
+		t.Fatalf("reordered events: %v", err)
+	}
+	gap := append(copyEvents(events[:1]), copyEvents(events[2:])...)
+	err = db.VerifyAuditEvents(gap)
+	if err == nil || !strings.Contains(err.Error(), "event 3 does not follow event 1") {
+		t.Fatalf("removed event: %v", err)
+	}
+
+	other := NewAuthDB("correct horse", "")
+	err = other.VerifyAuditEvents(events)
+	if err == nil {
+		t.Fatal("events verified with another AuditKey")
+	}
+}
+
+func TestAuditLogTruncation(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "audit.log")
+	open := func() (*FileAuthStore, *AuthDB) {
+		store, db := openFileAuthDB(t, filepath.Join(dir, "auth"))
+		log, err := NewFileAuditLog(logPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			log.Close()
+		})
+		db.AuditLog = log
+		return store, db
+	}
+	store, db := open()
+	auditSome(t, db, "alice", 3)
+	db.AuditLog.(*FileAuditLog).Close()
+	crash(t, store)
+
+	// Cut the last event off the log.
+	b, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.SplitAfter(string(b), "\n")
+	err = os.WriteFile(logPath, []byte(strings.Join(lines[:2], "")), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, db = open()
+	err = db.VerifyAuditLog()
+	if err == nil || !strings.Contains(err.Error(), "does not reach event 3") {
+		t.Fatalf("truncated log: %v", err)
+	}
+	// New events follow the recorded head, so the gap stays in the log.
+	auditSome(t, db, "alice", 1)
+	events, err := db.AuditEvents(&AuditQuery{})
+	if err != nil || len(events) != 3 || events[2].Seq != 4 {
+		t.Fatalf("%v %v", events, err)
+	}
+	err = db.VerifyAuditLog()
+	if err == nil || !strings.Contains(err.Error(), "event 4 does not follow event 2") {
+		t.Fatalf("log with a gap: %v", err)
+	}
+}
+
+func TestExportAuditLog(t *testing.T) {
+	db := NewAuthDB("correct horse", "")
+	auditSome(t, db, "alice", 2)
+	auditSome(t, db, "bob", 1)
+	var buf bytes.Buffer
+	err := db.ExportAuditLog(&buf, &AuditQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var events []*AuditEvent
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var e AuditEvent
+		err = json.Unmarshal(scanner.Bytes(), &e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, &e)
+	}
+	if len(events) != 3 {
+		t.Fatalf("%d events", len(events))
+	}
+	err = db.VerifyAuditEvents(events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.VerifyAuditEvents(events[:2])
+	if err != nil {
+		t.Fatalf("export cut short: %v", err)
+	}
+	buf.Reset()
+	err = db.ExportAuditLog(&buf, &AuditQuery{UserID: "bob"})
+	if err != nil || strings.Count(buf.String(), "\n") != 1 || !strings.Contains(buf.String(), `"user_id":"bob"`) {
+		t.Fatalf("%q %v", buf.String(), err)
+	}
+}
@@ -2,46 +2,681 @@ package web
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
+	"time"
 )
 
+// AuthClient is a client for an AuthDB served over HTTP.
+// Errors reported by the server are returned as Error values,
+// so they can be compared with ErrInvalidPassword, ErrUserNotFound and the like.
 type AuthClient struct {
+	// AuthServerAddr is the base URL of the AuthDB, e.g. "https://auth.example.com".
 	AuthServerAddr string
+	// HTTPClient is used to make requests.
+	// If it is nil, http.DefaultClient is used.
+	HTTPClient *http.Client
+	// Timeout limits how long each request may take.
+	// Zero means no limit other than the one set on ctx.
+	Timeout time.Duration
 }
 
-func (c *AuthClient) CreateInviteCode() {
-	panic("not implemented")
+// CreateInviteCode returns a new single-use registration code.
+// Use InviteAs to create one with a registrar's session instead of the registrar key.
+func (c *AuthClient) CreateInviteCode(ctx context.Context, registrarKey string) (string, error) {
+	req := struct {
+		RegistrarKey string `json:"registrar_key"`
+	}{registrarKey}
+	var invite Invite
+	err := c.post(ctx, "/invite", req, &invite)
+	return invite.Code, err
 }
 
-func (c *AuthClient) Register() {
-	panic("not implemented")
+// InviteAs returns a new single-use registration code created by the user that owns s,
+// who must have RoleRegistrar.
+func (c *AuthClient) InviteAs(ctx context.Context, s *Session) (string, error) {
+	invite, err := c.CreateInvite(ctx, s, &Invite{MaxUses: 1})
+	if err != nil {
+		return "", err
+	}
+	return invite.Code, nil
+}
+
+// CreateInvite creates an invite issued by the user that owns s, who must have RoleRegistrar.
+// ExpiresAt, MaxUses, Role, Org and Note are taken from opts; a MaxUses of zero means no limit.
+// Only admins can set Role or Org.
+func (c *AuthClient) CreateInvite(ctx context.Context, s *Session, opts *Invite) (*Invite, error) {
+	req := struct {
+		Session
+		ExpiresAt int64  `json:"expires_at"`
+		MaxUses   int    `json:"max_uses"`
+		Role      string `json:"role"`
+		Org       string `json:"org"`
+		Note      string `json:"note"`
+	}{*s, opts.ExpiresAt, opts.MaxUses, opts.Role, opts.Org, opts.Note}
+	var invite Invite
+	err := c.post(ctx, "/invite", req, &invite)
+	if err != nil {
+		return nil, err
+	}
+	return &invite, nil
+}
+
+// Invites returns the invites visible to the user that owns s, oldest first.
+// Admins see every invite; registrars see the ones they issued.
+func (c *AuthClient) Invites(ctx context.Context, s *Session) ([]*Invite, error) {
+	var invites []*Invite
+	err := c.post(ctx, "/invites", s, &invites)
+	return invites, err
+}
+
+// RevokeInvite revokes the invite with the given code.
+func (c *AuthClient) RevokeInvite(ctx context.Context, s *Session, code string) error {
+	req := struct {
+		Session
+		Code string `json:"code"`
+	}{*s, code}
+	return c.post(ctx, "/revoke-invite", req, nil)
+}
+
+// Register creates a new user and returns the new user's ID.
+func (c *AuthClient) Register(ctx context.Context, registrationCode, password string) (string, error) {
+	req := struct {
+		RegistrationCode string `json:"registration_code"`
+		Password         string `json:"password"`
+	}{registrationCode, password}
+	var userID string
+	err := c.post(ctx, "/register", req, &userID)
+	return userID, err
+}
+
+// Login starts a new session for the given user.
+// If the user has a second factor, the response holds a Challenge instead of a Token;
+// pass it to VerifyLogin along with the user's code.
+func (c *AuthClient) Login(ctx context.Context, userID, password string) (*LoginResponse, error) {
+	req := LoginRequeset{
+		UserID:   userID,
+		Password: password,
+	}
+	var resp LoginResponse
+	err := c.post(ctx, "/login", req, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// VerifyLogin answers a login challenge with a TOTP or recovery code and returns the new session.
+func (c *AuthClient) VerifyLogin(ctx context.Context, challenge, code string) (*Session, error) {
+	req := struct {
+		Challenge string `json:"challenge"`
+		Code      string `json:"code"`
+	}{challenge, code}
+	var session Session
+	err := c.post(ctx, "/verify-login", req, &session)
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// ValidateSession returns nil if the session is valid.
+func (c *AuthClient) ValidateSession(ctx context.Context, s *Session) error {
+	return c.post(ctx, "/validate-session", s, nil)
+}
+
+// Logout ends the given session.
+func (c *AuthClient) Logout(ctx context.Context, s *Session) error {
+	return c.post(ctx, "/logout", s, nil)
+}
+
+// User returns the user that owns the given session.
+func (c *AuthClient) User(ctx context.Context, s *Session) (*User, error) {
+	var user User
+	err := c.post(ctx, "/user", s, &user)
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// Sessions returns the active sessions of the user that owns the given session.
+func (c *AuthClient) Sessions(ctx context.Context, s *Session) ([]*SessionInfo, error) {
+	var sessions []*SessionInfo
+	err := c.post(ctx, "/sessions", s, &sessions)
+	return sessions, err
+}
+
+// RevokeSession ends the session with the given ID.
+// It must belong to the same user as s.
+func (c *AuthClient) RevokeSession(ctx context.Context, s *Session, sessionID string) error {
+	req := struct {
+		Session
+		SessionID string `json:"session_id"`
+	}{*s, sessionID}
+	return c.post(ctx, "/revoke-session", req, nil)
+}
+
+// RevokeSessions ends every session of the user that owns s, including s itself.
+func (c *AuthClient) RevokeSessions(ctx context.Context, s *Session) error {
+	return c.post(ctx, "/revoke-sessions", s, nil)
+}
+
+// EnrollTOTP starts enrolling an authenticator app for the user that owns s.
+func (c *AuthClient) EnrollTOTP(ctx context.Context, s *Session) (*TOTPEnrollment, error) {
+	var enrollment TOTPEnrollment
+	err := c.post(ctx, "/enroll-totp", s, &enrollment)
+	if err != nil {
+		return nil, err
+	}
+	return &enrollment, nil
+}
+
+// ConfirmTOTP finishes enrollment with a code from the authenticator app and returns the recovery codes.
+func (c *AuthClient) ConfirmTOTP(ctx context.Context, s *Session, code string) ([]string, error) {
+	req := struct {
+		Session
+		Code string `json:"code"`
+	}{*s, code}
+	var codes []string
+	err := c.post(ctx, "/confirm-totp", req, &codes)
+	return codes, err
+}
+
+// DisableTOTP turns off the second factor of the user that owns s.
+// The code may be a TOTP code or a recovery code.
+func (c *AuthClient) DisableTOTP(ctx context.Context, s *Session, code string) error {
+	req := struct {
+		Session
+		Code string `json:"code"`
+	}{*s, code}
+	return c.post(ctx, "/disable-totp", req, nil)
+}
+
+// BeginPasskeyRegistration starts adding a passkey to the user that owns s.
+// The options are passed to navigator.credentials.create in the browser.
+func (c *AuthClient) BeginPasskeyRegistration(ctx context.Context, s *Session) (*PasskeyCreationOptions, error) {
+	var options PasskeyCreationOptions
+	err := c.post(ctx, "/begin-passkey-registration", s, &options)
+	if err != nil {
+		return nil, err
+	}
+	return &options, nil
+}
+
+// FinishPasskeyRegistration adds the passkey the browser created to the user that owns s.
+func (c *AuthClient) FinishPasskeyRegistration(ctx context.Context, s *Session, name string, credential *PasskeyCredential) (*Passkey, error) {
+	req := struct {
+		Session
+		Name       string             `json:"name"`
+		Credential *PasskeyCredential `json:"credential"`
+	}{*s, name, credential}
+	var passkey Passkey
+	err := c.post(ctx, "/finish-passkey-registration", req, &passkey)
+	if err != nil {
+		return nil, err
+	}
+	return &passkey, nil
+}
+
+// BeginPasskeyLogin starts a passkey login.
+// The user ID may be empty to let the user pick any of their passkeys.
+// The options are passed to navigator.credentials.get in the browser.
+func (c *AuthClient) BeginPasskeyLogin(ctx context.Context, userID string) (*PasskeyRequestOptions, error) {
+	req := struct {
+		UserID string `json:"user_id"`
+	}{userID}
+	var options PasskeyRequestOptions
+	err := c.post(ctx, "/begin-passkey-login", req, &options)
+	if err != nil {
+		return nil, err
+	}
+	return &options, nil
+}
+
+// FinishPasskeyLogin logs in with the passkey the browser signed the challenge with and returns the new session.
+func (c *AuthClient) FinishPasskeyLogin(ctx context.Context, credential *PasskeyCredential) (*Session, error) {
+	var session Session
+	err := c.post(ctx, "/finish-passkey-login", credential, &session)
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// Passkeys returns the passkeys of the user that owns s.
+func (c *AuthClient) Passkeys(ctx context.Context, s *Session) ([]*Passkey, error) {
+	var passkeys []*Passkey
+	err := c.post(ctx, "/passkeys", s, &passkeys)
+	return passkeys, err
+}
+
+// RemovePasskey deletes a passkey of the user that owns s.
+func (c *AuthClient) RemovePasskey(ctx context.Context, s *Session, passkeyID string) error {
+	req := struct {
+		Session
+		PasskeyID string `json:"passkey_id"`
+	}{*s, passkeyID}
+	return c.post(ctx, "/remove-passkey", req, nil)
+}
+
+// CreateAPIKey creates an API key for the user that owns s and returns the key.
+// Name, ReadOnly, PathPrefixes and ExpiresAt are taken from key.
+func (c *AuthClient) CreateAPIKey(ctx context.Context, s *Session, key *APIKey) (string, error) {
+	req := struct {
+		Session
+		Name         string   `json:"name"`
+		ReadOnly     bool     `json:"read_only"`
+		PathPrefixes []string `json:"path_prefixes"`
+		ExpiresAt    int64    `json:"expires_at"`
+	}{*s, key.Name, key.ReadOnly, key.PathPrefixes, key.ExpiresAt}
+	var k string
+	err := c.post(ctx, "/create-api-key", req, &k)
+	return k, err
+}
+
+// APIKeys returns the API keys of the user that owns s.
+func (c *AuthClient) APIKeys(ctx context.Context, s *Session) ([]*APIKey, error) {
+	var keys []*APIKey
+	err := c.post(ctx, "/api-keys", s, &keys)
+	return keys, err
+}
+
+// RevokeAPIKey deletes the API key with the given ID.
+func (c *AuthClient) RevokeAPIKey(ctx context.Context, s *Session, keyID string) error {
+	req := struct {
+		Session
+		KeyID string `json:"key_id"`
+	}{*s, keyID}
+	return c.post(ctx, "/revoke-api-key", req, nil)
+}
+
+// AddEmail adds an email address to the user that owns s.
+// The server mails the address a token to pass to VerifyEmail.
+func (c *AuthClient) AddEmail(ctx context.Context, s *Session, email string) error {
+	req := struct {
+		Session
+		Email string `json:"email"`
+	}{*s, email}
+	return c.post(ctx, "/add-email", req, nil)
+}
+
+// RemoveEmail removes an email address from the user that owns s.
+func (c *AuthClient) RemoveEmail(ctx context.Context, s *Session, email string) error {
+	req := struct {
+		Session
+		Email string `json:"email"`
+	}{*s, email}
+	return c.post(ctx, "/remove-email", req, nil)
+}
+
+// VerifyEmail marks an email address as verified using the token mailed to it.
+func (c *AuthClient) VerifyEmail(ctx context.Context, token string) error {
+	req := struct {
+		Token string `json:"token"`
+	}{token}
+	return c.post(ctx, "/verify-email", req, nil)
+}
+
+// ForgotPassword asks for a password reset token to be mailed to the user with the given verified email address.
+func (c *AuthClient) ForgotPassword(ctx context.Context, email string) error {
+	req := struct {
+		Email string `json:"email"`
+	}{email}
+	return c.post(ctx, "/forgot-password", req, nil)
+}
+
+// ResetPassword sets a new password using a mailed reset token.
+// All of the user's sessions are ended.
+func (c *AuthClient) ResetPassword(ctx context.Context, token, password string) error {
+	req := struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}{token, password}
+	return c.post(ctx, "/reset-password", req, nil)
+}
+
+// FindUsers returns the users whose ID or email address contains query, keyed by user ID.
+// The user that owns s must be an admin.
+func (c *AuthClient) FindUsers(ctx context.Context, s *Session, query string) (map[string]*User, error) {
+	req := adminRequest{
+		Session: *s,
+		Query:   query,
+	}
+	var users map[string]*User
+	err := c.post(ctx, "/users", req, &users)
+	return users, err
+}
+
+// SetDisabled disables or re-enables a user.
+// The user that owns s must be an admin.
+func (c *AuthClient) SetDisabled(ctx context.Context, s *Session, userID string, disabled bool) error {
+	req := adminRequest{
+		Session:      *s,
+		TargetUserID: userID,
+		Disabled:     disabled,
+	}
+	return c.post(ctx, "/disable-user", req, nil)
+}
+
+// DeleteUser deletes a user.
+// The user that owns s must be an admin.
+func (c *AuthClient) DeleteUser(ctx context.Context, s *Session, userID string) error {
+	req := adminRequest{
+		Session:      *s,
+		TargetUserID: userID,
+	}
+	return c.post(ctx, "/delete-user", req, nil)
+}
+
+// LogoutUser ends every session of a user.
+// The user that owns s must be an admin.
+func (c *AuthClient) LogoutUser(ctx context.Context, s *Session, userID string) error {
+	req := adminRequest{
+		Session:      *s,
+		TargetUserID: userID,
+	}
+	return c.post(ctx, "/logout-user", req, nil)
+}
+
+// SetPassword sets the password of a user.
+// The user that owns s must be an admin.
+func (c *AuthClient) SetPassword(ctx context.Context, s *Session, userID, password string) error {
+	req := adminRequest{
+		Session:      *s,
+		TargetUserID: userID,
+		Password:     password,
+	}
+	return c.post(ctx, "/set-password", req, nil)
+}
+
+// SetRoles replaces the roles of a user.
+// The user that owns s must be an admin.
+func (c *AuthClient) SetRoles(ctx context.Context, s *Session, userID string, roles []string) error {
+	req := adminRequest{
+		Session:      *s,
+		TargetUserID: userID,
+		Roles:        roles,
+	}
+	return c.post(ctx, "/set-roles", req, nil)
+}
+
+// AuditEvents returns the events of the audit log selected by q, oldest first.
+// The user that owns s must be an admin.
+func (c *AuthClient) AuditEvents(ctx context.Context, s *Session, q *AuditQuery) ([]*AuditEvent, error) {
+	req := struct {
+		Session
+		Query *AuditQuery `json:"query"`
+	}{*s, q}
+	var events []*AuditEvent
+	err := c.post(ctx, "/audit-log", req, &events)
+	return events, err
+}
+
+// ExportAuditLog writes the events of the audit log selected by q to w as JSON Lines, oldest first.
+// The user that owns s must be an admin.
+func (c *AuthClient) ExportAuditLog(ctx context.Context, s *Session, q *AuditQuery, w io.Writer) error {
+	req := struct {
+		Session
+		Query *AuditQuery `json:"query"`
+	}{*s, q}
+	return c.post(ctx, "/export-audit-log", req, w)
+}
+
+// Unlock lifts the login lockout of a user or IP address; either may be empty.
+// The user that owns s must be an admin.
+func (c *AuthClient) Unlock(ctx context.Context, s *Session, userID, ip string) error {
+	req := struct {
+		Session
+		UnlockUserID string `json:"unlock_user_id"`
+		UnlockIP     string `json:"unlock_ip"`
+	}{*s, userID, ip}
+	return c.post(ctx, "/unlock", req, nil)
+}
+
+// RefreshSession renews the stateless session token of s and returns the session with the new token.
+func (c *AuthClient) RefreshSession(ctx context.Context, s *Session) (*Session, error) {
+	var session Session
+	err := c.post(ctx, "/refresh-session", s, &session)
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
 }
 
-func (c *AuthClient) Login() {
-	panic("not implemented")
+// JWKS returns the public keys that the AuthDB's tokens are verified with.
+func (c *AuthClient) JWKS(ctx context.Context) ([]*JWK, error) {
+	var resp struct {
+		Keys []*JWK `json:"keys"`
+	}
+	err := c.post(ctx, "/jwks", struct{}{}, &resp)
+	return resp.Keys, err
 }
 
-func (c *AuthClient) ValidateSession(s *Session) error {
-	b, err := json.Marshal(s)
+// SessionRevocations returns the stateless session tokens that the AuthDB has revoked.
+// Only confidential OAuth clients may fetch them, so it takes the ID and secret of one.
+func (c *AuthClient) SessionRevocations(ctx context.Context, clientID, clientSecret string) (*SessionRevocations, error) {
+	req := sessionRevocationsRequest{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+	}
+	var revocations SessionRevocations
+	err := c.post(ctx, "/session-revocations", req, &revocations)
+	if err != nil {
+		return nil, err
+	}
+	return &revocations, nil
+}
+
+// Identities returns the identity provider accounts linked to the user that owns s.
+func (c *AuthClient) Identities(ctx context.Context, s *Session) ([]*ExternalIdentity, error) {
+	var identities []*ExternalIdentity
+	err := c.post(ctx, "/identities", s, &identities)
+	return identities, err
+}
+
+// UnlinkIdentity removes an identity provider account from the user that owns s.
+func (c *AuthClient) UnlinkIdentity(ctx context.Context, s *Session, provider, subject string) error {
+	req := struct {
+		Session
+		Provider string `json:"provider"`
+		Subject  string `json:"subject"`
+	}{*s, provider, subject}
+	return c.post(ctx, "/unlink-identity", req, nil)
+}
+
+// CreateOAuthClient registers an app with the OpenID Connect provider and returns it with its secret.
+// The secret is empty for public clients. The user that owns s must be an admin.
+func (c *AuthClient) CreateOAuthClient(ctx context.Context, s *Session, client *OAuthClient) (*OAuthClient, string, error) {
+	req := struct {
+		Session
+		Client *OAuthClient `json:"client"`
+	}{*s, client}
+	var resp oauthClientResponse
+	err := c.post(ctx, "/create-oauth-client", req, &resp)
+	if err != nil {
+		return nil, "", err
+	}
+	return resp.Client, resp.Secret, nil
+}
+
+// OAuthClients returns the apps registered with the OpenID Connect provider.
+// The user that owns s must be an admin.
+func (c *AuthClient) OAuthClients(ctx context.Context, s *Session) ([]*OAuthClient, error) {
+	var clients []*OAuthClient
+	err := c.post(ctx, "/oauth-clients", s, &clients)
+	return clients, err
+}
+
+// DeleteOAuthClient deletes an app registered with the OpenID Connect provider.
+// The user that owns s must be an admin.
+func (c *AuthClient) DeleteOAuthClient(ctx context.Context, s *Session, clientID string) error {
+	req := struct {
+		Session
+		ClientID string `json:"client_id"`
+	}{*s, clientID}
+	return c.post(ctx, "/delete-oauth-client", req, nil)
+}
+
+// RotateSigningKey replaces the key that signs tokens and returns the new key's ID.
+// An empty alg keeps the configured algorithm. The user that owns s must be an admin.
+func (c *AuthClient) RotateSigningKey(ctx context.Context, s *Session, alg string) (string, error) {
+	req := struct {
+		Session
+		Alg string `json:"alg"`
+	}{*s, alg}
+	var kid string
+	err := c.post(ctx, "/rotate-signing-key", req, &kid)
+	return kid, err
+}
+
+// CreateOrg creates an organization owned by the user that owns s.
+func (c *AuthClient) CreateOrg(ctx context.Context, s *Session, name string) (*Organization, error) {
+	req := orgRequest{Session: *s, Name: name}
+	var org Organization
+	err := c.post(ctx, "/create-org", req, &org)
+	if err != nil {
+		return nil, err
+	}
+	return &org, nil
+}
+
+// Orgs returns the organizations the user that owns s is a member of.
+func (c *AuthClient) Orgs(ctx context.Context, s *Session) ([]*Organization, error) {
+	var orgs []*Organization
+	err := c.post(ctx, "/orgs", s, &orgs)
+	return orgs, err
+}
+
+// Org returns the organization with the given ID.
+// The user that owns s must be a member of it.
+func (c *AuthClient) Org(ctx context.Context, s *Session, orgID string) (*Organization, error) {
+	req := orgRequest{Session: *s, OrgID: orgID}
+	var org Organization
+	err := c.post(ctx, "/org", req, &org)
+	if err != nil {
+		return nil, err
+	}
+	return &org, nil
+}
+
+// AuthorizeOrg returns the org role of the user that owns s if it is at least role in the given organization.
+// An empty role lets in every member. Otherwise it returns ErrForbidden.
+func (c *AuthClient) AuthorizeOrg(ctx context.Context, s *Session, orgID, role string) (string, error) {
+	req := orgRequest{Session: *s, OrgID: orgID, Role: role}
+	var memberRole string
+	err := c.post(ctx, "/authorize-org", req, &memberRole)
+	return memberRole, err
+}
+
+// InviteToOrg invites a user to join an organization, by user ID or by email address, with the given role.
+func (c *AuthClient) InviteToOrg(ctx context.Context, s *Session, orgID string, opts *OrgInvitation) (*OrgInvitation, error) {
+	req := orgRequest{Session: *s, OrgID: orgID, TargetUserID: opts.UserID, Email: opts.Email, Role: opts.Role}
+	var invitation OrgInvitation
+	err := c.post(ctx, "/invite-to-org", req, &invitation)
+	if err != nil {
+		return nil, err
+	}
+	return &invitation, nil
+}
+
+// OrgInvitations returns the pending invitations for the user that owns s.
+func (c *AuthClient) OrgInvitations(ctx context.Context, s *Session) ([]*OrgInvitation, error) {
+	var invitations []*OrgInvitation
+	err := c.post(ctx, "/org-invitations", s, &invitations)
+	return invitations, err
+}
+
+// AcceptOrgInvitation joins the organization of an invitation sent to the user that owns s.
+func (c *AuthClient) AcceptOrgInvitation(ctx context.Context, s *Session, orgID, invitationID string) error {
+	req := orgRequest{Session: *s, OrgID: orgID, InvitationID: invitationID}
+	return c.post(ctx, "/accept-org-invitation", req, nil)
+}
+
+// DeclineOrgInvitation deletes an invitation sent to the user that owns s.
+func (c *AuthClient) DeclineOrgInvitation(ctx context.Context, s *Session, orgID, invitationID string) error {
+	req := orgRequest{Session: *s, OrgID: orgID, InvitationID: invitationID}
+	return c.post(ctx, "/decline-org-invitation", req, nil)
+}
+
+// RevokeOrgInvitation deletes a pending invitation to an organization.
+func (c *AuthClient) RevokeOrgInvitation(ctx context.Context, s *Session, orgID, invitationID string) error {
+	req := orgRequest{Session: *s, OrgID: orgID, InvitationID: invitationID}
+	return c.post(ctx, "/revoke-org-invitation", req, nil)
+}
+
+// SetOrgRole changes the org role of a member of an organization.
+func (c *AuthClient) SetOrgRole(ctx context.Context, s *Session, orgID, userID, role string) error {
+	req := orgRequest{Session: *s, OrgID: orgID, TargetUserID: userID, Role: role}
+	return c.post(ctx, "/set-org-role", req, nil)
+}
+
+// RemoveOrgMember removes a member from an organization.
+// Users leave an organization by removing themselves.
+func (c *AuthClient) RemoveOrgMember(ctx context.Context, s *Session, orgID, userID string) error {
+	req := orgRequest{Session: *s, OrgID: orgID, TargetUserID: userID}
+	return c.post(ctx, "/remove-org-member", req, nil)
+}
+
+// TransferOrgOwnership makes a member the owner of an organization.
+// The user that owns s must be its owner.
+func (c *AuthClient) TransferOrgOwnership(ctx context.Context, s *Session, orgID, userID string) error {
+	req := orgRequest{Session: *s, OrgID: orgID, TargetUserID: userID}
+	return c.post(ctx, "/transfer-org-ownership", req, nil)
+}
+
+// post sends req as JSON to the given endpoint and decodes the response into resp.
+// If resp is nil, the response body is ignored.
+func (c *AuthClient) post(ctx context.Context, endpoint string, req, resp any) error {
+	if c.Timeout != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+	b, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, c.AuthServerAddr+endpoint, bytes.NewReader(b))
 	if err != nil {
 		return err
 	}
-	resp, err := http.Post(c.validateSessionEndpoint(), "application/json", bytes.NewReader(b))
+	r.Header.Set("Content-Type", "application/json")
+	res, err := c.httpClient().Do(r)
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		return ErrInvalidSession
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return responseError(res)
 	}
-	return nil
+	if resp == nil {
+		return nil
+	}
+	if w, ok := resp.(io.Writer); ok {
+		_, err = io.Copy(w, res.Body)
+		return err
+	}
+	return json.NewDecoder(res.Body).Decode(resp)
 }
 
-func (c *AuthClient) Logout() {
-	panic("not implemented")
+func (c *AuthClient) httpClient() *http.Client {
+	if c.HTTPClient == nil {
+		return http.DefaultClient
+	}
+	return c.HTTPClient
 }
 
-func (c *AuthClient) validateSessionEndpoint() string {
-	return c.AuthServerAddr + "/validate-session"
+// responseError returns the Error in the body of a failed response.
+// If the body is not an Error, the status is used instead.
+func responseError(res *http.Response) error {
+	var e Error
+	err := json.NewDecoder(res.Body).Decode(&e)
+	if err != nil || e.Err == "" {
+		return fmt.Errorf("auth server: %s", res.Status)
+	}
+	return e
 }
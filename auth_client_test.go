@@ -0,0 +1,145 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newAuthClient(t *testing.T) (*AuthDB, *AuthClient) {
+	db := NewAuthDB("correct horse", "registrar key")
+	srv := httptest.NewServer(db)
+	t.Cleanup(srv.Close)
+	return db, &AuthClient{AuthServerAddr: srv.URL}
+}
+
+func TestAuthClientRegisterAndLogin(t *testing.T) {
+	ctx := context.Background()
+	db, c := newAuthClient(t)
+	_, err := c.CreateInviteCode(ctx, "wrong key")
+	if err != ErrInvalidRegistrarKey {
+		t.Fatalf("wrong registrar key: %v", err)
+	}
+	code, err := c.CreateInviteCode(ctx, "registrar key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.Register(ctx, "unknown code", "battery staple")
+	if err != ErrInvalidRegistrationCode {
+		t.Fatalf("unknown code: %v", err)
+	}
+	_, err = c.Register(ctx, code, "short")
+	if err != ErrPasswordTooShort {
+		t.Fatalf("short password: %v", err)
+	}
+	userID, err := c.Register(ctx, code, "battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.Register(ctx, code, "battery staple")
+	if err != ErrInvalidRegistrationCode {
+		t.Fatalf("used code: %v", err)
+	}
+	login, err := c.Login(ctx, userID, "battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if login.UserID != userID || !db.checkSession(userID, login.Token) {
+		t.Fatalf("login returned %+v", login)
+	}
+	user, err := c.User(ctx, &login.Session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.PasswordHash != "" || len(user.Sessions) != 0 {
+		t.Fatal("User returned secrets")
+	}
+	_, err = c.Login(ctx, userID, "wrong password")
+	if err != ErrInvalidPassword {
+		t.Fatalf("wrong password: %v", err)
+	}
+	_, err = c.Login(ctx, "nobody", "battery staple")
+	if err != ErrUserNotFound {
+		t.Fatalf("unknown user: %v", err)
+	}
+}
+
+func TestAuthClientSessions(t *testing.T) {
+	ctx := context.Background()
+	db, c := newAuthClient(t)
+	first, err := c.Login(ctx, "admin", "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := c.Login(ctx, "admin", "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.ValidateSession(ctx, &first.Session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.ValidateSession(ctx, &Session{UserID: "admin", Token: "forged"})
+	if err != ErrInvalidSession {
+		t.Fatalf("forged token: %v", err)
+	}
+	sessions, err := c.Sessions(ctx, &first.Session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 2 {
+		t.Fatalf("%d sessions", len(sessions))
+	}
+	err = c.RevokeSession(ctx, &first.Session, "unknown")
+	if err != ErrSessionNotFound {
+		t.Fatalf("unknown session: %v", err)
+	}
+	db.lock.RLock()
+	secondID := db.Users["admin"].Sessions[second.Token].ID
+	db.lock.RUnlock()
+	err = c.RevokeSession(ctx, &first.Session, secondID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.ValidateSession(ctx, &second.Session)
+	if err != ErrInvalidSession {
+		t.Fatalf("revoked session: %v", err)
+	}
+	err = c.Logout(ctx, &first.Session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.ValidateSession(ctx, &first.Session)
+	if err != ErrInvalidSession {
+		t.Fatalf("logged out session: %v", err)
+	}
+}
+
+func TestAuthClientTimeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+	c := &AuthClient{AuthServerAddr: srv.URL, Timeout: 50 * time.Millisecond}
+	start := time.Now()
+	err := c.ValidateSession(context.Background(), &Session{UserID: "admin", Token: "token"})
+	if err == nil || time.Since(start) > 5*time.Second {
+		t.Fatalf("returned %v after %v", err, time.Since(start))
+	}
+}
+
+func TestAuthClientUnexpectedResponse(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad gateway", http.StatusBadGateway)
+	}))
+	defer srv.Close()
+	c := &AuthClient{AuthServerAddr: srv.URL}
+	_, err := c.Login(context.Background(), "admin", "correct horse")
+	if err == nil || err.Error() != "auth server: 502 Bad Gateway" {
+		t.Fatal(err)
+	}
+}
@@ -2,124 +2,692 @@ package web
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"sort"
 	"sync"
+	"time"
 )
 
+// authSnapshotInterval is the number of ops an AuthDB logs between snapshots.
+const authSnapshotInterval = 1000
+
+// sessionTouchInterval is how much time must pass before a session's last-seen time is saved again.
+// It keeps every authenticated request from writing to the store.
+const sessionTouchInterval = time.Minute
+
 // AuthDB is a service for authentication.
 // It is intended to be set up as a service that is used by other services and not end users.
 // It is intended to be used on the open internet.
+// It is safe for concurrent use. Its exported fields are configuration and must not be changed once
+// it is serving requests; use its methods to change users and other state.
 type AuthDB struct {
 	// Users are the users of the service.
 	Users map[string]*User `json:"users"`
 	// RegistrationCodes are needed to create new users.
-	// Codes can be created by sending a POST request to /register with a valid registrar key.
-	RegistrationCodes map[string]bool `json:"registration_codes"`
+	// Deprecated: Use Invitations instead. Codes found here are moved to Invitations as single-use invites when the AuthDB is opened.
+	RegistrationCodes map[string]bool `json:"registration_codes,omitempty"`
+	// Invitations are the invites that new users register with, keyed by code.
+	// Invites can be created by sending a POST request to /invite with a valid registrar key or a registrar's session.
+	Invitations map[string]*Invite `json:"invitations"`
 	// RegistrarKey is a secret key that is needed to generate registration codes.
 	// If your service is open to new signups, you can share this string publicly.
 	RegistrarKey string `json:"registrar_key"`
-	// AdminID is the UserID of the admin.
+	// AdminID is the UserID of the first admin.
+	// This user is an admin whatever its Roles say.
 	AdminID string `json:"admin_id"`
-	// locks are used to prevent race conditions.
-	locks map[string]*sync.Mutex
+	// SessionIdleTimeout is how long a session stays valid without being used.
+	// Zero means sessions never go idle.
+	SessionIdleTimeout time.Duration `json:"session_idle_timeout"`
+	// SessionMaxAge is how long a session stays valid after it is created, even if it is in use.
+	// Zero means sessions have no absolute expiry.
+	SessionMaxAge time.Duration `json:"session_max_age"`
+	// TOTPIssuer names the service in users' authenticator apps.
+	TOTPIssuer string `json:"totp_issuer"`
+	// MaxLoginFailures is the number of failed logins in a row that locks an account for LoginLockout.
+	// Zero means accounts are never locked.
+	MaxLoginFailures int `json:"max_login_failures"`
+	// MaxLoginFailuresPerIP is the number of failed logins in a row that locks an IP address for LoginLockout.
+	// Zero means IP addresses are never locked.
+	MaxLoginFailuresPerIP int `json:"max_login_failures_per_ip"`
+	// IPBackoffThreshold is the number of failed logins in a row from an IP address before it has to wait
+	// between attempts, so that one user's typo does not slow down everyone behind the same NAT or proxy.
+	IPBackoffThreshold int `json:"ip_backoff_threshold"`
+	// LoginBackoff is the wait after the first failed login that counts.
+	// It doubles with every further failure, up to LoginLockout.
+	LoginBackoff time.Duration `json:"login_backoff"`
+	// TrustedProxies are the IP addresses and CIDR ranges of reverse proxies in front of the AuthDB.
+	// Requests from them are taken to come from the last address in X-Forwarded-For that is not a trusted proxy.
+	// Requests from other addresses are taken to come from the address they were sent from.
+	TrustedProxies []string `json:"trusted_proxies"`
+	// LoginLockout is how long an account or IP address stays locked.
+	LoginLockout time.Duration `json:"login_lockout"`
+	// TokenSecret signs the one-time tokens sent by email.
+	TokenSecret string `json:"token_secret"`
+	// EmailLinkURL is the page that handles links in emails, if there is one.
+	// The token and its purpose are appended as query parameters.
+	EmailLinkURL string `json:"email_link_url"`
+	// Issuer is the URL at which the AuthDB is served as an OpenID Connect provider.
+	// It should be set in production: if it is empty, it is taken from the Host header of each request,
+	// which clients choose, and from X-Forwarded-Proto if the request comes from one of TrustedProxies.
+	Issuer string `json:"issuer"`
+	// LoginURL is the page that users without a session are sent to by /authorize.
+	// The URL to return to afterwards is added as the return_to query parameter.
+	// Set it to "/login" to use the login page served by the AuthDB itself.
+	LoginURL string `json:"login_url"`
+	// OAuthClients are the apps that sign users in through the OpenID Connect endpoints, keyed by client ID.
+	OAuthClients map[string]*OAuthClient `json:"oauth_clients"`
+	// SigningKeys sign and verify ID tokens and access tokens, keyed by key ID.
+	SigningKeys map[string]*SigningKey `json:"signing_keys"`
+	// SigningAlg is the algorithm of newly generated signing keys, AlgRS256 or AlgEdDSA.
+	SigningAlg string `json:"signing_alg"`
+	// AccessTokenTTL is how long ID tokens and access tokens are valid.
+	AccessTokenTTL time.Duration `json:"access_token_ttl"`
+	// IdentityProviders are the upstream providers that users can log in with, keyed by a short name
+	// that is used in URLs and stored with linked accounts.
+	IdentityProviders map[string]*IdentityProvider `json:"-"`
+	// StatelessSessions makes logins issue signed session tokens that apps can check offline with a
+	// SessionVerifier instead of calling ValidateSession. The tokens expire after SessionIdleTimeout unless
+	// renewed with RefreshSession. Stateless sessions are not stored, so Sessions does not list them.
+	StatelessSessions bool `json:"stateless_sessions"`
+	// RevokedSessions maps the IDs of logged out stateless sessions to when their tokens expire.
+	RevokedSessions map[string]int64 `json:"revoked_sessions"`
+	// RevokedUsers maps user IDs to the Unix time in milliseconds up to which their stateless session tokens are rejected.
+	RevokedUsers map[string]int64 `json:"revoked_users"`
+	// Orgs are the organizations that users belong to, keyed by org ID.
+	Orgs map[string]*Organization `json:"orgs"`
+	// PasskeyRPID is the domain that passkeys are bound to, such as "example.com".
+	// Passkeys can be used on that domain and its subdomains. If it is empty, passkeys are disabled.
+	PasskeyRPID string `json:"passkey_rp_id"`
+	// PasskeyRPName names the service when the browser asks the user to create a passkey.
+	// If it is empty, PasskeyRPID is used.
+	PasskeyRPName string `json:"passkey_rp_name"`
+	// PasskeyOrigins are the origins of the pages allowed to run passkey ceremonies, such as "https://app.example.com".
+	// If it is empty, only https:// followed by PasskeyRPID is allowed.
+	PasskeyOrigins []string `json:"passkey_origins"`
+	// PasswordPolicy is what passwords chosen by users must be like.
+	// It is checked when users register and when passwords are reset or set.
+	PasswordPolicy PasswordPolicy `json:"password_policy"`
+	// PasswordHasher hashes new passwords.
+	// Stored hashes made with another hasher or other parameters are replaced when their users next log in.
+	// If it is nil, an Argon2idHasher with its default parameters is used.
+	PasswordHasher PasswordHasher `json:"-"`
+	// AuditLog records every authentication event, such as logins, failed passwords and redeemed invites.
+	// If it is nil, events are kept in memory.
+	AuditLog AuditLog `json:"-"`
+	// AuditKey keys the hashes that chain the events of the audit log.
+	// It is kept with the AuthDB rather than in the log, so that whoever can write the log cannot rewrite it.
+	AuditKey string `json:"audit_key"`
+	// AuditHead is the last event appended to the audit log, so that events cut from its end are noticed.
+	AuditHead *AuditHead `json:"audit_head"`
+	// Mailer sends email verification and password reset messages.
+	Mailer MailSender `json:"-"`
+	// Clock returns the current time.
+	// If it is nil, time.Now is used.
+	Clock func() time.Time `json:"-"`
+	// OnSnapshotError is called when saving a snapshot fails. The change that triggered the snapshot is
+	// already logged, so it still succeeds, and the snapshot is tried again on the next change.
+	// It is called with the AuthDB locked and must not call its methods.
+	OnSnapshotError func(err error) `json:"-"`
+	// store persists changes. If it is nil, the AuthDB is kept only in memory.
+	store AuthStore
+	// ops counts the ops logged since the last snapshot.
+	ops int
+	// lock guards the state of the AuthDB.
+	// Changes hold it for writing, which also keeps the log in the order the changes are applied in.
+	// Methods that only read hold it for reading, so that they do not wait for each other.
+	lock sync.RWMutex
+	// challenges are the logins waiting for a second factor, keyed by challenge token.
+	challenges map[string]*loginChallenge
+	// accounts throttles failed logins per user ID.
+	accounts *loginThrottle
+	// ips throttles failed logins per IP address.
+	ips *loginThrottle
+	// mailing counts the password reset mails being sent in the background.
+	mailing sync.WaitGroup
+	// loginDone is signalled whenever a login in flight has checked its password.
+	loginDone *sync.Cond
+	// resetAccounts throttles password reset requests per account.
+	resetAccounts *loginThrottle
+	// resetIPs throttles password reset requests per IP address.
+	resetIPs *loginThrottle
+	// authCodes are the authorization codes waiting to be exchanged for tokens.
+	authCodes map[string]*authCode
+	// externalLogins are the logins waiting for an identity provider, keyed by state.
+	externalLogins map[string]*externalLogin
+	// auditLock serializes appends to the audit log so that its hash chain has no forks.
+	auditLock sync.Mutex
+	// auditHead is the event that the next one is chained to, or nil if the log has not been read yet.
+	auditHead *AuditHead
+	// ceremonies are the passkey registrations and logins waiting for the browser, keyed by challenge.
+	ceremonies map[string]*passkeyCeremony
 }
 
-// GetUserFromRequest returns the user associated with the given request.
-// If the user is not found, it returns nil.
+// GetUserFromRequest returns the ID of the user associated with the given request.
+// The request is authenticated either by a session in the X-User and X-Token headers
+// or by an API key in the X-API-Key header, which must be scoped for the request.
+// If there is no valid session or key, it returns "".
+// Using a session extends its idle expiry.
 func (s *AuthDB) GetUserFromRequest(r *http.Request) string {
+	if r.Header.Get("X-API-Key") != "" {
+		return s.checkAPIKey(r)
+	}
 	userID := r.Header.Get("X-User")
 	sessionToken := r.Header.Get("X-Token")
-	user, ok := s.Users[userID]
-	if !ok {
+	if !s.checkSession(userID, sessionToken) {
 		return ""
 	}
-	if user.SessionTokens[sessionToken] {
-		return userID
-	}
-	return ""
+	return userID
 }
 
-// Invite creates a new registration code and returns it.
-func (s *AuthDB) Invite() string {
-	registrationCode := s.newID("registration_code")
-	s.RegistrationCodes[registrationCode] = true
-	return registrationCode
+// Invite creates a new single-use registration code that does not expire and returns it.
+// Use CreateInvite for more control.
+func (s *AuthDB) Invite() (string, error) {
+	invite, err := s.CreateInvite("", &Invite{MaxUses: 1})
+	if err != nil {
+		return "", err
+	}
+	return invite.Code, nil
 }
 
 // Register creates a new user with the given password and returns the new user's ID.
-// If it returns an error, it will be of type ErrInvalidRegistrationCode.
+// The user is given RoleMember and the role and org of the invite, if it has them.
+// If it returns an error, it will be of type ErrInvalidRegistrationCode, ErrPasswordTooShort,
+// ErrPasswordTooLong or ErrPasswordTooWeak.
 func (s *AuthDB) Register(registrationCode, password string) (string, error) {
-	if !s.RegistrationCodes[registrationCode] {
+	if registrationCode == "" {
 		return "", ErrInvalidRegistrationCode
 	}
-	delete(s.RegistrationCodes, registrationCode)
+	hash, err := s.hashPassword(password)
+	if err != nil {
+		return "", err
+	}
+	user := newUser(hash)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.addUser(user, registrationCode)
+}
+
+// addUser adds user with RoleMember and returns its new ID.
+// If registrationCode is not empty, the invite is redeemed and its role and org are given to the user.
+// Users join an org that the AuthDB knows as OrgRoleViewer.
+// If it returns an error, it may be of type ErrInvalidRegistrationCode.
+// The caller must hold s.lock.
+func (s *AuthDB) addUser(user *User, registrationCode string) (string, error) {
+	user.Roles = []string{RoleMember}
 	userID := s.newID("user")
-	user := &User{
-		PasswordHash:  passwordHash(password),
-		SessionTokens: map[string]bool{},
+	if registrationCode != "" {
+		invite, err := s.redeemInvite(registrationCode, userID)
+		if err != nil {
+			return "", err
+		}
+		if invite.Role != "" && invite.Role != RoleMember {
+			user.Roles = append(user.Roles, invite.Role)
+		}
+		if invite.Org != "" {
+			user.Orgs = []string{invite.Org}
+		}
+	}
+	err := s.commit("users", userID, user)
+	if err != nil {
+		return "", err
+	}
+	if len(user.Orgs) != 0 {
+		if org, ok := s.Orgs[user.Orgs[0]]; ok {
+			org = org.clone()
+			err = s.addOrgMember(org, userID, OrgRoleViewer)
+			if err != nil {
+				return "", err
+			}
+			err = s.commit("orgs", org.ID, org)
+			if err != nil {
+				return "", err
+			}
+		}
 	}
-	s.Users[userID] = user
 	return userID, nil
 }
 
 // Login returns a new session token for the given user ID and password.
-// If it returns an error, it will be of type ErrInvalidPassword or ErrUserNotFound.
+// If it returns an error, it will be of type ErrInvalidPassword, ErrUserNotFound,
+// ErrTooManyAttempts, ErrUserDisabled or ErrSecondFactorRequired.
+// Users with a second factor must log in with LoginWithSecondFactor instead.
 func (s *AuthDB) Login(userID, password string) (string, error) {
+	err := s.authenticate(userID, password, "")
+	if err != nil {
+		return "", err
+	}
+	if s.secondFactorRequired(userID) {
+		return "", ErrSecondFactorRequired
+	}
+	return s.startSession(userID, "", "")
+}
+
+// authenticate checks the password of the given user logging in from the given IP.
+// The ip may be empty, in which case only the account is throttled.
+// If it returns an error, it will be of type ErrInvalidPassword, ErrUserNotFound, ErrTooManyAttempts or ErrUserDisabled.
+func (s *AuthDB) authenticate(userID, password, ip string) error {
+	s.lock.Lock()
+	for {
+		if s.loginWait(userID, ip) > 0 {
+			s.lock.Unlock()
+			return ErrTooManyAttempts
+		}
+		// Parallel guesses must not all get past the wait while the hashes are computed,
+		// so logins beyond what could fail without a wait take turns with those in flight.
+		if s.loginAdmitted(userID, ip) {
+			break
+		}
+		s.loginsInFlight().Wait()
+	}
+	user, ok := s.Users[userID]
+	s.loginStarted(userID, ip)
+	s.lock.Unlock()
+	matches := ok && s.passwordMatches(user.PasswordHash, password)
+	s.lock.Lock()
+	s.loginFinished(userID, ip)
+	if !matches {
+		s.loginFailed(userID, ip)
+	}
+	s.lock.Unlock()
+	if !ok {
+		return ErrUserNotFound
+	}
+	if !matches {
+		return ErrInvalidPassword
+	}
+	if user.Disabled {
+		return ErrUserDisabled
+	}
+	s.upgradePasswordHash(userID, user.PasswordHash, password)
+	return nil
+}
+
+// startSession creates a new session for the given user and returns its token.
+// The ip and userAgent describe the client and may be empty.
+func (s *AuthDB) startSession(userID, ip, userAgent string) (string, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
 	user, ok := s.Users[userID]
 	if !ok {
 		return "", ErrUserNotFound
 	}
-	if !passwordHashMatches(password, user.PasswordHash) {
-		return "", ErrInvalidPassword
+	// The login is complete, so earlier failures no longer count.
+	s.accountThrottle().reset(userID)
+	now := s.now()
+	if s.StatelessSessions {
+		return s.issueSessionToken(userID, s.newID("session"), now.Unix())
+	}
+	user = user.clone()
+	for token, session := range user.Sessions {
+		if session.Expired(now) {
+			delete(user.Sessions, token)
+		}
 	}
 	sessionToken := s.newID("session_token")
-	user.SessionTokens[sessionToken] = true
+	session := s.newSessionInfo(now)
+	session.IP = ip
+	session.UserAgent = userAgent
+	user.Sessions[sessionToken] = session
+	err := s.commit("users", userID, user)
+	if err != nil {
+		return "", err
+	}
 	return sessionToken, nil
 }
 
-// User returns the User with the given ID.
+// newSessionInfo returns the info for a session created at the given time.
+// The caller must hold s.lock.
+func (s *AuthDB) newSessionInfo(now time.Time) *SessionInfo {
+	session := &SessionInfo{
+		ID:         s.newID("session"),
+		CreatedAt:  now.Unix(),
+		LastSeenAt: now.Unix(),
+	}
+	if s.SessionIdleTimeout != 0 {
+		session.IdleExpiresAt = now.Add(s.SessionIdleTimeout).Unix()
+	}
+	if s.SessionMaxAge != 0 {
+		session.ExpiresAt = now.Add(s.SessionMaxAge).Unix()
+	}
+	return session
+}
+
+// checkSession returns true if the token belongs to an unexpired session of the given user.
+// A valid session has its idle expiry extended; an expired one is removed.
+// Most calls need neither, so the session is first looked at under the read lock.
+func (s *AuthDB) checkSession(userID, sessionToken string) bool {
+	s.lock.RLock()
+	valid, stale := s.peekSession(userID, sessionToken)
+	s.lock.RUnlock()
+	if !stale {
+		return valid
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	// The session may have changed between the locks.
+	valid, stale = s.peekSession(userID, sessionToken)
+	if !stale {
+		return valid
+	}
+	user := s.Users[userID]
+	if !valid {
+		user = user.clone()
+		delete(user.Sessions, sessionToken)
+		s.commit("users", userID, user)
+		return false
+	}
+	now := s.now()
+	user = user.clone()
+	session := user.Sessions[sessionToken]
+	session.LastSeenAt = now.Unix()
+	if s.SessionIdleTimeout != 0 {
+		session.IdleExpiresAt = now.Add(s.SessionIdleTimeout).Unix()
+	}
+	if session.ExpiresAt != 0 && session.IdleExpiresAt > session.ExpiresAt {
+		session.IdleExpiresAt = session.ExpiresAt
+	}
+	s.commit("users", userID, user)
+	return true
+}
+
+// peekSession returns whether the token belongs to an unexpired session of the given user, and whether
+// the session must be written: removed because it has expired, or touched because it was last seen
+// more than sessionTouchInterval ago.
+// The caller must hold s.lock.
+func (s *AuthDB) peekSession(userID, sessionToken string) (valid, stale bool) {
+	user, ok := s.Users[userID]
+	if !ok || user.Disabled {
+		return false, false
+	}
+	if isStatelessToken(sessionToken) {
+		if !s.StatelessSessions {
+			return false, false
+		}
+		_, err := s.parseSessionToken(userID, sessionToken)
+		return err == nil, false
+	}
+	session, ok := user.Sessions[sessionToken]
+	if !ok {
+		return false, false
+	}
+	now := s.now()
+	if session.Expired(now) {
+		return false, true
+	}
+	return true, now.Unix()-session.LastSeenAt >= int64(sessionTouchInterval/time.Second)
+}
+
+// Sessions returns the unexpired sessions of the given user, oldest first.
+// If it returns an error, it will be of type ErrUserNotFound.
+func (s *AuthDB) Sessions(userID string) ([]*SessionInfo, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	user, ok := s.Users[userID]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	now := s.now()
+	sessions := []*SessionInfo{}
+	for _, session := range user.Sessions {
+		if !session.Expired(now) {
+			sessions = append(sessions, session)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].CreatedAt < sessions[j].CreatedAt
+	})
+	return sessions, nil
+}
+
+// RevokeSession ends the session of the given user with the given session ID.
+// If it returns an error, it will be of type ErrUserNotFound or ErrSessionNotFound.
+func (s *AuthDB) RevokeSession(userID, sessionID string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	user, ok := s.Users[userID]
+	if !ok {
+		return ErrUserNotFound
+	}
+	for token, session := range user.Sessions {
+		if session.ID == sessionID {
+			user = user.clone()
+			delete(user.Sessions, token)
+			return s.commit("users", userID, user)
+		}
+	}
+	return ErrSessionNotFound
+}
+
+// RevokeSessions ends every session of the given user.
+// If it returns an error, it will be of type ErrUserNotFound.
+func (s *AuthDB) RevokeSessions(userID string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	user, ok := s.Users[userID]
+	if !ok {
+		return ErrUserNotFound
+	}
+	err := s.revokeUserSessionTokens(userID)
+	if err != nil {
+		return err
+	}
+	user = user.clone()
+	user.Sessions = map[string]*SessionInfo{}
+	return s.commit("users", userID, user)
+}
+
+// Logout invalidates the given session token.
+// Unknown tokens are ignored.
+// If it returns an error, it may be of type ErrUserNotFound.
+func (s *AuthDB) Logout(userID, sessionToken string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	user, ok := s.Users[userID]
+	if !ok {
+		return ErrUserNotFound
+	}
+	if isStatelessToken(sessionToken) {
+		return s.revokeSessionToken(userID, sessionToken)
+	}
+	_, ok = user.Sessions[sessionToken]
+	if !ok {
+		return nil
+	}
+	user = user.clone()
+	delete(user.Sessions, sessionToken)
+	return s.commit("users", userID, user)
+}
+
+// User returns a copy of the User with the given ID.
 func (s *AuthDB) User(id string) (*User, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
 	u, ok := s.Users[id]
-	return u, ok
+	if !ok {
+		return nil, false
+	}
+	return u.clone(), true
 }
 
 // ServeHTTP serves the authentication server.
-// There are 5 endpoints: /invite, /register, /login, /logout, and /user.
+// There are 66 endpoints: /invite, /invites, /revoke-invite, /register, /login, /verify-login, /logout,
+// /validate-session, /refresh-session, /session-revocations, /user, /sessions, /revoke-session, /revoke-sessions, /enroll-totp, /confirm-totp, /disable-totp,
+// /begin-passkey-registration, /finish-passkey-registration, /begin-passkey-login, /finish-passkey-login, /passkeys, /remove-passkey,
+// /create-api-key, /api-keys, /revoke-api-key, /unlock, /add-email, /remove-email, /verify-email,
+// /forgot-password, /reset-password, /identities, /unlink-identity, the org endpoints /create-org, /orgs, /org,
+// /authorize-org, /invite-to-org, /org-invitations, /accept-org-invitation, /decline-org-invitation,
+// /revoke-org-invitation, /set-org-role, /remove-org-member and /transfer-org-ownership, the browser endpoints /oauth/start
+// and /oauth/callback for logging in with an identity provider, the admin endpoints /users, /disable-user, /delete-user,
+// /logout-user, /set-password, /set-roles, /audit-log, /export-audit-log, /oauth-clients, /create-oauth-client, /delete-oauth-client
+// and /rotate-signing-key, and the OpenID Connect provider endpoints /.well-known/openid-configuration,
+// /jwks, /authorize, /token, /introspect and /userinfo.
+// Browsers asking for HTML or posting forms to /login, /register and /logout get the pages of AuthPages.
+// Failed requests are answered with a JSON encoded Error, except that the OpenID Connect endpoints
+// answer the way OAuth 2.0 clients expect.
 func (s *AuthDB) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	switch r.URL.Path {
 	case "/invite":
 		s.invite(w, r)
-	case "/register":
-		s.register(w, r)
-	case "/login":
-		s.login(w, r)
-	case "/logout":
-		s.logout(w, r)
+	case "/register", "/login", "/logout":
+		if isBrowserRequest(r) {
+			s.pages().ServeHTTP(w, r)
+			return
+		}
+		switch r.URL.Path {
+		case "/register":
+			s.register(w, r)
+		case "/login":
+			s.login(w, r)
+		case "/logout":
+			s.logout(w, r)
+		}
+	case "/verify-login":
+		s.verifyLogin(w, r)
+	case "/validate-session":
+		s.validateSession(w, r)
 	case "/user":
 		s.user(w, r)
+	case "/sessions":
+		s.sessions(w, r)
+	case "/revoke-session":
+		s.revokeSession(w, r)
+	case "/revoke-sessions":
+		s.revokeSessions(w, r)
+	case "/enroll-totp":
+		s.enrollTOTP(w, r)
+	case "/confirm-totp":
+		s.confirmTOTP(w, r)
+	case "/disable-totp":
+		s.disableTOTP(w, r)
+	case "/begin-passkey-registration":
+		s.beginPasskeyRegistration(w, r)
+	case "/finish-passkey-registration":
+		s.finishPasskeyRegistration(w, r)
+	case "/begin-passkey-login":
+		s.beginPasskeyLogin(w, r)
+	case "/finish-passkey-login":
+		s.finishPasskeyLogin(w, r)
+	case "/passkeys":
+		s.passkeys(w, r)
+	case "/remove-passkey":
+		s.removePasskey(w, r)
+	case "/create-api-key":
+		s.createAPIKey(w, r)
+	case "/api-keys":
+		s.apiKeys(w, r)
+	case "/revoke-api-key":
+		s.revokeAPIKey(w, r)
+	case "/unlock":
+		s.unlock(w, r)
+	case "/add-email":
+		s.addEmail(w, r)
+	case "/remove-email":
+		s.removeEmail(w, r)
+	case "/verify-email":
+		s.verifyEmail(w, r)
+	case "/forgot-password":
+		s.forgotPassword(w, r)
+	case "/reset-password":
+		s.resetPassword(w, r)
+	case "/invites":
+		s.invites(w, r)
+	case "/revoke-invite":
+		s.revokeInvite(w, r)
+	case "/.well-known/openid-configuration":
+		s.discovery(w, r)
+	case "/jwks":
+		s.jwks(w, r)
+	case "/authorize":
+		s.oidcAuthorize(w, r)
+	case "/token":
+		s.token(w, r)
+	case "/introspect":
+		s.introspect(w, r)
+	case "/userinfo":
+		s.userinfo(w, r)
+	case "/oauth/start":
+		s.oauthStart(w, r)
+	case "/oauth/callback":
+		s.oauthCallback(w, r)
+	case "/refresh-session":
+		s.refreshSession(w, r)
+	case "/session-revocations":
+		s.sessionRevocations(w, r)
+	case "/identities":
+		s.identities(w, r)
+	case "/unlink-identity":
+		s.unlinkIdentity(w, r)
+	case "/oauth-clients", "/create-oauth-client", "/delete-oauth-client", "/rotate-signing-key":
+		s.oauthClients(w, r)
+	case "/create-org", "/orgs", "/org", "/authorize-org", "/invite-to-org", "/org-invitations", "/accept-org-invitation",
+		"/decline-org-invitation", "/revoke-org-invitation", "/set-org-role", "/remove-org-member", "/transfer-org-ownership":
+		s.orgs(w, r)
+	case "/audit-log", "/export-audit-log":
+		s.auditEvents(w, r)
+	case "/users", "/disable-user", "/delete-user", "/logout-user", "/set-password", "/set-roles":
+		s.admin(w, r)
 	default:
 		w.WriteHeader(http.StatusNotFound)
 	}
 }
 
 // invite handles an invite request.
-// It creates a new registration code and returns it.
+// It creates a new invite and returns it.
+// The request must carry either the registrar key or a session of a user with RoleRegistrar.
+// Only admins can create invites that give a role other than RoleMember or add users to an org.
 func (s *AuthDB) invite(w http.ResponseWriter, r *http.Request) {
 	var req struct {
+		Session
 		RegistrarKey string `json:"registrar_key"`
+		ExpiresAt    int64  `json:"expires_at"`
+		MaxUses      int    `json:"max_uses"`
+		Role         string `json:"role"`
+		Org          string `json:"org"`
+		Note         string `json:"note"`
 	}
+	req.MaxUses = 1
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte(err.Error()))
 		return
 	}
-	if req.RegistrarKey != s.RegistrarKey {
-		w.WriteHeader(http.StatusUnauthorized)
+	privileged := req.Role != "" && req.Role != RoleMember || req.Org != ""
+	if req.UserID != "" {
+		role := RoleRegistrar
+		if privileged {
+			role = RoleAdmin
+		}
+		err = s.authorize(&req.Session, role)
+		if err == ErrInvalidSession {
+			writeError(w, http.StatusUnauthorized, err)
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusForbidden, err)
+			return
+		}
+	} else if req.RegistrarKey == "" || req.RegistrarKey != s.RegistrarKey {
+		writeError(w, http.StatusUnauthorized, ErrInvalidRegistrarKey)
+		return
+	} else if privileged {
+		writeError(w, http.StatusForbidden, ErrForbidden)
+		return
+	}
+	invite, err := s.CreateInvite(req.UserID, &Invite{
+		ExpiresAt: req.ExpiresAt,
+		MaxUses:   req.MaxUses,
+		Role:      req.Role,
+		Org:       req.Org,
+		Note:      req.Note,
+	})
+	s.audit(r, AuditInviteCreated, req.UserID, "", err)
+	if err != nil {
+		ServeInternalServerError(w, r)
 		return
 	}
-	registrationCode := s.Invite()
-	json.NewEncoder(w).Encode(registrationCode)
+	json.NewEncoder(w).Encode(invite)
 }
 
 // register handles a registration request.
@@ -136,12 +704,26 @@ func (s *AuthDB) register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	userID, err := s.Register(req.RegistrationCode, req.Password)
+	s.audit(r, AuditRegister, userID, userID, err)
+	if err == ErrInvalidRegistrationCode {
+		writeError(w, http.StatusUnauthorized, err)
+		return
+	}
+	if isPasswordPolicyError(err) {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err != nil {
+		ServeInternalServerError(w, r)
+		return
+	}
 	json.NewEncoder(w).Encode(userID)
 }
 
 // login handles a login request.
 // It returns a session token that can be used to validate a session.
-// The session token is valid until the user logs out.
+// The session token is valid until the user logs out or the session expires.
+// If the user has a second factor, it returns a challenge to be answered at /verify-login instead.
 func (s *AuthDB) login(w http.ResponseWriter, r *http.Request) {
 	var req LoginRequeset
 	err := json.NewDecoder(r.Body).Decode(&req)
@@ -150,21 +732,51 @@ func (s *AuthDB) login(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(err.Error()))
 		return
 	}
-	user, ok := s.Users[req.UserID]
-	if !ok {
-		w.WriteHeader(http.StatusUnauthorized)
+	resp, err := s.loginFrom(req.UserID, req.Password, s.clientIP(r), r.UserAgent())
+	s.auditLogin(r, req.UserID, resp, err)
+	if err == ErrTooManyAttempts {
+		writeTooManyAttempts(w, s.retryAfter(req.UserID, s.clientIP(r)))
 		return
 	}
-	if user.PasswordHash != passwordHash(req.Password) {
-		w.WriteHeader(http.StatusUnauthorized)
+	if err == ErrUserDisabled {
+		writeError(w, http.StatusForbidden, err)
 		return
 	}
-	token := s.newID(req.UserID)
-	user.SessionTokens[token] = true
-	json.NewEncoder(w).Encode(&Session{
-		UserID: req.UserID,
-		Token:  token,
-	})
+	if err == ErrInvalidPassword || err == ErrUserNotFound {
+		writeError(w, http.StatusUnauthorized, err)
+		return
+	}
+	if err != nil {
+		ServeInternalServerError(w, r)
+		return
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// loginFrom logs a user in from the given IP address and user agent.
+// If the user has a second factor, the response holds a challenge instead of a session token.
+// If it returns an error, it may be of type ErrInvalidPassword, ErrUserNotFound, ErrTooManyAttempts or ErrUserDisabled.
+func (s *AuthDB) loginFrom(userID, password, ip, userAgent string) (*LoginResponse, error) {
+	err := s.authenticate(userID, password, ip)
+	if err != nil {
+		return nil, err
+	}
+	if s.secondFactorRequired(userID) {
+		return &LoginResponse{
+			Session:   Session{UserID: userID},
+			Challenge: s.newLoginChallenge(userID, ip, userAgent),
+		}, nil
+	}
+	token, err := s.startSession(userID, ip, userAgent)
+	if err != nil {
+		return nil, err
+	}
+	return &LoginResponse{
+		Session: Session{
+			UserID: userID,
+			Token:  token,
+		},
+	}, nil
 }
 
 // logout handles a logout request.
@@ -177,12 +789,16 @@ func (s *AuthDB) logout(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(err.Error()))
 		return
 	}
-	user, ok := s.Users[session.UserID]
-	if !ok {
-		w.WriteHeader(http.StatusUnauthorized)
+	err = s.Logout(session.UserID, session.Token)
+	s.audit(r, AuditLogout, session.UserID, session.UserID, err)
+	if err == ErrUserNotFound {
+		writeError(w, http.StatusUnauthorized, err)
+		return
+	}
+	if err != nil {
+		ServeInternalServerError(w, r)
 		return
 	}
-	delete(user.SessionTokens, session.Token)
 }
 
 // validateSession handles a session validation request.
@@ -195,14 +811,8 @@ func (server *AuthDB) validateSession(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(err.Error()))
 		return
 	}
-	user, ok := server.Users[session.UserID]
-	if !ok {
-		w.WriteHeader(http.StatusUnauthorized)
-		return
-	}
-	ok = user.SessionTokens[session.Token]
-	if !ok {
-		w.WriteHeader(http.StatusUnauthorized)
+	if !server.checkSession(session.UserID, session.Token) {
+		writeError(w, http.StatusUnauthorized, ErrInvalidSession)
 		return
 	}
 }
@@ -216,29 +826,275 @@ func (s *AuthDB) user(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(err.Error()))
 		return
 	}
+	if !s.checkSession(session.UserID, session.Token) {
+		writeError(w, http.StatusUnauthorized, ErrInvalidSession)
+		return
+	}
 	user, ok := s.User(session.UserID)
 	if !ok {
-		w.WriteHeader(http.StatusUnauthorized)
+		writeError(w, http.StatusUnauthorized, ErrInvalidSession)
 		return
 	}
-	ok = user.SessionTokens[session.Token]
-	if !ok {
-		w.WriteHeader(http.StatusUnauthorized)
+	json.NewEncoder(w).Encode(user.redacted())
+}
+
+// sessions handles requests to list the active sessions of a user.
+func (s *AuthDB) sessions(w http.ResponseWriter, r *http.Request) {
+	var session Session
+	err := json.NewDecoder(r.Body).Decode(&session)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if !s.checkSession(session.UserID, session.Token) {
+		writeError(w, http.StatusUnauthorized, ErrInvalidSession)
+		return
+	}
+	sessions, err := s.Sessions(session.UserID)
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, ErrInvalidSession)
+		return
+	}
+	json.NewEncoder(w).Encode(sessions)
+}
+
+// revokeSession handles requests to end one session of a user.
+// The session to end is identified by its ID, as returned by /sessions.
+func (s *AuthDB) revokeSession(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Session
+		SessionID string `json:"session_id"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if !s.checkSession(req.UserID, req.Token) {
+		writeError(w, http.StatusUnauthorized, ErrInvalidSession)
+		return
+	}
+	err = s.RevokeSession(req.UserID, req.SessionID)
+	s.audit(r, AuditRevokeSession, req.UserID, req.UserID, err)
+	if err == ErrSessionNotFound {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		ServeInternalServerError(w, r)
+		return
+	}
+}
+
+// revokeSessions handles requests to end every session of a user, including the current one.
+func (s *AuthDB) revokeSessions(w http.ResponseWriter, r *http.Request) {
+	var session Session
+	err := json.NewDecoder(r.Body).Decode(&session)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if !s.checkSession(session.UserID, session.Token) {
+		writeError(w, http.StatusUnauthorized, ErrInvalidSession)
+		return
+	}
+	err = s.RevokeSessions(session.UserID)
+	s.audit(r, AuditRevokeSessions, session.UserID, session.UserID, err)
+	if err != nil {
+		ServeInternalServerError(w, r)
 		return
 	}
-	json.NewEncoder(w).Encode(user)
 }
 
 // newID generates a new unique ID for the given scope.
+// The caller must hold s.lock.
 func (s *AuthDB) newID(scope string) string {
-	s.locks[scope].Lock()
-	defer s.locks[scope].Unlock()
 	return NewID()
 }
 
-// generateRegistrationCode generates a new registration code.
-func (s *AuthDB) generateRegistrationCode() string {
-	code := s.newID("registration_code")
-	s.RegistrationCodes[code] = true
-	return code
+// commit logs a change to the store and then applies it.
+// A nil v deletes the key.
+// Once the change is logged it has happened, so a failed snapshot is reported to OnSnapshotError instead of returned.
+// The caller must hold s.lock.
+func (s *AuthDB) commit(table, key string, v any) error {
+	op := &AuthOp{
+		Table: table,
+		Key:   key,
+	}
+	if v != nil {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		op.Value = b
+	}
+	if s.store != nil {
+		err := s.store.Append(op)
+		if err != nil {
+			return err
+		}
+	}
+	err := s.apply(op)
+	if err != nil {
+		return err
+	}
+	if s.store == nil {
+		return nil
+	}
+	s.ops++
+	if s.ops < authSnapshotInterval {
+		return nil
+	}
+	err = s.snapshot()
+	if err != nil && s.OnSnapshotError != nil {
+		s.OnSnapshotError(err)
+	}
+	return nil
+}
+
+// apply applies an op to the in-memory state.
+func (s *AuthDB) apply(op *AuthOp) error {
+	switch op.Table {
+	case "users":
+		if op.Value == nil {
+			delete(s.Users, op.Key)
+			return nil
+		}
+		var user User
+		err := json.Unmarshal(op.Value, &user)
+		if err != nil {
+			return err
+		}
+		s.upgradeUser(&user)
+		s.Users[op.Key] = &user
+	case "invitations":
+		if op.Value == nil {
+			delete(s.Invitations, op.Key)
+			return nil
+		}
+		var invite Invite
+		err := json.Unmarshal(op.Value, &invite)
+		if err != nil {
+			return err
+		}
+		s.Invitations[op.Key] = &invite
+	case "oauth_clients":
+		if op.Value == nil {
+			delete(s.OAuthClients, op.Key)
+			return nil
+		}
+		var client OAuthClient
+		err := json.Unmarshal(op.Value, &client)
+		if err != nil {
+			return err
+		}
+		s.OAuthClients[op.Key] = &client
+	case "signing_keys":
+		if op.Value == nil {
+			delete(s.SigningKeys, op.Key)
+			return nil
+		}
+		var key SigningKey
+		err := json.Unmarshal(op.Value, &key)
+		if err != nil {
+			return err
+		}
+		s.SigningKeys[op.Key] = &key
+	case "orgs":
+		if op.Value == nil {
+			delete(s.Orgs, op.Key)
+			return nil
+		}
+		var org Organization
+		err := json.Unmarshal(op.Value, &org)
+		if err != nil {
+			return err
+		}
+		s.Orgs[op.Key] = &org
+	case "revoked_sessions", "revoked_users":
+		m := s.RevokedSessions
+		if op.Table == "revoked_users" {
+			m = s.RevokedUsers
+		}
+		if op.Value == nil {
+			delete(m, op.Key)
+			return nil
+		}
+		var v int64
+		err := json.Unmarshal(op.Value, &v)
+		if err != nil {
+			return err
+		}
+		m[op.Key] = v
+	case "audit_head":
+		var head AuditHead
+		err := json.Unmarshal(op.Value, &head)
+		if err != nil {
+			return err
+		}
+		s.AuditHead = &head
+	case "registration_codes":
+		// Logs written before invites were added use this table.
+		if op.Value == nil {
+			delete(s.Invitations, op.Key)
+			return nil
+		}
+		s.Invitations[op.Key] = legacyInvite(op.Key)
+	default:
+		return fmt.Errorf("unknown auth table %q", op.Table)
+	}
+	return nil
+}
+
+// upgradeUser moves the user's deprecated SessionTokens into Sessions.
+func (s *AuthDB) upgradeUser(user *User) {
+	if user.Sessions == nil {
+		user.Sessions = map[string]*SessionInfo{}
+	}
+	now := s.now()
+	for token := range user.SessionTokens {
+		user.Sessions[token] = s.newSessionInfo(now)
+	}
+	user.SessionTokens = nil
+}
+
+// now returns the current time.
+func (s *AuthDB) now() time.Time {
+	if s.Clock != nil {
+		return s.Clock()
+	}
+	return time.Now()
+}
+
+// snapshot saves the full state to the store.
+// The caller must hold s.lock.
+func (s *AuthDB) snapshot() error {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	err = s.store.Snapshot(b)
+	if err != nil {
+		return err
+	}
+	s.ops = 0
+	return nil
+}
+
+// Close waits for the mails being sent, saves a final snapshot and closes the store.
+func (s *AuthDB) Close() error {
+	s.mailing.Wait()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.store == nil {
+		return nil
+	}
+	err := s.snapshot()
+	if err != nil {
+		return err
+	}
+	return s.store.Close()
 }
@@ -0,0 +1,265 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// snapshotFailingStore keeps ops in memory and fails to save snapshots while err is set.
+type snapshotFailingStore struct {
+	ops []*AuthOp
+	err error
+}
+
+func (s *snapshotFailingStore) Load() ([]byte, []*AuthOp, error) {
+	return nil, s.ops, nil
+}
+
+func (s *snapshotFailingStore) Append(op *AuthOp) error {
+	s.ops = append(s.ops, op)
+	return nil
+}
+
+func (s *snapshotFailingStore) Snapshot(state []byte) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.ops = nil
+	return nil
+}
+
+func (s *snapshotFailingStore) Close() error {
+	return nil
+}
+
+func TestCommitReportsSnapshotFailures(t *testing.T) {
+	store := &snapshotFailingStore{}
+	db, err := OpenAuthDB(store, "correct horse", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reported []error
+	db.OnSnapshotError = func(err error) {
+		reported = append(reported, err)
+	}
+	store.err = errors.New("disk full")
+	db.ops = authSnapshotInterval - 1
+	_, err = db.Invite()
+	if err != nil {
+		t.Fatalf("logged change returned %v", err)
+	}
+	if len(reported) != 1 || reported[0] != store.err {
+		t.Fatalf("reported %v", reported)
+	}
+	if len(store.ops) != 1 {
+		t.Fatalf("%d ops logged", len(store.ops))
+	}
+	store.err = nil
+	_, err = db.Invite()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reported) != 1 || len(store.ops) != 0 || db.ops != 0 {
+		t.Fatalf("snapshot was not retried: %d reports, %d ops", len(reported), len(store.ops))
+	}
+}
+
+func TestConcurrentClients(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewFileAuthStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := OpenAuthDB(store, "correct horse", "registrar key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(db)
+	defer srv.Close()
+	c := &AuthClient{AuthServerAddr: srv.URL}
+	session := func() error {
+		code, err := c.CreateInviteCode(ctx, "registrar key")
+		if err != nil {
+			return err
+		}
+		userID, err := c.Register(ctx, code, "battery staple")
+		if err != nil {
+			return err
+		}
+		login, err := c.Login(ctx, userID, "battery staple")
+		if err != nil {
+			return err
+		}
+		err = c.ValidateSession(ctx, &login.Session)
+		if err != nil {
+			return err
+		}
+		db.User(userID)
+		db.FindUsers("")
+		db.Sessions(userID)
+		db.HasRole(userID, RoleMember)
+		_, err = c.User(ctx, &login.Session)
+		if err != nil {
+			return err
+		}
+		err = c.Logout(ctx, &login.Session)
+		if err != nil {
+			return err
+		}
+		if c.ValidateSession(ctx, &login.Session) == nil {
+			return errors.New("session still valid after logout")
+		}
+		return nil
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error, 32)
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- session()
+			errs <- session()
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(db.FindUsers("")) != 33 {
+		t.Fatalf("%d users", len(db.FindUsers("")))
+	}
+	reopened, err := OpenAuthDB(store, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reopened.FindUsers("")) != 33 {
+		t.Fatalf("%d users after reopening", len(reopened.FindUsers("")))
+	}
+}
+
+// newSessionClock returns an AuthDB with the given session timeouts and a clock the test controls.
+func newSessionClock(idle, maxAge time.Duration) (*AuthDB, *time.Time) {
+	now := time.Unix(1000000, 0)
+	db := NewAuthDB("correct horse", "")
+	db.Clock = func() time.Time { return now }
+	db.SessionIdleTimeout = idle
+	db.SessionMaxAge = maxAge
+	return db, &now
+}
+
+func TestSessionIdleTimeout(t *testing.T) {
+	db, now := newSessionClock(time.Hour, 0)
+	token, err := db.Login("admin", "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	idle, err := db.Login("admin", "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Each use slides the idle expiry of the session that is used.
+	for i := 0; i < 3; i++ {
+		*now = now.Add(50 * time.Minute)
+		if !db.checkSession("admin", token) {
+			t.Fatalf("session in use expired after %d checks", i)
+		}
+	}
+	if db.checkSession("admin", idle) {
+		t.Fatal("idle session is still valid")
+	}
+	sessions, err := db.Sessions("admin")
+	if err != nil || len(sessions) != 1 {
+		t.Fatalf("%d sessions: %v", len(sessions), err)
+	}
+	if sessions[0].LastSeenAt != now.Unix() || sessions[0].IdleExpiresAt != now.Add(time.Hour).Unix() {
+		t.Fatalf("%+v", sessions[0])
+	}
+	*now = now.Add(time.Hour)
+	if db.checkSession("admin", token) {
+		t.Fatal("session is valid after the idle timeout")
+	}
+	db.lock.RLock()
+	left := len(db.Users["admin"].Sessions)
+	db.lock.RUnlock()
+	if left != 0 {
+		t.Fatalf("%d expired sessions kept", left)
+	}
+}
+
+func TestSessionMaxAge(t *testing.T) {
+	db, now := newSessionClock(time.Hour, 90*time.Minute)
+	token, err := db.Login("admin", "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	*now = now.Add(50 * time.Minute)
+	if !db.checkSession("admin", token) {
+		t.Fatal("session expired early")
+	}
+	sessions, _ := db.Sessions("admin")
+	// The idle expiry slides no further than the absolute one.
+	if sessions[0].IdleExpiresAt != sessions[0].ExpiresAt || sessions[0].ExpiresAt != now.Add(40*time.Minute).Unix() {
+		t.Fatalf("%+v", sessions[0])
+	}
+	*now = now.Add(39 * time.Minute)
+	if !db.checkSession("admin", token) {
+		t.Fatal("session expired early")
+	}
+	*now = now.Add(time.Minute)
+	if db.checkSession("admin", token) {
+		t.Fatal("session is valid after SessionMaxAge")
+	}
+}
+
+func TestSessionMetadataAndRevocation(t *testing.T) {
+	db, now := newSessionClock(0, 0)
+	resp, err := db.loginFrom("admin", "correct horse", "10.0.0.1", "test-agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	*now = now.Add(time.Second)
+	other, err := db.Login("admin", "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessions, err := db.Sessions("admin")
+	if err != nil || len(sessions) != 2 {
+		t.Fatalf("%d sessions: %v", len(sessions), err)
+	}
+	first := sessions[0]
+	if first.IP != "10.0.0.1" || first.UserAgent != "test-agent" || first.ExpiresAt != 0 || first.IdleExpiresAt != 0 {
+		t.Fatalf("%+v", first)
+	}
+	*now = now.Add(365 * 24 * time.Hour)
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("X-User", "admin")
+	r.Header.Set("X-Token", resp.Token)
+	if db.GetUserFromRequest(r) != "admin" {
+		t.Fatal("session without timeouts expired")
+	}
+	err = db.RevokeSession("admin", first.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db.GetUserFromRequest(r) != "" || !db.checkSession("admin", other) {
+		t.Fatal("RevokeSession ended the wrong session")
+	}
+	err = db.RevokeSession("admin", first.ID)
+	if err != ErrSessionNotFound {
+		t.Fatalf("revoked twice: %v", err)
+	}
+	err = db.RevokeSessions("admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db.checkSession("admin", other) {
+		t.Fatal("session survived RevokeSessions")
+	}
+}
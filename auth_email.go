@@ -0,0 +1,483 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// emailVerificationTTL is how long an email verification token is valid.
+	emailVerificationTTL = 24 * time.Hour
+	// passwordResetTTL is how long a password reset token is valid.
+	passwordResetTTL = time.Hour
+)
+
+// AddEmail adds an unverified email address to the given user and mails it a verification token.
+// Adding an address the user already has sends a new token if it is still unverified.
+// If the mail cannot be sent, the address is not added.
+// If it returns an error, it may be of type ErrUserNotFound, ErrInvalidEmail or ErrMailNotConfigured.
+func (s *AuthDB) AddEmail(userID, email string) error {
+	email, err := normalizeEmail(email)
+	if err != nil {
+		return err
+	}
+	if s.Mailer == nil {
+		return ErrMailNotConfigured
+	}
+	s.lock.Lock()
+	user, ok := s.Users[userID]
+	if !ok {
+		s.lock.Unlock()
+		return ErrUserNotFound
+	}
+	if user.VerifiedEmails[email] {
+		s.lock.Unlock()
+		return nil
+	}
+	user = user.clone()
+	added := !containsString(user.Emails, email)
+	if added {
+		user.Emails = append(user.Emails, email)
+	}
+	token := s.issueToken(user, "verify-email", userID, email, emailVerificationTTL)
+	err = s.commit("users", userID, user)
+	s.lock.Unlock()
+	if err != nil {
+		return err
+	}
+	err = s.sendMail(&Mail{
+		To:      email,
+		Subject: "Verify your email address",
+		Body:    s.tokenMailBody("To verify this email address, use the following code.", "verify-email", token),
+	})
+	if err != nil {
+		s.undoAddEmail(userID, email, token, added)
+		return err
+	}
+	return nil
+}
+
+// undoAddEmail takes back an AddEmail whose mail could not be sent: it drops the token and,
+// if the address was new and is still unverified, the address too.
+func (s *AuthDB) undoAddEmail(userID, email, token string, added bool) {
+	t, err := parseToken(s.TokenSecret, token)
+	if err != nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	user, ok := s.Users[userID]
+	if !ok {
+		return
+	}
+	user = user.clone()
+	delete(user.OneTimeTokens, t.Nonce)
+	if added && !user.VerifiedEmails[email] {
+		emails := []string{}
+		for _, e := range user.Emails {
+			if e != email {
+				emails = append(emails, e)
+			}
+		}
+		user.Emails = emails
+	}
+	s.commit("users", userID, user)
+}
+
+// RemoveEmail removes an email address from the given user.
+// If it returns an error, it may be of type ErrUserNotFound or ErrEmailNotFound.
+func (s *AuthDB) RemoveEmail(userID, email string) error {
+	email, err := normalizeEmail(email)
+	if err != nil {
+		return err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	user, ok := s.Users[userID]
+	if !ok {
+		return ErrUserNotFound
+	}
+	if !containsString(user.Emails, email) {
+		return ErrEmailNotFound
+	}
+	user = user.clone()
+	emails := []string{}
+	for _, e := range user.Emails {
+		if e != email {
+			emails = append(emails, e)
+		}
+	}
+	user.Emails = emails
+	delete(user.VerifiedEmails, email)
+	return s.commit("users", userID, user)
+}
+
+// VerifyEmail marks the email address in a verification token as verified.
+// If it returns an error, it may be of type ErrInvalidToken, ErrEmailNotFound or ErrEmailTaken.
+func (s *AuthDB) VerifyEmail(token string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	t, user, err := s.useToken(token, "verify-email")
+	if err != nil {
+		return err
+	}
+	if !containsString(user.Emails, t.Email) {
+		return ErrEmailNotFound
+	}
+	if owner := s.userIDByEmail(t.Email); owner != "" && owner != t.UserID {
+		return ErrEmailTaken
+	}
+	if user.VerifiedEmails == nil {
+		user.VerifiedEmails = map[string]bool{}
+	}
+	user.VerifiedEmails[t.Email] = true
+	return s.commit("users", t.UserID, user)
+}
+
+// ForgotPassword mails a password reset token to the user with the given ID or verified email address.
+// The token goes to the given address, or to the user's first verified address if an ID is given.
+// To avoid revealing which accounts exist, it returns nil whether or not there is such a user or address,
+// and the mail is sent in the background so that the time it takes tells nothing either.
+// Mail that cannot be sent is recorded in the audit log.
+// If it returns an error, it may be of type ErrMailNotConfigured or ErrTooManyAttempts.
+func (s *AuthDB) ForgotPassword(userIDOrEmail string) error {
+	return s.forgotPasswordFrom(userIDOrEmail, "")
+}
+
+// forgotPasswordFrom is like ForgotPassword for a request from the given IP address, which may be empty.
+// Requests are throttled per account and per IP address whether or not the account exists,
+// so that the throttle reveals nothing either.
+func (s *AuthDB) forgotPasswordFrom(userIDOrEmail, ip string) error {
+	if s.Mailer == nil {
+		return ErrMailNotConfigured
+	}
+	s.lock.Lock()
+	if s.resetWait(userIDOrEmail, ip) > 0 {
+		s.lock.Unlock()
+		return ErrTooManyAttempts
+	}
+	s.resetRequested(userIDOrEmail, ip)
+	s.mailing.Add(1)
+	s.lock.Unlock()
+	go func() {
+		defer s.mailing.Done()
+		userID, err := s.mailResetToken(userIDOrEmail)
+		if err != nil {
+			s.Audit(&AuditEvent{
+				Type:    AuditPasswordForgotten,
+				UserID:  userID,
+				IP:      ip,
+				Outcome: AuditFailure,
+				Detail:  err.Error(),
+			})
+		}
+	}()
+	return nil
+}
+
+// mailResetToken mails a password reset token to the user with the given ID or verified email address,
+// if there is one, and returns the ID of the user.
+func (s *AuthDB) mailResetToken(userIDOrEmail string) (string, error) {
+	s.lock.Lock()
+	userID := userIDOrEmail
+	email := ""
+	if strings.Contains(userIDOrEmail, "@") {
+		email, _ = normalizeEmail(userIDOrEmail)
+		userID = s.userIDByEmail(email)
+	}
+	user, ok := s.Users[userID]
+	if !ok {
+		s.lock.Unlock()
+		return "", nil
+	}
+	if email == "" {
+		for _, e := range user.Emails {
+			if user.VerifiedEmails[e] {
+				email = e
+				break
+			}
+		}
+	}
+	if email == "" {
+		s.lock.Unlock()
+		return userID, nil
+	}
+	user = user.clone()
+	token := s.issueToken(user, "reset-password", userID, email, passwordResetTTL)
+	err := s.commit("users", userID, user)
+	s.lock.Unlock()
+	if err != nil {
+		return userID, err
+	}
+	return userID, s.sendMail(&Mail{
+		To:      email,
+		Subject: "Reset your password",
+		Body:    s.tokenMailBody("To choose a new password, use the following code. If you did not ask for this, you can ignore this email.", "reset-password", token),
+	})
+}
+
+// ResetPassword sets a new password using a password reset token.
+// Every session of the user is ended, along with any other emailed tokens and login lockouts.
+// If it returns an error, it may be of type ErrInvalidToken, ErrPasswordTooShort, ErrPasswordTooLong or ErrPasswordTooWeak.
+func (s *AuthDB) ResetPassword(token, password string) error {
+	hash, err := s.hashPassword(password)
+	if err != nil {
+		return err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	t, user, err := s.useToken(token, "reset-password")
+	if err != nil {
+		return err
+	}
+	user.PasswordHash = hash
+	user.Sessions = map[string]*SessionInfo{}
+	user.OneTimeTokens = nil
+	err = s.revokeUserSessionTokens(t.UserID)
+	if err != nil {
+		return err
+	}
+	err = s.commit("users", t.UserID, user)
+	if err != nil {
+		return err
+	}
+	s.accountThrottle().reset(t.UserID)
+	return nil
+}
+
+// issueToken records a new one-time token on user, which must be a clone the caller commits.
+// The caller must hold s.lock.
+func (s *AuthDB) issueToken(user *User, purpose, userID, email string, ttl time.Duration) string {
+	now := s.now()
+	for nonce, expiresAt := range user.OneTimeTokens {
+		if now.Unix() >= expiresAt {
+			delete(user.OneTimeTokens, nonce)
+		}
+	}
+	if user.OneTimeTokens == nil {
+		user.OneTimeTokens = map[string]int64{}
+	}
+	t := &oneTimeToken{
+		Purpose:   purpose,
+		UserID:    userID,
+		Email:     email,
+		Nonce:     randomToken(16),
+		ExpiresAt: now.Add(ttl).Unix(),
+	}
+	user.OneTimeTokens[t.Nonce] = t.ExpiresAt
+	return signToken(s.TokenSecret, t)
+}
+
+// useToken checks a one-time token for the given purpose and marks it as used.
+// It returns the token and a clone of its user, which the caller must commit.
+// The caller must hold s.lock.
+func (s *AuthDB) useToken(token, purpose string) (*oneTimeToken, *User, error) {
+	t, err := parseToken(s.TokenSecret, token)
+	if err != nil {
+		return nil, nil, err
+	}
+	if t.Purpose != purpose || s.now().Unix() >= t.ExpiresAt {
+		return nil, nil, ErrInvalidToken
+	}
+	user, ok := s.Users[t.UserID]
+	if !ok {
+		return nil, nil, ErrInvalidToken
+	}
+	_, ok = user.OneTimeTokens[t.Nonce]
+	if !ok {
+		return nil, nil, ErrInvalidToken
+	}
+	user = user.clone()
+	delete(user.OneTimeTokens, t.Nonce)
+	return t, user, nil
+}
+
+// userIDByEmail returns the ID of the user with the given verified email address, or "" if there is none.
+// The caller must hold s.lock.
+func (s *AuthDB) userIDByEmail(email string) string {
+	for id, user := range s.Users {
+		if user.VerifiedEmails[email] {
+			return id
+		}
+	}
+	return ""
+}
+
+// tokenMailBody returns the body of an email carrying a one-time token.
+func (s *AuthDB) tokenMailBody(intro, purpose, token string) string {
+	body := intro + "\n\n" + token + "\n"
+	if s.EmailLinkURL != "" {
+		q := url.Values{
+			"purpose": {purpose},
+			"token":   {token},
+		}
+		body += "\nOr open this link:\n\n" + s.EmailLinkURL + "?" + q.Encode() + "\n"
+	}
+	return body
+}
+
+// sendMail sends m with s.Mailer.
+func (s *AuthDB) sendMail(m *Mail) error {
+	if s.Mailer == nil {
+		return ErrMailNotConfigured
+	}
+	return s.Mailer.SendMail(m)
+}
+
+// normalizeEmail checks that email is a bare address and lowercases it.
+// If it returns an error, it will be of type ErrInvalidEmail.
+func normalizeEmail(email string) (string, error) {
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != strings.TrimSpace(email) {
+		return "", ErrInvalidEmail
+	}
+	return strings.ToLower(addr.Address), nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// addEmail handles requests to add an email address.
+func (s *AuthDB) addEmail(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Session
+		Email string `json:"email"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if !s.checkSession(req.UserID, req.Token) {
+		writeError(w, http.StatusUnauthorized, ErrInvalidSession)
+		return
+	}
+	err = s.AddEmail(req.UserID, req.Email)
+	s.audit(r, AuditEmailAdded, req.UserID, req.UserID, err)
+	switch err {
+	case nil:
+	case ErrInvalidEmail:
+		writeError(w, http.StatusBadRequest, err)
+	default:
+		ServeInternalServerError(w, r)
+	}
+}
+
+// removeEmail handles requests to remove an email address.
+func (s *AuthDB) removeEmail(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Session
+		Email string `json:"email"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if !s.checkSession(req.UserID, req.Token) {
+		writeError(w, http.StatusUnauthorized, ErrInvalidSession)
+		return
+	}
+	err = s.RemoveEmail(req.UserID, req.Email)
+	s.audit(r, AuditEmailRemoved, req.UserID, req.UserID, err)
+	switch err {
+	case nil:
+	case ErrInvalidEmail:
+		writeError(w, http.StatusBadRequest, err)
+	case ErrEmailNotFound:
+		writeError(w, http.StatusNotFound, err)
+	default:
+		ServeInternalServerError(w, r)
+	}
+}
+
+// verifyEmail handles requests carrying an email verification token.
+func (s *AuthDB) verifyEmail(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Token string `json:"token"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	err = s.VerifyEmail(req.Token)
+	s.audit(r, AuditEmailVerified, "", s.tokenUser(req.Token), err)
+	switch err {
+	case nil:
+	case ErrInvalidToken:
+		writeError(w, http.StatusUnauthorized, err)
+	case ErrEmailNotFound:
+		writeError(w, http.StatusNotFound, err)
+	case ErrEmailTaken:
+		writeError(w, http.StatusConflict, err)
+	default:
+		ServeInternalServerError(w, r)
+	}
+}
+
+// forgotPassword handles requests for a password reset email.
+// It succeeds whether or not the account exists.
+func (s *AuthDB) forgotPassword(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		UserID string `json:"user_id"`
+		Email  string `json:"email"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	account := req.UserID
+	if req.Email != "" {
+		account = req.Email
+	}
+	ip := s.clientIP(r)
+	err = s.forgotPasswordFrom(account, ip)
+	s.auditEvent(r, &AuditEvent{Type: AuditPasswordForgotten, Detail: account}, err)
+	switch err {
+	case ErrMailNotConfigured:
+		ServeInternalServerError(w, r)
+	case ErrTooManyAttempts:
+		writeTooManyAttempts(w, s.resetRetryAfter(account, ip))
+	}
+}
+
+// resetPassword handles requests to set a new password with a reset token.
+func (s *AuthDB) resetPassword(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	err = s.ResetPassword(req.Token, req.Password)
+	s.audit(r, AuditPasswordReset, "", s.tokenUser(req.Token), err)
+	switch err {
+	case nil:
+	case ErrInvalidToken:
+		writeError(w, http.StatusUnauthorized, err)
+	case ErrPasswordTooShort, ErrPasswordTooLong, ErrPasswordTooWeak:
+		writeError(w, http.StatusBadRequest, err)
+	default:
+		ServeInternalServerError(w, r)
+	}
+}
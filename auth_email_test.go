@@ -0,0 +1,120 @@
+package web
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAddEmailRollsBackWhenMailFails(t *testing.T) {
+	mailer := &FakeMailSender{Err: errors.New("smtp down")}
+	db := NewAuthDB("correct horse", "")
+	db.Mailer = mailer
+	err := db.AddEmail("admin", "admin@example.com")
+	if err != mailer.Err {
+		t.Fatal(err)
+	}
+	user, _ := db.User("admin")
+	if len(user.Emails) != 0 || len(user.OneTimeTokens) != 0 {
+		t.Fatalf("failed AddEmail left %v and %d tokens", user.Emails, len(user.OneTimeTokens))
+	}
+	mailer.Err = nil
+	err = db.AddEmail("admin", "admin@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mailer.Last("admin@example.com") == nil {
+		t.Fatal("no verification mail")
+	}
+	db.Mailer = nil
+	err = db.AddEmail("admin", "other@example.com")
+	if err != ErrMailNotConfigured {
+		t.Fatal(err)
+	}
+	user, _ = db.User("admin")
+	if len(user.Emails) != 1 {
+		t.Fatal(user.Emails)
+	}
+}
+
+func TestForgotPasswordAnswersAlike(t *testing.T) {
+	mailer := &FakeMailSender{}
+	db := NewAuthDB("correct horse", "")
+	db.Mailer = mailer
+	err := db.AddEmail("admin", "admin@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.VerifyEmail(strings.Split(mailer.Last("admin@example.com").Body, "\n")[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	answer := func(account, ip string) (int, string) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/forgot-password", strings.NewReader(`{"email":"`+account+`"}`))
+		r.RemoteAddr = ip + ":1234"
+		db.ServeHTTP(w, r)
+		return w.Code, w.Body.String()
+	}
+	// The mail is sent in the background, so holding it up does not hold up the answer.
+	mailer.lock.Lock()
+	code, body := answer("admin@example.com", "10.0.0.1")
+	mailer.lock.Unlock()
+	unknownCode, unknownBody := answer("nobody@example.com", "10.0.0.2")
+	if code != unknownCode || body != unknownBody {
+		t.Fatalf("existing account got %d %q, unknown got %d %q", code, body, unknownCode, unknownBody)
+	}
+	db.mailing.Wait()
+	if len(mailer.Messages()) != 2 || mailer.Last("admin@example.com").Subject != "Reset your password" {
+		t.Fatalf("%d mails", len(mailer.Messages()))
+	}
+
+	mailer.Err = errors.New("smtp down")
+	failedCode, failedBody := answer("admin@example.com", "10.0.0.3")
+	if failedCode != unknownCode || failedBody != unknownBody {
+		t.Fatalf("failed mail got %d %q", failedCode, failedBody)
+	}
+	db.mailing.Wait()
+	events, err := db.AuditEvents(&AuditQuery{UserID: "admin", Type: AuditPasswordForgotten})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Outcome != AuditFailure || events[0].Detail != "smtp down" || events[0].IP != "10.0.0.3" {
+		t.Fatalf("failed mail was not audited: %+v", events)
+	}
+}
+
+func TestForgotPasswordThrottle(t *testing.T) {
+	now := time.Unix(1000000, 0)
+	db := NewAuthDB("correct horse", "")
+	db.Clock = func() time.Time { return now }
+	db.Mailer = &FakeMailSender{}
+	for i := 0; i < 3; i++ {
+		err := db.forgotPasswordFrom("nobody@example.com", "10.0.0.1")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := db.forgotPasswordFrom("NOBODY@example.com", "10.0.0.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.forgotPasswordFrom("nobody@example.com", "10.0.0.3")
+	if err != ErrTooManyAttempts {
+		t.Fatalf("fifth request for one account: %v", err)
+	}
+	for i := 0; i < 21; i++ {
+		db.forgotPasswordFrom("user"+string(rune('a'+i)), "10.0.0.9")
+	}
+	err = db.forgotPasswordFrom("someone-else", "10.0.0.9")
+	if err != ErrTooManyAttempts {
+		t.Fatalf("request after 21 from one IP: %v", err)
+	}
+	now = now.Add(2 * time.Hour)
+	err = db.forgotPasswordFrom("nobody@example.com", "10.0.0.9")
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,374 @@
+package web
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// externalLoginTTL is how long a user has to finish logging in at an identity provider.
+const externalLoginTTL = 10 * time.Minute
+
+// externalStateCookie binds an external login to the browser that started it.
+const externalStateCookie = "oauth_state"
+
+// externalLogin is a login waiting for the identity provider to redirect back.
+// External logins only live in memory; a restart means starting again.
+type externalLogin struct {
+	provider         string
+	codeVerifier     string
+	redirectURL      string
+	returnTo         string
+	registrationCode string
+	linkUserID       string
+	expiresAt        time.Time
+}
+
+// LoginWithIdentity returns the ID of the user that identity belongs to.
+// Unknown identities are linked to the user with the same verified email address if the provider
+// has LinkByEmail set, and otherwise registered as a new user if registrationCode is a valid invite
+// or the provider's AllowedEmails allow the verified email address.
+// If it returns an error, it may be of type ErrUnknownProvider, ErrUserDisabled or ErrInvalidRegistrationCode.
+func (s *AuthDB) LoginWithIdentity(identity *ExternalIdentity, registrationCode string) (string, error) {
+	p, ok := s.IdentityProviders[identity.Provider]
+	if !ok {
+		return "", ErrUnknownProvider
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	userID := s.userIDByIdentity(identity.Provider, identity.Subject)
+	if userID == "" && p.LinkByEmail && identity.EmailVerified && identity.Email != "" {
+		userID = s.userIDByEmail(identity.Email)
+		if userID != "" {
+			err := s.linkIdentity(userID, identity)
+			if err != nil {
+				return "", err
+			}
+		}
+	}
+	if userID != "" {
+		if s.Users[userID].Disabled {
+			return "", ErrUserDisabled
+		}
+		return userID, nil
+	}
+	allowed := identity.EmailVerified && identity.Email != "" && p.allowsEmail(identity.Email)
+	if !allowed && registrationCode == "" {
+		return "", ErrInvalidRegistrationCode
+	}
+	if allowed {
+		registrationCode = ""
+	}
+	// The password is random so that only the identity provider or a password reset can log the user in.
+	user := NewUser(randomToken(32))
+	if identity.Email != "" {
+		user.Emails = []string{identity.Email}
+		if identity.EmailVerified && s.userIDByEmail(identity.Email) == "" {
+			user.VerifiedEmails = map[string]bool{identity.Email: true}
+		}
+	}
+	linked := *identity
+	linked.LinkedAt = s.now().Unix()
+	user.Identities = []*ExternalIdentity{&linked}
+	return s.addUser(user, registrationCode)
+}
+
+// LinkIdentity links identity to the given user, so that the user can log in with it.
+// If it returns an error, it may be of type ErrUserNotFound or ErrIdentityTaken.
+func (s *AuthDB) LinkIdentity(userID string, identity *ExternalIdentity) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.linkIdentity(userID, identity)
+}
+
+// linkIdentity is like LinkIdentity.
+// The caller must hold s.lock.
+func (s *AuthDB) linkIdentity(userID string, identity *ExternalIdentity) error {
+	user, ok := s.Users[userID]
+	if !ok {
+		return ErrUserNotFound
+	}
+	owner := s.userIDByIdentity(identity.Provider, identity.Subject)
+	if owner == userID {
+		return nil
+	}
+	if owner != "" {
+		return ErrIdentityTaken
+	}
+	linked := *identity
+	linked.LinkedAt = s.now().Unix()
+	user = user.clone()
+	user.Identities = append(user.Identities, &linked)
+	return s.commit("users", userID, user)
+}
+
+// UnlinkIdentity removes the given provider account from the user.
+// If it returns an error, it may be of type ErrUserNotFound or ErrIdentityNotFound.
+func (s *AuthDB) UnlinkIdentity(userID, provider, subject string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	user, ok := s.Users[userID]
+	if !ok {
+		return ErrUserNotFound
+	}
+	identities := []*ExternalIdentity{}
+	for _, id := range user.Identities {
+		if id.Provider != provider || id.Subject != subject {
+			identities = append(identities, id)
+		}
+	}
+	if len(identities) == len(user.Identities) {
+		return ErrIdentityNotFound
+	}
+	user = user.clone()
+	user.Identities = identities
+	return s.commit("users", userID, user)
+}
+
+// Identities returns the provider accounts linked to the given user.
+// If it returns an error, it will be of type ErrUserNotFound.
+func (s *AuthDB) Identities(userID string) ([]*ExternalIdentity, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	user, ok := s.Users[userID]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	return user.clone().Identities, nil
+}
+
+// userIDByIdentity returns the ID of the user the given provider account is linked to, or "" if there is none.
+// The caller must hold s.lock.
+func (s *AuthDB) userIDByIdentity(provider, subject string) string {
+	for id, user := range s.Users {
+		for _, identity := range user.Identities {
+			if identity.Provider == provider && identity.Subject == subject {
+				return id
+			}
+		}
+	}
+	return ""
+}
+
+// newExternalLogin records a login that is about to be sent to an identity provider and returns its state.
+func (s *AuthDB) newExternalLogin(l *externalLogin) string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := s.now()
+	if s.externalLogins == nil {
+		s.externalLogins = map[string]*externalLogin{}
+	}
+	for state, l := range s.externalLogins {
+		if now.After(l.expiresAt) {
+			delete(s.externalLogins, state)
+		}
+	}
+	state := randomToken(32)
+	l.expiresAt = now.Add(externalLoginTTL)
+	s.externalLogins[state] = l
+	return state
+}
+
+// takeExternalLogin removes and returns the login with the given state, or nil if there is none.
+func (s *AuthDB) takeExternalLogin(state string) *externalLogin {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	l, ok := s.externalLogins[state]
+	delete(s.externalLogins, state)
+	if !ok || s.now().After(l.expiresAt) {
+		return nil
+	}
+	return l
+}
+
+// oauthStart handles requests to log in with an identity provider.
+// It takes the provider, return_to and registration_code query parameters and redirects to the provider.
+// If link is set and the browser has a session, the provider account is linked to its user instead.
+func (s *AuthDB) oauthStart(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	name := q.Get("provider")
+	p, ok := s.IdentityProviders[name]
+	if !ok {
+		http.Error(w, ErrUnknownProvider.Error(), http.StatusNotFound)
+		return
+	}
+	endpoints, err := p.discover(r.Context())
+	if err != nil {
+		http.Error(w, "identity provider unavailable", http.StatusBadGateway)
+		return
+	}
+	l := &externalLogin{
+		provider:         name,
+		codeVerifier:     randomToken(32),
+		redirectURL:      p.RedirectURL,
+		returnTo:         safeReturnTo(r, q.Get("return_to")),
+		registrationCode: q.Get("registration_code"),
+	}
+	if l.redirectURL == "" {
+		l.redirectURL = s.issuer(r) + "/oauth/callback"
+	}
+	if q.Get("link") != "" {
+		l.linkUserID = s.browserUser(r)
+		if l.linkUserID == "" {
+			ServeUnauthorized(w, r)
+			return
+		}
+	}
+	state := s.newExternalLogin(l)
+	http.SetCookie(w, &http.Cookie{
+		Name:     externalStateCookie,
+		Value:    state,
+		Path:     "/",
+		MaxAge:   int(externalLoginTTL / time.Second),
+		HttpOnly: true,
+		Secure:   isHTTPS(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+	sum := sha256.Sum256([]byte(l.codeVerifier))
+	challenge := base64.RawURLEncoding.EncodeToString(sum[:])
+	http.Redirect(w, r, p.authCodeURL(endpoints, l.redirectURL, state, challenge), http.StatusSeeOther)
+}
+
+// oauthCallback handles the redirect back from an identity provider.
+// It logs the user in by setting the session cookie and redirects to return_to.
+// Users with a second factor are instead redirected with a challenge to answer at /verify-login,
+// and failures are reported in the error query parameter.
+func (s *AuthDB) oauthCallback(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	cookie, err := r.Cookie(externalStateCookie)
+	if err != nil || cookie.Value != q.Get("state") {
+		ServeBadRequest(w, r)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:   externalStateCookie,
+		Path:   "/",
+		MaxAge: -1,
+	})
+	l := s.takeExternalLogin(q.Get("state"))
+	if l == nil {
+		ServeBadRequest(w, r)
+		return
+	}
+	fail := func(err error) {
+		redirectWithParams(w, r, l.returnTo, url.Values{
+			"error": {err.Error()},
+		})
+	}
+	if q.Get("error") != "" {
+		fail(ErrInvalidToken)
+		return
+	}
+	p := s.IdentityProviders[l.provider]
+	endpoints, err := p.discover(r.Context())
+	if err != nil {
+		fail(ErrInvalidToken)
+		return
+	}
+	accessToken, err := p.exchange(r.Context(), endpoints, l.redirectURL, q.Get("code"), l.codeVerifier)
+	if err != nil {
+		fail(ErrInvalidToken)
+		return
+	}
+	identity, err := p.identity(r.Context(), endpoints, accessToken)
+	if err != nil {
+		fail(ErrInvalidToken)
+		return
+	}
+	identity.Provider = l.provider
+	if l.linkUserID != "" {
+		err = s.LinkIdentity(l.linkUserID, identity)
+		s.audit(r, AuditIdentityLinked, l.linkUserID, l.linkUserID, err)
+		if err != nil {
+			fail(err)
+			return
+		}
+		http.Redirect(w, r, l.returnTo, http.StatusSeeOther)
+		return
+	}
+	userID, err := s.LoginWithIdentity(identity, l.registrationCode)
+	if err != nil {
+		s.auditEvent(r, &AuditEvent{Type: AuditExternalLogin, Detail: l.provider}, err)
+		fail(err)
+		return
+	}
+	if s.secondFactorRequired(userID) {
+		s.auditEvent(r, &AuditEvent{Type: AuditExternalLogin, ActorID: userID, UserID: userID, Outcome: AuditChallenged, Detail: l.provider}, nil)
+		redirectWithParams(w, r, l.returnTo, url.Values{
+			"challenge": {s.newLoginChallenge(userID, s.clientIP(r), r.UserAgent())},
+		})
+		return
+	}
+	token, err := s.startSession(userID, s.clientIP(r), r.UserAgent())
+	s.auditEvent(r, &AuditEvent{Type: AuditExternalLogin, ActorID: userID, UserID: userID, Detail: l.provider}, err)
+	if err != nil {
+		ServeInternalServerError(w, r)
+		return
+	}
+	session := &Session{
+		UserID: userID,
+		Token:  token,
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     DefaultSessionCookieName,
+		Value:    session.Credential(),
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   isHTTPS(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, l.returnTo, http.StatusSeeOther)
+}
+
+// identities handles requests to list the provider accounts linked to a user.
+func (s *AuthDB) identities(w http.ResponseWriter, r *http.Request) {
+	var session Session
+	err := json.NewDecoder(r.Body).Decode(&session)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if !s.checkSession(session.UserID, session.Token) {
+		writeError(w, http.StatusUnauthorized, ErrInvalidSession)
+		return
+	}
+	identities, err := s.Identities(session.UserID)
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, ErrInvalidSession)
+		return
+	}
+	json.NewEncoder(w).Encode(identities)
+}
+
+// unlinkIdentity handles requests to remove a provider account from a user.
+func (s *AuthDB) unlinkIdentity(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Session
+		Provider string `json:"provider"`
+		Subject  string `json:"subject"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if !s.checkSession(req.UserID, req.Token) {
+		writeError(w, http.StatusUnauthorized, ErrInvalidSession)
+		return
+	}
+	err = s.UnlinkIdentity(req.UserID, req.Provider, req.Subject)
+	s.audit(r, AuditIdentityUnlinked, req.UserID, req.UserID, err)
+	if err == ErrIdentityNotFound {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		ServeInternalServerError(w, r)
+		return
+	}
+}
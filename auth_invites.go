@@ -0,0 +1,157 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+)
+
+// CreateInvite creates a new invite issued by the given user and returns it.
+// The issuerID is empty for invites created with the registrar key.
+// ExpiresAt, MaxUses, Role, Org and Note are taken from opts, which may be nil; the rest is filled in.
+func (s *AuthDB) CreateInvite(issuerID string, opts *Invite) (*Invite, error) {
+	if opts == nil {
+		opts = &Invite{}
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	invite := &Invite{
+		Code:       s.newID("registration_code"),
+		IssuedBy:   issuerID,
+		CreatedAt:  s.now().Unix(),
+		ExpiresAt:  opts.ExpiresAt,
+		MaxUses:    opts.MaxUses,
+		Role:       opts.Role,
+		Org:        opts.Org,
+		Note:       opts.Note,
+		RedeemedBy: []string{},
+	}
+	err := s.commit("invitations", invite.Code, invite)
+	if err != nil {
+		return nil, err
+	}
+	return invite, nil
+}
+
+// Invites returns copies of the invites issued by the given user, oldest first.
+// An empty issuerID returns every invite.
+func (s *AuthDB) Invites(issuerID string) []*Invite {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	invites := []*Invite{}
+	for _, invite := range s.Invitations {
+		if issuerID == "" || invite.IssuedBy == issuerID {
+			c := *invite
+			c.RedeemedBy = append([]string{}, invite.RedeemedBy...)
+			invites = append(invites, &c)
+		}
+	}
+	sort.Slice(invites, func(i, j int) bool {
+		return invites[i].CreatedAt < invites[j].CreatedAt
+	})
+	return invites
+}
+
+// RevokeInvite stops the invite with the given code from being redeemed.
+// The invite is kept so that its redemptions can still be seen.
+// If issuerID is not empty, only invites issued by that user can be revoked.
+// If it returns an error, it may be of type ErrInviteNotFound.
+func (s *AuthDB) RevokeInvite(issuerID, code string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	invite, ok := s.Invitations[code]
+	if !ok || issuerID != "" && invite.IssuedBy != issuerID {
+		return ErrInviteNotFound
+	}
+	c := *invite
+	c.Revoked = true
+	return s.commit("invitations", code, &c)
+}
+
+// redeemInvite records that the given user registered with the invite with the given code.
+// It returns the invite as it was before.
+// If it returns an error, it may be of type ErrInvalidRegistrationCode.
+// The caller must hold s.lock.
+func (s *AuthDB) redeemInvite(code, userID string) (*Invite, error) {
+	invite, ok := s.Invitations[code]
+	if !ok || !invite.Redeemable(s.now()) {
+		return nil, ErrInvalidRegistrationCode
+	}
+	c := *invite
+	c.RedeemedBy = append(append([]string{}, invite.RedeemedBy...), userID)
+	err := s.commit("invitations", code, &c)
+	if err != nil {
+		return nil, err
+	}
+	return invite, nil
+}
+
+// inviteIssuer returns the user ID that the invite endpoints act for.
+// Admins act for every issuer, which is "", and registrars for themselves.
+// If it returns an error, it will be of type ErrInvalidSession or ErrForbidden.
+func (s *AuthDB) inviteIssuer(session *Session) (string, error) {
+	err := s.authorize(session, RoleRegistrar)
+	if err != nil {
+		return "", err
+	}
+	if s.HasRole(session.UserID, RoleAdmin) {
+		return "", nil
+	}
+	return session.UserID, nil
+}
+
+// invites handles requests to list invites.
+// Admins see every invite; registrars see the invites they issued.
+func (s *AuthDB) invites(w http.ResponseWriter, r *http.Request) {
+	var session Session
+	err := json.NewDecoder(r.Body).Decode(&session)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	issuerID, err := s.inviteIssuer(&session)
+	if err == ErrInvalidSession {
+		writeError(w, http.StatusUnauthorized, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusForbidden, err)
+		return
+	}
+	json.NewEncoder(w).Encode(s.Invites(issuerID))
+}
+
+// revokeInvite handles requests to revoke an invite.
+// Admins can revoke any invite; registrars only the invites they issued.
+func (s *AuthDB) revokeInvite(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Session
+		Code string `json:"code"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	issuerID, err := s.inviteIssuer(&req.Session)
+	if err == ErrInvalidSession {
+		writeError(w, http.StatusUnauthorized, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusForbidden, err)
+		return
+	}
+	err = s.RevokeInvite(issuerID, req.Code)
+	s.audit(r, AuditInviteRevoked, req.UserID, "", err)
+	if err == ErrInviteNotFound {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		ServeInternalServerError(w, r)
+		return
+	}
+}
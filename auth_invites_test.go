@@ -0,0 +1,154 @@
+package web
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestInviteUses(t *testing.T) {
+	db := NewAuthDB("correct horse", "")
+	code, err := db.Invite()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Register(code, "battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Register(code, "battery staple")
+	if err != ErrInvalidRegistrationCode {
+		t.Fatalf("single-use code used twice: %v", err)
+	}
+
+	invite, err := db.CreateInvite("admin", &Invite{MaxUses: 2, Role: RoleRegistrar, Note: "for the team"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var users []string
+	for i := 0; i < 2; i++ {
+		userID, err := db.Register(invite.Code, "battery staple")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !db.HasRole(userID, RoleRegistrar) || !db.HasRole(userID, RoleMember) {
+			t.Fatalf("user %d did not get the roles of the invite", i)
+		}
+		users = append(users, userID)
+	}
+	_, err = db.Register(invite.Code, "battery staple")
+	if err != ErrInvalidRegistrationCode {
+		t.Fatalf("invite used more than MaxUses: %v", err)
+	}
+	invites := db.Invites("admin")
+	if len(invites) != 1 || invites[0].Note != "for the team" || invites[0].IssuedBy != "admin" {
+		t.Fatalf("%+v", invites)
+	}
+	if len(invites[0].RedeemedBy) != 2 || invites[0].RedeemedBy[0] != users[0] || invites[0].RedeemedBy[1] != users[1] {
+		t.Fatalf("redeemed by %v, want %v", invites[0].RedeemedBy, users)
+	}
+	if len(db.Invites("")) != 2 {
+		t.Fatal("registrar key invite is not listed")
+	}
+}
+
+func TestInviteExpiresAndRevokes(t *testing.T) {
+	now := time.Unix(1000000, 0)
+	db := NewAuthDB("correct horse", "")
+	db.Clock = func() time.Time { return now }
+	expiring, err := db.CreateInvite("admin", &Invite{ExpiresAt: now.Add(time.Hour).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	revoked, err := db.CreateInvite("admin", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.RevokeInvite("someone-else", revoked.Code)
+	if err != ErrInviteNotFound {
+		t.Fatalf("revoked another issuer's invite: %v", err)
+	}
+	err = db.RevokeInvite("admin", revoked.Code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Register(revoked.Code, "battery staple")
+	if err != ErrInvalidRegistrationCode {
+		t.Fatalf("revoked invite: %v", err)
+	}
+	now = now.Add(2 * time.Hour)
+	_, err = db.Register(expiring.Code, "battery staple")
+	if err != ErrInvalidRegistrationCode {
+		t.Fatalf("expired invite: %v", err)
+	}
+	if len(db.Users) != 1 {
+		t.Fatalf("%d users", len(db.Users))
+	}
+}
+
+func TestInviteOrg(t *testing.T) {
+	db := NewAuthDB("correct horse", "")
+	org, err := db.CreateOrg("admin", "Team")
+	if err != nil {
+		t.Fatal(err)
+	}
+	invite, err := db.CreateInvite("admin", &Invite{Org: org.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	userID, err := db.Register(invite.Code, "battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db.OrgRole(org.ID, userID) != OrgRoleViewer {
+		t.Fatalf("role %q in the org of the invite", db.OrgRole(org.ID, userID))
+	}
+}
+
+func TestInviteEndpoints(t *testing.T) {
+	ctx := context.Background()
+	db, c := newAuthClient(t)
+	registrar := registerUser(t, db)
+	err := db.SetRoles(registrar.UserID, []string{RoleMember, RoleRegistrar})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.CreateInvite(ctx, registrar, &Invite{Role: RoleAdmin})
+	if err != ErrForbidden {
+		t.Fatalf("registrar set the role of an invite: %v", err)
+	}
+	invite, err := c.CreateInvite(ctx, registrar, &Invite{MaxUses: 1, Note: "bob"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if invite.IssuedBy != registrar.UserID {
+		t.Fatalf("issued by %q", invite.IssuedBy)
+	}
+	admin := loginAs(t, c, "admin", "correct horse")
+	_, err = c.InviteAs(ctx, admin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	invites, err := c.Invites(ctx, registrar)
+	if err != nil || len(invites) != 1 || invites[0].Code != invite.Code {
+		t.Fatalf("registrar sees %+v: %v", invites, err)
+	}
+	// Admins also see the invite the registrar registered with.
+	invites, err = c.Invites(ctx, admin)
+	if err != nil || len(invites) != 3 {
+		t.Fatalf("admin sees %d invites: %v", len(invites), err)
+	}
+	member := registerUser(t, db)
+	_, err = c.Invites(ctx, member)
+	if err != ErrForbidden {
+		t.Fatalf("member listed invites: %v", err)
+	}
+	err = c.RevokeInvite(ctx, registrar, invite.Code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.Register(ctx, invite.Code, "battery staple")
+	if err != ErrInvalidRegistrationCode {
+		t.Fatalf("revoked invite: %v", err)
+	}
+}
@@ -0,0 +1,222 @@
+package web
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// loginWait returns how long a login for the given user from the given IP must wait.
+// The caller must hold s.lock.
+func (s *AuthDB) loginWait(userID, ip string) time.Duration {
+	now := s.now()
+	wait := s.accountThrottle().wait(userID, now)
+	if ip != "" {
+		ipWait := s.ipThrottle().wait(ip, now)
+		if ipWait > wait {
+			wait = ipWait
+		}
+	}
+	return wait
+}
+
+// retryAfter is like loginWait but takes the lock itself.
+func (s *AuthDB) retryAfter(userID, ip string) time.Duration {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.loginWait(userID, ip)
+}
+
+// loginFailed records a failed login.
+// Failures for unknown users only count against the IP.
+// The caller must hold s.lock.
+func (s *AuthDB) loginFailed(userID, ip string) {
+	now := s.now()
+	if _, ok := s.Users[userID]; ok {
+		s.accountThrottle().fail(userID, now)
+	}
+	if ip != "" {
+		s.ipThrottle().fail(ip, now)
+	}
+}
+
+// loginAdmitted returns true if a login for the given user from the given IP may check its password now.
+// Logins are held back while enough others are in flight to reach a wait if they all fail.
+// The caller must hold s.lock.
+func (s *AuthDB) loginAdmitted(userID, ip string) bool {
+	if !s.accountThrottle().admits(userID) {
+		return false
+	}
+	return ip == "" || s.ipThrottle().admits(ip)
+}
+
+// loginStarted records a login whose password is being checked.
+// The caller must hold s.lock and call loginFinished once the password is checked.
+func (s *AuthDB) loginStarted(userID, ip string) {
+	now := s.now()
+	s.accountThrottle().start(userID, now)
+	if ip != "" {
+		s.ipThrottle().start(ip, now)
+	}
+}
+
+// loginFinished ends a login recorded by loginStarted and wakes the logins waiting for it.
+// The failed logins of the user are only cleared once the login is complete, so that wrong second
+// factor codes keep counting against the account.
+// The caller must hold s.lock.
+func (s *AuthDB) loginFinished(userID, ip string) {
+	s.accountThrottle().finish(userID)
+	if ip != "" {
+		s.ipThrottle().finish(ip)
+	}
+	s.loginsInFlight().Broadcast()
+}
+
+// loginsInFlight returns the condition that logins held back by loginAdmitted wait on.
+// The caller must hold s.lock.
+func (s *AuthDB) loginsInFlight() *sync.Cond {
+	if s.loginDone == nil {
+		s.loginDone = sync.NewCond(&s.lock)
+	}
+	return s.loginDone
+}
+
+// Unlock clears the failed logins of the given user, lifting any lockout.
+// If it returns an error, it will be of type ErrUserNotFound.
+func (s *AuthDB) Unlock(userID string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.Users[userID]; !ok {
+		return ErrUserNotFound
+	}
+	s.accountThrottle().reset(userID)
+	return nil
+}
+
+// UnlockIP clears the failed logins from the given IP address, lifting any lockout.
+func (s *AuthDB) UnlockIP(ip string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.ipThrottle().reset(ip)
+}
+
+// accountThrottle returns the throttle for failed logins per user, configured from s.
+// The caller must hold s.lock.
+func (s *AuthDB) accountThrottle() *loginThrottle {
+	if s.accounts == nil {
+		s.accounts = &loginThrottle{}
+	}
+	s.accounts.max = s.MaxLoginFailures
+	s.accounts.backoff = s.LoginBackoff
+	s.accounts.lockout = s.LoginLockout
+	return s.accounts
+}
+
+// ipThrottle returns the throttle for failed logins per IP address, configured from s.
+// The caller must hold s.lock.
+func (s *AuthDB) ipThrottle() *loginThrottle {
+	if s.ips == nil {
+		s.ips = &loginThrottle{}
+	}
+	s.ips.max = s.MaxLoginFailuresPerIP
+	s.ips.free = s.IPBackoffThreshold
+	s.ips.backoff = s.LoginBackoff
+	s.ips.lockout = s.LoginLockout
+	return s.ips
+}
+
+// resetWait returns how long a password reset request for the given account from the given IP must wait.
+// The caller must hold s.lock.
+func (s *AuthDB) resetWait(account, ip string) time.Duration {
+	now := s.now()
+	wait := s.resetAccountThrottle().wait(strings.ToLower(account), now)
+	if ip != "" {
+		ipWait := s.resetIPThrottle().wait(ip, now)
+		if ipWait > wait {
+			wait = ipWait
+		}
+	}
+	return wait
+}
+
+// resetRetryAfter is like resetWait but takes the lock itself.
+func (s *AuthDB) resetRetryAfter(account, ip string) time.Duration {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.resetWait(account, ip)
+}
+
+// resetRequested records a password reset request.
+// The caller must hold s.lock.
+func (s *AuthDB) resetRequested(account, ip string) {
+	now := s.now()
+	s.resetAccountThrottle().fail(strings.ToLower(account), now)
+	if ip != "" {
+		s.resetIPThrottle().fail(ip, now)
+	}
+}
+
+// resetAccountThrottle returns the throttle for password reset requests per account.
+// The caller must hold s.lock.
+func (s *AuthDB) resetAccountThrottle() *loginThrottle {
+	if s.resetAccounts == nil {
+		s.resetAccounts = &loginThrottle{free: 3, backoff: time.Minute, lockout: time.Hour}
+	}
+	return s.resetAccounts
+}
+
+// resetIPThrottle returns the throttle for password reset requests per IP address.
+// The caller must hold s.lock.
+func (s *AuthDB) resetIPThrottle() *loginThrottle {
+	if s.resetIPs == nil {
+		s.resetIPs = &loginThrottle{free: 20, backoff: time.Minute, lockout: time.Hour}
+	}
+	return s.resetIPs
+}
+
+// writeTooManyAttempts answers a throttled login with a Retry-After header.
+func writeTooManyAttempts(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	writeError(w, http.StatusTooManyRequests, ErrTooManyAttempts)
+}
+
+// unlock handles admin requests to lift a lockout.
+// Either unlock_user_id or unlock_ip, or both, may be given.
+func (s *AuthDB) unlock(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Session
+		UnlockUserID string `json:"unlock_user_id"`
+		UnlockIP     string `json:"unlock_ip"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	err = s.authorize(&req.Session, RoleAdmin)
+	if err == ErrInvalidSession {
+		writeError(w, http.StatusUnauthorized, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusForbidden, err)
+		return
+	}
+	if req.UnlockUserID != "" {
+		err = s.Unlock(req.UnlockUserID)
+		s.audit(r, AuditUnlock, req.UserID, req.UnlockUserID, err)
+		if err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+	}
+	if req.UnlockIP != "" {
+		s.UnlockIP(req.UnlockIP)
+		s.auditEvent(r, &AuditEvent{Type: AuditUnlock, ActorID: req.UserID, Detail: req.UnlockIP}, nil)
+	}
+}
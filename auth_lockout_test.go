@@ -0,0 +1,151 @@
+package web
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParallelLoginGuessesAreThrottled(t *testing.T) {
+	db := NewAuthDB("correct horse", "")
+	db.MaxLoginFailures = 3
+	var lock sync.Mutex
+	checked := 0
+	var wg sync.WaitGroup
+	for i := 0; i < 40; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := db.Login("admin", "wrong")
+			if err == ErrInvalidPassword {
+				lock.Lock()
+				checked++
+				lock.Unlock()
+			} else if err != ErrTooManyAttempts {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if checked != 1 {
+		t.Fatalf("%d of 40 parallel guesses were checked, want 1", checked)
+	}
+}
+
+func TestCorrectPasswordReleasesReservation(t *testing.T) {
+	now := time.Unix(1000000, 0)
+	db := NewAuthDB("correct horse", "")
+	db.Clock = func() time.Time { return now }
+	db.IPBackoffThreshold = 1
+	_, err := db.loginFrom("admin", "correct horse", "10.0.0.1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The released reservation leaves the IP's one free failure unused.
+	_, err = db.loginFrom("admin", "wrong", "10.0.0.1", "")
+	if err != ErrInvalidPassword {
+		t.Fatal(err)
+	}
+	now = now.Add(time.Second)
+	_, err = db.loginFrom("admin", "correct horse", "10.0.0.1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParallelCorrectLoginsFromOneIP(t *testing.T) {
+	db := NewAuthDB("correct horse", "")
+	users := []string{"admin"}
+	for i := 0; i < 2; i++ {
+		users = append(users, registerUser(t, db).UserID)
+	}
+	passwords := map[string]string{users[0]: "correct horse", users[1]: "battery staple", users[2]: "battery staple"}
+	var wg sync.WaitGroup
+	for i := 0; i < 3*db.IPBackoffThreshold; i++ {
+		wg.Add(1)
+		go func(userID string) {
+			defer wg.Done()
+			_, err := db.loginFrom(userID, passwords[userID], "10.0.0.1", "")
+			if err != nil {
+				t.Error(err)
+			}
+		}(users[i%len(users)])
+	}
+	wg.Wait()
+	// The IP is only held up once guesses fail.
+	var checked int32
+	for i := 0; i < 3*db.IPBackoffThreshold; i++ {
+		wg.Add(1)
+		go func(userID string) {
+			defer wg.Done()
+			_, err := db.loginFrom(userID, "wrong", "10.0.0.1", "")
+			if err == ErrUserNotFound {
+				atomic.AddInt32(&checked, 1)
+			}
+		}(fmt.Sprint("nobody", i))
+	}
+	wg.Wait()
+	if int(checked) != db.IPBackoffThreshold+1 {
+		t.Fatalf("%d parallel guesses from one IP were checked, want %d", checked, db.IPBackoffThreshold+1)
+	}
+}
+
+func TestIPBackoffThreshold(t *testing.T) {
+	now := time.Unix(1000000, 0)
+	db := NewAuthDB("correct horse", "")
+	db.Clock = func() time.Time { return now }
+	code, err := db.Invite()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := db.Register(code, "battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.loginFrom("admin", "typo", "10.0.0.1", "")
+	if err != ErrInvalidPassword {
+		t.Fatal(err)
+	}
+	// Another user behind the same address is not slowed down by the typo.
+	_, err = db.loginFrom(bob, "battery staple", "10.0.0.1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < db.IPBackoffThreshold; i++ {
+		db.loginFrom("nobody", "guess", "10.0.0.1", "")
+	}
+	_, err = db.loginFrom(bob, "battery staple", "10.0.0.1", "")
+	if err != ErrTooManyAttempts {
+		t.Fatal(err)
+	}
+}
+
+func TestClientIPTrustedProxies(t *testing.T) {
+	db := &AuthDB{TrustedProxies: []string{"10.0.0.0/8", "192.168.1.1"}}
+	tests := []struct {
+		remoteAddr string
+		forwarded  string
+		want       string
+	}{
+		{"203.0.113.5:1234", "", "203.0.113.5"},
+		{"203.0.113.5:1234", "198.51.100.7", "203.0.113.5"},
+		{"10.1.2.3:1234", "198.51.100.7", "198.51.100.7"},
+		{"10.1.2.3:1234", "1.2.3.4, 198.51.100.7, 192.168.1.1", "198.51.100.7"},
+		{"10.1.2.3:1234", "", "10.1.2.3"},
+		{"10.1.2.3:1234", "not-an-ip", "10.1.2.3"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tt.remoteAddr
+		if tt.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", tt.forwarded)
+		}
+		got := db.clientIP(r)
+		if got != tt.want {
+			t.Errorf("clientIP(%s, %q) = %s, want %s", tt.remoteAddr, tt.forwarded, got, tt.want)
+		}
+	}
+}
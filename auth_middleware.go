@@ -0,0 +1,175 @@
+package web
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultSessionCookieName is the session cookie read by AuthMiddleware when CookieName is empty.
+const DefaultSessionCookieName = "session"
+
+// DefaultAuthCacheTTL is how long AuthMiddleware caches remote validations when CacheTTL is zero.
+const DefaultAuthCacheTTL = 30 * time.Second
+
+// AuthMiddleware authenticates requests before passing them to Handler.
+// The session is read from, in order:
+// - the X-User and X-Token headers
+// - an "Authorization: Bearer <user_id>:<token>" header
+// - a session cookie holding "<user_id>:<token>"
+// When DB is set, an API key in the X-API-Key header is accepted as well.
+// The authenticated user's ID is available to Handler through UserIDFromContext.
+type AuthMiddleware struct {
+	// Handler serves the requests that pass authentication.
+	Handler http.Handler
+	// DB validates sessions locally.
+	DB *AuthDB
+	// Client validates sessions against a remote AuthDB.
+	// It is only used if DB is nil.
+	Client *AuthClient
+	// Verifier checks stateless session tokens without a request to Client for each one.
+	// It is only used if DB is nil.
+	Verifier *SessionVerifier
+	// Required makes requests without a valid session fail with ServeUnauthorized.
+	// Otherwise they are passed to Handler without a user ID.
+	Required bool
+	// CookieName is the name of the session cookie.
+	// If it is empty, DefaultSessionCookieName is used.
+	CookieName string
+	// CacheTTL is how long a session validated by Client is trusted before it is checked again.
+	// A session that is logged out may keep working for this long.
+	// If it is zero, DefaultAuthCacheTTL is used.
+	CacheTTL time.Duration
+
+	lock  sync.Mutex
+	cache map[Session]time.Time
+}
+
+func (m *AuthMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	userID, err := m.userID(r)
+	if err != nil && m.Required {
+		ServeInternalServerError(w, r)
+		return
+	}
+	if userID == "" {
+		if m.Required {
+			ServeUnauthorized(w, r)
+			return
+		}
+		m.Handler.ServeHTTP(w, r)
+		return
+	}
+	m.Handler.ServeHTTP(w, r.WithContext(ContextWithUserID(r.Context(), userID)))
+}
+
+// userID returns the ID of the authenticated user, or "" if the request is not authenticated.
+// An error means the credentials could not be checked.
+func (m *AuthMiddleware) userID(r *http.Request) (string, error) {
+	if m.DB != nil && r.Header.Get("X-API-Key") != "" {
+		return m.DB.checkAPIKey(r), nil
+	}
+	session, ok := m.session(r)
+	if !ok {
+		return "", nil
+	}
+	valid, err := m.validate(r, session)
+	if !valid {
+		return "", err
+	}
+	return session.UserID, nil
+}
+
+// session reads the session credentials from the request.
+func (m *AuthMiddleware) session(r *http.Request) (*Session, bool) {
+	userID := r.Header.Get("X-User")
+	token := r.Header.Get("X-Token")
+	if userID != "" && token != "" {
+		return &Session{
+			UserID: userID,
+			Token:  token,
+		}, true
+	}
+	auth := r.Header.Get("Authorization")
+	if strings.HasPrefix(auth, "Bearer ") {
+		return ParseCredential(strings.TrimPrefix(auth, "Bearer "))
+	}
+	cookie, err := r.Cookie(m.cookieName())
+	if err == nil {
+		return ParseCredential(cookie.Value)
+	}
+	return nil, false
+}
+
+// validate returns true if the session is valid.
+// An error means the session could not be checked.
+func (m *AuthMiddleware) validate(r *http.Request, session *Session) (bool, error) {
+	if m.DB != nil {
+		return m.DB.checkSession(session.UserID, session.Token), nil
+	}
+	if m.Verifier != nil && isStatelessToken(session.Token) {
+		err := m.Verifier.Verify(r.Context(), session)
+		if _, ok := err.(Error); ok {
+			return false, nil
+		}
+		return err == nil, err
+	}
+	if m.cached(session) {
+		return true, nil
+	}
+	err := m.Client.ValidateSession(r.Context(), session)
+	if _, ok := err.(Error); ok {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	m.remember(session)
+	return true, nil
+}
+
+// cached returns true if the session was validated less than CacheTTL ago.
+func (m *AuthMiddleware) cached(session *Session) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	expiresAt, ok := m.cache[*session]
+	if !ok {
+		return false
+	}
+	if time.Now().After(expiresAt) {
+		delete(m.cache, *session)
+		return false
+	}
+	return true
+}
+
+// remember caches a valid session.
+// Expired entries are swept out as new ones are added.
+func (m *AuthMiddleware) remember(session *Session) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	now := time.Now()
+	if m.cache == nil {
+		m.cache = map[Session]time.Time{}
+	}
+	for s, expiresAt := range m.cache {
+		if now.After(expiresAt) {
+			delete(m.cache, s)
+		}
+	}
+	m.cache[*session] = now.Add(m.cacheTTL())
+}
+
+func (m *AuthMiddleware) cookieName() string {
+	if m.CookieName == "" {
+		return DefaultSessionCookieName
+	}
+	return m.CookieName
+}
+
+func (m *AuthMiddleware) cacheTTL() time.Duration {
+	if m.CacheTTL == 0 {
+		return DefaultAuthCacheTTL
+	}
+	return m.CacheTTL
+}
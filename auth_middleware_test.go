@@ -0,0 +1,134 @@
+package web
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// echoUserID answers with the user ID that AuthMiddleware put in the context.
+var echoUserID = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	io.WriteString(w, "user:"+UserIDFromContext(r.Context()))
+})
+
+// authRequest passes a request made by set to m and returns the answer.
+func authRequest(m http.Handler, set func(r *http.Request)) (int, string) {
+	r := httptest.NewRequest("GET", "/", nil)
+	if set != nil {
+		set(r)
+	}
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, r)
+	return w.Code, w.Body.String()
+}
+
+func TestAuthMiddlewareCredentials(t *testing.T) {
+	db := NewAuthDB("correct horse", "")
+	token, err := db.Login("admin", "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Session{UserID: "admin", Token: token}
+	m := &AuthMiddleware{DB: db, Handler: echoUserID, Required: true, CookieName: "sid"}
+	tests := map[string]func(r *http.Request){
+		"headers": func(r *http.Request) {
+			r.Header.Set("X-User", s.UserID)
+			r.Header.Set("X-Token", s.Token)
+		},
+		"bearer": func(r *http.Request) {
+			r.Header.Set("Authorization", "Bearer "+s.Credential())
+		},
+		"cookie": func(r *http.Request) {
+			r.AddCookie(&http.Cookie{Name: "sid", Value: s.Credential()})
+		},
+	}
+	for name, set := range tests {
+		code, body := authRequest(m, set)
+		if code != http.StatusOK || body != "user:admin" {
+			t.Errorf("%s: %d %q", name, code, body)
+		}
+	}
+	forged := map[string]func(r *http.Request){
+		"none": nil,
+		"forged bearer": func(r *http.Request) {
+			r.Header.Set("Authorization", "Bearer admin:forged")
+		},
+		"other cookie": func(r *http.Request) {
+			r.AddCookie(&http.Cookie{Name: DefaultSessionCookieName, Value: s.Credential()})
+		},
+		"malformed cookie": func(r *http.Request) {
+			r.AddCookie(&http.Cookie{Name: "sid", Value: "admin"})
+		},
+	}
+	for name, set := range forged {
+		code, _ := authRequest(m, set)
+		if code != http.StatusUnauthorized {
+			t.Errorf("required, %s: %d", name, code)
+		}
+	}
+	m.Required = false
+	for name, set := range forged {
+		code, body := authRequest(m, set)
+		if code != http.StatusOK || body != "user:" {
+			t.Errorf("optional, %s: %d %q", name, code, body)
+		}
+	}
+}
+
+func TestAuthMiddlewareClientCache(t *testing.T) {
+	ctx := context.Background()
+	db := NewAuthDB("correct horse", "")
+	var validations int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/validate-session" {
+			atomic.AddInt32(&validations, 1)
+		}
+		db.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	c := &AuthClient{AuthServerAddr: srv.URL}
+	s := loginAs(t, c, "admin", "correct horse")
+	m := &AuthMiddleware{Client: c, Handler: echoUserID, Required: true, CacheTTL: 200 * time.Millisecond}
+	bearer := func(r *http.Request) {
+		r.Header.Set("Authorization", "Bearer "+s.Credential())
+	}
+	for i := 0; i < 3; i++ {
+		code, body := authRequest(m, bearer)
+		if code != http.StatusOK || body != "user:admin" {
+			t.Fatalf("%d %q", code, body)
+		}
+	}
+	if n := atomic.LoadInt32(&validations); n != 1 {
+		t.Fatalf("%d validations, want 1", n)
+	}
+	err := c.Logout(ctx, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, _ := authRequest(m, bearer)
+	if code != http.StatusOK {
+		t.Fatalf("cached session got %d", code)
+	}
+	time.Sleep(300 * time.Millisecond)
+	code, _ = authRequest(m, bearer)
+	if code != http.StatusUnauthorized {
+		t.Fatalf("logged out session got %d after CacheTTL", code)
+	}
+
+	// A session that cannot be checked is an error, not a missing user.
+	srv.Close()
+	token, err := db.Login("admin", "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, _ = authRequest(m, func(r *http.Request) {
+		r.Header.Set("Authorization", "Bearer admin:"+token)
+	})
+	if code != http.StatusInternalServerError {
+		t.Fatalf("unreachable auth server got %d", code)
+	}
+}
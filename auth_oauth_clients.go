@@ -0,0 +1,176 @@
+package web
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sort"
+)
+
+// CreateOAuthClient registers an app that can sign users in through the OpenID Connect endpoints.
+// Name, RedirectURIs and Public are taken from client; the rest is filled in.
+// It returns the new client and its secret, which is empty for public clients and cannot be retrieved again.
+func (s *AuthDB) CreateOAuthClient(client *OAuthClient) (*OAuthClient, string, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	c := &OAuthClient{
+		ID:           s.newID("oauth_client"),
+		Name:         client.Name,
+		RedirectURIs: client.RedirectURIs,
+		Public:       client.Public,
+		CreatedAt:    s.now().Unix(),
+	}
+	secret := ""
+	if !c.Public {
+		secret = randomToken(32)
+		c.SecretHash = apiKeyHash(secret)
+	}
+	err := s.commit("oauth_clients", c.ID, c)
+	if err != nil {
+		return nil, "", err
+	}
+	r := *c
+	r.SecretHash = ""
+	return &r, secret, nil
+}
+
+// ListOAuthClients returns the registered OAuth clients, oldest first, without their secret hashes.
+func (s *AuthDB) ListOAuthClients() []*OAuthClient {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	clients := []*OAuthClient{}
+	for _, c := range s.OAuthClients {
+		r := *c
+		r.SecretHash = ""
+		clients = append(clients, &r)
+	}
+	sort.Slice(clients, func(i, j int) bool {
+		return clients[i].CreatedAt < clients[j].CreatedAt
+	})
+	return clients
+}
+
+// DeleteOAuthClient deletes the OAuth client with the given ID.
+// Tokens already issued to it stop being accepted by introspection.
+// If it returns an error, it may be of type ErrInvalidClient.
+func (s *AuthDB) DeleteOAuthClient(clientID string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.OAuthClients[clientID]; !ok {
+		return ErrInvalidClient
+	}
+	return s.commit("oauth_clients", clientID, nil)
+}
+
+// authenticateClient returns the OAuth client making r.
+// Confidential clients authenticate with HTTP basic auth or the client_id and client_secret form values;
+// public clients only send client_id.
+// If it returns an error, it will be of type ErrInvalidClient.
+func (s *AuthDB) authenticateClient(r *http.Request) (*OAuthClient, error) {
+	clientID, secret, ok := r.BasicAuth()
+	if ok {
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID = r.PostFormValue("client_id")
+		secret = r.PostFormValue("client_secret")
+	}
+	return s.checkClient(clientID, secret)
+}
+
+// checkClient returns the OAuth client with the given ID if secret is its secret.
+// Public clients have no secret and are returned whatever secret is given.
+// If it returns an error, it will be of type ErrInvalidClient.
+func (s *AuthDB) checkClient(clientID, secret string) (*OAuthClient, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	c, ok := s.OAuthClients[clientID]
+	if !ok {
+		return nil, ErrInvalidClient
+	}
+	if c.Public {
+		return c, nil
+	}
+	if subtle.ConstantTimeCompare([]byte(c.SecretHash), []byte(apiKeyHash(secret))) != 1 {
+		return nil, ErrInvalidClient
+	}
+	return c, nil
+}
+
+// oauthClientRequest is the body of the OAuth client and signing key admin endpoints.
+type oauthClientRequest struct {
+	Session
+	Client   *OAuthClient `json:"client"`
+	ClientID string       `json:"client_id"`
+	Alg      string       `json:"alg"`
+}
+
+// oauthClientResponse is the response to a request to create an OAuth client.
+type oauthClientResponse struct {
+	Client *OAuthClient `json:"client"`
+	Secret string       `json:"secret"`
+}
+
+// oauthClients handles the OAuth client and signing key admin endpoints.
+// Every request must come from a session of a user with RoleAdmin.
+func (s *AuthDB) oauthClients(w http.ResponseWriter, r *http.Request) {
+	var req oauthClientRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	err = s.authorize(&req.Session, RoleAdmin)
+	if err == ErrInvalidSession {
+		writeError(w, http.StatusUnauthorized, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusForbidden, err)
+		return
+	}
+	switch r.URL.Path {
+	case "/oauth-clients":
+		json.NewEncoder(w).Encode(s.ListOAuthClients())
+		return
+	case "/create-oauth-client":
+		if req.Client == nil || len(req.Client.RedirectURIs) == 0 {
+			writeError(w, http.StatusBadRequest, ErrInvalidClient)
+			return
+		}
+		var resp oauthClientResponse
+		resp.Client, resp.Secret, err = s.CreateOAuthClient(req.Client)
+		s.audit(r, AuditOAuthClientCreated, req.UserID, "", err)
+		if err != nil {
+			ServeInternalServerError(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(&resp)
+		return
+	case "/delete-oauth-client":
+		err = s.DeleteOAuthClient(req.ClientID)
+		s.audit(r, AuditOAuthClientDeleted, req.UserID, "", err)
+	case "/rotate-signing-key":
+		var kid string
+		kid, err = s.RotateSigningKey(req.Alg)
+		s.audit(r, AuditSigningKeyRotated, req.UserID, "", err)
+		if err == nil {
+			json.NewEncoder(w).Encode(kid)
+			return
+		}
+	}
+	if err == ErrInvalidClient {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	if err == ErrUnsupportedAlgorithm {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err != nil {
+		ServeInternalServerError(w, r)
+		return
+	}
+}
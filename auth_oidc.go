@@ -0,0 +1,548 @@
+package web
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// authCodeTTL is how long an authorization code can be exchanged for tokens.
+const authCodeTTL = time.Minute
+
+// scopeDescriptions are the supported scopes and how they are described on the consent page.
+var scopeDescriptions = map[string]string{
+	"openid": "Your user ID",
+	"email":  "Your verified email address",
+}
+
+// authCode is an authorization code waiting to be exchanged at the token endpoint.
+// Codes only live in memory; a restart means signing in again.
+type authCode struct {
+	clientID      string
+	userID        string
+	redirectURI   string
+	scope         string
+	nonce         string
+	codeChallenge string
+	expiresAt     time.Time
+}
+
+// idTokenClaims are the claims of an ID token.
+type idTokenClaims struct {
+	Issuer        string `json:"iss"`
+	Subject       string `json:"sub"`
+	Audience      string `json:"aud"`
+	ExpiresAt     int64  `json:"exp"`
+	IssuedAt      int64  `json:"iat"`
+	Nonce         string `json:"nonce,omitempty"`
+	Email         string `json:"email,omitempty"`
+	EmailVerified bool   `json:"email_verified,omitempty"`
+}
+
+// accessTokenClaims are the claims of an access token, following RFC 9068.
+type accessTokenClaims struct {
+	Issuer    string `json:"iss"`
+	Subject   string `json:"sub"`
+	Audience  string `json:"aud"`
+	ExpiresAt int64  `json:"exp"`
+	IssuedAt  int64  `json:"iat"`
+	ID        string `json:"jti"`
+	ClientID  string `json:"client_id"`
+	Scope     string `json:"scope"`
+}
+
+// tokenResponse is the response of the token endpoint.
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+	IDToken     string `json:"id_token"`
+	Scope       string `json:"scope"`
+}
+
+// introspection is the response of the introspection endpoint.
+type introspection struct {
+	Active    bool   `json:"active"`
+	Subject   string `json:"sub,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Scope     string `json:"scope,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+	Issuer    string `json:"iss,omitempty"`
+	Audience  string `json:"aud,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+}
+
+// issuer returns the OpenID Connect issuer URL, falling back to the URL r was sent to.
+// X-Forwarded-Proto is only believed from TrustedProxies.
+func (s *AuthDB) issuer(r *http.Request) string {
+	if s.Issuer != "" {
+		return strings.TrimSuffix(s.Issuer, "/")
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if s.trustedProxy(clientIP(r)) {
+		proto := strings.TrimSpace(strings.Split(r.Header.Get("X-Forwarded-Proto"), ",")[0])
+		if proto == "http" || proto == "https" {
+			scheme = proto
+		}
+	}
+	return scheme + "://" + r.Host
+}
+
+// newAuthCode stores c and returns the code for it.
+func (s *AuthDB) newAuthCode(c *authCode) string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := s.now()
+	if s.authCodes == nil {
+		s.authCodes = map[string]*authCode{}
+	}
+	for code, c := range s.authCodes {
+		if now.After(c.expiresAt) {
+			delete(s.authCodes, code)
+		}
+	}
+	code := randomToken(32)
+	c.expiresAt = now.Add(authCodeTTL)
+	s.authCodes[code] = c
+	return code
+}
+
+// redeemAuthCode exchanges an authorization code sent by client for tokens signed for the given issuer.
+// If it returns an error, it will be of type ErrInvalidToken.
+func (s *AuthDB) redeemAuthCode(client *OAuthClient, code, redirectURI, codeVerifier, issuer string) (*tokenResponse, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	c, ok := s.authCodes[code]
+	delete(s.authCodes, code)
+	now := s.now()
+	if !ok || now.After(c.expiresAt) || c.clientID != client.ID || c.redirectURI != redirectURI {
+		return nil, ErrInvalidToken
+	}
+	if c.codeChallenge != "" || client.Public {
+		sum := sha256.Sum256([]byte(codeVerifier))
+		challenge := base64.RawURLEncoding.EncodeToString(sum[:])
+		if codeVerifier == "" || subtle.ConstantTimeCompare([]byte(challenge), []byte(c.codeChallenge)) != 1 {
+			return nil, ErrInvalidToken
+		}
+	}
+	user, ok := s.Users[c.userID]
+	if !ok || user.Disabled {
+		return nil, ErrInvalidToken
+	}
+	key, err := s.signingKey()
+	if err != nil {
+		return nil, err
+	}
+	expiresAt := now.Add(s.AccessTokenTTL).Unix()
+	idClaims := &idTokenClaims{
+		Issuer:    issuer,
+		Subject:   c.userID,
+		Audience:  client.ID,
+		ExpiresAt: expiresAt,
+		IssuedAt:  now.Unix(),
+		Nonce:     c.nonce,
+	}
+	if containsString(strings.Fields(c.scope), "email") {
+		idClaims.Email = user.verifiedEmail()
+		idClaims.EmailVerified = idClaims.Email != ""
+	}
+	idToken, err := signJWT(key, "JWT", idClaims)
+	if err != nil {
+		return nil, err
+	}
+	accessToken, err := signJWT(key, "at+jwt", &accessTokenClaims{
+		Issuer:    issuer,
+		Subject:   c.userID,
+		Audience:  client.ID,
+		ExpiresAt: expiresAt,
+		IssuedAt:  now.Unix(),
+		ID:        randomToken(16),
+		ClientID:  client.ID,
+		Scope:     c.scope,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &tokenResponse{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(s.AccessTokenTTL / time.Second),
+		IDToken:     idToken,
+		Scope:       c.scope,
+	}, nil
+}
+
+// checkAccessToken returns the claims of an access token issued by s.
+// The token must be unexpired and its user and client must still exist; the user must not be disabled.
+// If it returns an error, it will be of type ErrInvalidToken.
+func (s *AuthDB) checkAccessToken(token string) (*accessTokenClaims, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	var claims accessTokenClaims
+	err := parseJWT(s.verificationKey, "at+jwt", token, &claims)
+	if err != nil {
+		return nil, err
+	}
+	if s.now().Unix() >= claims.ExpiresAt {
+		return nil, ErrInvalidToken
+	}
+	user, ok := s.Users[claims.Subject]
+	if !ok || user.Disabled {
+		return nil, ErrInvalidToken
+	}
+	if _, ok := s.OAuthClients[claims.ClientID]; !ok {
+		return nil, ErrInvalidToken
+	}
+	return &claims, nil
+}
+
+// hasConsent returns true if the given user has allowed client to see every scope in scope.
+func (s *AuthDB) hasConsent(userID, clientID, scope string) bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	user, ok := s.Users[userID]
+	if !ok {
+		return false
+	}
+	for _, sc := range strings.Fields(scope) {
+		if !containsString(user.Consents[clientID], sc) {
+			return false
+		}
+	}
+	return true
+}
+
+// grantConsent records that the given user allows client to see the scopes in scope.
+func (s *AuthDB) grantConsent(userID, clientID, scope string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	user, ok := s.Users[userID]
+	if !ok {
+		return ErrUserNotFound
+	}
+	user = user.clone()
+	if user.Consents == nil {
+		user.Consents = map[string][]string{}
+	}
+	for _, sc := range strings.Fields(scope) {
+		if !containsString(user.Consents[clientID], sc) {
+			user.Consents[clientID] = append(user.Consents[clientID], sc)
+		}
+	}
+	return s.commit("users", userID, user)
+}
+
+// consentToken ties a consent form to the user and client it was shown for.
+func (s *AuthDB) consentToken(userID, clientID, scope string) string {
+	return tokenSignature(s.TokenSecret, "consent\x00"+userID+"\x00"+clientID+"\x00"+scope)
+}
+
+// browserUser returns the ID of the user whose session is in the session cookie of r, or "".
+func (s *AuthDB) browserUser(r *http.Request) string {
+	cookie, err := r.Cookie(DefaultSessionCookieName)
+	if err != nil {
+		return ""
+	}
+	session, ok := ParseCredential(cookie.Value)
+	if !ok || !s.checkSession(session.UserID, session.Token) {
+		return ""
+	}
+	return session.UserID
+}
+
+// supportedScope returns the scopes in scope that s supports, in order and without duplicates.
+func supportedScope(scope string) string {
+	scopes := []string{}
+	for _, sc := range strings.Fields(scope) {
+		if _, ok := scopeDescriptions[sc]; ok && !containsString(scopes, sc) {
+			scopes = append(scopes, sc)
+		}
+	}
+	return strings.Join(scopes, " ")
+}
+
+// discovery serves the OpenID Connect discovery document.
+func (s *AuthDB) discovery(w http.ResponseWriter, r *http.Request) {
+	issuer := s.issuer(r)
+	scopes := []string{}
+	for sc := range scopeDescriptions {
+		scopes = append(scopes, sc)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"issuer":                                issuer,
+		"authorization_endpoint":                issuer + "/authorize",
+		"token_endpoint":                        issuer + "/token",
+		"userinfo_endpoint":                     issuer + "/userinfo",
+		"introspection_endpoint":                issuer + "/introspect",
+		"jwks_uri":                              issuer + "/jwks",
+		"scopes_supported":                      scopes,
+		"response_types_supported":              []string{"code"},
+		"grant_types_supported":                 []string{"authorization_code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{AlgRS256, AlgEdDSA},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post", "none"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"claims_supported":                      []string{"iss", "sub", "aud", "exp", "iat", "nonce", "email", "email_verified"},
+	})
+}
+
+// jwks serves the public keys that tokens are verified with.
+func (s *AuthDB) jwks(w http.ResponseWriter, r *http.Request) {
+	keys, err := s.JWKS()
+	if err != nil {
+		ServeInternalServerError(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"keys": keys,
+	})
+}
+
+// authorizeParams are the parameters of an authorization request that are carried through login and consent.
+var authorizeParams = []string{"response_type", "client_id", "redirect_uri", "scope", "state", "nonce", "code_challenge", "code_challenge_method", "prompt"}
+
+// oidcAuthorize handles the authorization endpoint of the authorization code flow.
+// Users without a session are sent to LoginURL. Users who have not yet allowed the client
+// to see the requested scopes are shown a consent page, which posts back to this endpoint.
+func (s *AuthDB) oidcAuthorize(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		ServeBadRequest(w, r)
+		return
+	}
+	params := url.Values{}
+	for _, p := range authorizeParams {
+		if v := r.Form.Get(p); v != "" {
+			params.Set(p, v)
+		}
+	}
+	clientID := params.Get("client_id")
+	redirectURI := params.Get("redirect_uri")
+	s.lock.RLock()
+	client, ok := s.OAuthClients[clientID]
+	s.lock.RUnlock()
+	if !ok || !client.allowsRedirect(redirectURI) {
+		// The redirect URI cannot be trusted, so the error is shown to the user instead.
+		http.Error(w, "unknown client or redirect URI", http.StatusBadRequest)
+		return
+	}
+	fail := func(code string) {
+		redirectWithParams(w, r, redirectURI, url.Values{
+			"error": {code},
+			"state": {params.Get("state")},
+		})
+	}
+	if params.Get("response_type") != "code" {
+		fail("unsupported_response_type")
+		return
+	}
+	scope := supportedScope(params.Get("scope"))
+	if !containsString(strings.Fields(scope), "openid") {
+		fail("invalid_scope")
+		return
+	}
+	challenge := params.Get("code_challenge")
+	if challenge == "" && client.Public || challenge != "" && params.Get("code_challenge_method") != "S256" {
+		fail("invalid_request")
+		return
+	}
+	prompt := params.Get("prompt")
+	userID := s.browserUser(r)
+	if userID == "" {
+		if prompt == "none" {
+			fail("login_required")
+			return
+		}
+		if s.LoginURL == "" {
+			ServeUnauthorized(w, r)
+			return
+		}
+		returnTo := s.issuer(r) + "/authorize?" + params.Encode()
+		redirectWithParams(w, r, s.LoginURL, url.Values{
+			"return_to": {returnTo},
+		})
+		return
+	}
+	if isPOST(r) && r.PostForm.Get("consent") != "" {
+		token := r.PostForm.Get("consent_token")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.consentToken(userID, clientID, scope))) != 1 {
+			ServeBadRequest(w, r)
+			return
+		}
+		if r.PostForm.Get("consent") != "allow" {
+			fail("access_denied")
+			return
+		}
+		err = s.grantConsent(userID, clientID, scope)
+		if err != nil {
+			ServeInternalServerError(w, r)
+			return
+		}
+	} else if prompt == "consent" || !s.hasConsent(userID, clientID, scope) {
+		if prompt == "none" {
+			fail("consent_required")
+			return
+		}
+		s.serveConsent(w, r, client, userID, scope, params)
+		return
+	}
+	code := s.newAuthCode(&authCode{
+		clientID:      clientID,
+		userID:        userID,
+		redirectURI:   redirectURI,
+		scope:         scope,
+		nonce:         params.Get("nonce"),
+		codeChallenge: challenge,
+	})
+	redirectWithParams(w, r, redirectURI, url.Values{
+		"code":  {code},
+		"state": {params.Get("state")},
+	})
+}
+
+// serveConsent shows the page where the user allows or denies client access to scope.
+func (s *AuthDB) serveConsent(w http.ResponseWriter, r *http.Request, client *OAuthClient, userID, scope string, params url.Values) {
+	hidden := map[string]string{}
+	for name := range params {
+		hidden[name] = params.Get(name)
+	}
+	scopes := []string{}
+	for _, sc := range strings.Fields(scope) {
+		scopes = append(scopes, scopeDescriptions[sc])
+	}
+	name := client.Name
+	if name == "" {
+		name = client.ID
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Cache-Control", "no-store")
+	consentTmpl.Execute(w, map[string]any{
+		"Action":       s.issuer(r) + "/authorize",
+		"ClientName":   name,
+		"UserID":       userID,
+		"Scopes":       scopes,
+		"Params":       hidden,
+		"ConsentToken": s.consentToken(userID, client.ID, scope),
+	})
+}
+
+// redirectWithParams redirects to uri with params added to its query.
+// Empty params are left out.
+func redirectWithParams(w http.ResponseWriter, r *http.Request, uri string, params url.Values) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		ServeBadRequest(w, r)
+		return
+	}
+	q := u.Query()
+	for name := range params {
+		if v := params.Get(name); v != "" {
+			q.Set(name, v)
+		}
+	}
+	u.RawQuery = q.Encode()
+	http.Redirect(w, r, u.String(), http.StatusSeeOther)
+}
+
+// token handles the token endpoint.
+// It exchanges an authorization code for an ID token and an access token.
+func (s *AuthDB) token(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	if !isPOST(r) {
+		writeOAuthError(w, http.StatusMethodNotAllowed, "invalid_request")
+		return
+	}
+	err := r.ParseForm()
+	if err != nil {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+	client, err := s.authenticateClient(r)
+	if err != nil {
+		writeOAuthError(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		writeOAuthError(w, http.StatusBadRequest, "unsupported_grant_type")
+		return
+	}
+	resp, err := s.redeemAuthCode(client, r.PostForm.Get("code"), r.PostForm.Get("redirect_uri"), r.PostForm.Get("code_verifier"), s.issuer(r))
+	if err == ErrInvalidToken {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_grant")
+		return
+	}
+	if err != nil {
+		ServeInternalServerError(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// introspect handles the token introspection endpoint of RFC 7662.
+// Only confidential clients may introspect tokens.
+func (s *AuthDB) introspect(w http.ResponseWriter, r *http.Request) {
+	client, err := s.authenticateClient(r)
+	if err != nil || client.Public {
+		writeOAuthError(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	claims, err := s.checkAccessToken(r.PostFormValue("token"))
+	if err != nil {
+		json.NewEncoder(w).Encode(&introspection{})
+		return
+	}
+	json.NewEncoder(w).Encode(&introspection{
+		Active:    true,
+		Subject:   claims.Subject,
+		ClientID:  claims.ClientID,
+		Scope:     claims.Scope,
+		ExpiresAt: claims.ExpiresAt,
+		IssuedAt:  claims.IssuedAt,
+		Issuer:    claims.Issuer,
+		Audience:  claims.Audience,
+		TokenType: "Bearer",
+	})
+}
+
+// userinfo handles the OpenID Connect userinfo endpoint.
+// It returns the claims that the bearer access token's scope allows.
+func (s *AuthDB) userinfo(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	claims, err := s.checkAccessToken(token)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		writeOAuthError(w, http.StatusUnauthorized, "invalid_token")
+		return
+	}
+	info := map[string]any{
+		"sub": claims.Subject,
+	}
+	if containsString(strings.Fields(claims.Scope), "email") {
+		email := ""
+		s.lock.RLock()
+		if user, ok := s.Users[claims.Subject]; ok {
+			email = user.verifiedEmail()
+		}
+		s.lock.RUnlock()
+		if email != "" {
+			info["email"] = email
+			info["email_verified"] = true
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(info)
+}
@@ -0,0 +1,189 @@
+package web
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// pkceChallenge returns the S256 code challenge of verifier.
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// authorize runs the authorization endpoint of srv for the user of session, allowing the consent form
+// if it is shown, and returns the code it redirects to redirectURI with.
+func authorize(t *testing.T, srv *httptest.Server, db *AuthDB, session *Session, clientID, redirectURI, verifier string) string {
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {clientID},
+		"redirect_uri":          {redirectURI},
+		"scope":                 {"openid"},
+		"state":                 {"st"},
+		"code_challenge":        {pkceChallenge(verifier)},
+		"code_challenge_method": {"S256"},
+	}
+	params.Set("consent", "allow")
+	params.Set("consent_token", db.consentToken(session.UserID, clientID, "openid"))
+	req, err := http.NewRequest("POST", srv.URL+"/authorize", strings.NewReader(params.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(&http.Cookie{Name: DefaultSessionCookieName, Value: session.Credential()})
+	res, err := noRedirect.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	loc, err := url.Parse(res.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := loc.Query().Get("code")
+	if res.StatusCode != http.StatusSeeOther || code == "" || loc.Query().Get("state") != "st" ||
+		!strings.HasPrefix(loc.String(), redirectURI+"?") {
+		t.Fatalf("authorize: %d %s", res.StatusCode, loc)
+	}
+	return code
+}
+
+// exchangeCode calls the token endpoint of srv and returns the status and the decoded response.
+func exchangeCode(t *testing.T, srv *httptest.Server, client *OAuthClient, secret, code, redirectURI, verifier string) (int, map[string]any) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"code_verifier": {verifier},
+		"client_id":     {client.ID},
+	}
+	if secret != "" {
+		form.Set("client_secret", secret)
+	}
+	res, err := http.PostForm(srv.URL+"/token", form)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	var m map[string]any
+	err = json.NewDecoder(res.Body).Decode(&m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res.StatusCode, m
+}
+
+func TestOIDCAuthorizationCodeWithPKCE(t *testing.T) {
+	ctx := context.Background()
+	db := NewAuthDB("correct horse", "")
+	srv := httptest.NewServer(db)
+	defer srv.Close()
+	c := &AuthClient{AuthServerAddr: srv.URL}
+	admin := loginAs(t, c, "admin", "correct horse")
+	confidential, secret, err := c.CreateOAuthClient(ctx, admin, &OAuthClient{Name: "App", RedirectURIs: []string{"https://app.test/cb"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	public, _, err := c.CreateOAuthClient(ctx, admin, &OAuthClient{Name: "SPA", RedirectURIs: []string{"https://spa.test/cb"}, Public: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const verifier = "a-verifier-that-is-long-enough-for-rfc-7636"
+
+	for _, tc := range []struct {
+		client      *OAuthClient
+		secret      string
+		redirectURI string
+	}{
+		{confidential, secret, "https://app.test/cb"},
+		{public, "", "https://spa.test/cb"},
+	} {
+		code := authorize(t, srv, db, admin, tc.client.ID, tc.redirectURI, verifier)
+		status, resp := exchangeCode(t, srv, tc.client, tc.secret, code, tc.redirectURI, verifier)
+		if status != http.StatusOK || resp["id_token"] == nil || resp["access_token"] == nil {
+			t.Fatalf("%s: %d %v", tc.client.Name, status, resp)
+		}
+		var claims idTokenClaims
+		err = parseJWT(db.verificationKey, "JWT", resp["id_token"].(string), &claims)
+		if err != nil || claims.Subject != "admin" || claims.Audience != tc.client.ID || claims.Issuer != srv.URL {
+			t.Fatalf("%s: %+v %v", tc.client.Name, claims, err)
+		}
+		status, resp = exchangeCode(t, srv, tc.client, tc.secret, code, tc.redirectURI, verifier)
+		if status != http.StatusBadRequest || resp["error"] != "invalid_grant" {
+			t.Fatalf("%s: reused code: %d %v", tc.client.Name, status, resp)
+		}
+
+		code = authorize(t, srv, db, admin, tc.client.ID, tc.redirectURI, verifier)
+		status, resp = exchangeCode(t, srv, tc.client, tc.secret, code, tc.redirectURI, "another-verifier-that-is-long-enough-for-7636")
+		if status != http.StatusBadRequest || resp["error"] != "invalid_grant" {
+			t.Fatalf("%s: bad verifier: %d %v", tc.client.Name, status, resp)
+		}
+		// A failed exchange spends the code, so guessing verifiers gets one try.
+		status, _ = exchangeCode(t, srv, tc.client, tc.secret, code, tc.redirectURI, verifier)
+		if status != http.StatusBadRequest {
+			t.Fatalf("%s: code after a bad verifier: %d", tc.client.Name, status)
+		}
+
+		code = authorize(t, srv, db, admin, tc.client.ID, tc.redirectURI, verifier)
+		status, resp = exchangeCode(t, srv, tc.client, tc.secret, code, tc.redirectURI+"/other", verifier)
+		if status != http.StatusBadRequest || resp["error"] != "invalid_grant" {
+			t.Fatalf("%s: wrong redirect_uri: %d %v", tc.client.Name, status, resp)
+		}
+
+		code = authorize(t, srv, db, admin, tc.client.ID, tc.redirectURI, verifier)
+		status, resp = exchangeCode(t, srv, tc.client, tc.secret, code, tc.redirectURI, "")
+		if status != http.StatusBadRequest || resp["error"] != "invalid_grant" {
+			t.Fatalf("%s: missing verifier: %d %v", tc.client.Name, status, resp)
+		}
+	}
+
+	code := authorize(t, srv, db, admin, confidential.ID, "https://app.test/cb", verifier)
+	status, resp := exchangeCode(t, srv, public, "", code, "https://app.test/cb", verifier)
+	if status != http.StatusBadRequest || resp["error"] != "invalid_grant" {
+		t.Fatalf("code of another client: %d %v", status, resp)
+	}
+	status, resp = exchangeCode(t, srv, confidential, "wrong secret", code, "https://app.test/cb", verifier)
+	if status != http.StatusUnauthorized || resp["error"] != "invalid_client" {
+		t.Fatalf("wrong secret: %d %v", status, resp)
+	}
+}
+
+func TestOIDCIssuer(t *testing.T) {
+	db := NewAuthDB("correct horse", "")
+	db.TrustedProxies = []string{"10.0.0.1"}
+	issuer := func(remoteAddr, proto string) string {
+		r := httptest.NewRequest("GET", "http://auth.test/.well-known/openid-configuration", nil)
+		r.RemoteAddr = remoteAddr
+		if proto != "" {
+			r.Header.Set("X-Forwarded-Proto", proto)
+		}
+		w := httptest.NewRecorder()
+		db.ServeHTTP(w, r)
+		var d map[string]any
+		err := json.NewDecoder(w.Body).Decode(&d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d["issuer"].(string)
+	}
+	if iss := issuer("192.0.2.1:1234", ""); iss != "http://auth.test" {
+		t.Fatalf("direct request: %s", iss)
+	}
+	if iss := issuer("192.0.2.1:1234", "https"); iss != "http://auth.test" {
+		t.Fatalf("X-Forwarded-Proto from a client was believed: %s", iss)
+	}
+	if iss := issuer("10.0.0.1:1234", "https"); iss != "https://auth.test" {
+		t.Fatalf("X-Forwarded-Proto from a trusted proxy: %s", iss)
+	}
+	db.Issuer = "https://login.example.com/"
+	if iss := issuer("192.0.2.1:1234", "http"); iss != "https://login.example.com" {
+		t.Fatalf("configured issuer: %s", iss)
+	}
+}
@@ -0,0 +1,15 @@
+package web
+
+import "encoding/json"
+
+// AuthOp is a single change to the state of an AuthDB.
+// Ops are written to an AuthStore before they are applied in memory.
+type AuthOp struct {
+	// Table is the name of the AuthDB map being changed, e.g. "users".
+	Table string `json:"table"`
+	// Key is the key within the table.
+	Key string `json:"key"`
+	// Value is the JSON encoded new value.
+	// A nil Value deletes the key.
+	Value json.RawMessage `json:"value,omitempty"`
+}
@@ -0,0 +1,569 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+)
+
+// CreateOrg creates an organization owned by the given user and returns a copy of it.
+// If it returns an error, it may be of type ErrUserNotFound.
+func (s *AuthDB) CreateOrg(userID, name string) (*Organization, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.Users[userID]; !ok {
+		return nil, ErrUserNotFound
+	}
+	org := &Organization{
+		ID:          s.newID("org"),
+		Name:        name,
+		CreatedAt:   s.now().Unix(),
+		Members:     map[string]*OrgMember{},
+		Invitations: map[string]*OrgInvitation{},
+	}
+	err := s.addOrgMember(org, userID, OrgRoleOwner)
+	if err != nil {
+		return nil, err
+	}
+	err = s.commit("orgs", org.ID, org)
+	if err != nil {
+		return nil, err
+	}
+	return org.clone(), nil
+}
+
+// Org returns a copy of the organization with the given ID.
+// If it returns an error, it will be of type ErrOrgNotFound.
+func (s *AuthDB) Org(orgID string) (*Organization, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	org, ok := s.Orgs[orgID]
+	if !ok {
+		return nil, ErrOrgNotFound
+	}
+	return org.clone(), nil
+}
+
+// UserOrgs returns copies of the organizations the given user is a member of, oldest first.
+func (s *AuthDB) UserOrgs(userID string) []*Organization {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	orgs := []*Organization{}
+	for _, org := range s.Orgs {
+		if _, ok := org.Members[userID]; ok {
+			orgs = append(orgs, org.clone())
+		}
+	}
+	sort.Slice(orgs, func(i, j int) bool {
+		return orgs[i].CreatedAt < orgs[j].CreatedAt
+	})
+	return orgs
+}
+
+// OrgRole returns the org role of the given user in the given organization, or "" if they have none.
+// Disabled users have no role, and admins of the AuthDB act as owners of every organization.
+func (s *AuthDB) OrgRole(orgID, userID string) string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	org, ok := s.Orgs[orgID]
+	if !ok {
+		return ""
+	}
+	return s.orgRole(org, userID)
+}
+
+// orgRole is like OrgRole.
+// The caller must hold s.lock.
+func (s *AuthDB) orgRole(org *Organization, userID string) string {
+	if s.hasRole(userID, RoleAdmin) {
+		return OrgRoleOwner
+	}
+	user, ok := s.Users[userID]
+	if !ok || user.Disabled {
+		return ""
+	}
+	m, ok := org.Members[userID]
+	if !ok {
+		return ""
+	}
+	return m.Role
+}
+
+// AuthorizeOrg returns nil if the given user has the given org role, or a more privileged one,
+// in the given organization. An empty role lets in every member.
+// If it returns an error, it will be of type ErrOrgNotFound or ErrForbidden.
+func (s *AuthDB) AuthorizeOrg(orgID, userID, role string) error {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	org, ok := s.Orgs[orgID]
+	if !ok {
+		return ErrOrgNotFound
+	}
+	rank := orgRoleRank(s.orgRole(org, userID))
+	if rank == 0 || rank < orgRoleRank(role) {
+		return ErrForbidden
+	}
+	return nil
+}
+
+// InviteToOrg invites a user to join an organization on behalf of the given member and returns the invitation.
+// UserID or Email and Role are taken from opts; the rest is filled in.
+// Members can only invite with roles less privileged than their own, so admins invite editors and viewers
+// and the owner may also invite admins.
+// If the user is invited by email and a Mailer is set, they are sent an email about the invitation;
+// if it cannot be sent, the invitation is taken back.
+// If it returns an error, it may be of type ErrOrgNotFound, ErrForbidden, ErrInvalidOrgRole,
+// ErrUserNotFound, ErrInvalidEmail or ErrAlreadyOrgMember.
+func (s *AuthDB) InviteToOrg(actorID, orgID string, opts *OrgInvitation) (*OrgInvitation, error) {
+	invitation := &OrgInvitation{
+		OrgID:     orgID,
+		UserID:    opts.UserID,
+		Role:      opts.Role,
+		InvitedBy: actorID,
+	}
+	if opts.Email != "" {
+		email, err := normalizeEmail(opts.Email)
+		if err != nil {
+			return nil, err
+		}
+		invitation.Email = email
+	}
+	if invitation.UserID == "" && invitation.Email == "" || invitation.UserID != "" && invitation.Email != "" {
+		return nil, ErrUserNotFound
+	}
+	s.lock.Lock()
+	org, err := s.manageOrg(orgID, actorID, invitation.Role)
+	if err != nil {
+		s.lock.Unlock()
+		return nil, err
+	}
+	if invitation.UserID != "" {
+		if _, ok := s.Users[invitation.UserID]; !ok {
+			s.lock.Unlock()
+			return nil, ErrUserNotFound
+		}
+		if _, ok := org.Members[invitation.UserID]; ok {
+			s.lock.Unlock()
+			return nil, ErrAlreadyOrgMember
+		}
+	}
+	invitation.ID = s.newID("org_invitation")
+	invitation.CreatedAt = s.now().Unix()
+	org.Invitations[invitation.ID] = invitation
+	err = s.commit("orgs", orgID, org)
+	s.lock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if invitation.Email != "" && s.Mailer != nil {
+		err = s.sendMail(&Mail{
+			To:      invitation.Email,
+			Subject: "You have been invited to join " + org.Name,
+			Body:    "You have been invited to join " + org.Name + ". To accept, log in with an account that has verified this email address.\n",
+		})
+		if err != nil {
+			s.undoOrgInvitation(orgID, invitation.ID)
+			return nil, err
+		}
+	}
+	c := *invitation
+	return &c, nil
+}
+
+// undoOrgInvitation takes back an invitation whose mail could not be sent, if it is still pending.
+func (s *AuthDB) undoOrgInvitation(orgID, invitationID string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	org, ok := s.Orgs[orgID]
+	if !ok {
+		return
+	}
+	if _, ok := org.Invitations[invitationID]; !ok {
+		return
+	}
+	org = org.clone()
+	delete(org.Invitations, invitationID)
+	s.commit("orgs", orgID, org)
+}
+
+// OrgInvitations returns the pending invitations for the given user, oldest first.
+// They are the invitations sent to the user's ID or to one of their verified email addresses.
+func (s *AuthDB) OrgInvitations(userID string) []*OrgInvitation {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	invitations := []*OrgInvitation{}
+	user, ok := s.Users[userID]
+	if !ok {
+		return invitations
+	}
+	for _, org := range s.Orgs {
+		for _, invitation := range org.Invitations {
+			if invitedUser(invitation, userID, user) {
+				c := *invitation
+				invitations = append(invitations, &c)
+			}
+		}
+	}
+	sort.Slice(invitations, func(i, j int) bool {
+		return invitations[i].CreatedAt < invitations[j].CreatedAt
+	})
+	return invitations
+}
+
+// invitedUser returns true if the invitation is meant for the given user.
+func invitedUser(invitation *OrgInvitation, userID string, user *User) bool {
+	if invitation.UserID != "" {
+		return invitation.UserID == userID
+	}
+	return user.VerifiedEmails[invitation.Email]
+}
+
+// AcceptOrgInvitation makes the given user a member of the organization with the role of the invitation.
+// If it returns an error, it may be of type ErrOrgNotFound, ErrOrgInvitationNotFound or ErrAlreadyOrgMember.
+func (s *AuthDB) AcceptOrgInvitation(userID, orgID, invitationID string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	org, invitation, err := s.takeOrgInvitation(userID, orgID, invitationID)
+	if err != nil {
+		return err
+	}
+	if _, ok := org.Members[userID]; ok {
+		return ErrAlreadyOrgMember
+	}
+	err = s.addOrgMember(org, userID, invitation.Role)
+	if err != nil {
+		return err
+	}
+	return s.commit("orgs", orgID, org)
+}
+
+// DeclineOrgInvitation deletes an invitation sent to the given user without joining the organization.
+// If it returns an error, it may be of type ErrOrgNotFound or ErrOrgInvitationNotFound.
+func (s *AuthDB) DeclineOrgInvitation(userID, orgID, invitationID string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	org, _, err := s.takeOrgInvitation(userID, orgID, invitationID)
+	if err != nil {
+		return err
+	}
+	return s.commit("orgs", orgID, org)
+}
+
+// takeOrgInvitation removes the invitation meant for the given user from a copy of its organization.
+// It returns the copy, which the caller commits, and the invitation.
+// If it returns an error, it will be of type ErrOrgNotFound or ErrOrgInvitationNotFound.
+// The caller must hold s.lock.
+func (s *AuthDB) takeOrgInvitation(userID, orgID, invitationID string) (*Organization, *OrgInvitation, error) {
+	org, ok := s.Orgs[orgID]
+	if !ok {
+		return nil, nil, ErrOrgNotFound
+	}
+	invitation, ok := org.Invitations[invitationID]
+	user, exists := s.Users[userID]
+	if !ok || !exists || user.Disabled || !invitedUser(invitation, userID, user) {
+		return nil, nil, ErrOrgInvitationNotFound
+	}
+	org = org.clone()
+	delete(org.Invitations, invitationID)
+	return org, invitation, nil
+}
+
+// RevokeOrgInvitation deletes a pending invitation on behalf of the given member.
+// Members can revoke the invitations they could have sent.
+// If it returns an error, it may be of type ErrOrgNotFound, ErrOrgInvitationNotFound or ErrForbidden.
+func (s *AuthDB) RevokeOrgInvitation(actorID, orgID, invitationID string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	org, ok := s.Orgs[orgID]
+	if !ok {
+		return ErrOrgNotFound
+	}
+	invitation, ok := org.Invitations[invitationID]
+	if !ok {
+		return ErrOrgInvitationNotFound
+	}
+	org, err := s.manageOrg(orgID, actorID, invitation.Role)
+	if err != nil {
+		return err
+	}
+	delete(org.Invitations, invitationID)
+	return s.commit("orgs", orgID, org)
+}
+
+// SetOrgRole changes the org role of a member on behalf of another member.
+// Members can only change the roles of less privileged members, to roles less privileged than their own.
+// Use TransferOrgOwnership to make a member the owner.
+// If it returns an error, it may be of type ErrOrgNotFound, ErrOrgMemberNotFound, ErrForbidden or ErrInvalidOrgRole.
+func (s *AuthDB) SetOrgRole(actorID, orgID, userID, role string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	org, err := s.manageOrg(orgID, actorID, role)
+	if err != nil {
+		return err
+	}
+	m, ok := org.Members[userID]
+	if !ok {
+		return ErrOrgMemberNotFound
+	}
+	if orgRoleRank(m.Role) >= orgRoleRank(s.orgRole(org, actorID)) {
+		return ErrForbidden
+	}
+	m.Role = role
+	return s.commit("orgs", orgID, org)
+}
+
+// RemoveOrgMember removes a member from an organization on behalf of the given member.
+// Members can leave by removing themselves, except the owner, who must transfer ownership first.
+// Otherwise members can only remove less privileged members, and only admins and the owner can remove anyone.
+// If it returns an error, it may be of type ErrOrgNotFound, ErrOrgMemberNotFound or ErrForbidden.
+func (s *AuthDB) RemoveOrgMember(actorID, orgID, userID string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	org, ok := s.Orgs[orgID]
+	if !ok {
+		return ErrOrgNotFound
+	}
+	m, ok := org.Members[userID]
+	if !ok {
+		return ErrOrgMemberNotFound
+	}
+	if m.Role == OrgRoleOwner {
+		return ErrForbidden
+	}
+	if actorID != userID {
+		actorRole := s.orgRole(org, actorID)
+		if orgRoleRank(actorRole) < orgRoleRank(OrgRoleAdmin) || orgRoleRank(m.Role) >= orgRoleRank(actorRole) {
+			return ErrForbidden
+		}
+	}
+	org = org.clone()
+	err := s.removeOrgMember(org, userID)
+	if err != nil {
+		return err
+	}
+	return s.commit("orgs", orgID, org)
+}
+
+// TransferOrgOwnership makes the given member the owner of an organization on behalf of its owner,
+// who becomes an admin.
+// If it returns an error, it may be of type ErrOrgNotFound, ErrOrgMemberNotFound or ErrForbidden.
+func (s *AuthDB) TransferOrgOwnership(actorID, orgID, userID string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	org, ok := s.Orgs[orgID]
+	if !ok {
+		return ErrOrgNotFound
+	}
+	if s.orgRole(org, actorID) != OrgRoleOwner {
+		return ErrForbidden
+	}
+	if _, ok := org.Members[userID]; !ok {
+		return ErrOrgMemberNotFound
+	}
+	org = org.clone()
+	for _, m := range org.Members {
+		if m.Role == OrgRoleOwner {
+			m.Role = OrgRoleAdmin
+		}
+	}
+	org.Members[userID].Role = OrgRoleOwner
+	return s.commit("orgs", orgID, org)
+}
+
+// manageOrg returns a copy of the organization, which the caller commits, if the given member may
+// give others the given role: it must be an org role other than OrgRoleOwner and less privileged than the member's own,
+// and the member must be at least an admin.
+// If it returns an error, it will be of type ErrOrgNotFound, ErrInvalidOrgRole or ErrForbidden.
+// The caller must hold s.lock.
+func (s *AuthDB) manageOrg(orgID, actorID, role string) (*Organization, error) {
+	org, ok := s.Orgs[orgID]
+	if !ok {
+		return nil, ErrOrgNotFound
+	}
+	if orgRoleRank(role) == 0 || role == OrgRoleOwner {
+		return nil, ErrInvalidOrgRole
+	}
+	actorRank := orgRoleRank(s.orgRole(org, actorID))
+	if actorRank < orgRoleRank(OrgRoleAdmin) || orgRoleRank(role) >= actorRank {
+		return nil, ErrForbidden
+	}
+	return org.clone(), nil
+}
+
+// addOrgMember adds the given user to org with the given role and records the org in the user's Orgs.
+// The org must be a copy that the caller commits.
+// The caller must hold s.lock.
+func (s *AuthDB) addOrgMember(org *Organization, userID, role string) error {
+	user, ok := s.Users[userID]
+	if !ok {
+		return ErrUserNotFound
+	}
+	org.Members[userID] = &OrgMember{Role: role, JoinedAt: s.now().Unix()}
+	for _, id := range user.Orgs {
+		if id == org.ID {
+			return nil
+		}
+	}
+	user = user.clone()
+	user.Orgs = append(user.Orgs, org.ID)
+	return s.commit("users", userID, user)
+}
+
+// removeOrgMember removes the given user from org and from the user's Orgs.
+// The org must be a copy that the caller commits.
+// The caller must hold s.lock.
+func (s *AuthDB) removeOrgMember(org *Organization, userID string) error {
+	delete(org.Members, userID)
+	user, ok := s.Users[userID]
+	if !ok {
+		return nil
+	}
+	user = user.clone()
+	orgs := []string{}
+	for _, id := range user.Orgs {
+		if id != org.ID {
+			orgs = append(orgs, id)
+		}
+	}
+	user.Orgs = orgs
+	return s.commit("users", userID, user)
+}
+
+// forgetOrgUser removes a deleted user from the members and invitations of every organization.
+// An organization whose owner is deleted has no owner until an admin transfers its ownership.
+// The caller must hold s.lock.
+func (s *AuthDB) forgetOrgUser(userID string) error {
+	for orgID, org := range s.Orgs {
+		changed := false
+		c := org.clone()
+		if _, ok := c.Members[userID]; ok {
+			delete(c.Members, userID)
+			changed = true
+		}
+		for id, invitation := range c.Invitations {
+			if invitation.UserID == userID || invitation.InvitedBy == userID {
+				delete(c.Invitations, id)
+				changed = true
+			}
+		}
+		if changed {
+			err := s.commit("orgs", orgID, c)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// orgRequest is the body of the org endpoints.
+type orgRequest struct {
+	Session
+	OrgID        string `json:"org_id"`
+	Name         string `json:"name"`
+	TargetUserID string `json:"target_user_id"`
+	Email        string `json:"email"`
+	Role         string `json:"role"`
+	InvitationID string `json:"invitation_id"`
+}
+
+// orgs handles the org endpoints.
+// Every request must come from a valid session; what it may do depends on the user's role in the org.
+func (s *AuthDB) orgs(w http.ResponseWriter, r *http.Request) {
+	var req orgRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if !s.checkSession(req.UserID, req.Token) {
+		writeError(w, http.StatusUnauthorized, ErrInvalidSession)
+		return
+	}
+	event := &AuditEvent{ActorID: req.UserID, UserID: req.TargetUserID, Detail: req.OrgID}
+	var resp any
+	switch r.URL.Path {
+	case "/create-org":
+		var org *Organization
+		org, err = s.CreateOrg(req.UserID, req.Name)
+		event.Type, event.UserID = AuditOrgCreated, req.UserID
+		if org != nil {
+			event.Detail = org.ID
+		}
+		resp = org
+	case "/orgs":
+		json.NewEncoder(w).Encode(s.UserOrgs(req.UserID))
+		return
+	case "/org":
+		var org *Organization
+		err = s.AuthorizeOrg(req.OrgID, req.UserID, OrgRoleViewer)
+		if err == nil {
+			org, err = s.Org(req.OrgID)
+		}
+		if err != nil {
+			writeOrgError(w, r, err)
+			return
+		}
+		json.NewEncoder(w).Encode(org)
+		return
+	case "/authorize-org":
+		err = s.AuthorizeOrg(req.OrgID, req.UserID, req.Role)
+		if err != nil {
+			writeOrgError(w, r, err)
+			return
+		}
+		json.NewEncoder(w).Encode(s.OrgRole(req.OrgID, req.UserID))
+		return
+	case "/invite-to-org":
+		var invitation *OrgInvitation
+		invitation, err = s.InviteToOrg(req.UserID, req.OrgID, &OrgInvitation{UserID: req.TargetUserID, Email: req.Email, Role: req.Role})
+		event.Type = AuditOrgMemberInvited
+		resp = invitation
+	case "/org-invitations":
+		json.NewEncoder(w).Encode(s.OrgInvitations(req.UserID))
+		return
+	case "/accept-org-invitation":
+		err = s.AcceptOrgInvitation(req.UserID, req.OrgID, req.InvitationID)
+		event.Type, event.UserID = AuditOrgInviteAccepted, req.UserID
+	case "/decline-org-invitation":
+		err = s.DeclineOrgInvitation(req.UserID, req.OrgID, req.InvitationID)
+		event.Type, event.UserID = AuditOrgInviteDeclined, req.UserID
+	case "/revoke-org-invitation":
+		err = s.RevokeOrgInvitation(req.UserID, req.OrgID, req.InvitationID)
+		event.Type = AuditOrgInviteRevoked
+	case "/set-org-role":
+		err = s.SetOrgRole(req.UserID, req.OrgID, req.TargetUserID, req.Role)
+		event.Type = AuditOrgRoleSet
+	case "/remove-org-member":
+		err = s.RemoveOrgMember(req.UserID, req.OrgID, req.TargetUserID)
+		event.Type = AuditOrgMemberRemoved
+	case "/transfer-org-ownership":
+		err = s.TransferOrgOwnership(req.UserID, req.OrgID, req.TargetUserID)
+		event.Type = AuditOrgOwnerChanged
+	}
+	s.auditEvent(r, event, err)
+	if err != nil {
+		writeOrgError(w, r, err)
+		return
+	}
+	if resp != nil {
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// writeOrgError answers a failed org request with the status that fits err.
+func writeOrgError(w http.ResponseWriter, r *http.Request, err error) {
+	switch err {
+	case ErrOrgNotFound, ErrOrgMemberNotFound, ErrOrgInvitationNotFound, ErrUserNotFound:
+		writeError(w, http.StatusNotFound, err)
+	case ErrInvalidOrgRole, ErrInvalidEmail:
+		writeError(w, http.StatusBadRequest, err)
+	case ErrForbidden:
+		writeError(w, http.StatusForbidden, err)
+	case ErrAlreadyOrgMember:
+		writeError(w, http.StatusConflict, err)
+	default:
+		ServeInternalServerError(w, r)
+	}
+}
@@ -0,0 +1,156 @@
+package web
+
+import (
+	"errors"
+	"testing"
+)
+
+// newOrgMembers returns an AuthDB with an organization owned by the first of n new users.
+func newOrgMembers(t *testing.T, n int) (*AuthDB, *Organization, []string) {
+	db := NewAuthDB("correct horse", "")
+	var users []string
+	for i := 0; i < n; i++ {
+		users = append(users, registerUser(t, db).UserID)
+	}
+	org, err := db.CreateOrg(users[0], "Team")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, org, users
+}
+
+// joinOrg invites the given user to org with the given role on behalf of actorID and accepts the invitation.
+func joinOrg(t *testing.T, db *AuthDB, actorID, orgID, userID, role string) {
+	invitation, err := db.InviteToOrg(actorID, orgID, &OrgInvitation{UserID: userID, Role: role})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.AcceptOrgInvitation(userID, orgID, invitation.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOrgInvitationRoles(t *testing.T) {
+	db, org, users := newOrgMembers(t, 5)
+	owner, admin, editor, outsider, invitee := users[0], users[1], users[2], users[3], users[4]
+	joinOrg(t, db, owner, org.ID, admin, OrgRoleAdmin)
+	joinOrg(t, db, admin, org.ID, editor, OrgRoleEditor)
+	tests := []struct {
+		actorID string
+		role    string
+		want    error
+	}{
+		{owner, OrgRoleOwner, ErrInvalidOrgRole},
+		{owner, "superuser", ErrInvalidOrgRole},
+		{admin, OrgRoleAdmin, ErrForbidden},
+		{editor, OrgRoleViewer, ErrForbidden},
+		{outsider, OrgRoleViewer, ErrForbidden},
+		{admin, OrgRoleEditor, nil},
+	}
+	for _, test := range tests {
+		_, err := db.InviteToOrg(test.actorID, org.ID, &OrgInvitation{UserID: invitee, Role: test.role})
+		if err != test.want {
+			t.Errorf("%s inviting as %s: %v, want %v", db.OrgRole(org.ID, test.actorID), test.role, err, test.want)
+		}
+	}
+	_, err := db.InviteToOrg(owner, org.ID, &OrgInvitation{UserID: admin, Role: OrgRoleViewer})
+	if err != ErrAlreadyOrgMember {
+		t.Fatalf("invited a member: %v", err)
+	}
+	invitations := db.OrgInvitations(invitee)
+	if len(invitations) != 1 {
+		t.Fatalf("%d invitations", len(invitations))
+	}
+	err = db.AcceptOrgInvitation(outsider, org.ID, invitations[0].ID)
+	if err != ErrOrgInvitationNotFound {
+		t.Fatalf("accepted another user's invitation: %v", err)
+	}
+	err = db.RevokeOrgInvitation(editor, org.ID, invitations[0].ID)
+	if err != ErrForbidden {
+		t.Fatalf("editor revoked an invitation: %v", err)
+	}
+	err = db.AcceptOrgInvitation(invitee, org.ID, invitations[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db.OrgRole(org.ID, invitee) != OrgRoleEditor {
+		t.Fatalf("joined as %q", db.OrgRole(org.ID, invitee))
+	}
+}
+
+func TestOrgMemberRules(t *testing.T) {
+	db, org, users := newOrgMembers(t, 4)
+	owner, admin, editor, viewer := users[0], users[1], users[2], users[3]
+	joinOrg(t, db, owner, org.ID, admin, OrgRoleAdmin)
+	joinOrg(t, db, owner, org.ID, editor, OrgRoleEditor)
+	joinOrg(t, db, owner, org.ID, viewer, OrgRoleViewer)
+
+	for _, err := range []error{
+		db.SetOrgRole(admin, org.ID, owner, OrgRoleViewer),
+		db.SetOrgRole(admin, org.ID, editor, OrgRoleAdmin),
+		db.SetOrgRole(editor, org.ID, viewer, OrgRoleViewer),
+		db.RemoveOrgMember(admin, org.ID, owner),
+		db.RemoveOrgMember(editor, org.ID, viewer),
+		db.TransferOrgOwnership(admin, org.ID, admin),
+	} {
+		if err != ErrForbidden {
+			t.Fatalf("got %v, want ErrForbidden", err)
+		}
+	}
+	err := db.SetOrgRole(admin, org.ID, editor, OrgRoleViewer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.RemoveOrgMember(viewer, org.ID, viewer)
+	if err != nil {
+		t.Fatalf("member could not leave: %v", err)
+	}
+	err = db.RemoveOrgMember(admin, org.ID, editor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, _ := db.User(editor)
+	if len(user.Orgs) != 0 || db.OrgRole(org.ID, editor) != "" {
+		t.Fatal("removed member is still in the org")
+	}
+
+	// The only owner cannot leave the org without one.
+	err = db.RemoveOrgMember(owner, org.ID, owner)
+	if err != ErrForbidden {
+		t.Fatalf("owner left: %v", err)
+	}
+	err = db.TransferOrgOwnership(owner, org.ID, admin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db.OrgRole(org.ID, admin) != OrgRoleOwner || db.OrgRole(org.ID, owner) != OrgRoleAdmin {
+		t.Fatal("ownership was not transferred")
+	}
+	err = db.RemoveOrgMember(owner, org.ID, owner)
+	if err != nil {
+		t.Fatalf("former owner could not leave: %v", err)
+	}
+}
+
+func TestInviteToOrgRollsBackWhenMailFails(t *testing.T) {
+	db, org, users := newOrgMembers(t, 1)
+	mailer := &FakeMailSender{Err: errors.New("smtp down")}
+	db.Mailer = mailer
+	_, err := db.InviteToOrg(users[0], org.ID, &OrgInvitation{Email: "Bob@Example.com", Role: OrgRoleViewer})
+	if err != mailer.Err {
+		t.Fatal(err)
+	}
+	org, _ = db.Org(org.ID)
+	if len(org.Invitations) != 0 {
+		t.Fatalf("failed invitation was kept: %d invitations", len(org.Invitations))
+	}
+	mailer.Err = nil
+	invitation, err := db.InviteToOrg(users[0], org.ID, &OrgInvitation{Email: "Bob@Example.com", Role: OrgRoleViewer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if invitation.Email != "bob@example.com" || mailer.Last("bob@example.com") == nil {
+		t.Fatalf("invitation to %q was not mailed", invitation.Email)
+	}
+}
@@ -0,0 +1,248 @@
+package web
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// AuthPages serves HTML pages for logging in, registering and logging out with a browser.
+// Logging in sets an HttpOnly session cookie that AuthMiddleware accepts, and every form is
+// protected against cross-site request forgery with CSRFToken.
+// The pages are served at /login, /register and /logout under Prefix.
+type AuthPages struct {
+	// DB logs users in locally.
+	DB *AuthDB
+	// Client logs users in through a remote AuthDB.
+	// It is only used if DB is nil.
+	Client *AuthClient
+	// Prefix is the path the pages are mounted at, such as "/auth".
+	Prefix string
+	// CookieName is the name of the session cookie.
+	// If it is empty, DefaultSessionCookieName is used.
+	CookieName string
+}
+
+// authPage is the data that the page templates are executed with.
+type authPage struct {
+	Title            string
+	Prefix           string
+	Error            string
+	CSRF             string
+	ReturnTo         string
+	Challenge        string
+	RegistrationCode string
+	UserID           string
+}
+
+func (p *AuthPages) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	page := &authPage{
+		Prefix:   p.Prefix,
+		CSRF:     CSRFToken(w, r),
+		ReturnTo: safeReturnTo(r, r.FormValue("return_to")),
+	}
+	if r.Method == http.MethodPost && !validCSRF(r) {
+		ServeForbidden(w, r)
+		return
+	}
+	switch strings.TrimPrefix(r.URL.Path, p.Prefix) {
+	case "/login":
+		p.serveLogin(w, r, page)
+	case "/register":
+		p.serveRegister(w, r, page)
+	case "/logout":
+		p.serveLogout(w, r, page)
+	default:
+		ServeNotFound(w, r)
+	}
+}
+
+// serveLogin shows the login form and logs the user in when it is posted.
+// Users with a second factor are then shown a form for their code.
+func (p *AuthPages) serveLogin(w http.ResponseWriter, r *http.Request, page *authPage) {
+	page.Title = "Log in"
+	if r.Method != http.MethodPost {
+		p.render(w, "login", page)
+		return
+	}
+	if challenge := r.PostFormValue("challenge"); challenge != "" {
+		session, err := p.verifyLogin(r, challenge, r.PostFormValue("code"))
+		if err == ErrInvalidCode {
+			page.Title = "Enter your code"
+			page.Challenge = challenge
+			page.Error = err.Error()
+			p.render(w, "challenge", page)
+			return
+		}
+		if err != nil {
+			page.Error = pageError(err)
+			p.render(w, "login", page)
+			return
+		}
+		p.startSession(w, r, session, page.ReturnTo)
+		return
+	}
+	resp, err := p.login(r, r.PostFormValue("user_id"), r.PostFormValue("password"))
+	if err != nil {
+		page.Error = pageError(err)
+		p.render(w, "login", page)
+		return
+	}
+	if resp.Challenge != "" {
+		page.Title = "Enter your code"
+		page.Challenge = resp.Challenge
+		p.render(w, "challenge", page)
+		return
+	}
+	p.startSession(w, r, &resp.Session, page.ReturnTo)
+}
+
+// serveRegister shows the registration form and creates the user when it is posted.
+// The new user is logged in and shown their user ID, which they need to log in again.
+func (p *AuthPages) serveRegister(w http.ResponseWriter, r *http.Request, page *authPage) {
+	page.Title = "Register"
+	page.RegistrationCode = r.FormValue("registration_code")
+	if r.Method != http.MethodPost {
+		p.render(w, "register", page)
+		return
+	}
+	password := r.PostFormValue("password")
+	userID, err := p.register(r, page.RegistrationCode, password)
+	if err != nil {
+		page.Error = pageError(err)
+		p.render(w, "register", page)
+		return
+	}
+	resp, err := p.login(r, userID, password)
+	if err != nil || resp.Challenge != "" {
+		ServeInternalServerError(w, r)
+		return
+	}
+	p.setCookie(w, r, resp.Session.Credential(), 0)
+	page.Title = "Welcome"
+	page.UserID = userID
+	p.render(w, "registered", page)
+}
+
+// serveLogout asks the user to confirm and ends the session when the form is posted.
+// Logging out takes a POST so that other sites cannot log users out with a link.
+func (p *AuthPages) serveLogout(w http.ResponseWriter, r *http.Request, page *authPage) {
+	page.Title = "Log out"
+	if r.Method != http.MethodPost {
+		p.render(w, "logout", page)
+		return
+	}
+	cookie, err := r.Cookie(p.cookieName())
+	if err == nil {
+		if session, ok := ParseCredential(cookie.Value); ok {
+			p.logout(r, session)
+		}
+	}
+	p.setCookie(w, r, "", -1)
+	http.Redirect(w, r, page.ReturnTo, http.StatusSeeOther)
+}
+
+// startSession sets the session cookie and sends the browser on to returnTo.
+func (p *AuthPages) startSession(w http.ResponseWriter, r *http.Request, session *Session, returnTo string) {
+	p.setCookie(w, r, session.Credential(), 0)
+	http.Redirect(w, r, returnTo, http.StatusSeeOther)
+}
+
+// setCookie sets the session cookie to value; a negative maxAge deletes it.
+func (p *AuthPages) setCookie(w http.ResponseWriter, r *http.Request, value string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     p.cookieName(),
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   isHTTPS(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (p *AuthPages) render(w http.ResponseWriter, name string, page *authPage) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Cache-Control", "no-store")
+	if page.Error != "" {
+		w.WriteHeader(http.StatusUnauthorized)
+	}
+	authPagesTmpl.ExecuteTemplate(w, name, page)
+}
+
+func (p *AuthPages) login(r *http.Request, userID, password string) (*LoginResponse, error) {
+	if p.DB != nil {
+		resp, err := p.DB.loginFrom(userID, password, p.DB.clientIP(r), r.UserAgent())
+		p.DB.auditLogin(r, userID, resp, err)
+		return resp, err
+	}
+	return p.Client.Login(r.Context(), userID, password)
+}
+
+func (p *AuthPages) verifyLogin(r *http.Request, challenge, code string) (*Session, error) {
+	if p.DB != nil {
+		userID := p.DB.challengeUser(challenge)
+		session, err := p.DB.VerifyLogin(challenge, code)
+		p.DB.audit(r, AuditSecondFactor, userID, userID, err)
+		return session, err
+	}
+	return p.Client.VerifyLogin(r.Context(), challenge, code)
+}
+
+func (p *AuthPages) register(r *http.Request, registrationCode, password string) (string, error) {
+	if p.DB != nil {
+		userID, err := p.DB.Register(registrationCode, password)
+		p.DB.audit(r, AuditRegister, userID, userID, err)
+		return userID, err
+	}
+	return p.Client.Register(r.Context(), registrationCode, password)
+}
+
+func (p *AuthPages) logout(r *http.Request, session *Session) error {
+	if p.DB != nil {
+		err := p.DB.Logout(session.UserID, session.Token)
+		p.DB.audit(r, AuditLogout, session.UserID, session.UserID, err)
+		return err
+	}
+	return p.Client.Logout(r.Context(), session)
+}
+
+func (p *AuthPages) cookieName() string {
+	if p.CookieName == "" {
+		return DefaultSessionCookieName
+	}
+	return p.CookieName
+}
+
+// pageError returns the message shown on a page for err.
+// Only the package's Err values are shown as they are.
+func pageError(err error) string {
+	if e, ok := err.(Error); ok {
+		return e.Error()
+	}
+	return "Something went wrong. Please try again."
+}
+
+// safeReturnTo returns returnTo if it is a path or a URL on the host of r, and "/" otherwise.
+func safeReturnTo(r *http.Request, returnTo string) string {
+	if strings.HasPrefix(returnTo, "/") && !strings.HasPrefix(returnTo, "//") && !strings.HasPrefix(returnTo, "/\\") {
+		return returnTo
+	}
+	u, err := url.Parse(returnTo)
+	if err == nil && (u.Scheme == "https" || u.Scheme == "http") && u.Host == r.Host {
+		return returnTo
+	}
+	return "/"
+}
+
+// pages returns the AuthPages that the AuthDB serves to browsers.
+func (s *AuthDB) pages() *AuthPages {
+	return &AuthPages{DB: s}
+}
+
+// isBrowserRequest returns true if r asks for HTML or posts a form.
+func isBrowserRequest(r *http.Request) bool {
+	contentType := r.Header.Get("Content-Type")
+	return IsHTML(r) || strings.HasPrefix(contentType, "application/x-www-form-urlencoded") || strings.HasPrefix(contentType, "multipart/form-data")
+}
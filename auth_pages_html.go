@@ -0,0 +1,8 @@
+package web
+
+import (
+	_ "embed"
+)
+
+//go:embed auth_pages.html
+var authPagesHTML string
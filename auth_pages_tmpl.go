@@ -0,0 +1,5 @@
+package web
+
+import "html/template"
+
+var authPagesTmpl = template.Must(template.New("auth_pages").Parse(authPagesHTML))
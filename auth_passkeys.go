@@ -0,0 +1,560 @@
+package web
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// passkeyCeremonyTTL is how long a browser has to answer a passkey ceremony.
+const passkeyCeremonyTTL = 5 * time.Minute
+
+// passkeyCeremony is a passkey registration or login waiting for the browser's answer.
+// Ceremonies only live in memory; a restart means starting again.
+type passkeyCeremony struct {
+	// userID is the user registering a passkey, or the user logging in if known beforehand.
+	userID    string
+	register  bool
+	expiresAt time.Time
+}
+
+// passkeyClientData is the client data that browsers hash into a passkey signature.
+type passkeyClientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+// BeginPasskeyRegistration starts adding a passkey to the given user.
+// The returned options are passed to navigator.credentials.create and its answer to FinishPasskeyRegistration.
+// If it returns an error, it may be of type ErrPasskeysNotConfigured or ErrUserNotFound.
+func (s *AuthDB) BeginPasskeyRegistration(userID string) (*PasskeyCreationOptions, error) {
+	if s.PasskeyRPID == "" {
+		return nil, ErrPasskeysNotConfigured
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	user, ok := s.Users[userID]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	name := userID
+	if len(user.Emails) != 0 {
+		name = user.PrimaryEmail()
+	}
+	rpName := s.PasskeyRPName
+	if rpName == "" {
+		rpName = s.PasskeyRPID
+	}
+	return &PasskeyCreationOptions{
+		Challenge: s.newPasskeyCeremony(userID, true),
+		RP:        PasskeyRP{ID: s.PasskeyRPID, Name: rpName},
+		User: PasskeyUser{
+			ID:          base64.RawURLEncoding.EncodeToString([]byte(userID)),
+			Name:        name,
+			DisplayName: name,
+		},
+		PubKeyCredParams: []PasskeyParam{
+			{Type: "public-key", Alg: coseES256},
+			{Type: "public-key", Alg: coseEdDSA},
+			{Type: "public-key", Alg: coseRS256},
+		},
+		Timeout:            passkeyCeremonyTTL.Milliseconds(),
+		ExcludeCredentials: passkeyDescriptors(user),
+		AuthenticatorSelection: PasskeySelection{
+			ResidentKey:      "preferred",
+			UserVerification: "required",
+		},
+		Attestation: "none",
+	}, nil
+}
+
+// FinishPasskeyRegistration checks the browser's answer to a registration started by BeginPasskeyRegistration
+// and adds the new passkey with the given name to the user.
+// Only the "none" attestation format is accepted, since the model of the authenticator is not checked.
+// If it returns an error, it may be of type ErrPasskeysNotConfigured, ErrUserNotFound, ErrInvalidChallenge,
+// ErrInvalidCredential or ErrUnsupportedAlgorithm.
+func (s *AuthDB) FinishPasskeyRegistration(userID, name string, credential *PasskeyCredential) (*Passkey, error) {
+	if s.PasskeyRPID == "" {
+		return nil, ErrPasskeysNotConfigured
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	_, c, err := s.checkClientData(credential, "webauthn.create")
+	if err != nil {
+		return nil, err
+	}
+	if !c.register || c.userID != userID {
+		return nil, ErrInvalidChallenge
+	}
+	user, ok := s.Users[userID]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	b, err := base64.RawURLEncoding.DecodeString(credential.Response.AttestationObject)
+	if err != nil {
+		return nil, ErrInvalidCredential
+	}
+	v, rest, err := cborDecode(b)
+	if err != nil || len(rest) != 0 {
+		return nil, ErrInvalidCredential
+	}
+	attestation, _ := v.(map[any]any)
+	format, _ := attestation["fmt"].(string)
+	authData, _ := attestation["authData"].([]byte)
+	if format != "none" {
+		return nil, ErrInvalidCredential
+	}
+	d, err := s.checkAuthenticatorData(authData)
+	if err != nil {
+		return nil, err
+	}
+	if d.flags&authDataAttested == 0 || len(d.credentialID) == 0 {
+		return nil, ErrInvalidCredential
+	}
+	id := base64.RawURLEncoding.EncodeToString(d.credentialID)
+	if id != credential.ID {
+		return nil, ErrInvalidCredential
+	}
+	if owner, _ := s.passkeyOwner(id); owner != "" {
+		return nil, ErrInvalidCredential
+	}
+	_, alg, err := parseCOSEKey(d.publicKey)
+	if err != nil {
+		return nil, err
+	}
+	now := s.now().Unix()
+	passkey := &Passkey{
+		ID:        id,
+		Name:      name,
+		PublicKey: d.publicKey,
+		Alg:       alg,
+		SignCount: d.signCount,
+		CreatedAt: now,
+	}
+	user = user.clone()
+	if user.Passkeys == nil {
+		user.Passkeys = map[string]*Passkey{}
+	}
+	user.Passkeys[id] = passkey
+	err = s.commit("users", userID, user)
+	if err != nil {
+		return nil, err
+	}
+	return passkey, nil
+}
+
+// BeginPasskeyLogin starts a passkey login.
+// If userID is empty, the browser offers every passkey the user has for the relying party;
+// otherwise only the passkeys of the given user are allowed.
+// The returned options are passed to navigator.credentials.get and its answer to FinishPasskeyLogin.
+// If it returns an error, it may be of type ErrPasskeysNotConfigured or ErrUserNotFound.
+func (s *AuthDB) BeginPasskeyLogin(userID string) (*PasskeyRequestOptions, error) {
+	if s.PasskeyRPID == "" {
+		return nil, ErrPasskeysNotConfigured
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	options := &PasskeyRequestOptions{
+		Timeout:          passkeyCeremonyTTL.Milliseconds(),
+		RPID:             s.PasskeyRPID,
+		AllowCredentials: []PasskeyDescriptor{},
+		UserVerification: "required",
+	}
+	if userID != "" {
+		user, ok := s.Users[userID]
+		if !ok {
+			return nil, ErrUserNotFound
+		}
+		options.AllowCredentials = passkeyDescriptors(user)
+	}
+	options.Challenge = s.newPasskeyCeremony(userID, false)
+	return options, nil
+}
+
+// FinishPasskeyLogin checks the browser's answer to a login started by BeginPasskeyLogin
+// and starts a session for the owner of the passkey.
+// A passkey stands in for both the password and the second factor.
+// The ip and userAgent describe the client and may be empty.
+// If it returns an error, it may be of type ErrPasskeysNotConfigured, ErrInvalidChallenge, ErrInvalidCredential,
+// ErrTooManyAttempts or ErrUserDisabled.
+func (s *AuthDB) FinishPasskeyLogin(credential *PasskeyCredential, ip, userAgent string) (*Session, error) {
+	if s.PasskeyRPID == "" {
+		return nil, ErrPasskeysNotConfigured
+	}
+	userID, err := s.checkPasskeyAssertion(credential, ip)
+	if err != nil {
+		return nil, err
+	}
+	token, err := s.startSession(userID, ip, userAgent)
+	if err != nil {
+		return nil, err
+	}
+	return &Session{
+		UserID: userID,
+		Token:  token,
+	}, nil
+}
+
+// checkPasskeyAssertion verifies the signature of a passkey login, saves the passkey's new
+// sign count and returns the ID of its owner.
+func (s *AuthDB) checkPasskeyAssertion(credential *PasskeyCredential, ip string) (string, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	clientData, c, err := s.checkClientData(credential, "webauthn.get")
+	if err != nil {
+		return "", err
+	}
+	if c.register {
+		return "", ErrInvalidChallenge
+	}
+	userID, passkey := s.passkeyOwner(credential.ID)
+	if userID == "" || c.userID != "" && c.userID != userID {
+		s.loginFailed("", ip)
+		return "", ErrInvalidCredential
+	}
+	if s.loginWait(userID, ip) > 0 {
+		return "", ErrTooManyAttempts
+	}
+	if credential.Response.UserHandle != "" {
+		handle, err := base64.RawURLEncoding.DecodeString(credential.Response.UserHandle)
+		if err != nil || string(handle) != userID {
+			s.loginFailed(userID, ip)
+			return "", ErrInvalidCredential
+		}
+	}
+	authData, err := base64.RawURLEncoding.DecodeString(credential.Response.AuthenticatorData)
+	if err != nil {
+		return "", ErrInvalidCredential
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(credential.Response.Signature)
+	if err != nil {
+		return "", ErrInvalidCredential
+	}
+	d, err := s.checkAuthenticatorData(authData)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(clientData)
+	err = passkey.verify(append(authData, hash[:]...), sig)
+	if err != nil {
+		s.loginFailed(userID, ip)
+		return "", err
+	}
+	// A counter that does not increase means the credential may have been cloned.
+	if (d.signCount != 0 || passkey.SignCount != 0) && d.signCount <= passkey.SignCount {
+		return "", ErrInvalidCredential
+	}
+	user := s.Users[userID]
+	if user.Disabled {
+		return "", ErrUserDisabled
+	}
+	s.accountThrottle().reset(userID)
+	user = user.clone()
+	p := user.Passkeys[passkey.ID]
+	p.SignCount = d.signCount
+	p.LastUsedAt = s.now().Unix()
+	err = s.commit("users", userID, user)
+	if err != nil {
+		return "", err
+	}
+	return userID, nil
+}
+
+// checkClientData checks the type, origin and challenge of the client data in credential,
+// and returns the client data with the ceremony it answers. The ceremony can only be answered once.
+// The caller must hold s.lock.
+func (s *AuthDB) checkClientData(credential *PasskeyCredential, typ string) ([]byte, *passkeyCeremony, error) {
+	b, err := base64.RawURLEncoding.DecodeString(credential.Response.ClientDataJSON)
+	if err != nil {
+		return nil, nil, ErrInvalidCredential
+	}
+	var clientData passkeyClientData
+	err = json.Unmarshal(b, &clientData)
+	if err != nil {
+		return nil, nil, ErrInvalidCredential
+	}
+	c, ok := s.ceremonies[clientData.Challenge]
+	delete(s.ceremonies, clientData.Challenge)
+	if !ok || s.now().After(c.expiresAt) {
+		return nil, nil, ErrInvalidChallenge
+	}
+	if clientData.Type != typ || !s.allowsPasskeyOrigin(clientData.Origin) {
+		return nil, nil, ErrInvalidCredential
+	}
+	return b, c, nil
+}
+
+// checkAuthenticatorData parses authenticator data and checks that it was made for this relying party
+// with the user present and verified. A passkey stands in for both the password and the second factor,
+// so a bare touch of the authenticator is not enough.
+func (s *AuthDB) checkAuthenticatorData(b []byte) (*authenticatorData, error) {
+	d, err := parseAuthenticatorData(b)
+	if err != nil {
+		return nil, err
+	}
+	rpIDHash := sha256.Sum256([]byte(s.PasskeyRPID))
+	if subtle.ConstantTimeCompare(d.rpIDHash, rpIDHash[:]) != 1 || d.flags&authDataUserPresent == 0 || d.flags&authDataUserVerified == 0 {
+		return nil, ErrInvalidCredential
+	}
+	return d, nil
+}
+
+// allowsPasskeyOrigin returns true if passkey ceremonies may be run by pages at the given origin.
+func (s *AuthDB) allowsPasskeyOrigin(origin string) bool {
+	if len(s.PasskeyOrigins) == 0 {
+		return origin == "https://"+s.PasskeyRPID
+	}
+	for _, o := range s.PasskeyOrigins {
+		if o == origin {
+			return true
+		}
+	}
+	return false
+}
+
+// newPasskeyCeremony records a passkey ceremony and returns its challenge.
+// The caller must hold s.lock.
+func (s *AuthDB) newPasskeyCeremony(userID string, register bool) string {
+	now := s.now()
+	if s.ceremonies == nil {
+		s.ceremonies = map[string]*passkeyCeremony{}
+	}
+	for challenge, c := range s.ceremonies {
+		if now.After(c.expiresAt) {
+			delete(s.ceremonies, challenge)
+		}
+	}
+	challenge := randomToken(32)
+	s.ceremonies[challenge] = &passkeyCeremony{
+		userID:    userID,
+		register:  register,
+		expiresAt: now.Add(passkeyCeremonyTTL),
+	}
+	return challenge
+}
+
+// passkeyOwner returns the ID of the user with the given passkey and the passkey, or "" and nil if there is none.
+// The caller must hold s.lock.
+func (s *AuthDB) passkeyOwner(id string) (string, *Passkey) {
+	for userID, user := range s.Users {
+		if p, ok := user.Passkeys[id]; ok {
+			return userID, p
+		}
+	}
+	return "", nil
+}
+
+// passkeyDescriptors returns descriptors of the user's passkeys.
+func passkeyDescriptors(user *User) []PasskeyDescriptor {
+	descriptors := []PasskeyDescriptor{}
+	for id := range user.Passkeys {
+		descriptors = append(descriptors, PasskeyDescriptor{Type: "public-key", ID: id})
+	}
+	sort.Slice(descriptors, func(i, j int) bool {
+		return descriptors[i].ID < descriptors[j].ID
+	})
+	return descriptors
+}
+
+// Passkeys returns the passkeys of the given user, oldest first.
+// If it returns an error, it will be of type ErrUserNotFound.
+func (s *AuthDB) Passkeys(userID string) ([]*Passkey, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	user, ok := s.Users[userID]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	passkeys := []*Passkey{}
+	for _, p := range user.clone().Passkeys {
+		passkeys = append(passkeys, p)
+	}
+	sort.Slice(passkeys, func(i, j int) bool {
+		if passkeys[i].CreatedAt != passkeys[j].CreatedAt {
+			return passkeys[i].CreatedAt < passkeys[j].CreatedAt
+		}
+		return passkeys[i].ID < passkeys[j].ID
+	})
+	return passkeys, nil
+}
+
+// RemovePasskey deletes the passkey with the given ID from the user.
+// If it returns an error, it may be of type ErrUserNotFound or ErrPasskeyNotFound.
+func (s *AuthDB) RemovePasskey(userID, passkeyID string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	user, ok := s.Users[userID]
+	if !ok {
+		return ErrUserNotFound
+	}
+	if _, ok := user.Passkeys[passkeyID]; !ok {
+		return ErrPasskeyNotFound
+	}
+	user = user.clone()
+	delete(user.Passkeys, passkeyID)
+	return s.commit("users", userID, user)
+}
+
+// writePasskeyError answers a failed passkey request with the status that fits err.
+func writePasskeyError(w http.ResponseWriter, r *http.Request, err error) {
+	switch err {
+	case ErrPasskeysNotConfigured:
+		writeError(w, http.StatusNotImplemented, err)
+	case ErrUserNotFound, ErrPasskeyNotFound:
+		writeError(w, http.StatusNotFound, err)
+	case ErrInvalidChallenge, ErrInvalidCredential, ErrUnsupportedAlgorithm:
+		writeError(w, http.StatusBadRequest, err)
+	case ErrUserDisabled:
+		writeError(w, http.StatusForbidden, err)
+	case ErrTooManyAttempts:
+		writeError(w, http.StatusTooManyRequests, err)
+	default:
+		ServeInternalServerError(w, r)
+	}
+}
+
+// beginPasskeyRegistration handles requests to start adding a passkey.
+// It returns the options for navigator.credentials.create.
+func (s *AuthDB) beginPasskeyRegistration(w http.ResponseWriter, r *http.Request) {
+	var session Session
+	err := json.NewDecoder(r.Body).Decode(&session)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if !s.checkSession(session.UserID, session.Token) {
+		writeError(w, http.StatusUnauthorized, ErrInvalidSession)
+		return
+	}
+	options, err := s.BeginPasskeyRegistration(session.UserID)
+	if err != nil {
+		writePasskeyError(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(options)
+}
+
+// finishPasskeyRegistration handles requests to finish adding a passkey.
+// It returns the new passkey.
+func (s *AuthDB) finishPasskeyRegistration(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Session
+		Name       string            `json:"name"`
+		Credential PasskeyCredential `json:"credential"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if !s.checkSession(req.UserID, req.Token) {
+		writeError(w, http.StatusUnauthorized, ErrInvalidSession)
+		return
+	}
+	passkey, err := s.FinishPasskeyRegistration(req.UserID, req.Name, &req.Credential)
+	s.audit(r, AuditPasskeyAdded, req.UserID, req.UserID, err)
+	if err != nil {
+		writePasskeyError(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(passkey)
+}
+
+// beginPasskeyLogin handles requests to start a passkey login.
+// The user ID is optional. It returns the options for navigator.credentials.get.
+func (s *AuthDB) beginPasskeyLogin(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		UserID string `json:"user_id"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	options, err := s.BeginPasskeyLogin(req.UserID)
+	if err != nil {
+		writePasskeyError(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(options)
+}
+
+// finishPasskeyLogin handles requests to finish a passkey login.
+// It returns the new session.
+func (s *AuthDB) finishPasskeyLogin(w http.ResponseWriter, r *http.Request) {
+	var credential PasskeyCredential
+	err := json.NewDecoder(r.Body).Decode(&credential)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	session, err := s.FinishPasskeyLogin(&credential, s.clientIP(r), r.UserAgent())
+	userID := ""
+	if session != nil {
+		userID = session.UserID
+	}
+	s.audit(r, AuditPasskeyLogin, userID, userID, err)
+	if err == ErrInvalidCredential {
+		writeError(w, http.StatusUnauthorized, err)
+		return
+	}
+	if err != nil {
+		writePasskeyError(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(session)
+}
+
+// passkeys handles requests to list the passkeys of a user.
+func (s *AuthDB) passkeys(w http.ResponseWriter, r *http.Request) {
+	var session Session
+	err := json.NewDecoder(r.Body).Decode(&session)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if !s.checkSession(session.UserID, session.Token) {
+		writeError(w, http.StatusUnauthorized, ErrInvalidSession)
+		return
+	}
+	passkeys, err := s.Passkeys(session.UserID)
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, ErrInvalidSession)
+		return
+	}
+	json.NewEncoder(w).Encode(passkeys)
+}
+
+// removePasskey handles requests to delete a passkey.
+func (s *AuthDB) removePasskey(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Session
+		PasskeyID string `json:"passkey_id"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if !s.checkSession(req.UserID, req.Token) {
+		writeError(w, http.StatusUnauthorized, ErrInvalidSession)
+		return
+	}
+	err = s.RemovePasskey(req.UserID, req.PasskeyID)
+	s.audit(r, AuditPasskeyRemoved, req.UserID, req.UserID, err)
+	if err != nil {
+		writePasskeyError(w, r, err)
+		return
+	}
+}
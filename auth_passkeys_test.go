@@ -0,0 +1,179 @@
+package web
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+)
+
+// newPasskeyUser returns an AuthDB for example.com with a user who has registered a passkey on a.
+func newPasskeyUser(t *testing.T, a *FakeAuthenticator) (*AuthDB, string, *Passkey) {
+	db := NewAuthDB("correct horse", "")
+	db.PasskeyRPID = "example.com"
+	code, err := db.Invite()
+	if err != nil {
+		t.Fatal(err)
+	}
+	userID, err := db.Register(code, "battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	options, err := db.BeginPasskeyRegistration(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if options.AuthenticatorSelection.UserVerification != "required" {
+		t.Fatalf("registration asks for user verification %q", options.AuthenticatorSelection.UserVerification)
+	}
+	credential, err := a.Create(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	passkey, err := db.FinishPasskeyRegistration(userID, "laptop", credential)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, userID, passkey
+}
+
+// passkeyLogin runs a passkey login for userID, or a discoverable one if userID is empty.
+func passkeyLogin(t *testing.T, db *AuthDB, a *FakeAuthenticator, userID string) (*Session, error) {
+	options, err := db.BeginPasskeyLogin(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	credential, err := a.Get(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db.FinishPasskeyLogin(credential, "", "")
+}
+
+func TestPasskeyLogin(t *testing.T) {
+	a := &FakeAuthenticator{Origin: "https://example.com"}
+	db, userID, passkey := newPasskeyUser(t, a)
+	options, err := db.BeginPasskeyLogin("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if options.UserVerification != "required" {
+		t.Fatalf("login asks for user verification %q", options.UserVerification)
+	}
+	credential, err := a.Get(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, err := db.FinishPasskeyLogin(credential, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.UserID != userID || !db.checkSession(userID, session.Token) {
+		t.Fatal("passkey login gave no valid session")
+	}
+	_, err = db.FinishPasskeyLogin(credential, "", "")
+	if err == nil {
+		t.Fatal("replayed assertion accepted")
+	}
+	passkeys, err := db.Passkeys(userID)
+	if err != nil || len(passkeys) != 1 || passkeys[0].SignCount != 1 {
+		t.Fatal(passkeys, err)
+	}
+	err = db.RemovePasskey(userID, passkey.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = passkeyLogin(t, db, a, "")
+	if err != ErrInvalidCredential {
+		t.Fatal(err)
+	}
+}
+
+func TestPasskeyRequiresUserVerification(t *testing.T) {
+	a := &FakeAuthenticator{Origin: "https://example.com"}
+	db, userID, _ := newPasskeyUser(t, a)
+	a.NoUserVerification = true
+	_, err := passkeyLogin(t, db, a, userID)
+	if err != ErrInvalidCredential {
+		t.Fatalf("assertion without user verification: %v", err)
+	}
+	options, err := db.BeginPasskeyRegistration(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	credential, err := (&FakeAuthenticator{Origin: "https://example.com", NoUserVerification: true}).Create(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.FinishPasskeyRegistration(userID, "security key", credential)
+	if err != ErrInvalidCredential {
+		t.Fatalf("registration without user verification: %v", err)
+	}
+}
+
+func TestPasskeyRejectsForgeries(t *testing.T) {
+	a := &FakeAuthenticator{Origin: "https://example.com"}
+	db, userID, passkey := newPasskeyUser(t, a)
+	evil := &FakeAuthenticator{Origin: "https://evil.example", credentials: a.credentials}
+	_, err := passkeyLogin(t, db, evil, userID)
+	if err != ErrInvalidCredential {
+		t.Fatalf("assertion from another origin: %v", err)
+	}
+	db.Unlock(userID)
+	options, _ := db.BeginPasskeyLogin(userID)
+	credential, _ := a.Get(options)
+	sig := credential.Response.Signature
+	credential.Response.Signature = sig[:len(sig)-4] + "AAAA"
+	_, err = db.FinishPasskeyLogin(credential, "", "")
+	if err != ErrInvalidCredential {
+		t.Fatalf("tampered signature: %v", err)
+	}
+	db.Unlock(userID)
+	_, err = passkeyLogin(t, db, a, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A sign count that does not go up means the credential may have been cloned.
+	a.credentials[passkey.ID].signCount = 0
+	_, err = passkeyLogin(t, db, a, userID)
+	if err != ErrInvalidCredential {
+		t.Fatalf("stale sign count: %v", err)
+	}
+}
+
+func TestPasskeyClient(t *testing.T) {
+	ctx := context.Background()
+	db := NewAuthDB("correct horse", "")
+	db.PasskeyRPID = "example.com"
+	server := httptest.NewServer(db)
+	defer server.Close()
+	c := &AuthClient{AuthServerAddr: server.URL}
+	resp, err := c.Login(ctx, "admin", "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &FakeAuthenticator{Origin: "https://example.com"}
+	options, err := c.BeginPasskeyRegistration(ctx, &resp.Session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	credential, err := a.Create(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.FinishPasskeyRegistration(ctx, &resp.Session, "laptop", credential)
+	if err != nil {
+		t.Fatal(err)
+	}
+	requestOptions, err := c.BeginPasskeyLogin(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertion, err := a.Get(requestOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, err := c.FinishPasskeyLogin(ctx, assertion)
+	if err != nil || session.UserID != "admin" {
+		t.Fatal(session, err)
+	}
+}
@@ -0,0 +1,52 @@
+package web
+
+// passwordHasher returns the hasher for new passwords.
+func (s *AuthDB) passwordHasher() PasswordHasher {
+	if s.PasswordHasher == nil {
+		return defaultPasswordHasher
+	}
+	return s.PasswordHasher
+}
+
+// passwordMatches returns true if hash is a hash of password.
+// The hash is checked with the PasswordHasher first and then with the built-in hashers,
+// so that hashes made by a custom hasher and by earlier configurations both keep working.
+func (s *AuthDB) passwordMatches(hash, password string) bool {
+	if s.PasswordHasher != nil && s.PasswordHasher.Verify(hash, password) {
+		return true
+	}
+	return passwordHashMatches(hash, password)
+}
+
+// hashPassword checks a password chosen by a user against the PasswordPolicy and returns its hash.
+// If it returns an error, it may be of type ErrPasswordTooShort, ErrPasswordTooLong or ErrPasswordTooWeak.
+func (s *AuthDB) hashPassword(password string) (string, error) {
+	err := s.PasswordPolicy.Check(password)
+	if err != nil {
+		return "", err
+	}
+	return s.passwordHasher().Hash(password)
+}
+
+// upgradePasswordHash replaces the hash of the given user's password if it was made with another
+// algorithm or other parameters than the PasswordHasher's, now that the password is known.
+// The hash is only replaced if it is still oldHash. Failures are ignored, since the old hash keeps working.
+func (s *AuthDB) upgradePasswordHash(userID, oldHash, password string) {
+	hasher := s.passwordHasher()
+	if !hasher.NeedsRehash(oldHash) {
+		return
+	}
+	hash, err := hasher.Hash(password)
+	if err != nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	user, ok := s.Users[userID]
+	if !ok || user.PasswordHash != oldHash {
+		return
+	}
+	user = user.clone()
+	user.PasswordHash = hash
+	s.commit("users", userID, user)
+}
@@ -0,0 +1,113 @@
+package web
+
+import (
+	"sort"
+	"time"
+)
+
+// RotateSigningKey generates a new key for signing tokens with the given algorithm and returns its ID.
+// An empty alg means SigningAlg. The previous key is retired: it stops signing tokens but keeps
+// verifying them until every token it signed has expired.
+// If it returns an error, it may be of type ErrUnsupportedAlgorithm.
+func (s *AuthDB) RotateSigningKey(alg string) (string, error) {
+	if alg == "" {
+		alg = s.SigningAlg
+	}
+	key, err := newSigningKey(NewID(), alg, s.now().Unix())
+	if err != nil {
+		return "", err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	err = s.addSigningKey(key)
+	if err != nil {
+		return "", err
+	}
+	return key.ID, nil
+}
+
+// signingKey returns the key that signs new tokens, generating one if there is none.
+// The caller must hold s.lock.
+func (s *AuthDB) signingKey() (*SigningKey, error) {
+	var active *SigningKey
+	for _, k := range s.SigningKeys {
+		if k.RetiredAt == 0 && (active == nil || k.CreatedAt > active.CreatedAt) {
+			active = k
+		}
+	}
+	if active != nil {
+		return active, nil
+	}
+	key, err := newSigningKey(s.newID("signing_key"), s.SigningAlg, s.now().Unix())
+	if err != nil {
+		return nil, err
+	}
+	err = s.addSigningKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// addSigningKey makes key the key that signs new tokens.
+// It retires the keys before it and drops the ones that no unexpired token can be signed with.
+// The caller must hold s.lock.
+func (s *AuthDB) addSigningKey(key *SigningKey) error {
+	now := s.now()
+	// Keys sign both access tokens and stateless session tokens, so they are kept as long as either lives.
+	retention := s.AccessTokenTTL
+	if ttl := s.sessionTokenTTL(); ttl > retention {
+		retention = ttl
+	}
+	for id, k := range s.SigningKeys {
+		var err error
+		switch {
+		case k.RetiredAt == 0:
+			c := *k
+			c.RetiredAt = now.Unix()
+			err = s.commit("signing_keys", id, &c)
+		case now.Unix()-k.RetiredAt > int64(retention/time.Second):
+			err = s.commit("signing_keys", id, nil)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return s.commit("signing_keys", key.ID, key)
+}
+
+// verificationKey returns the key with the given ID, retired or not.
+// The caller must hold s.lock.
+func (s *AuthDB) verificationKey(kid string) (jwtKey, bool) {
+	k, ok := s.SigningKeys[kid]
+	return k, ok
+}
+
+// JWKS returns the public keys that tokens are verified with, the active key first and then the most recently retired.
+func (s *AuthDB) JWKS() ([]*JWK, error) {
+	s.lock.Lock()
+	_, err := s.signingKey()
+	keys := []*SigningKey{}
+	for _, k := range s.SigningKeys {
+		keys = append(keys, k)
+	}
+	s.lock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if (keys[i].RetiredAt == 0) != (keys[j].RetiredAt == 0) {
+			return keys[i].RetiredAt == 0
+		}
+		return keys[i].RetiredAt > keys[j].RetiredAt
+	})
+	jwks := []*JWK{}
+	for _, k := range keys {
+		jwk, err := k.JWK()
+		if err != nil {
+			return nil, err
+		}
+		jwks = append(jwks, jwk)
+	}
+	return jwks, nil
+}
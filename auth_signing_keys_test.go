@@ -0,0 +1,38 @@
+package web
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRotationKeepsStatelessSessions(t *testing.T) {
+	now := time.Now()
+	db := NewAuthDB("correct horse", "")
+	db.Clock = func() time.Time { return now }
+	db.StatelessSessions = true
+	token, err := db.Login("admin", "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		now = now.Add(2 * db.AccessTokenTTL)
+		_, err = db.RotateSigningKey("")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !db.checkSession("admin", token) {
+		t.Fatal("session token rejected after the key that signed it was rotated out")
+	}
+	now = now.Add(db.SessionIdleTimeout + time.Second)
+	_, err = db.RotateSigningKey("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.lock.RLock()
+	keys := len(db.SigningKeys)
+	db.lock.RUnlock()
+	if keys != 2 {
+		t.Fatalf("%d signing keys kept, want the current one and the one retired just now", keys)
+	}
+}
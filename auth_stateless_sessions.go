@@ -0,0 +1,226 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// statelessSessionTTL is how long a stateless session token is valid when neither
+// SessionIdleTimeout nor SessionMaxAge is set.
+const statelessSessionTTL = 24 * time.Hour
+
+// sessionClaims are the claims of a stateless session token.
+type sessionClaims struct {
+	Subject    string `json:"sub"`
+	SessionID  string `json:"sid"`
+	AuthTime   int64  `json:"auth_time"`
+	IssuedAt   int64  `json:"iat"`
+	IssuedAtMs int64  `json:"iat_ms"`
+	ExpiresAt  int64  `json:"exp"`
+}
+
+// isStatelessToken returns true if token is a signed session token rather than a stored one.
+func isStatelessToken(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+// issueSessionToken returns a signed session token for the given session.
+// The token is valid for SessionIdleTimeout and never past SessionMaxAge after authTime.
+// The caller must hold s.lock.
+func (s *AuthDB) issueSessionToken(userID, sessionID string, authTime int64) (string, error) {
+	key, err := s.signingKey()
+	if err != nil {
+		return "", err
+	}
+	now := s.now()
+	ttl := s.sessionTokenTTL()
+	claims := &sessionClaims{
+		Subject:    userID,
+		SessionID:  sessionID,
+		AuthTime:   authTime,
+		IssuedAt:   now.Unix(),
+		IssuedAtMs: now.UnixMilli(),
+		ExpiresAt:  now.Add(ttl).Unix(),
+	}
+	if s.SessionMaxAge != 0 {
+		maxExpiresAt := time.Unix(authTime, 0).Add(s.SessionMaxAge).Unix()
+		if claims.ExpiresAt > maxExpiresAt {
+			claims.ExpiresAt = maxExpiresAt
+		}
+	}
+	return signJWT(key, "session+jwt", claims)
+}
+
+// parseSessionToken returns the claims of a stateless session token of the given user
+// if it is unexpired and not revoked.
+// If it returns an error, it will be of type ErrInvalidSession.
+// The caller must hold s.lock.
+func (s *AuthDB) parseSessionToken(userID, token string) (*sessionClaims, error) {
+	var claims sessionClaims
+	err := parseJWT(s.verificationKey, "session+jwt", token, &claims)
+	if err != nil || claims.Subject != userID || s.now().Unix() >= claims.ExpiresAt {
+		return nil, ErrInvalidSession
+	}
+	revocations := &SessionRevocations{
+		Sessions: s.RevokedSessions,
+		Users:    s.RevokedUsers,
+	}
+	if revocations.revoked(&claims) {
+		return nil, ErrInvalidSession
+	}
+	return &claims, nil
+}
+
+// RefreshSession returns a new stateless session token for the session of token, valid from now on.
+// It lets a session in use outlive SessionIdleTimeout, but never SessionMaxAge.
+// If it returns an error, it will be of type ErrInvalidSession.
+func (s *AuthDB) RefreshSession(userID, token string) (string, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	user, ok := s.Users[userID]
+	if !ok || user.Disabled || !s.StatelessSessions {
+		return "", ErrInvalidSession
+	}
+	claims, err := s.parseSessionToken(userID, token)
+	if err != nil {
+		return "", err
+	}
+	if s.SessionMaxAge != 0 && !s.now().Before(time.Unix(claims.AuthTime, 0).Add(s.SessionMaxAge)) {
+		return "", ErrInvalidSession
+	}
+	return s.issueSessionToken(userID, claims.SessionID, claims.AuthTime)
+}
+
+// SessionRevocations returns the stateless session tokens that are revoked but not yet expired.
+func (s *AuthDB) SessionRevocations() *SessionRevocations {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	r := &SessionRevocations{
+		Sessions: map[string]int64{},
+		Users:    map[string]int64{},
+	}
+	for id, expiresAt := range s.RevokedSessions {
+		r.Sessions[id] = expiresAt
+	}
+	for id, revokedAt := range s.RevokedUsers {
+		r.Users[id] = revokedAt
+	}
+	return r
+}
+
+// revokeSessionToken stops a stateless session token from being accepted before it expires.
+// Invalid tokens are ignored.
+// The caller must hold s.lock.
+func (s *AuthDB) revokeSessionToken(userID, token string) error {
+	claims, err := s.parseSessionToken(userID, token)
+	if err != nil {
+		return nil
+	}
+	err = s.pruneRevocations()
+	if err != nil {
+		return err
+	}
+	return s.commit("revoked_sessions", claims.SessionID, claims.ExpiresAt)
+}
+
+// revokeUserSessionTokens stops every stateless session token issued to the user so far from being accepted.
+// It does nothing unless StatelessSessions is set.
+// The caller must hold s.lock.
+func (s *AuthDB) revokeUserSessionTokens(userID string) error {
+	if !s.StatelessSessions {
+		return nil
+	}
+	err := s.pruneRevocations()
+	if err != nil {
+		return err
+	}
+	return s.commit("revoked_users", userID, s.now().UnixMilli())
+}
+
+// sessionTokenTTL returns the longest a stateless session token is valid for after it is issued.
+func (s *AuthDB) sessionTokenTTL() time.Duration {
+	ttl := s.SessionIdleTimeout
+	if ttl == 0 || s.SessionMaxAge != 0 && s.SessionMaxAge < ttl {
+		ttl = s.SessionMaxAge
+	}
+	if ttl == 0 {
+		ttl = statelessSessionTTL
+	}
+	return ttl
+}
+
+// pruneRevocations drops the revocations of tokens that have expired anyway.
+// The caller must hold s.lock.
+func (s *AuthDB) pruneRevocations() error {
+	now := s.now()
+	ttl := s.sessionTokenTTL()
+	for id, expiresAt := range s.RevokedSessions {
+		if now.Unix() >= expiresAt {
+			err := s.commit("revoked_sessions", id, nil)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for id, revokedAt := range s.RevokedUsers {
+		if now.After(time.UnixMilli(revokedAt).Add(ttl)) {
+			err := s.commit("revoked_users", id, nil)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// refreshSession handles requests to renew a stateless session token.
+// It returns the session with the new token.
+func (s *AuthDB) refreshSession(w http.ResponseWriter, r *http.Request) {
+	var session Session
+	err := json.NewDecoder(r.Body).Decode(&session)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	token, err := s.RefreshSession(session.UserID, session.Token)
+	if err == ErrInvalidSession {
+		writeError(w, http.StatusUnauthorized, err)
+		return
+	}
+	if err != nil {
+		ServeInternalServerError(w, r)
+		return
+	}
+	json.NewEncoder(w).Encode(&Session{
+		UserID: session.UserID,
+		Token:  token,
+	})
+}
+
+// sessionRevocationsRequest is the body of a request for the revoked stateless session tokens.
+type sessionRevocationsRequest struct {
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+}
+
+// sessionRevocations serves the list of revoked stateless session tokens.
+// The list names users and their sessions, so only confidential OAuth clients may fetch it.
+func (s *AuthDB) sessionRevocations(w http.ResponseWriter, r *http.Request) {
+	var req sessionRevocationsRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	client, err := s.checkClient(req.ClientID, req.ClientSecret)
+	if err != nil || client.Public {
+		writeError(w, http.StatusUnauthorized, ErrInvalidClient)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.SessionRevocations())
+}
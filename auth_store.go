@@ -0,0 +1,15 @@
+package web
+
+// AuthStore persists the state of an AuthDB.
+// The state is kept as a snapshot of the whole AuthDB plus a log of the ops applied since.
+type AuthStore interface {
+	// Load returns the latest snapshot and the ops logged after it.
+	// A nil snapshot means nothing has been saved yet.
+	Load() ([]byte, []*AuthOp, error)
+	// Append durably logs an op.
+	Append(op *AuthOp) error
+	// Snapshot durably saves the full state and discards the logged ops.
+	Snapshot(state []byte) error
+	// Close releases any resources held by the store.
+	Close() error
+}
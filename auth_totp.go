@@ -0,0 +1,362 @@
+package web
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// loginChallengeTTL is how long a user has to answer a login challenge.
+const loginChallengeTTL = 5 * time.Minute
+
+// loginChallengeAttempts is how many wrong codes a login challenge survives.
+// Wrong codes also count as failed logins of the user, so new challenges do not give more guesses.
+const loginChallengeAttempts = 5
+
+// loginChallenge is a login that passed the password check and waits for a second factor.
+// Challenges only live in memory; a restart means logging in again.
+type loginChallenge struct {
+	userID    string
+	ip        string
+	userAgent string
+	expiresAt time.Time
+	attempts  int
+}
+
+// EnrollTOTP generates a new TOTP secret for the given user.
+// The second factor is not required until the enrollment is confirmed with ConfirmTOTP.
+// If it returns an error, it may be of type ErrUserNotFound or ErrTOTPEnabled.
+func (s *AuthDB) EnrollTOTP(userID string) (*TOTPEnrollment, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	user, ok := s.Users[userID]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	if user.TOTPEnabled {
+		return nil, ErrTOTPEnabled
+	}
+	user = user.clone()
+	user.TOTPSecret = newTOTPSecret()
+	err := s.commit("users", userID, user)
+	if err != nil {
+		return nil, err
+	}
+	return &TOTPEnrollment{
+		Secret: user.TOTPSecret,
+		URI:    totpURI(s.TOTPIssuer, userID, user.TOTPSecret),
+	}, nil
+}
+
+// ConfirmTOTP turns on the second factor for the given user if code is valid for the enrolled secret.
+// It returns the user's recovery codes, which are not stored anywhere in plain text.
+// If it returns an error, it may be of type ErrUserNotFound, ErrTOTPEnabled, ErrTOTPNotEnrolled or ErrInvalidCode.
+func (s *AuthDB) ConfirmTOTP(userID, code string) ([]string, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	user, ok := s.Users[userID]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	if user.TOTPEnabled {
+		return nil, ErrTOTPEnabled
+	}
+	if user.TOTPSecret == "" {
+		return nil, ErrTOTPNotEnrolled
+	}
+	step, ok := matchTOTP(user.TOTPSecret, code, s.now(), user.TOTPLastStep)
+	if !ok {
+		return nil, ErrInvalidCode
+	}
+	codes, hashes := newRecoveryCodes()
+	user = user.clone()
+	user.TOTPEnabled = true
+	user.TOTPLastStep = step
+	user.RecoveryCodes = hashes
+	err := s.commit("users", userID, user)
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// DisableTOTP turns off the second factor for the given user.
+// The code may be a TOTP code or a recovery code.
+// Wrong codes count as failed logins, so that they cannot be guessed with a stolen session.
+// If it returns an error, it may be of type ErrUserNotFound, ErrTOTPNotEnrolled, ErrInvalidCode or ErrTooManyAttempts.
+func (s *AuthDB) DisableTOTP(userID, code string) error {
+	return s.disableTOTPFrom(userID, code, "")
+}
+
+// disableTOTPFrom is like DisableTOTP for a request from the given IP.
+func (s *AuthDB) disableTOTPFrom(userID, code, ip string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	user, ok := s.Users[userID]
+	if !ok {
+		return ErrUserNotFound
+	}
+	if !user.TOTPEnabled {
+		return ErrTOTPNotEnrolled
+	}
+	if s.loginWait(userID, ip) > 0 {
+		return ErrTooManyAttempts
+	}
+	user = user.clone()
+	if !s.checkSecondFactor(user, code) {
+		s.loginFailed(userID, ip)
+		return ErrInvalidCode
+	}
+	user.TOTPSecret = ""
+	user.TOTPEnabled = false
+	user.TOTPLastStep = 0
+	user.RecoveryCodes = nil
+	return s.commit("users", userID, user)
+}
+
+// LoginWithSecondFactor is like Login for users with a second factor.
+// The code may be a TOTP code or a recovery code.
+// If it returns an error, it may be of type ErrInvalidPassword, ErrUserNotFound,
+// ErrTooManyAttempts or ErrInvalidCode.
+func (s *AuthDB) LoginWithSecondFactor(userID, password, code string) (string, error) {
+	err := s.authenticate(userID, password, "")
+	if err != nil {
+		return "", err
+	}
+	return s.startSessionWithCode(userID, code, "", "")
+}
+
+// startSessionWithCode checks the second factor of the given user and starts a session if it is valid.
+// Users without a second factor get a session regardless of the code.
+func (s *AuthDB) startSessionWithCode(userID, code, ip, userAgent string) (string, error) {
+	s.lock.Lock()
+	err := s.passSecondFactor(userID, code, ip)
+	s.lock.Unlock()
+	if err != nil {
+		return "", err
+	}
+	return s.startSession(userID, ip, userAgent)
+}
+
+// passSecondFactor checks code against the second factor of the given user.
+// Wrong codes count as failed logins, and users who must wait get ErrTooManyAttempts without a check.
+// The caller must hold s.lock.
+func (s *AuthDB) passSecondFactor(userID, code, ip string) error {
+	user, ok := s.Users[userID]
+	if !ok {
+		return ErrUserNotFound
+	}
+	if !user.TOTPEnabled {
+		return nil
+	}
+	if s.loginWait(userID, ip) > 0 {
+		return ErrTooManyAttempts
+	}
+	user = user.clone()
+	if !s.checkSecondFactor(user, code) {
+		s.loginFailed(userID, ip)
+		return ErrInvalidCode
+	}
+	return s.commit("users", userID, user)
+}
+
+// checkSecondFactor returns true if code is a valid TOTP code or an unused recovery code for the user.
+// It marks the code as used on user, which must be a clone that the caller commits.
+// The caller must hold s.lock.
+func (s *AuthDB) checkSecondFactor(user *User, code string) bool {
+	step, ok := matchTOTP(user.TOTPSecret, code, s.now(), user.TOTPLastStep)
+	if ok {
+		user.TOTPLastStep = step
+		return true
+	}
+	hash := recoveryCodeHash(code)
+	for i, h := range user.RecoveryCodes {
+		if subtle.ConstantTimeCompare([]byte(h), []byte(hash)) == 1 {
+			user.RecoveryCodes = append(user.RecoveryCodes[:i], user.RecoveryCodes[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// secondFactorRequired returns true if the given user must supply a code to log in.
+func (s *AuthDB) secondFactorRequired(userID string) bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	user, ok := s.Users[userID]
+	return ok && user.TOTPEnabled
+}
+
+// newLoginChallenge records a login waiting for a second factor and returns its token.
+func (s *AuthDB) newLoginChallenge(userID, ip, userAgent string) string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := s.now()
+	if s.challenges == nil {
+		s.challenges = map[string]*loginChallenge{}
+	}
+	for token, c := range s.challenges {
+		if now.After(c.expiresAt) {
+			delete(s.challenges, token)
+		}
+	}
+	token := s.newID("login_challenge")
+	s.challenges[token] = &loginChallenge{
+		userID:    userID,
+		ip:        ip,
+		userAgent: userAgent,
+		expiresAt: now.Add(loginChallengeTTL),
+	}
+	return token
+}
+
+// VerifyLogin completes a login that is waiting for a second factor.
+// It returns the new session.
+// If it returns an error, it may be of type ErrInvalidChallenge, ErrInvalidCode or ErrTooManyAttempts.
+func (s *AuthDB) VerifyLogin(challenge, code string) (*Session, error) {
+	s.lock.Lock()
+	c, ok := s.challenges[challenge]
+	if !ok || s.now().After(c.expiresAt) {
+		delete(s.challenges, challenge)
+		s.lock.Unlock()
+		return nil, ErrInvalidChallenge
+	}
+	// The code is checked and counted under the same lock, so that parallel guesses share the attempts.
+	err := s.passSecondFactor(c.userID, code, c.ip)
+	if err == ErrInvalidCode {
+		c.attempts++
+		if c.attempts >= loginChallengeAttempts {
+			delete(s.challenges, challenge)
+		}
+	}
+	if err != nil {
+		s.lock.Unlock()
+		return nil, err
+	}
+	delete(s.challenges, challenge)
+	s.lock.Unlock()
+	token, err := s.startSession(c.userID, c.ip, c.userAgent)
+	if err != nil {
+		return nil, err
+	}
+	return &Session{
+		UserID: c.userID,
+		Token:  token,
+	}, nil
+}
+
+// enrollTOTP handles requests to start TOTP enrollment.
+func (s *AuthDB) enrollTOTP(w http.ResponseWriter, r *http.Request) {
+	var session Session
+	err := json.NewDecoder(r.Body).Decode(&session)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if !s.checkSession(session.UserID, session.Token) {
+		writeError(w, http.StatusUnauthorized, ErrInvalidSession)
+		return
+	}
+	enrollment, err := s.EnrollTOTP(session.UserID)
+	if err == ErrTOTPEnabled {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	if err != nil {
+		ServeInternalServerError(w, r)
+		return
+	}
+	json.NewEncoder(w).Encode(enrollment)
+}
+
+// confirmTOTP handles requests to finish TOTP enrollment.
+// It returns the recovery codes.
+func (s *AuthDB) confirmTOTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Session
+		Code string `json:"code"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if !s.checkSession(req.UserID, req.Token) {
+		writeError(w, http.StatusUnauthorized, ErrInvalidSession)
+		return
+	}
+	codes, err := s.ConfirmTOTP(req.UserID, req.Code)
+	s.audit(r, AuditTOTPEnabled, req.UserID, req.UserID, err)
+	switch err {
+	case nil:
+		json.NewEncoder(w).Encode(codes)
+	case ErrInvalidCode:
+		writeError(w, http.StatusUnauthorized, err)
+	case ErrTOTPEnabled, ErrTOTPNotEnrolled:
+		writeError(w, http.StatusConflict, err)
+	default:
+		ServeInternalServerError(w, r)
+	}
+}
+
+// disableTOTP handles requests to turn off the second factor.
+func (s *AuthDB) disableTOTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Session
+		Code string `json:"code"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if !s.checkSession(req.UserID, req.Token) {
+		writeError(w, http.StatusUnauthorized, ErrInvalidSession)
+		return
+	}
+	err = s.disableTOTPFrom(req.UserID, req.Code, s.clientIP(r))
+	s.audit(r, AuditTOTPDisabled, req.UserID, req.UserID, err)
+	switch err {
+	case nil:
+	case ErrInvalidCode:
+		writeError(w, http.StatusUnauthorized, err)
+	case ErrTooManyAttempts:
+		writeTooManyAttempts(w, s.retryAfter(req.UserID, s.clientIP(r)))
+	case ErrTOTPNotEnrolled:
+		writeError(w, http.StatusConflict, err)
+	default:
+		ServeInternalServerError(w, r)
+	}
+}
+
+// verifyLogin handles the second step of a login.
+// It exchanges a login challenge and a code for a session.
+func (s *AuthDB) verifyLogin(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Challenge string `json:"challenge"`
+		Code      string `json:"code"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	userID := s.challengeUser(req.Challenge)
+	session, err := s.VerifyLogin(req.Challenge, req.Code)
+	s.audit(r, AuditSecondFactor, userID, userID, err)
+	switch err {
+	case nil:
+		json.NewEncoder(w).Encode(session)
+	case ErrInvalidChallenge, ErrInvalidCode:
+		writeError(w, http.StatusUnauthorized, err)
+	case ErrTooManyAttempts:
+		writeTooManyAttempts(w, s.retryAfter(userID, s.clientIP(r)))
+	default:
+		ServeInternalServerError(w, r)
+	}
+}
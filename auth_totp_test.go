@@ -0,0 +1,139 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTOTPUser returns an AuthDB whose admin has a confirmed TOTP secret, with a clock the test controls.
+func newTOTPUser(t *testing.T) (*AuthDB, string, *time.Time) {
+	now := time.Now()
+	db := NewAuthDB("correct horse", "")
+	db.Clock = func() time.Time { return now }
+	enrollment, err := db.EnrollTOTP("admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, _ := totpCode(enrollment.Secret, totpStep(now)-1)
+	_, err = db.ConfirmTOTP("admin", code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, enrollment.Secret, &now
+}
+
+func TestLoginWithSecondFactorCountsWrongCodes(t *testing.T) {
+	db, _, now := newTOTPUser(t)
+	_, err := db.LoginWithSecondFactor("admin", "correct horse", "000000")
+	if err != ErrInvalidCode {
+		t.Fatal(err)
+	}
+	// The correct password does not wipe the failure of the wrong code.
+	_, err = db.LoginWithSecondFactor("admin", "correct horse", "000000")
+	if err != ErrTooManyAttempts {
+		t.Fatal(err)
+	}
+	for i := 0; i < db.MaxLoginFailures; i++ {
+		*now = now.Add(db.LoginLockout)
+		db.LoginWithSecondFactor("admin", "correct horse", "000000")
+	}
+	*now = now.Add(time.Minute)
+	_, err = db.LoginWithSecondFactor("admin", "correct horse", "000000")
+	if err != ErrTooManyAttempts {
+		t.Fatalf("account not locked after %d wrong codes: %v", db.MaxLoginFailures, err)
+	}
+}
+
+func TestVerifyLoginParallelCodes(t *testing.T) {
+	db, _, _ := newTOTPUser(t)
+	resp, err := db.loginFrom("admin", "correct horse", "10.0.0.1", "")
+	if err != nil || resp.Challenge == "" {
+		t.Fatal(resp, err)
+	}
+	var lock sync.Mutex
+	checked := 0
+	var wg sync.WaitGroup
+	for i := 0; i < 40; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := db.VerifyLogin(resp.Challenge, "000000")
+			if err == ErrInvalidCode {
+				lock.Lock()
+				checked++
+				lock.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if checked != 1 {
+		t.Fatalf("%d of 40 parallel codes were checked, want 1", checked)
+	}
+}
+
+func TestVerifyLoginResetsThrottle(t *testing.T) {
+	db, secret, now := newTOTPUser(t)
+	_, err := db.loginFrom("admin", "wrong", "", "")
+	if err != ErrInvalidPassword {
+		t.Fatal(err)
+	}
+	*now = now.Add(time.Minute)
+	resp, err := db.loginFrom("admin", "correct horse", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, _ := totpCode(secret, totpStep(*now))
+	_, err = db.VerifyLogin(resp.Challenge, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.lock.RLock()
+	wait := db.accountThrottle().wait("admin", *now)
+	failures := len(db.accounts.entries)
+	db.lock.RUnlock()
+	if wait != 0 || failures != 0 {
+		t.Fatal("failures kept after a complete login")
+	}
+}
+
+func TestDisableTOTPCountsWrongCodes(t *testing.T) {
+	db, secret, now := newTOTPUser(t)
+	code, _ := totpCode(secret, totpStep(*now))
+	token, err := db.LoginWithSecondFactor("admin", "correct horse", code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.DisableTOTP("admin", "000000")
+	if err != ErrInvalidCode {
+		t.Fatal(err)
+	}
+	for i := 1; i < db.MaxLoginFailures; i++ {
+		*now = now.Add(db.LoginLockout)
+		err = db.DisableTOTP("admin", "000000")
+		if err != ErrInvalidCode {
+			t.Fatalf("wrong code %d: %v", i+1, err)
+		}
+	}
+	*now = now.Add(time.Minute)
+	code, _ = totpCode(secret, totpStep(*now))
+	err = db.DisableTOTP("admin", code)
+	if err != ErrTooManyAttempts {
+		t.Fatalf("account not locked after %d wrong codes: %v", db.MaxLoginFailures, err)
+	}
+	body := `{"user_id":"admin","token":"` + token + `","code":"` + code + `"}`
+	w := httptest.NewRecorder()
+	db.ServeHTTP(w, httptest.NewRequest("POST", "/disable-totp", strings.NewReader(body)))
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("%d %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	*now = now.Add(db.LoginLockout)
+	code, _ = totpCode(secret, totpStep(*now))
+	err = db.DisableTOTP("admin", code)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,65 @@
+package web
+
+import (
+	"encoding/binary"
+)
+
+// Flags of the WebAuthn authenticator data.
+const (
+	authDataUserPresent  = 0x01
+	authDataUserVerified = 0x04
+	authDataAttested     = 0x40
+	authDataExtensions   = 0x80
+)
+
+// authenticatorData is the data an authenticator signs in a WebAuthn ceremony.
+// Registrations also carry the new credential's ID and COSE encoded public key.
+type authenticatorData struct {
+	rpIDHash     []byte
+	flags        byte
+	signCount    uint32
+	credentialID []byte
+	publicKey    []byte
+}
+
+// parseAuthenticatorData parses the authenticator data of a registration or login.
+// Extensions are skipped.
+func parseAuthenticatorData(b []byte) (*authenticatorData, error) {
+	if len(b) < 37 {
+		return nil, ErrInvalidCredential
+	}
+	d := &authenticatorData{
+		rpIDHash:  b[:32],
+		flags:     b[32],
+		signCount: binary.BigEndian.Uint32(b[33:37]),
+	}
+	rest := b[37:]
+	if d.flags&authDataAttested != 0 {
+		// The AAGUID of the authenticator model is followed by the length of the credential ID.
+		if len(rest) < 18 {
+			return nil, ErrInvalidCredential
+		}
+		n := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if len(rest) < n {
+			return nil, ErrInvalidCredential
+		}
+		d.credentialID, rest = rest[:n], rest[n:]
+		_, after, err := cborDecode(rest)
+		if err != nil {
+			return nil, ErrInvalidCredential
+		}
+		d.publicKey, rest = rest[:len(rest)-len(after)], after
+	}
+	if d.flags&authDataExtensions != 0 {
+		_, after, err := cborDecode(rest)
+		if err != nil {
+			return nil, ErrInvalidCredential
+		}
+		rest = after
+	}
+	if len(rest) != 0 {
+		return nil, ErrInvalidCredential
+	}
+	return d, nil
+}
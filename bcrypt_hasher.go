@@ -0,0 +1,47 @@
+package web
+
+import (
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// BcryptHasher hashes passwords with bcrypt.
+// Its hashes are in the usual "$2a$<cost>$..." form, which records the cost along with the salt.
+// Bcrypt only uses the first 72 bytes of a password, so longer passwords are refused.
+type BcryptHasher struct {
+	// Cost is the bcrypt cost.
+	// If it is zero, bcrypt.DefaultCost is used.
+	Cost int
+}
+
+func (h *BcryptHasher) Hash(password string) (string, error) {
+	if len(password) > 72 {
+		return "", ErrPasswordTooLong
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.cost())
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func (h *BcryptHasher) Verify(hash, password string) bool {
+	if !strings.HasPrefix(hash, "$2") {
+		return false
+	}
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	return err == nil
+}
+
+func (h *BcryptHasher) NeedsRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost != h.cost()
+}
+
+func (h *BcryptHasher) cost() int {
+	if h.Cost == 0 {
+		return bcrypt.DefaultCost
+	}
+	return h.Cost
+}
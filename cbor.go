@@ -0,0 +1,174 @@
+package web
+
+import (
+	"encoding/binary"
+	"errors"
+	"sort"
+)
+
+// errCBOR is returned for CBOR data that cannot be decoded.
+var errCBOR = errors.New("invalid or unsupported CBOR")
+
+// cborDecode decodes the first CBOR data item in b and returns it with the bytes that follow it.
+// Unsigned and negative integers decode to int64, byte strings to []byte, text strings to string,
+// arrays to []any, maps to map[any]any and simple values to bool or nil.
+// Tags, floats and indefinite lengths are not used by WebAuthn and are not supported.
+func cborDecode(b []byte) (any, []byte, error) {
+	if len(b) == 0 {
+		return nil, nil, errCBOR
+	}
+	major, info := b[0]>>5, b[0]&0x1f
+	b = b[1:]
+	var n uint64
+	switch {
+	case info < 24:
+		n = uint64(info)
+	case info == 24 && len(b) >= 1:
+		n, b = uint64(b[0]), b[1:]
+	case info == 25 && len(b) >= 2:
+		n, b = uint64(binary.BigEndian.Uint16(b)), b[2:]
+	case info == 26 && len(b) >= 4:
+		n, b = uint64(binary.BigEndian.Uint32(b)), b[4:]
+	case info == 27 && len(b) >= 8:
+		n, b = binary.BigEndian.Uint64(b), b[8:]
+	default:
+		return nil, nil, errCBOR
+	}
+	switch major {
+	case 0:
+		if n > 1<<63-1 {
+			return nil, nil, errCBOR
+		}
+		return int64(n), b, nil
+	case 1:
+		if n > 1<<63-1 {
+			return nil, nil, errCBOR
+		}
+		return -1 - int64(n), b, nil
+	case 2, 3:
+		if uint64(len(b)) < n {
+			return nil, nil, errCBOR
+		}
+		if major == 2 {
+			return append([]byte{}, b[:n]...), b[n:], nil
+		}
+		return string(b[:n]), b[n:], nil
+	case 4:
+		if n > uint64(len(b)) {
+			return nil, nil, errCBOR
+		}
+		items := make([]any, 0, n)
+		for i := uint64(0); i < n; i++ {
+			var item any
+			var err error
+			item, b, err = cborDecode(b)
+			if err != nil {
+				return nil, nil, err
+			}
+			items = append(items, item)
+		}
+		return items, b, nil
+	case 5:
+		if n > uint64(len(b)) {
+			return nil, nil, errCBOR
+		}
+		m := make(map[any]any, n)
+		for i := uint64(0); i < n; i++ {
+			var k, v any
+			var err error
+			k, b, err = cborDecode(b)
+			if err != nil {
+				return nil, nil, err
+			}
+			switch k.(type) {
+			case int64, string:
+			default:
+				return nil, nil, errCBOR
+			}
+			v, b, err = cborDecode(b)
+			if err != nil {
+				return nil, nil, err
+			}
+			m[k] = v
+		}
+		return m, b, nil
+	case 7:
+		switch info {
+		case 20:
+			return false, b, nil
+		case 21:
+			return true, b, nil
+		case 22:
+			return nil, b, nil
+		}
+	}
+	return nil, nil, errCBOR
+}
+
+// cborEncode encodes v as CBOR.
+// It supports the types that cborDecode returns, with int and uint32 as integers as well.
+// Maps are encoded in the canonical key order of RFC 7049.
+func cborEncode(v any) []byte {
+	switch v := v.(type) {
+	case int:
+		return cborEncode(int64(v))
+	case uint32:
+		return cborEncode(int64(v))
+	case int64:
+		if v < 0 {
+			return cborHead(1, uint64(-1-v))
+		}
+		return cborHead(0, uint64(v))
+	case []byte:
+		return append(cborHead(2, uint64(len(v))), v...)
+	case string:
+		return append(cborHead(3, uint64(len(v))), v...)
+	case []any:
+		b := cborHead(4, uint64(len(v)))
+		for _, item := range v {
+			b = append(b, cborEncode(item)...)
+		}
+		return b
+	case map[any]any:
+		pairs := [][2][]byte{}
+		for k, item := range v {
+			pairs = append(pairs, [2][]byte{cborEncode(k), cborEncode(item)})
+		}
+		sort.Slice(pairs, func(i, j int) bool {
+			a, b := pairs[i][0], pairs[j][0]
+			if len(a) != len(b) {
+				return len(a) < len(b)
+			}
+			return string(a) < string(b)
+		})
+		b := cborHead(5, uint64(len(v)))
+		for _, p := range pairs {
+			b = append(b, p[0]...)
+			b = append(b, p[1]...)
+		}
+		return b
+	case bool:
+		if v {
+			return []byte{0xf5}
+		}
+		return []byte{0xf4}
+	case nil:
+		return []byte{0xf6}
+	}
+	panic("cborEncode: unsupported type")
+}
+
+// cborHead encodes the initial bytes of a data item of the given major type.
+func cborHead(major byte, n uint64) []byte {
+	switch {
+	case n < 24:
+		return []byte{major<<5 | byte(n)}
+	case n <= 0xff:
+		return []byte{major<<5 | 24, byte(n)}
+	case n <= 0xffff:
+		return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(n))
+	case n <= 0xffffffff:
+		return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(n))
+	}
+	return binary.BigEndian.AppendUint64([]byte{major<<5 | 27}, n)
+}
@@ -0,0 +1,62 @@
+package web
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// clientIP returns the IP address of the client that sent the request.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// clientIP returns the IP address of the client that sent the request, looking through TrustedProxies.
+func (s *AuthDB) clientIP(r *http.Request) string {
+	ip := clientIP(r)
+	if !s.trustedProxy(ip) {
+		return ip
+	}
+	forwarded := []string{}
+	for _, v := range r.Header.Values("X-Forwarded-For") {
+		forwarded = append(forwarded, strings.Split(v, ",")...)
+	}
+	// Each proxy appends the address it got the request from, so only the addresses
+	// after the last untrusted one were added by proxies that can be believed.
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		addr := strings.TrimSpace(forwarded[i])
+		if net.ParseIP(addr) == nil {
+			return ip
+		}
+		ip = addr
+		if !s.trustedProxy(ip) {
+			return ip
+		}
+	}
+	return ip
+}
+
+// trustedProxy returns true if ip is one of TrustedProxies.
+func (s *AuthDB) trustedProxy(ip string) bool {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	for _, proxy := range s.TrustedProxies {
+		if strings.Contains(proxy, "/") {
+			_, network, err := net.ParseCIDR(proxy)
+			if err == nil && network.Contains(addr) {
+				return true
+			}
+			continue
+		}
+		if trusted := net.ParseIP(proxy); trusted != nil && trusted.Equal(addr) {
+			return true
+		}
+	}
+	return false
+}
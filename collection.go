@@ -28,6 +28,10 @@ func (c Collection[T]) Delete(id string) {
 }
 
 func (c Collection[T]) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !CheckCSRF(r) {
+		ServeForbidden(w, r)
+		return
+	}
 	path := ParsePath(r.URL.Path)
 	if len(path) == 0 {
 		c.serveRoot(w, r)
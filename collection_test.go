@@ -0,0 +1,51 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCollectionChecksCSRF(t *testing.T) {
+	c := Collection[*File]{"a": {Name: "a"}, "b": {Name: "b"}}
+	request := func(method, path, token string, cookie bool) int {
+		r := httptest.NewRequest(method, path, strings.NewReader(`{}`))
+		if cookie {
+			r.AddCookie(&http.Cookie{Name: CSRFCookieName, Value: "cookie-token"})
+		}
+		if token != "" {
+			r.Header.Set(CSRFHeaderName, token)
+		}
+		w := httptest.NewRecorder()
+		c.ServeHTTP(w, r)
+		return w.Code
+	}
+	for _, token := range []string{"", "forged"} {
+		if code := request("POST", "/", token, true); code != http.StatusForbidden {
+			t.Fatalf("POST with token %q got %d", token, code)
+		}
+		if code := request("DELETE", "/a", token, true); code != http.StatusForbidden {
+			t.Fatalf("DELETE with token %q got %d", token, code)
+		}
+	}
+	if len(c) != 2 {
+		t.Fatalf("forged requests changed the collection: %d items", len(c))
+	}
+	if code := request("GET", "/", "", true); code != http.StatusOK {
+		t.Fatalf("GET got %d", code)
+	}
+	if code := request("POST", "/", "cookie-token", true); code != http.StatusCreated {
+		t.Fatalf("POST with the token got %d", code)
+	}
+	if code := request("DELETE", "/a", "cookie-token", true); code != http.StatusOK {
+		t.Fatalf("DELETE with the token got %d", code)
+	}
+	// Clients without cookies cannot be riding on a browser's session.
+	if code := request("DELETE", "/b", "", false); code != http.StatusOK {
+		t.Fatalf("DELETE without cookies got %d", code)
+	}
+	if _, ok := c["a"]; ok || len(c) != 1 {
+		t.Fatalf("%d items", len(c))
+	}
+}
@@ -0,0 +1,8 @@
+package web
+
+import (
+	_ "embed"
+)
+
+//go:embed consent.html
+var consentHTML string
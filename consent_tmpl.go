@@ -0,0 +1,5 @@
+package web
+
+import "html/template"
+
+var consentTmpl = template.Must(template.New("consent").Parse(consentHTML))
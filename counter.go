@@ -20,5 +20,7 @@ func (c *Counter) Dec() {
 }
 
 func (c *Counter) Value() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
 	return c.n
 }
@@ -0,0 +1,64 @@
+package web
+
+import (
+	"crypto/subtle"
+	"net/http"
+)
+
+// CSRFCookieName is the cookie holding the CSRF token of a browser.
+const CSRFCookieName = "csrf_token"
+
+// CSRFHeaderName is the header that scripts send the CSRF token in.
+// HTML forms send it in a field named CSRFCookieName instead.
+const CSRFHeaderName = "X-CSRF-Token"
+
+// CSRFToken returns the CSRF token of the browser making r, setting a new token cookie if it has none.
+// Pages put the token in a hidden form field named CSRFCookieName, or scripts read the cookie
+// and send it in the CSRFHeaderName header, so that state-changing requests pass CheckCSRF.
+func CSRFToken(w http.ResponseWriter, r *http.Request) string {
+	cookie, err := r.Cookie(CSRFCookieName)
+	if err == nil && cookie.Value != "" {
+		return cookie.Value
+	}
+	token := randomToken(32)
+	http.SetCookie(w, &http.Cookie{
+		Name:     CSRFCookieName,
+		Value:    token,
+		Path:     "/",
+		Secure:   isHTTPS(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+	return token
+}
+
+// CheckCSRF returns false if r is a state-changing request that may have been forged by another site.
+// Requests with safe methods or without any cookies cannot ride on a browser's session and always pass.
+// Other requests must carry the token from the CSRF cookie in the CSRFHeaderName header or form field.
+func CheckCSRF(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	if len(r.Cookies()) == 0 {
+		return true
+	}
+	return validCSRF(r)
+}
+
+// validCSRF returns true if r carries a CSRF token that matches its CSRF cookie.
+func validCSRF(r *http.Request) bool {
+	cookie, err := r.Cookie(CSRFCookieName)
+	if err != nil || cookie.Value == "" {
+		return false
+	}
+	token := r.Header.Get(CSRFHeaderName)
+	if token == "" {
+		token = r.PostFormValue(CSRFCookieName)
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(cookie.Value)) == 1
+}
+
+// isHTTPS returns true if r reached the server, or the proxy in front of it, over TLS.
+func isHTTPS(r *http.Request) bool {
+	return r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https"
+}
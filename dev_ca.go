@@ -0,0 +1,184 @@
+package web
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// DevCACertFile is the file in CertDir holding the certificate of the local CA used in dev mode.
+// Add it to the trust store of a browser or the RootCAs of a client to trust the dev certificates.
+const DevCACertFile = "dev-ca.pem"
+
+// DevCAKeyFile is the file in CertDir holding the private key of the local CA used in dev mode.
+const DevCAKeyFile = "dev-ca-key.pem"
+
+// devCA is a local certificate authority that issues certificates for development.
+type devCA struct {
+	cert *x509.Certificate
+	key  crypto.Signer
+	// allow returns true if a certificate may be issued for host.
+	allow func(host string) bool
+
+	lock  sync.Mutex
+	certs map[string]*tls.Certificate
+}
+
+// loadDevCA reads the local CA from dir, creating it if there is none yet.
+func loadDevCA(dir string, allow func(host string) bool) (*devCA, error) {
+	certPath := filepath.Join(dir, DevCACertFile)
+	keyPath := filepath.Join(dir, DevCAKeyFile)
+	_, err := os.Stat(certPath)
+	if os.IsNotExist(err) {
+		err = createDevCA(certPath, keyPath)
+	}
+	if err != nil {
+		return nil, err
+	}
+	certPEM, err := os.ReadFile(certPath)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	key, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("%s: unsupported key type %T", keyPath, pair.PrivateKey)
+	}
+	return &devCA{
+		cert:  cert,
+		key:   key,
+		allow: allow,
+		certs: map[string]*tls.Certificate{},
+	}, nil
+}
+
+// createDevCA generates a new CA and writes its certificate and key to the given files.
+func createDevCA(certPath, keyPath string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := newSerialNumber()
+	if err != nil {
+		return err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "Platform development CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(certPath), 0700)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+}
+
+// TLSConfig returns a TLS config that serves certificates issued by the CA.
+func (ca *devCA) TLSConfig() *tls.Config {
+	return &tls.Config{
+		GetCertificate: ca.getCertificate,
+		NextProtos:     []string{"h2", "http/1.1"},
+	}
+}
+
+// getCertificate returns the certificate for the server name of hello, issuing it on first use.
+func (ca *devCA) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	host := hello.ServerName
+	if host == "" {
+		return nil, fmt.Errorf("missing server name")
+	}
+	if !ca.allow(host) {
+		return nil, fmt.Errorf("host %q not allowed", host)
+	}
+	ca.lock.Lock()
+	defer ca.lock.Unlock()
+	cert, ok := ca.certs[host]
+	if ok {
+		return cert, nil
+	}
+	cert, err := ca.issue(host)
+	if err != nil {
+		return nil, err
+	}
+	ca.certs[host] = cert
+	return cert, nil
+}
+
+// issue returns a new certificate for host signed by the CA.
+func (ca *devCA) issue(host string) (*tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := newSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: host},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(1, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	} else {
+		template.DNSNames = []string{host}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Certificate{
+		Certificate: [][]byte{der, ca.cert.Raw},
+		PrivateKey:  key,
+	}, nil
+}
+
+// newSerialNumber returns a random certificate serial number.
+func newSerialNumber() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
@@ -2,21 +2,23 @@ package web
 
 import "net/http"
 
+// DevServer serves Apps over plain HTTP for development.
+// Requests for /{host}/... are passed to the app at host, as requests for that host with the prefix stripped.
 type DevServer struct {
 	Apps map[string]http.Handler
 }
 
 func (d *DevServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	path := ParsePath(r.URL.Path)
-	if len(path) == 0 {
-		// TODO: Serve a list of apps
-		http.NotFound(w, r)
-		return
-	}
-	app, ok := d.Apps[r.Host]
+	ok := servePathHost(w, r, func(w http.ResponseWriter, r *http.Request) {
+		app, ok := d.Apps[r.Host]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		app.ServeHTTP(w, r)
+	})
 	if !ok {
+		// TODO: Serve a list of apps
 		http.NotFound(w, r)
-		return
 	}
-	http.StripPrefix("/"+path[0], app).ServeHTTP(w, r)
 }
@@ -15,7 +15,43 @@ func NewError(msg string) Error {
 var NoError = NewError("")
 
 var ErrInvalidSession = NewError("invalid session")
+var ErrSessionNotFound = NewError("session not found")
 var ErrInvalidPassword = NewError("invalid password")
+var ErrPasswordTooShort = NewError("password too short")
+var ErrPasswordTooLong = NewError("password too long")
+var ErrPasswordTooWeak = NewError("password too weak")
 var ErrUserNotFound = NewError("user not found")
 var ErrInvalidRegistrationCode = NewError("invalid registration code")
+var ErrInvalidRegistrarKey = NewError("invalid registrar key")
+var ErrSecondFactorRequired = NewError("second factor required")
+var ErrInvalidCode = NewError("invalid code")
+var ErrInvalidChallenge = NewError("invalid challenge")
+var ErrTOTPNotEnrolled = NewError("totp not enrolled")
+var ErrTOTPEnabled = NewError("totp already enabled")
+var ErrAPIKeyNotFound = NewError("api key not found")
+var ErrInviteNotFound = NewError("invite not found")
+var ErrInvalidClient = NewError("invalid client")
+var ErrUnsupportedAlgorithm = NewError("unsupported algorithm")
+var ErrUnknownProvider = NewError("unknown identity provider")
+var ErrIdentityTaken = NewError("identity linked to another user")
+var ErrIdentityNotFound = NewError("identity not found")
+var ErrPasskeysNotConfigured = NewError("passkeys not configured")
+var ErrInvalidCredential = NewError("invalid credential")
+var ErrPasskeyNotFound = NewError("passkey not found")
+var ErrOrgNotFound = NewError("org not found")
+var ErrOrgMemberNotFound = NewError("org member not found")
+var ErrOrgInvitationNotFound = NewError("org invitation not found")
+var ErrInvalidOrgRole = NewError("invalid org role")
+var ErrAlreadyOrgMember = NewError("already an org member")
+var ErrTooManyAttempts = NewError("too many attempts")
+var ErrForbidden = NewError("forbidden")
+var ErrInvalidToken = NewError("invalid token")
+var ErrInvalidEmail = NewError("invalid email")
+var ErrEmailNotFound = NewError("email not found")
+var ErrEmailTaken = NewError("email taken")
+var ErrMailNotConfigured = NewError("mail not configured")
+var ErrUserDisabled = NewError("user disabled")
+var ErrHostNotFound = NewError("host not found")
+var ErrUnknownApp = NewError("unknown app")
+var ErrPlatformNotRunning = NewError("platform not running")
 var ErrMethodNotSupported = NewError("method not supported")
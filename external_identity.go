@@ -0,0 +1,15 @@
+package web
+
+// ExternalIdentity is an account at an upstream identity provider that is linked to a user.
+type ExternalIdentity struct {
+	// Provider is the key of the provider in AuthDB.IdentityProviders.
+	Provider string `json:"provider"`
+	// Subject is the provider's ID for the account.
+	Subject string `json:"subject"`
+	// Email is the email address the provider reported for the account, if any.
+	Email string `json:"email,omitempty"`
+	// EmailVerified is true if the provider has verified Email.
+	EmailVerified bool `json:"email_verified,omitempty"`
+	// LinkedAt is the Unix timestamp of when the account was linked.
+	LinkedAt int64 `json:"linked_at"`
+}
@@ -0,0 +1,174 @@
+package web
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"sync"
+)
+
+// FakeAuthenticator is a software passkey authenticator that answers passkey ceremonies the way
+// a browser would for a page at Origin. It is meant for tests.
+// Its credentials are ES256 keys kept in memory, and every signature increases their sign count.
+type FakeAuthenticator struct {
+	// Origin is the origin of the page the ceremonies run on, such as "https://example.com".
+	Origin string
+	// NoUserVerification makes the authenticator only check that the user is present,
+	// like a security key without a PIN.
+	NoUserVerification bool
+	lock               sync.Mutex
+	credentials        map[string]*fakeCredential
+}
+
+// fakeCredential is a passkey held by a FakeAuthenticator.
+type fakeCredential struct {
+	rpID       string
+	userHandle string
+	key        *ecdsa.PrivateKey
+	signCount  uint32
+}
+
+// Create creates a passkey as navigator.credentials.create would.
+func (a *FakeAuthenticator) Create(options *PasskeyCreationOptions) (*PasskeyCredential, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	supported := false
+	for _, p := range options.PubKeyCredParams {
+		supported = supported || p.Alg == coseES256
+	}
+	if !supported {
+		return nil, errors.New("no supported algorithm")
+	}
+	for _, d := range options.ExcludeCredentials {
+		if _, ok := a.credentials[d.ID]; ok {
+			return nil, errors.New("credential already registered")
+		}
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	id := make([]byte, 16)
+	_, err = rand.Read(id)
+	if err != nil {
+		return nil, err
+	}
+	c := &fakeCredential{rpID: options.RP.ID, userHandle: options.User.ID, key: key}
+	if a.credentials == nil {
+		a.credentials = map[string]*fakeCredential{}
+	}
+	encodedID := base64.RawURLEncoding.EncodeToString(id)
+	a.credentials[encodedID] = c
+	x, y := make([]byte, 32), make([]byte, 32)
+	key.X.FillBytes(x)
+	key.Y.FillBytes(y)
+	publicKey := cborEncode(map[any]any{
+		int64(1):  int64(2),
+		int64(3):  int64(coseES256),
+		int64(-1): int64(1),
+		int64(-2): x,
+		int64(-3): y,
+	})
+	authData := c.authData(a.flags() | authDataAttested)
+	// A zero AAGUID stands for an unknown authenticator model.
+	authData = append(authData, make([]byte, 16)...)
+	authData = binary.BigEndian.AppendUint16(authData, uint16(len(id)))
+	authData = append(authData, id...)
+	authData = append(authData, publicKey...)
+	attestation := cborEncode(map[any]any{
+		"fmt":      "none",
+		"attStmt":  map[any]any{},
+		"authData": authData,
+	})
+	return &PasskeyCredential{
+		ID:    encodedID,
+		RawID: encodedID,
+		Type:  "public-key",
+		Response: PasskeyResponse{
+			ClientDataJSON:    a.clientData("webauthn.create", options.Challenge),
+			AttestationObject: base64.RawURLEncoding.EncodeToString(attestation),
+		},
+	}, nil
+}
+
+// Get signs in with a passkey as navigator.credentials.get would.
+// If options allow no credentials in particular, any passkey for the relying party is used.
+func (a *FakeAuthenticator) Get(options *PasskeyRequestOptions) (*PasskeyCredential, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	var id string
+	var c *fakeCredential
+	for i, credential := range a.credentials {
+		if credential.rpID != options.RPID {
+			continue
+		}
+		allowed := len(options.AllowCredentials) == 0
+		for _, d := range options.AllowCredentials {
+			allowed = allowed || d.ID == i
+		}
+		if allowed {
+			id, c = i, credential
+			break
+		}
+	}
+	if c == nil {
+		return nil, errors.New("no credential for relying party")
+	}
+	c.signCount++
+	authData := c.authData(a.flags())
+	clientData := a.clientData("webauthn.get", options.Challenge)
+	b, err := base64.RawURLEncoding.DecodeString(clientData)
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256(b)
+	sum := sha256.Sum256(append(append([]byte{}, authData...), hash[:]...))
+	sig, err := ecdsa.SignASN1(rand.Reader, c.key, sum[:])
+	if err != nil {
+		return nil, err
+	}
+	return &PasskeyCredential{
+		ID:    id,
+		RawID: id,
+		Type:  "public-key",
+		Response: PasskeyResponse{
+			ClientDataJSON:    clientData,
+			AuthenticatorData: base64.RawURLEncoding.EncodeToString(authData),
+			Signature:         base64.RawURLEncoding.EncodeToString(sig),
+			UserHandle:        c.userHandle,
+		},
+	}, nil
+}
+
+// flags returns the flags of the authenticator data the authenticator signs.
+func (a *FakeAuthenticator) flags() byte {
+	if a.NoUserVerification {
+		return authDataUserPresent
+	}
+	return authDataUserPresent | authDataUserVerified
+}
+
+// authData returns the authenticator data of the credential without attested credential data.
+func (c *fakeCredential) authData(flags byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(c.rpID))
+	authData := append(rpIDHash[:], flags)
+	return binary.BigEndian.AppendUint32(authData, c.signCount)
+}
+
+// clientData returns the base64url encoded client data of a ceremony.
+func (a *FakeAuthenticator) clientData(typ, challenge string) string {
+	b, err := json.Marshal(passkeyClientData{
+		Type:      typ,
+		Challenge: challenge,
+		Origin:    a.Origin,
+	})
+	if err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,84 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// FakeIdentityProvider is a minimal OpenID Connect provider that logs every visitor in as Identity.
+// It is meant for tests: serve it with httptest and point an IdentityProvider's Issuer at it.
+// It does not check client credentials or PKCE.
+type FakeIdentityProvider struct {
+	// Identity is the account that the next login is for.
+	// Its Provider and LinkedAt are ignored.
+	Identity ExternalIdentity
+	lock     sync.Mutex
+	codes    map[string]ExternalIdentity
+	tokens   map[string]ExternalIdentity
+}
+
+// ServeHTTP serves the discovery document and the authorization, token and userinfo endpoints.
+func (p *FakeIdentityProvider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.codes == nil {
+		p.codes = map[string]ExternalIdentity{}
+		p.tokens = map[string]ExternalIdentity{}
+	}
+	switch r.URL.Path {
+	case "/.well-known/openid-configuration":
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+		issuer := scheme + "://" + r.Host
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 issuer,
+			"authorization_endpoint": issuer + "/authorize",
+			"token_endpoint":         issuer + "/token",
+			"userinfo_endpoint":      issuer + "/userinfo",
+		})
+	case "/authorize":
+		code := randomToken(16)
+		p.codes[code] = p.Identity
+		u, err := url.Parse(r.URL.Query().Get("redirect_uri"))
+		if err != nil {
+			ServeBadRequest(w, r)
+			return
+		}
+		q := u.Query()
+		q.Set("code", code)
+		q.Set("state", r.URL.Query().Get("state"))
+		u.RawQuery = q.Encode()
+		http.Redirect(w, r, u.String(), http.StatusFound)
+	case "/token":
+		identity, ok := p.codes[r.PostFormValue("code")]
+		delete(p.codes, r.PostFormValue("code"))
+		if !ok {
+			writeOAuthError(w, http.StatusBadRequest, "invalid_grant")
+			return
+		}
+		token := randomToken(16)
+		p.tokens[token] = identity
+		json.NewEncoder(w).Encode(map[string]string{
+			"access_token": token,
+			"token_type":   "Bearer",
+		})
+	case "/userinfo":
+		identity, ok := p.tokens[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")]
+		if !ok {
+			writeOAuthError(w, http.StatusUnauthorized, "invalid_token")
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{
+			"sub":            identity.Subject,
+			"email":          identity.Email,
+			"email_verified": identity.EmailVerified,
+		})
+	default:
+		ServeNotFound(w, r)
+	}
+}
@@ -0,0 +1,42 @@
+package web
+
+import "sync"
+
+// FakeMailSender is a MailSender that keeps messages in memory instead of sending them.
+// It is meant for tests.
+type FakeMailSender struct {
+	// Err, if set, is returned by SendMail, which then records nothing.
+	Err      error
+	lock     sync.Mutex
+	messages []*Mail
+}
+
+// SendMail records m.
+func (s *FakeMailSender) SendMail(m *Mail) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.Err != nil {
+		return s.Err
+	}
+	s.messages = append(s.messages, m)
+	return nil
+}
+
+// Messages returns the messages sent so far, oldest first.
+func (s *FakeMailSender) Messages() []*Mail {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]*Mail{}, s.messages...)
+}
+
+// Last returns the last message sent to the given address, or nil if there is none.
+func (s *FakeMailSender) Last(to string) *Mail {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for i := len(s.messages) - 1; i >= 0; i-- {
+		if s.messages[i].To == to {
+			return s.messages[i]
+		}
+	}
+	return nil
+}
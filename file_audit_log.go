@@ -0,0 +1,81 @@
+package web
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// FileAuditLog is an AuditLog backed by a JSON Lines file, one event per line.
+// Every event is synced to disk before Append returns.
+// A partially written event at the end of the file is discarded when the file is opened.
+type FileAuditLog struct {
+	// Path is the path of the file.
+	Path string
+
+	lock sync.Mutex
+	file *os.File
+}
+
+// Append durably adds an event to the end of the file.
+func (l *FileAuditLog) Append(e *AuditEvent) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	_, err = l.file.Write(b)
+	if err != nil {
+		return err
+	}
+	return l.file.Sync()
+}
+
+// Events reads every event in the file, oldest first.
+func (l *FileAuditLog) Events() ([]*AuditEvent, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	events, _, err := l.read()
+	return events, err
+}
+
+// read reads every complete event in the file and returns them with the offset just after the last one.
+func (l *FileAuditLog) read() ([]*AuditEvent, int64, error) {
+	f, err := os.Open(l.Path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+	events := []*AuditEvent{}
+	var offset int64
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			return events, offset, nil
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		var e AuditEvent
+		err = json.Unmarshal(bytes.TrimSpace(line), &e)
+		if err != nil {
+			return nil, 0, fmt.Errorf("corrupt audit log at offset %d: %w", offset, err)
+		}
+		events = append(events, &e)
+		offset += int64(len(line))
+	}
+}
+
+// Close closes the file.
+func (l *FileAuditLog) Close() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.file.Close()
+}
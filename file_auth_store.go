@@ -0,0 +1,157 @@
+package web
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// FileAuthStore is an AuthStore backed by a directory on disk.
+// Ops are appended to a write-ahead log and synced before they are applied.
+// Snapshots are written to a temporary file and renamed into place,
+// so a crash at any point leaves either the old or the new snapshot.
+// A partially written op at the end of the log is discarded on Load.
+type FileAuthStore struct {
+	// Dir is the directory holding the snapshot and the log.
+	Dir string
+
+	lock sync.Mutex
+	wal  *os.File
+}
+
+// Load returns the latest snapshot and the ops logged after it.
+func (s *FileAuthStore) Load() ([]byte, []*AuthOp, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	snapshot, err := os.ReadFile(s.snapshotPath())
+	if err != nil && !os.IsNotExist(err) {
+		return nil, nil, err
+	}
+	ops, err := s.readLog()
+	if err != nil {
+		return nil, nil, err
+	}
+	return snapshot, ops, nil
+}
+
+// readLog reads every complete op in the log.
+// A torn write at the end of the log is truncated away.
+func (s *FileAuthStore) readLog() ([]*AuthOp, error) {
+	_, err := s.wal.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+	ops := []*AuthOp{}
+	var offset int64
+	r := bufio.NewReader(s.wal)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				return ops, s.truncateLog(offset)
+			}
+			return ops, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		var op AuthOp
+		if err := json.Unmarshal(bytes.TrimSpace(line), &op); err != nil {
+			if _, peekErr := r.Peek(1); peekErr == io.EOF {
+				return ops, s.truncateLog(offset)
+			}
+			return nil, fmt.Errorf("corrupt auth log at offset %d: %w", offset, err)
+		}
+		ops = append(ops, &op)
+		offset += int64(len(line))
+	}
+}
+
+// truncateLog cuts the log off at the given offset.
+func (s *FileAuthStore) truncateLog(offset int64) error {
+	err := s.wal.Truncate(offset)
+	if err != nil {
+		return err
+	}
+	return s.wal.Sync()
+}
+
+// Append durably logs an op.
+func (s *FileAuthStore) Append(op *AuthOp) error {
+	b, err := json.Marshal(op)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	_, err = s.wal.Write(b)
+	if err != nil {
+		return err
+	}
+	return s.wal.Sync()
+}
+
+// Snapshot durably saves the full state and empties the log.
+func (s *FileAuthStore) Snapshot(state []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	tmp := s.snapshotPath() + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(state)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	err = f.Sync()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmp, s.snapshotPath())
+	if err != nil {
+		return err
+	}
+	err = syncDir(s.Dir)
+	if err != nil {
+		return err
+	}
+	return s.truncateLog(0)
+}
+
+// Close closes the log file.
+func (s *FileAuthStore) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.wal.Close()
+}
+
+func (s *FileAuthStore) snapshotPath() string {
+	return filepath.Join(s.Dir, "snapshot.json")
+}
+
+func (s *FileAuthStore) logPath() string {
+	return filepath.Join(s.Dir, "wal.jsonl")
+}
+
+// syncDir flushes directory entries, making renames within dir durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,152 @@
+package web
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// openFileAuthDB opens the AuthDB saved in dir.
+// The store is closed at the end of the test if it is still open.
+func openFileAuthDB(t *testing.T, dir string) (*FileAuthStore, *AuthDB) {
+	store, err := NewFileAuthStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		store.Close()
+	})
+	db, err := OpenAuthDB(store, "correct horse", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store, db
+}
+
+// crash leaves the store as a process killed without closing the AuthDB would.
+func crash(t *testing.T, store *FileAuthStore) {
+	err := store.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func inviteCodes(t *testing.T, db *AuthDB, n int) []string {
+	var codes []string
+	for i := 0; i < n; i++ {
+		code, err := db.Invite()
+		if err != nil {
+			t.Fatal(err)
+		}
+		codes = append(codes, code)
+	}
+	return codes
+}
+
+func TestFileAuthStoreTornLogRecord(t *testing.T) {
+	dir := t.TempDir()
+	store, db := openFileAuthDB(t, dir)
+	codes := inviteCodes(t, db, 3)
+	crash(t, store)
+	wal := filepath.Join(dir, "wal.jsonl")
+	b, err := os.ReadFile(wal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(string(b), "\n") != 3 {
+		t.Fatalf("log has %d records", strings.Count(string(b), "\n"))
+	}
+	// Cut the last record off in the middle, as a crash during the write would.
+	err = os.WriteFile(wal, b[:len(b)-20], 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, db = openFileAuthDB(t, dir)
+	for i, code := range codes {
+		_, ok := db.Invitations[code]
+		if ok != (i < 2) {
+			t.Fatalf("invite %d after reopening: %v", i, ok)
+		}
+	}
+	// The torn record is gone, so new records follow the last complete one.
+	more := inviteCodes(t, db, 1)
+	crash(t, store)
+	_, db = openFileAuthDB(t, dir)
+	for _, code := range append(codes[:2], more...) {
+		if _, ok := db.Invitations[code]; !ok {
+			t.Fatalf("invite %s lost", code)
+		}
+	}
+	if len(db.Invitations) != 3 {
+		t.Fatalf("%d invites", len(db.Invitations))
+	}
+}
+
+func TestFileAuthStoreCorruptLogRecord(t *testing.T) {
+	dir := t.TempDir()
+	store, db := openFileAuthDB(t, dir)
+	inviteCodes(t, db, 2)
+	crash(t, store)
+	wal := filepath.Join(dir, "wal.jsonl")
+	b, err := os.ReadFile(wal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Damage to a record before the last one is not a torn write and must not be skipped silently.
+	err = os.WriteFile(wal, append([]byte("{garbage\n"), b...), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err = NewFileAuthStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	_, err = OpenAuthDB(store, "", "")
+	if err == nil || !strings.Contains(err.Error(), "corrupt auth log at offset 0") {
+		t.Fatalf("opened a corrupt log: %v", err)
+	}
+}
+
+func TestFileAuthStoreHalfWrittenSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	store, db := openFileAuthDB(t, dir)
+	codes := inviteCodes(t, db, 2)
+	err := db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, db = openFileAuthDB(t, dir)
+	codes = append(codes, inviteCodes(t, db, 1)...)
+	crash(t, store)
+	// A crash while the next snapshot was written leaves only its temporary file behind.
+	tmp := filepath.Join(dir, "snapshot.json.tmp")
+	err = os.WriteFile(tmp, []byte(`{"users":{"admin":`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, db = openFileAuthDB(t, dir)
+	for _, code := range codes {
+		if _, ok := db.Invitations[code]; !ok {
+			t.Fatalf("invite %s lost", code)
+		}
+	}
+	_, err = db.Login("admin", "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(tmp); !os.IsNotExist(err) {
+		t.Fatalf("temporary snapshot was left behind: %v", err)
+	}
+	_, db = openFileAuthDB(t, dir)
+	if len(db.Invitations) != 3 {
+		t.Fatalf("%d invites after the next snapshot", len(db.Invitations))
+	}
+}
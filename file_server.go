@@ -16,10 +16,11 @@ type FileServer struct {
 // PUT /path/to/file creates or updates the file.
 // GET /path/to/dir returns the directory as a special type of file.
 func (s *FileServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	var session *Session
-	session.UserID = r.Header.Get("X-User-ID")
-	session.Token = r.Header.Get("X-Token")
-	err := s.authClient().ValidateSession(session)
+	session := &Session{
+		UserID: r.Header.Get("X-User-ID"),
+		Token:  r.Header.Get("X-Token"),
+	}
+	err := s.authClient().ValidateSession(r.Context(), session)
 	if err != nil {
 		w.WriteHeader(http.StatusUnauthorized)
 		w.Write([]byte(err.Error()))
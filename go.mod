@@ -12,5 +12,6 @@ require (
 require (
 	github.com/library-development/go-nameconv v0.0.0-20230118230451-7e86b2bd3679 // indirect
 	golang.org/x/net v0.5.0 // indirect
+	golang.org/x/sys v0.4.0 // indirect
 	golang.org/x/text v0.6.0 // indirect
 )
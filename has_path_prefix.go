@@ -0,0 +1,10 @@
+package web
+
+import "strings"
+
+// hasPathPrefix returns true if p is prefix or lies under it, comparing whole path elements,
+// so that "/api" covers "/api/users" but not "/apiadmin". An empty prefix covers every path.
+func hasPathPrefix(p, prefix string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+	return prefix == "" || p == prefix || strings.HasPrefix(p, prefix+"/")
+}
@@ -0,0 +1,12 @@
+package web
+
+import "net"
+
+// hostName returns the host of a Host header or address, without the port if it has one.
+func hostName(hostport string) string {
+	host, _, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return hostport
+	}
+	return host
+}
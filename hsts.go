@@ -0,0 +1,35 @@
+package web
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// HSTS sends a Strict-Transport-Security header with the responses to HTTPS requests,
+// telling browsers to only use HTTPS for the host from then on.
+type HSTS struct {
+	Handler http.Handler
+	// MaxAge is how long browsers remember to use HTTPS.
+	MaxAge time.Duration
+	// IncludeSubdomains makes browsers use HTTPS for every subdomain of the host too.
+	IncludeSubdomains bool
+}
+
+func (h *HSTS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	writeHSTS(w, r, h.MaxAge, h.IncludeSubdomains)
+	h.Handler.ServeHTTP(w, r)
+}
+
+// writeHSTS sets the Strict-Transport-Security header if r came over TLS and maxAge is positive.
+// Browsers ignore the header on plain HTTP, so it is not sent there.
+func writeHSTS(w http.ResponseWriter, r *http.Request, maxAge time.Duration, includeSubdomains bool) {
+	if r.TLS == nil || maxAge <= 0 {
+		return
+	}
+	v := "max-age=" + strconv.FormatInt(int64(maxAge/time.Second), 10)
+	if includeSubdomains {
+		v += "; includeSubDomains"
+	}
+	w.Header().Set("Strict-Transport-Security", v)
+}
@@ -0,0 +1,34 @@
+package web
+
+import (
+	"net"
+	"net/http"
+)
+
+// HTTPSRedirect permanently redirects plain HTTP requests to the same URL over HTTPS.
+// GET and HEAD requests get 301 Moved Permanently and other requests get 308 Permanent Redirect,
+// so that their method and body are kept.
+type HTTPSRedirect struct {
+	// Port is the port HTTPS is served on.
+	// If it is empty or "443", the URLs redirected to have no port.
+	Port string
+}
+
+func (h *HTTPSRedirect) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = r.Host
+	}
+	if host == "" {
+		ServeBadRequest(w, r)
+		return
+	}
+	if h.Port != "" && h.Port != "443" {
+		host = net.JoinHostPort(host, h.Port)
+	}
+	status := http.StatusPermanentRedirect
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		status = http.StatusMovedPermanently
+	}
+	http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), status)
+}
@@ -0,0 +1,233 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// IdentityProvider is an upstream OAuth 2.0 or OpenID Connect provider that users can log in with.
+// For OpenID Connect providers, setting Issuer is enough; the endpoints are discovered.
+// For plain OAuth 2.0 providers, set AuthURL, TokenURL and UserInfoURL, and the claims if they differ.
+type IdentityProvider struct {
+	// DisplayName names the provider to users.
+	DisplayName string
+	// Issuer is the issuer URL of an OpenID Connect provider.
+	Issuer string
+	// AuthURL, TokenURL and UserInfoURL are the provider's endpoints.
+	// Any that are empty are discovered from Issuer.
+	AuthURL     string
+	TokenURL    string
+	UserInfoURL string
+	// ClientID and ClientSecret are the credentials of the AuthDB at the provider.
+	ClientID     string
+	ClientSecret string
+	// Scopes are requested from the provider.
+	// If it is empty, "openid" and "email" are requested.
+	Scopes []string
+	// RedirectURL is the /oauth/callback URL of the AuthDB as registered with the provider.
+	// If it is empty, it is derived from the AuthDB's issuer.
+	RedirectURL string
+	// SubjectClaim and EmailClaim name the userinfo fields holding the account ID and email address.
+	// If they are empty, "sub" and "email" are used.
+	SubjectClaim string
+	EmailClaim   string
+	// LinkByEmail links an unknown account to the user with the same verified email address,
+	// if the provider says it has verified the address too.
+	// Only turn it on for providers whose email verification you trust.
+	LinkByEmail bool
+	// AllowedEmails lets unknown accounts with a verified email address register without an invite.
+	// An entry is either a full address or a domain starting with "@".
+	AllowedEmails []string
+	// HTTPClient makes the requests to the provider.
+	// If it is nil, http.DefaultClient is used.
+	HTTPClient *http.Client
+	lock       sync.Mutex
+	// endpoints are set by discover.
+	endpoints *providerEndpoints
+}
+
+// providerEndpoints are the endpoints of an IdentityProvider, with the discovered ones filled in.
+type providerEndpoints struct {
+	authURL     string
+	tokenURL    string
+	userInfoURL string
+}
+
+// discover returns the endpoints of the provider, fetching the ones that are not set from its
+// discovery document the first time it is called.
+func (p *IdentityProvider) discover(ctx context.Context) (providerEndpoints, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.endpoints != nil {
+		return *p.endpoints, nil
+	}
+	e := providerEndpoints{
+		authURL:     p.AuthURL,
+		tokenURL:    p.TokenURL,
+		userInfoURL: p.UserInfoURL,
+	}
+	if e.authURL == "" || e.tokenURL == "" || e.userInfoURL == "" {
+		if p.Issuer == "" {
+			return e, fmt.Errorf("identity provider has neither endpoints nor an issuer")
+		}
+		var doc struct {
+			AuthorizationEndpoint string `json:"authorization_endpoint"`
+			TokenEndpoint         string `json:"token_endpoint"`
+			UserInfoEndpoint      string `json:"userinfo_endpoint"`
+		}
+		err := p.getJSON(ctx, strings.TrimSuffix(p.Issuer, "/")+"/.well-known/openid-configuration", "", &doc)
+		if err != nil {
+			return e, err
+		}
+		if e.authURL == "" {
+			e.authURL = doc.AuthorizationEndpoint
+		}
+		if e.tokenURL == "" {
+			e.tokenURL = doc.TokenEndpoint
+		}
+		if e.userInfoURL == "" {
+			e.userInfoURL = doc.UserInfoEndpoint
+		}
+	}
+	p.endpoints = &e
+	return e, nil
+}
+
+// authCodeURL returns the URL that starts a login at the provider.
+func (p *IdentityProvider) authCodeURL(e providerEndpoints, redirectURL, state, codeChallenge string) string {
+	scopes := p.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "email"}
+	}
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.ClientID},
+		"redirect_uri":          {redirectURL},
+		"scope":                 {strings.Join(scopes, " ")},
+		"state":                 {state},
+		"code_challenge":        {codeChallenge},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(e.authURL, "?") {
+		sep = "&"
+	}
+	return e.authURL + sep + q.Encode()
+}
+
+// exchange trades an authorization code for an access token.
+func (p *IdentityProvider) exchange(ctx context.Context, e providerEndpoints, redirectURL, code, codeVerifier string) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURL},
+		"code_verifier": {codeVerifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	res, err := p.httpClient().Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	var token struct {
+		AccessToken string `json:"access_token"`
+		Error       string `json:"error"`
+	}
+	err = json.NewDecoder(res.Body).Decode(&token)
+	if err != nil {
+		return "", err
+	}
+	if res.StatusCode != http.StatusOK || token.AccessToken == "" {
+		return "", fmt.Errorf("token request failed: %s %s", res.Status, token.Error)
+	}
+	return token.AccessToken, nil
+}
+
+// identity fetches the account that accessToken belongs to.
+// The identity is read from the userinfo endpoint, which the provider answers directly over TLS,
+// so the ID token does not need to be verified.
+func (p *IdentityProvider) identity(ctx context.Context, e providerEndpoints, accessToken string) (*ExternalIdentity, error) {
+	var info map[string]any
+	err := p.getJSON(ctx, e.userInfoURL, accessToken, &info)
+	if err != nil {
+		return nil, err
+	}
+	subjectClaim := p.SubjectClaim
+	if subjectClaim == "" {
+		subjectClaim = "sub"
+	}
+	emailClaim := p.EmailClaim
+	if emailClaim == "" {
+		emailClaim = "email"
+	}
+	identity := &ExternalIdentity{}
+	switch sub := info[subjectClaim].(type) {
+	case string:
+		identity.Subject = sub
+	case json.Number:
+		identity.Subject = sub.String()
+	}
+	if identity.Subject == "" {
+		return nil, fmt.Errorf("userinfo has no %q claim", subjectClaim)
+	}
+	if email, ok := info[emailClaim].(string); ok {
+		identity.Email, err = normalizeEmail(email)
+		if err != nil {
+			identity.Email = ""
+		}
+	}
+	identity.EmailVerified, _ = info["email_verified"].(bool)
+	return identity, nil
+}
+
+// allowsEmail returns true if email may register without an invite.
+func (p *IdentityProvider) allowsEmail(email string) bool {
+	for _, allowed := range p.AllowedEmails {
+		allowed = strings.ToLower(allowed)
+		if email == allowed || strings.HasPrefix(allowed, "@") && strings.HasSuffix(email, allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// getJSON decodes the JSON response to a GET request, sent with accessToken as a bearer token if it is not empty.
+func (p *IdentityProvider) getJSON(ctx context.Context, u, accessToken string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+	res, err := p.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", u, res.Status)
+	}
+	d := json.NewDecoder(res.Body)
+	d.UseNumber()
+	return d.Decode(v)
+}
+
+func (p *IdentityProvider) httpClient() *http.Client {
+	if p.HTTPClient != nil {
+		return p.HTTPClient
+	}
+	return http.DefaultClient
+}
@@ -0,0 +1,189 @@
+package web
+
+import (
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+)
+
+// newExternalLoginServer serves an AuthDB with a FakeIdentityProvider named "fake".
+func newExternalLoginServer(t *testing.T) (*AuthDB, *FakeIdentityProvider, *httptest.Server) {
+	db := NewAuthDB("correct horse", "")
+	fake := &FakeIdentityProvider{}
+	provider := httptest.NewServer(fake)
+	t.Cleanup(provider.Close)
+	db.IdentityProviders = map[string]*IdentityProvider{
+		"fake": {Issuer: provider.URL, ClientID: "client", ClientSecret: "secret"},
+	}
+	srv := httptest.NewServer(db)
+	t.Cleanup(srv.Close)
+	return db, fake, srv
+}
+
+// loginWithFakeProvider follows a login with the fake provider through to the page it returns to.
+// It returns that page's URL and the user ID of the session cookie set on the way, if any.
+func loginWithFakeProvider(t *testing.T, db *AuthDB, srv *httptest.Server, q url.Values) (*url.URL, string) {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var returnedTo *url.URL
+	client := &http.Client{
+		Jar: jar,
+		CheckRedirect: func(r *http.Request, via []*http.Request) error {
+			if len(via) > 0 && via[len(via)-1].URL.Path == "/oauth/callback" {
+				returnedTo = r.URL
+				return http.ErrUseLastResponse
+			}
+			return nil
+		},
+	}
+	q.Set("provider", "fake")
+	res, err := client.Get(srv.URL + "/oauth/start?" + q.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if returnedTo == nil {
+		t.Fatalf("login ended at %s with %d", res.Request.URL, res.StatusCode)
+	}
+	for _, cookie := range jar.Cookies(returnedTo) {
+		if cookie.Name != DefaultSessionCookieName {
+			continue
+		}
+		session, ok := ParseCredential(cookie.Value)
+		if !ok || !db.checkSession(session.UserID, session.Token) {
+			t.Fatal("invalid session cookie")
+		}
+		return returnedTo, session.UserID
+	}
+	return returnedTo, ""
+}
+
+func TestExternalLoginRegistersWithInvite(t *testing.T) {
+	db, fake, srv := newExternalLoginServer(t)
+	fake.Identity = ExternalIdentity{Subject: "1", Email: "Bob@example.com", EmailVerified: true}
+	u, userID := loginWithFakeProvider(t, db, srv, url.Values{"return_to": {"/done"}})
+	if u.Path != "/done" || u.Query().Get("error") != ErrInvalidRegistrationCode.Error() || userID != "" {
+		t.Fatalf("login without an invite returned to %s as %q", u, userID)
+	}
+	code, err := db.Invite()
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, userID = loginWithFakeProvider(t, db, srv, url.Values{"return_to": {"/done"}, "registration_code": {code}})
+	if u.Path != "/done" || u.Query().Get("error") != "" || userID == "" {
+		t.Fatalf("login with an invite returned to %s as %q", u, userID)
+	}
+	user, _ := db.User(userID)
+	if len(user.Emails) != 1 || user.Emails[0] != "bob@example.com" || !user.VerifiedEmails["bob@example.com"] {
+		t.Fatalf("emails %v, verified %v", user.Emails, user.VerifiedEmails)
+	}
+	_, again := loginWithFakeProvider(t, db, srv, url.Values{})
+	if again != userID {
+		t.Fatalf("second login was for %q, not %q", again, userID)
+	}
+}
+
+func TestExternalLoginAllowedEmailsAndLinking(t *testing.T) {
+	db, fake, srv := newExternalLoginServer(t)
+	provider := db.IdentityProviders["fake"]
+	provider.AllowedEmails = []string{"@example.com"}
+	fake.Identity = ExternalIdentity{Subject: "1", Email: "al@example.com", EmailVerified: true}
+	_, userID := loginWithFakeProvider(t, db, srv, url.Values{})
+	if userID == "" {
+		t.Fatal("allowed email could not register")
+	}
+	fake.Identity = ExternalIdentity{Subject: "2", Email: "eve@example.org", EmailVerified: true}
+	u, _ := loginWithFakeProvider(t, db, srv, url.Values{})
+	if u.Query().Get("error") == "" {
+		t.Fatal("email outside the allowed domain registered")
+	}
+
+	provider.AllowedEmails = nil
+	fake.Identity = ExternalIdentity{Subject: "3", Email: "al@example.com"}
+	u, _ = loginWithFakeProvider(t, db, srv, url.Values{})
+	if u.Query().Get("error") == "" {
+		t.Fatal("linked by email without LinkByEmail")
+	}
+	provider.LinkByEmail = true
+	u, _ = loginWithFakeProvider(t, db, srv, url.Values{})
+	if u.Query().Get("error") == "" {
+		t.Fatal("linked by an address the provider has not verified")
+	}
+	fake.Identity.EmailVerified = true
+	_, linked := loginWithFakeProvider(t, db, srv, url.Values{})
+	if linked != userID {
+		t.Fatalf("linked login was for %q, not %q", linked, userID)
+	}
+	err := db.UnlinkIdentity(userID, "fake", "3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.UnlinkIdentity(userID, "fake", "3")
+	if err != ErrIdentityNotFound {
+		t.Fatal(err)
+	}
+}
+
+func TestExternalLoginConcurrentDiscovery(t *testing.T) {
+	_, _, srv := newExternalLoginServer(t)
+	client := &http.Client{
+		CheckRedirect: func(r *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	var wg sync.WaitGroup
+	locations := make([]string, 8)
+	for i := range locations {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			res, err := client.Get(srv.URL + "/oauth/start?provider=fake")
+			if err != nil {
+				return
+			}
+			res.Body.Close()
+			locations[i] = res.Header.Get("Location")
+		}(i)
+	}
+	wg.Wait()
+	for _, location := range locations {
+		u, err := url.Parse(location)
+		if err != nil || u.Path != "/authorize" {
+			t.Fatalf("start redirected to %q", location)
+		}
+	}
+}
+
+func TestExternalLoginRejectsForgedState(t *testing.T) {
+	_, _, srv := newExternalLoginServer(t)
+	res, err := http.Get(srv.URL + "/oauth/callback?state=forged&code=code")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Fatal(res.StatusCode)
+	}
+}
+
+func TestSafeReturnTo(t *testing.T) {
+	r := httptest.NewRequest("GET", "http://app.example.com/", nil)
+	tests := map[string]string{
+		"":                             "/",
+		"/items":                       "/items",
+		"//evil.example.com":           "/",
+		"https://evil.example.com":     "/",
+		"http://app.example.com/items": "http://app.example.com/items",
+	}
+	for returnTo, want := range tests {
+		got := safeReturnTo(r, returnTo)
+		if got != want {
+			t.Errorf("safeReturnTo(%q) = %q, want %q", returnTo, got, want)
+		}
+	}
+}
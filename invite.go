@@ -0,0 +1,53 @@
+package web
+
+import "time"
+
+// Invite is a registration code and the rules for redeeming it.
+type Invite struct {
+	// Code is the registration code.
+	Code string `json:"code"`
+	// IssuedBy is the ID of the user who created the invite.
+	// It is empty if the invite was created with the registrar key.
+	IssuedBy string `json:"issued_by"`
+	// CreatedAt is the Unix timestamp of when the invite was created.
+	CreatedAt int64 `json:"created_at"`
+	// ExpiresAt is the Unix timestamp after which the invite can no longer be redeemed.
+	// Zero means the invite does not expire.
+	ExpiresAt int64 `json:"expires_at"`
+	// MaxUses is the number of users that can register with the invite.
+	// Zero means there is no limit.
+	MaxUses int `json:"max_uses"`
+	// Role is given to users who register with the invite, in addition to RoleMember.
+	Role string `json:"role,omitempty"`
+	// Org is the ID of an organization that users who register with the invite are added to.
+	Org string `json:"org,omitempty"`
+	// Note is a free-form description, e.g. who the invite was meant for.
+	Note string `json:"note,omitempty"`
+	// Revoked invites can no longer be redeemed.
+	Revoked bool `json:"revoked"`
+	// RedeemedBy are the IDs of the users who registered with the invite, in order.
+	RedeemedBy []string `json:"redeemed_by"`
+}
+
+// Redeemable returns true if a new user can register with the invite at the given time.
+func (i *Invite) Redeemable(now time.Time) bool {
+	if i.Revoked {
+		return false
+	}
+	if i.ExpiresAt != 0 && now.Unix() >= i.ExpiresAt {
+		return false
+	}
+	if i.MaxUses != 0 && len(i.RedeemedBy) >= i.MaxUses {
+		return false
+	}
+	return true
+}
+
+// legacyInvite returns the single-use invite that a registration code from before invites stands for.
+func legacyInvite(code string) *Invite {
+	return &Invite{
+		Code:       code,
+		MaxUses:    1,
+		RedeemedBy: []string{},
+	}
+}
@@ -0,0 +1,56 @@
+package web
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"math/big"
+)
+
+// JWK is a public key in JSON Web Key form, as served by the JWKS endpoint.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	// N and E are the modulus and exponent of an RSA key.
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// Crv and X are the curve and public key of an OKP key.
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// Verify returns true if sig is a valid signature of data by the key.
+func (k *JWK) Verify(data, sig []byte) bool {
+	switch {
+	case k.Kty == "RSA" && k.Alg == AlgRS256:
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return false
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return false
+		}
+		key := &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+		sum := sha256.Sum256(data)
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, sum[:], sig) == nil
+	case k.Kty == "OKP" && k.Crv == "Ed25519" && k.Alg == AlgEdDSA:
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return false
+		}
+		return ed25519.Verify(ed25519.PublicKey(x), data, sig)
+	}
+	return false
+}
+
+func (k *JWK) algorithm() string {
+	return k.Alg
+}
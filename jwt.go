@@ -0,0 +1,78 @@
+package web
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+)
+
+// jwtHeader is the header of a JSON Web Token.
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	Typ string `json:"typ"`
+}
+
+// jwtKey verifies the signatures of JSON Web Tokens.
+// SigningKey verifies with its private key and JWK with just the public key.
+type jwtKey interface {
+	algorithm() string
+	Verify(data, sig []byte) bool
+}
+
+// signJWT returns claims as a JSON Web Token of the given type signed with key.
+func signJWT(key *SigningKey, typ string, claims any) (string, error) {
+	header, err := json.Marshal(&jwtHeader{
+		Alg: key.Alg,
+		Kid: key.ID,
+		Typ: typ,
+	})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	sig, err := key.Sign([]byte(signingInput))
+	if err != nil {
+		return "", err
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// parseJWT checks that token is a JSON Web Token of the given type signed by the key that
+// keys returns for its key ID, and decodes its claims into claims.
+// If it returns an error, it will be of type ErrInvalidToken.
+func parseJWT(keys func(kid string) (jwtKey, bool), typ, token string, claims any) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return ErrInvalidToken
+	}
+	b, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return ErrInvalidToken
+	}
+	var header jwtHeader
+	err = json.Unmarshal(b, &header)
+	if err != nil || header.Typ != typ {
+		return ErrInvalidToken
+	}
+	key, ok := keys(header.Kid)
+	if !ok || key.algorithm() != header.Alg {
+		return ErrInvalidToken
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !key.Verify([]byte(parts[0]+"."+parts[1]), sig) {
+		return ErrInvalidToken
+	}
+	b, err = base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return ErrInvalidToken
+	}
+	if json.Unmarshal(b, claims) != nil {
+		return ErrInvalidToken
+	}
+	return nil
+}
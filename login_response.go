@@ -0,0 +1,9 @@
+package web
+
+// LoginResponse is the response to a login request.
+// If the user has a second factor, Token is empty and Challenge must be
+// answered with a code at /verify-login to get a session.
+type LoginResponse struct {
+	Session
+	Challenge string `json:"challenge,omitempty"`
+}
@@ -0,0 +1,159 @@
+package web
+
+import "time"
+
+// loginThrottle tracks failed logins per key, such as a user ID or an IP address.
+// The first free failures in a row cost nothing. Each failure after them doubles the wait
+// before the next attempt, starting at backoff, and max failures in a row lock the key for lockout.
+// A key that sees no failures for lockout after its wait is forgotten.
+// Attempts in flight are tracked apart from failures, so that a key never has more of them
+// than the failures it has left before a wait; the others must wait for them to finish.
+type loginThrottle struct {
+	max     int
+	free    int
+	backoff time.Duration
+	lockout time.Duration
+
+	entries   map[string]*throttleEntry
+	lastSweep time.Time
+}
+
+type throttleEntry struct {
+	failures    int
+	lastFailure time.Time
+	retryAt     time.Time
+	// pending is the number of attempts in flight, whose outcome is not known yet.
+	pending int
+}
+
+// wait returns how long key must wait before its next attempt.
+func (t *loginThrottle) wait(key string, now time.Time) time.Duration {
+	e, ok := t.entries[key]
+	if !ok || !now.Before(e.retryAt) {
+		return 0
+	}
+	return e.retryAt.Sub(now)
+}
+
+// admits returns true if another attempt for key may start now, given the attempts in flight.
+// If they all failed, the new one must not get past the point where a wait starts.
+func (t *loginThrottle) admits(key string) bool {
+	e, ok := t.entries[key]
+	if !ok || e.pending == 0 {
+		return true
+	}
+	limit := t.limit()
+	return limit == 0 || e.failures+e.pending < limit
+}
+
+// limit returns the number of failures in a row after which key must wait, or 0 if there is none.
+func (t *loginThrottle) limit() int {
+	limit := 0
+	if t.backoff != 0 {
+		limit = t.free + 1
+	}
+	if t.max != 0 && (limit == 0 || t.max < limit) {
+		limit = t.max
+	}
+	return limit
+}
+
+// start records an attempt for key that is in flight until finish is called.
+func (t *loginThrottle) start(key string, now time.Time) {
+	if t.max == 0 && t.backoff == 0 {
+		return
+	}
+	t.entry(key, now).pending++
+}
+
+// finish records that an attempt recorded by start is over, whether it failed or not.
+func (t *loginThrottle) finish(key string) {
+	e, ok := t.entries[key]
+	if !ok || e.pending == 0 {
+		return
+	}
+	e.pending--
+	if e.pending == 0 && e.failures == 0 {
+		delete(t.entries, key)
+	}
+}
+
+// fail records a failed attempt for key.
+func (t *loginThrottle) fail(key string, now time.Time) {
+	if t.max == 0 && t.backoff == 0 {
+		return
+	}
+	e := t.entry(key, now)
+	e.failures++
+	e.lastFailure = now
+	t.update(e)
+}
+
+// entry returns the entry for key, creating it if there is none and forgetting its failures if they are stale.
+func (t *loginThrottle) entry(key string, now time.Time) *throttleEntry {
+	if t.entries == nil {
+		t.entries = map[string]*throttleEntry{}
+	}
+	t.sweep(now)
+	e, ok := t.entries[key]
+	if !ok {
+		e = &throttleEntry{}
+		t.entries[key] = e
+	} else if t.stale(e, now) {
+		e.failures = 0
+	}
+	return e
+}
+
+// update sets when the key of e may try again after its failures.
+func (t *loginThrottle) update(e *throttleEntry) {
+	if t.max != 0 && e.failures >= t.max {
+		e.retryAt = e.lastFailure.Add(t.lockout)
+		return
+	}
+	if e.failures <= t.free {
+		e.retryAt = e.lastFailure
+		return
+	}
+	shift := e.failures - t.free - 1
+	if shift > 30 {
+		shift = 30
+	}
+	wait := t.backoff << shift
+	if t.lockout != 0 && wait > t.lockout {
+		wait = t.lockout
+	}
+	e.retryAt = e.lastFailure.Add(wait)
+}
+
+// reset forgets the failures of key.
+func (t *loginThrottle) reset(key string) {
+	e, ok := t.entries[key]
+	if !ok {
+		return
+	}
+	if e.pending == 0 {
+		delete(t.entries, key)
+		return
+	}
+	e.failures = 0
+	e.retryAt = time.Time{}
+}
+
+// stale returns true if the entry has been quiet long enough to be forgotten.
+func (t *loginThrottle) stale(e *throttleEntry, now time.Time) bool {
+	return now.After(e.retryAt.Add(t.lockout))
+}
+
+// sweep drops stale entries, at most once a minute.
+func (t *loginThrottle) sweep(now time.Time) {
+	if now.Sub(t.lastSweep) < time.Minute {
+		return
+	}
+	t.lastSweep = now
+	for key, e := range t.entries {
+		if e.pending == 0 && t.stale(e, now) {
+			delete(t.entries, key)
+		}
+	}
+}
@@ -0,0 +1,8 @@
+package web
+
+// Mail is an email message.
+type Mail struct {
+	To      string `json:"to"`
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+}
@@ -0,0 +1,6 @@
+package web
+
+// MailSender delivers email.
+type MailSender interface {
+	SendMail(m *Mail) error
+}
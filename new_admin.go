@@ -0,0 +1,8 @@
+package web
+
+// NewAdmin returns a new user with the admin role.
+func NewAdmin(password string) *User {
+	u := NewUser(password)
+	u.Roles = []string{RoleAdmin}
+	return u
+}
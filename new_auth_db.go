@@ -1,13 +1,33 @@
 package web
 
+import "time"
+
+// NewAuthDB returns a new in-memory AuthDB with a single admin user.
+// Use OpenAuthDB for an AuthDB that survives restarts.
 func NewAuthDB(adminPassword, registrarKey string) *AuthDB {
 	authDB := &AuthDB{
 		Users: map[string]*User{
-			"admin": NewUser(adminPassword),
+			"admin": NewAdmin(adminPassword),
 		},
-		RegistrationCodes: map[string]bool{},
-		RegistrarKey:      registrarKey,
-		AdminID:           "admin",
+		Invitations:           map[string]*Invite{},
+		RegistrarKey:          registrarKey,
+		AdminID:               "admin",
+		SessionIdleTimeout:    7 * 24 * time.Hour,
+		SessionMaxAge:         30 * 24 * time.Hour,
+		MaxLoginFailures:      10,
+		MaxLoginFailuresPerIP: 50,
+		IPBackoffThreshold:    10,
+		LoginBackoff:          time.Second,
+		LoginLockout:          15 * time.Minute,
+		TokenSecret:           randomToken(32),
+		AuditKey:              randomToken(32),
+		OAuthClients:          map[string]*OAuthClient{},
+		SigningKeys:           map[string]*SigningKey{},
+		SigningAlg:            AlgRS256,
+		AccessTokenTTL:        time.Hour,
+		RevokedSessions:       map[string]int64{},
+		RevokedUsers:          map[string]int64{},
+		Orgs:                  map[string]*Organization{},
 	}
 	return authDB
 }
@@ -0,0 +1,25 @@
+package web
+
+import "os"
+
+// NewFileAuditLog opens the FileAuditLog at path, creating the file if needed.
+func NewFileAuditLog(path string) (*FileAuditLog, error) {
+	l := &FileAuditLog{Path: path}
+	var err error
+	l.file, err = os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	_, offset, err := l.read()
+	if err != nil {
+		l.file.Close()
+		return nil, err
+	}
+	// Cut off a torn write left by a crash, so that the next event starts on a line of its own.
+	err = l.file.Truncate(offset)
+	if err != nil {
+		l.file.Close()
+		return nil, err
+	}
+	return l, nil
+}
@@ -0,0 +1,17 @@
+package web
+
+import "os"
+
+// NewFileAuthStore opens the FileAuthStore in dir, creating the directory if needed.
+func NewFileAuthStore(dir string) (*FileAuthStore, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+	s := &FileAuthStore{Dir: dir}
+	s.wal, err = os.OpenFile(s.logPath(), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
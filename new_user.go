@@ -1,9 +1,14 @@
 package web
 
 func NewUser(password string) *User {
+	return newUser(passwordHash(password))
+}
+
+// newUser returns a new user with the given password hash.
+func newUser(passwordHash string) *User {
 	return &User{
-		PasswordHash:  passwordHash(password),
-		SessionTokens: map[string]bool{},
-		Emails:        []string{},
+		PasswordHash: passwordHash,
+		Sessions:     map[string]*SessionInfo{},
+		Emails:       []string{},
 	}
 }
@@ -0,0 +1,23 @@
+package web
+
+// OAuthClient is an app that signs users in through AuthDB as an OpenID Connect provider.
+type OAuthClient struct {
+	// ID is the client_id of the app.
+	ID string `json:"id"`
+	// Name is shown to users when they are asked for consent.
+	Name string `json:"name"`
+	// RedirectURIs are the only URIs that authorization codes are sent to.
+	RedirectURIs []string `json:"redirect_uris"`
+	// Public clients, such as single-page and mobile apps, cannot keep a secret.
+	// They must use PKCE and have no SecretHash.
+	Public bool `json:"public"`
+	// SecretHash is the hash of the client secret.
+	SecretHash string `json:"secret_hash,omitempty"`
+	// CreatedAt is the Unix timestamp of when the client was registered.
+	CreatedAt int64 `json:"created_at"`
+}
+
+// allowsRedirect returns true if uri is one of the client's redirect URIs.
+func (c *OAuthClient) allowsRedirect(uri string) bool {
+	return containsString(c.RedirectURIs, uri)
+}
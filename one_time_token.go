@@ -0,0 +1,58 @@
+package web
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+)
+
+// oneTimeToken is the payload of a signed token sent to a user, e.g. by email.
+// The nonce is also recorded on the user and removed when the token is used,
+// so each token works only once.
+type oneTimeToken struct {
+	Purpose   string `json:"purpose"`
+	UserID    string `json:"user_id"`
+	Email     string `json:"email,omitempty"`
+	Nonce     string `json:"nonce"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+// signToken encodes t and signs it with the given secret.
+func signToken(secret string, t *oneTimeToken) string {
+	b, err := json.Marshal(t)
+	if err != nil {
+		panic(err)
+	}
+	payload := base64.RawURLEncoding.EncodeToString(b)
+	return payload + "." + tokenSignature(secret, payload)
+}
+
+// parseToken checks the signature of a token made by signToken and decodes it.
+// If it returns an error, it will be of type ErrInvalidToken.
+func parseToken(secret, token string) (*oneTimeToken, error) {
+	payload, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+	if !hmac.Equal([]byte(sig), []byte(tokenSignature(secret, payload))) {
+		return nil, ErrInvalidToken
+	}
+	b, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	var t oneTimeToken
+	err = json.Unmarshal(b, &t)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	return &t, nil
+}
+
+func tokenSignature(secret, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,93 @@
+package web
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// OpenAuthDB opens the AuthDB saved in the given store.
+// If the store is empty, a new AuthDB is created with NewAuthDB and saved;
+// otherwise adminPassword and registrarKey are ignored.
+// Every change made through the returned AuthDB is written to the store.
+func OpenAuthDB(store AuthStore, adminPassword, registrarKey string) (*AuthDB, error) {
+	snapshot, ops, err := store.Load()
+	if err != nil {
+		return nil, err
+	}
+	if snapshot == nil && len(ops) == 0 {
+		s := NewAuthDB(adminPassword, registrarKey)
+		s.store = store
+		err = s.snapshot()
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	}
+	s := &AuthDB{}
+	if snapshot != nil {
+		err = json.Unmarshal(snapshot, s)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if s.Users == nil {
+		s.Users = map[string]*User{}
+	}
+	if s.Invitations == nil {
+		s.Invitations = map[string]*Invite{}
+	}
+	if s.OAuthClients == nil {
+		s.OAuthClients = map[string]*OAuthClient{}
+	}
+	if s.SigningKeys == nil {
+		s.SigningKeys = map[string]*SigningKey{}
+	}
+	if s.RevokedSessions == nil {
+		s.RevokedSessions = map[string]int64{}
+	}
+	if s.RevokedUsers == nil {
+		s.RevokedUsers = map[string]int64{}
+	}
+	if s.Orgs == nil {
+		s.Orgs = map[string]*Organization{}
+	}
+	upgraded := len(s.RegistrationCodes) != 0
+	for code := range s.RegistrationCodes {
+		s.Invitations[code] = legacyInvite(code)
+	}
+	s.RegistrationCodes = nil
+	for _, user := range s.Users {
+		s.upgradeUser(user)
+	}
+	for _, op := range ops {
+		err = s.apply(op)
+		if err != nil {
+			return nil, err
+		}
+	}
+	s.store = store
+	s.ops = len(ops)
+	if s.TokenSecret == "" {
+		s.TokenSecret = randomToken(32)
+		upgraded = true
+	}
+	if s.AuditKey == "" {
+		s.AuditKey = randomToken(32)
+		upgraded = true
+	}
+	if s.SigningAlg == "" {
+		s.SigningAlg = AlgRS256
+		upgraded = true
+	}
+	if s.AccessTokenTTL == 0 {
+		s.AccessTokenTTL = time.Hour
+		upgraded = true
+	}
+	if upgraded {
+		err = s.snapshot()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
@@ -1,11 +1,19 @@
 package web
 
-import "net/http"
+import (
+	"net/http"
+	"reflect"
+)
 
 type Org[DataType any] struct {
 	Data DataType
 }
 
 func (o *Org[T]) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Data that is not a pointer is served through its address, so that its methods can change it.
+	if reflect.ValueOf(o.Data).Kind() != reflect.Pointer {
+		ServeAny(&o.Data, w, r)
+		return
+	}
 	ServeAny(o.Data, w, r)
 }
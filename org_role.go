@@ -0,0 +1,29 @@
+package web
+
+// Roles of the members of an Organization, from most to least privileged.
+// Each role may do everything the roles below it may.
+const (
+	// OrgRoleOwner may transfer ownership and give OrgRoleAdmin. Every organization has one owner.
+	OrgRoleOwner = "owner"
+	// OrgRoleAdmin may invite and remove members and change the roles of editors and viewers.
+	OrgRoleAdmin = "admin"
+	// OrgRoleEditor may change the organization's data.
+	OrgRoleEditor = "editor"
+	// OrgRoleViewer may see the organization's data.
+	OrgRoleViewer = "viewer"
+)
+
+// orgRoleRank returns how privileged an org role is, or 0 if it is not one.
+func orgRoleRank(role string) int {
+	switch role {
+	case OrgRoleOwner:
+		return 4
+	case OrgRoleAdmin:
+		return 3
+	case OrgRoleEditor:
+		return 2
+	case OrgRoleViewer:
+		return 1
+	}
+	return 0
+}
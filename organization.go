@@ -0,0 +1,75 @@
+package web
+
+import "encoding/json"
+
+// Organization is a group of users, such as a tenant of a SaaS, that owns files and data.
+// Every member has an org role, and there is one member with OrgRoleOwner.
+type Organization struct {
+	// ID is the ID of the organization, which File.Owner refers to.
+	ID string `json:"id"`
+	// Name is the display name of the organization.
+	Name string `json:"name"`
+	// CreatedAt is the Unix timestamp of when the organization was created.
+	CreatedAt int64 `json:"created_at"`
+	// Members are the members of the organization keyed by user ID.
+	Members map[string]*OrgMember `json:"members"`
+	// Invitations are the pending invitations to join the organization keyed by invitation ID.
+	Invitations map[string]*OrgInvitation `json:"invitations"`
+}
+
+// OrgMember is the membership of a user in an organization.
+type OrgMember struct {
+	// Role is the org role of the member, such as OrgRoleEditor.
+	Role string `json:"role"`
+	// JoinedAt is the Unix timestamp of when the user joined the organization.
+	JoinedAt int64 `json:"joined_at"`
+}
+
+// OrgInvitation asks a user to join an organization with a given role.
+// It names the user either by ID or by an email address that the user must have verified.
+type OrgInvitation struct {
+	// ID is the ID of the invitation.
+	ID string `json:"id"`
+	// OrgID is the ID of the organization the invitation is for.
+	OrgID string `json:"org_id"`
+	// UserID is the ID of the invited user, if they were invited by ID.
+	UserID string `json:"user_id,omitempty"`
+	// Email is the address of the invited user, if they were invited by email.
+	Email string `json:"email,omitempty"`
+	// Role is the org role the user gets by accepting the invitation.
+	Role string `json:"role"`
+	// InvitedBy is the ID of the member who sent the invitation.
+	InvitedBy string `json:"invited_by"`
+	// CreatedAt is the Unix timestamp of when the invitation was sent.
+	CreatedAt int64 `json:"created_at"`
+}
+
+// Owner returns the ID of the member with OrgRoleOwner, or "" if there is none.
+func (o *Organization) Owner() string {
+	for userID, m := range o.Members {
+		if m.Role == OrgRoleOwner {
+			return userID
+		}
+	}
+	return ""
+}
+
+// clone returns a deep copy of the organization.
+func (o *Organization) clone() *Organization {
+	b, err := json.Marshal(o)
+	if err != nil {
+		panic(err)
+	}
+	var c Organization
+	err = json.Unmarshal(b, &c)
+	if err != nil {
+		panic(err)
+	}
+	if c.Members == nil {
+		c.Members = map[string]*OrgMember{}
+	}
+	if c.Invitations == nil {
+		c.Invitations = map[string]*OrgInvitation{}
+	}
+	return &c
+}
@@ -0,0 +1,111 @@
+package web
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"math/big"
+)
+
+// COSE algorithm identifiers of the passkey signatures that are supported.
+const (
+	coseES256 = -7
+	coseEdDSA = -8
+	coseRS256 = -257
+)
+
+// Passkey is a WebAuthn credential that a user can log in with instead of a password.
+// The private key never leaves the user's authenticator; only its public key is stored.
+type Passkey struct {
+	// ID is the base64url encoded credential ID chosen by the authenticator.
+	ID string `json:"id"`
+	// Name describes the passkey to the user, such as the device it was created on.
+	Name string `json:"name"`
+	// PublicKey is the COSE encoded public key of the credential.
+	PublicKey []byte `json:"public_key"`
+	// Alg is the COSE algorithm of the credential's signatures.
+	Alg int64 `json:"alg"`
+	// SignCount is the signature counter last reported by the authenticator.
+	// Authenticators that keep a counter increase it with every login, which exposes cloned credentials.
+	SignCount uint32 `json:"sign_count"`
+	// CreatedAt is the Unix timestamp of when the passkey was registered.
+	CreatedAt int64 `json:"created_at"`
+	// LastUsedAt is the Unix timestamp of the last login with the passkey.
+	LastUsedAt int64 `json:"last_used_at"`
+}
+
+// verify returns nil if sig is the passkey's signature of data.
+func (p *Passkey) verify(data, sig []byte) error {
+	key, _, err := parseCOSEKey(p.PublicKey)
+	if err != nil {
+		return err
+	}
+	return verifyCOSE(key, data, sig)
+}
+
+// parseCOSEKey returns the public key and algorithm of a COSE encoded key.
+// It supports ES256 keys on P-256, EdDSA keys on Ed25519 and RS256 keys.
+func parseCOSEKey(b []byte) (crypto.PublicKey, int64, error) {
+	v, rest, err := cborDecode(b)
+	if err != nil || len(rest) != 0 {
+		return nil, 0, ErrInvalidCredential
+	}
+	m, ok := v.(map[any]any)
+	if !ok {
+		return nil, 0, ErrInvalidCredential
+	}
+	kty, _ := m[int64(1)].(int64)
+	alg, _ := m[int64(3)].(int64)
+	switch {
+	case kty == 2 && alg == coseES256:
+		crv, _ := m[int64(-1)].(int64)
+		x, _ := m[int64(-2)].([]byte)
+		y, _ := m[int64(-3)].([]byte)
+		if crv != 1 || len(x) != 32 || len(y) != 32 {
+			return nil, 0, ErrInvalidCredential
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil, 0, ErrInvalidCredential
+		}
+		return key, alg, nil
+	case kty == 1 && alg == coseEdDSA:
+		crv, _ := m[int64(-1)].(int64)
+		x, _ := m[int64(-2)].([]byte)
+		if crv != 6 || len(x) != ed25519.PublicKeySize {
+			return nil, 0, ErrInvalidCredential
+		}
+		return ed25519.PublicKey(x), alg, nil
+	case kty == 3 && alg == coseRS256:
+		n, _ := m[int64(-1)].([]byte)
+		e, _ := m[int64(-2)].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, 0, ErrInvalidCredential
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, alg, nil
+	}
+	return nil, 0, ErrUnsupportedAlgorithm
+}
+
+// verifyCOSE returns nil if sig is a signature of data by key in the encoding WebAuthn uses for its algorithm.
+func verifyCOSE(key crypto.PublicKey, data, sig []byte) error {
+	sum := sha256.Sum256(data)
+	switch key := key.(type) {
+	case *ecdsa.PublicKey:
+		if ecdsa.VerifyASN1(key, sum[:], sig) {
+			return nil
+		}
+	case ed25519.PublicKey:
+		if ed25519.Verify(key, data, sig) {
+			return nil
+		}
+	case *rsa.PublicKey:
+		if rsa.VerifyPKCS1v15(key, crypto.SHA256, sum[:], sig) == nil {
+			return nil
+		}
+	}
+	return ErrInvalidCredential
+}
@@ -0,0 +1,77 @@
+package web
+
+// PasskeyCreationOptions are the options a browser needs to create a passkey.
+// They are encoded the way PublicKeyCredential.parseCreationOptionsFromJSON expects,
+// with binary values as base64url strings.
+type PasskeyCreationOptions struct {
+	Challenge              string              `json:"challenge"`
+	RP                     PasskeyRP           `json:"rp"`
+	User                   PasskeyUser         `json:"user"`
+	PubKeyCredParams       []PasskeyParam      `json:"pubKeyCredParams"`
+	Timeout                int64               `json:"timeout"`
+	ExcludeCredentials     []PasskeyDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection PasskeySelection    `json:"authenticatorSelection"`
+	Attestation            string              `json:"attestation"`
+}
+
+// PasskeyRequestOptions are the options a browser needs to log in with a passkey.
+// They are encoded the way PublicKeyCredential.parseRequestOptionsFromJSON expects.
+// If AllowCredentials is empty, the user picks any passkey they have for the relying party.
+type PasskeyRequestOptions struct {
+	Challenge        string              `json:"challenge"`
+	Timeout          int64               `json:"timeout"`
+	RPID             string              `json:"rpId"`
+	AllowCredentials []PasskeyDescriptor `json:"allowCredentials"`
+	UserVerification string              `json:"userVerification"`
+}
+
+// PasskeyRP identifies the relying party that passkeys are created for.
+type PasskeyRP struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// PasskeyUser describes the user a passkey is created for.
+// ID is the base64url encoded user ID, which authenticators return as the user handle.
+type PasskeyUser struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+// PasskeyParam names a signature algorithm the relying party accepts.
+type PasskeyParam struct {
+	Type string `json:"type"`
+	Alg  int64  `json:"alg"`
+}
+
+// PasskeyDescriptor names an existing credential by its base64url encoded ID.
+type PasskeyDescriptor struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+// PasskeySelection states what kind of authenticator the relying party wants.
+type PasskeySelection struct {
+	ResidentKey      string `json:"residentKey"`
+	UserVerification string `json:"userVerification"`
+}
+
+// PasskeyCredential is the browser's answer to a passkey ceremony, as encoded by PublicKeyCredential.toJSON.
+// Registrations fill in AttestationObject; logins fill in AuthenticatorData, Signature and UserHandle.
+type PasskeyCredential struct {
+	ID       string          `json:"id"`
+	RawID    string          `json:"rawId"`
+	Type     string          `json:"type"`
+	Response PasskeyResponse `json:"response"`
+}
+
+// PasskeyResponse is the authenticator's response within a PasskeyCredential.
+// All values are base64url encoded.
+type PasskeyResponse struct {
+	ClientDataJSON    string `json:"clientDataJSON"`
+	AttestationObject string `json:"attestationObject,omitempty"`
+	AuthenticatorData string `json:"authenticatorData,omitempty"`
+	Signature         string `json:"signature,omitempty"`
+	UserHandle        string `json:"userHandle,omitempty"`
+}
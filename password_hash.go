@@ -1,12 +1,11 @@
 package web
 
-import "golang.org/x/crypto/bcrypt"
-
-// See https://security.stackexchange.com/questions/211/how-to-securely-hash-passwords/31846#31846
+// passwordHash returns a hash of password made by the default hasher.
+// It is meant for users created in code, such as the first admin; it panics if the password cannot be hashed.
 func passwordHash(password string) string {
-	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	hash, err := defaultPasswordHasher.Hash(password)
 	if err != nil {
 		panic(err)
 	}
-	return string(hash)
+	return hash
 }
@@ -1,9 +1,12 @@
 package web
 
-import "golang.org/x/crypto/bcrypt"
-
 // passwordHashMatches returns true if the given password hash matches the given password.
+// The hash may have been made by any of the supported hashers.
 func passwordHashMatches(passwordHash, password string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(password))
-	return err == nil
+	for _, hasher := range passwordHashers {
+		if hasher.Verify(passwordHash, password) {
+			return true
+		}
+	}
+	return false
 }
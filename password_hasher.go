@@ -0,0 +1,22 @@
+package web
+
+// PasswordHasher hashes passwords for an AuthDB.
+// Hashes are strings that record the algorithm and its parameters along with the salt,
+// so that they can be checked after the hasher or its parameters change.
+type PasswordHasher interface {
+	// Hash returns a new salted hash of password.
+	// If it returns an error, it may be of type ErrPasswordTooLong.
+	Hash(password string) (string, error)
+	// Verify returns true if hash is a hash of password made by this kind of hasher.
+	// It returns false for hashes of other algorithms.
+	Verify(hash, password string) bool
+	// NeedsRehash returns true if hash was made with another algorithm or with other parameters
+	// than the hasher's, so that it should be replaced the next time the password is known.
+	NeedsRehash(hash string) bool
+}
+
+// defaultPasswordHasher is used by an AuthDB without a PasswordHasher.
+var defaultPasswordHasher PasswordHasher = &Argon2idHasher{}
+
+// passwordHashers are the hashers that can check stored hashes, whatever the configured hasher is.
+var passwordHashers = []PasswordHasher{&Argon2idHasher{}, &BcryptHasher{}}
@@ -0,0 +1,137 @@
+package web
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// plainHasher is a custom PasswordHasher that stores passwords as they are.
+type plainHasher struct{}
+
+func (plainHasher) Hash(password string) (string, error) {
+	return "$plain$" + password, nil
+}
+
+func (plainHasher) Verify(hash, password string) bool {
+	return hash == "$plain$"+password
+}
+
+func (plainHasher) NeedsRehash(hash string) bool {
+	return !strings.HasPrefix(hash, "$plain$")
+}
+
+// fastArgon2id is cheap enough to hash with in tests.
+var fastArgon2id = &Argon2idHasher{Memory: 64, Time: 1, Threads: 1}
+
+func TestPasswordHashers(t *testing.T) {
+	for _, h := range []PasswordHasher{fastArgon2id, &BcryptHasher{Cost: bcrypt.MinCost}} {
+		hash, err := h.Hash("battery staple")
+		if err != nil {
+			t.Fatal(err)
+		}
+		again, _ := h.Hash("battery staple")
+		if hash == again {
+			t.Errorf("%T: hashes are not salted", h)
+		}
+		if !h.Verify(hash, "battery staple") || h.Verify(hash, "battery stapler") {
+			t.Errorf("%T: Verify is wrong for %s", h, hash)
+		}
+		if !passwordHashMatches(hash, "battery staple") {
+			t.Errorf("%T: %s is not checked by the built-in hashers", h, hash)
+		}
+		if h.NeedsRehash(hash) {
+			t.Errorf("%T: fresh hash needs rehash", h)
+		}
+	}
+	argonHash, _ := fastArgon2id.Hash("battery staple")
+	bcryptHash, _ := (&BcryptHasher{Cost: bcrypt.MinCost}).Hash("battery staple")
+	if (&BcryptHasher{}).Verify(argonHash, "battery staple") || fastArgon2id.Verify(bcryptHash, "battery staple") {
+		t.Fatal("hasher verified a hash of another algorithm")
+	}
+	if !(&Argon2idHasher{}).NeedsRehash(argonHash) || !fastArgon2id.NeedsRehash(bcryptHash) {
+		t.Fatal("hash with other parameters does not need rehash")
+	}
+	if !(&BcryptHasher{}).NeedsRehash(bcryptHash) || !(&BcryptHasher{}).NeedsRehash(argonHash) {
+		t.Fatal("hash with other cost does not need rehash")
+	}
+	_, err := (&BcryptHasher{}).Hash(strings.Repeat("x", 73))
+	if err != ErrPasswordTooLong {
+		t.Fatalf("long bcrypt password: %v", err)
+	}
+}
+
+func TestParseArgon2idHash(t *testing.T) {
+	p, ok := parseArgon2idHash("$argon2id$v=19$m=64,t=1,p=2$c2FsdHNhbHQ$a2V5a2V5")
+	if !ok || p.memory != 64 || p.time != 1 || p.threads != 2 || string(p.salt) != "saltsalt" || string(p.key) != "keykey" {
+		t.Fatalf("%+v %v", p, ok)
+	}
+	if p.String() != "$argon2id$v=19$m=64,t=1,p=2$c2FsdHNhbHQ$a2V5a2V5" {
+		t.Fatal(p.String())
+	}
+	for _, hash := range []string{
+		"",
+		"$argon2i$v=19$m=64,t=1,p=2$c2FsdHNhbHQ$a2V5a2V5",
+		"$argon2id$v=16$m=64,t=1,p=2$c2FsdHNhbHQ$a2V5a2V5",
+		"$argon2id$v=19$m=64,t=0,p=2$c2FsdHNhbHQ$a2V5a2V5",
+		"$argon2id$v=19$m=64,t=1,p=0$c2FsdHNhbHQ$a2V5a2V5",
+		"$argon2id$v=19$m=64,t=1$c2FsdHNhbHQ$a2V5a2V5",
+		"$argon2id$v=19$m=64,t=1,p=2$c2FsdHNhbHQ$",
+		"$argon2id$v=19$m=64,t=1,p=2$!!$a2V5a2V5",
+		"$argon2id$v=19$m=64,t=1,p=2$c2FsdHNhbHQ$a2V5a2V5$",
+	} {
+		_, ok := parseArgon2idHash(hash)
+		if ok {
+			t.Errorf("parsed %q", hash)
+		}
+	}
+}
+
+func TestCustomPasswordHasher(t *testing.T) {
+	db := NewAuthDB("correct horse", "")
+	db.PasswordHasher = plainHasher{}
+	user := registerUser(t, db)
+	db.lock.RLock()
+	hash := db.Users[user.UserID].PasswordHash
+	db.lock.RUnlock()
+	if hash != "$plain$battery staple" {
+		t.Fatalf("hash %q", hash)
+	}
+	_, err := db.Login(user.UserID, "wrong password")
+	if err != ErrInvalidPassword {
+		t.Fatalf("wrong password: %v", err)
+	}
+	// The admin's hash was made by the default hasher before the custom one was set.
+	_, err = db.Login("admin", "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.lock.RLock()
+	hash = db.Users["admin"].PasswordHash
+	db.lock.RUnlock()
+	if hash != "$plain$correct horse" {
+		t.Fatalf("admin hash was not upgraded: %q", hash)
+	}
+}
+
+func TestPasswordRehashOnLogin(t *testing.T) {
+	db := NewAuthDB("correct horse", "")
+	db.PasswordHasher = &BcryptHasher{Cost: bcrypt.MinCost}
+	user := registerUser(t, db)
+	db.PasswordHasher = fastArgon2id
+	_, err := db.Login(user.UserID, "battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.lock.RLock()
+	hash := db.Users[user.UserID].PasswordHash
+	db.lock.RUnlock()
+	if !strings.HasPrefix(hash, "$argon2id$") || fastArgon2id.NeedsRehash(hash) {
+		t.Fatalf("hash was not upgraded: %q", hash)
+	}
+	_, err = db.Login(user.UserID, "battery staple")
+	if err != nil {
+		t.Fatalf("login with upgraded hash: %v", err)
+	}
+}
@@ -0,0 +1,83 @@
+package web
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// PasswordPolicy is what the passwords that users choose must be like.
+// The zero PasswordPolicy only limits the length of passwords to between
+// DefaultMinPasswordLength and DefaultMaxPasswordLength.
+type PasswordPolicy struct {
+	// MinLength is the least number of characters in a password.
+	// If it is zero, DefaultMinPasswordLength is used.
+	MinLength int `json:"min_length"`
+	// MaxLength is the most characters in a password.
+	// If it is zero, DefaultMaxPasswordLength is used.
+	MaxLength int `json:"max_length"`
+	// MinCharClasses is how many of lowercase letters, uppercase letters, digits and other characters
+	// a password must mix.
+	MinCharClasses int `json:"min_char_classes"`
+	// Forbidden are passwords that may not be used, such as the most common ones.
+	// They are compared without regard to case.
+	Forbidden []string `json:"forbidden"`
+}
+
+// DefaultMinPasswordLength is the shortest password allowed by a PasswordPolicy without a MinLength.
+const DefaultMinPasswordLength = 8
+
+// DefaultMaxPasswordLength is the longest password allowed by a PasswordPolicy without a MaxLength.
+const DefaultMaxPasswordLength = 256
+
+// Check returns an error if password does not follow the policy.
+// If it returns an error, it will be of type ErrPasswordTooShort, ErrPasswordTooLong or ErrPasswordTooWeak.
+func (p *PasswordPolicy) Check(password string) error {
+	length := utf8.RuneCountInString(password)
+	minLength := p.MinLength
+	if minLength == 0 {
+		minLength = DefaultMinPasswordLength
+	}
+	if length < minLength {
+		return ErrPasswordTooShort
+	}
+	maxLength := p.MaxLength
+	if maxLength == 0 {
+		maxLength = DefaultMaxPasswordLength
+	}
+	if length > maxLength {
+		return ErrPasswordTooLong
+	}
+	if charClasses(password) < p.MinCharClasses {
+		return ErrPasswordTooWeak
+	}
+	for _, forbidden := range p.Forbidden {
+		if strings.EqualFold(password, forbidden) {
+			return ErrPasswordTooWeak
+		}
+	}
+	return nil
+}
+
+// charClasses returns how many of lowercase letters, uppercase letters, digits and other characters s has.
+func charClasses(s string) int {
+	var lower, upper, digit, other int
+	for _, r := range s {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			other = 1
+		}
+	}
+	return lower + upper + digit + other
+}
+
+// isPasswordPolicyError returns true if err is one of the errors returned by PasswordPolicy.Check.
+func isPasswordPolicyError(err error) bool {
+	return err == ErrPasswordTooShort || err == ErrPasswordTooLong || err == ErrPasswordTooWeak
+}
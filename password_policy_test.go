@@ -0,0 +1,26 @@
+package web
+
+import "testing"
+
+func TestPasswordPolicy(t *testing.T) {
+	tests := []struct {
+		policy   PasswordPolicy
+		password string
+		want     error
+	}{
+		{PasswordPolicy{}, "", ErrPasswordTooShort},
+		{PasswordPolicy{}, "1234567", ErrPasswordTooShort},
+		{PasswordPolicy{}, "12345678", nil},
+		{PasswordPolicy{MinLength: 4}, "1234", nil},
+		{PasswordPolicy{MaxLength: 10}, "12345678901", ErrPasswordTooLong},
+		{PasswordPolicy{MinCharClasses: 3}, "abcdefgh1", ErrPasswordTooWeak},
+		{PasswordPolicy{MinCharClasses: 3}, "abcdefgH1", nil},
+		{PasswordPolicy{Forbidden: []string{"password"}}, "PassWord", ErrPasswordTooWeak},
+	}
+	for _, test := range tests {
+		err := test.policy.Check(test.password)
+		if err != test.want {
+			t.Errorf("%+v.Check(%q) = %v, want %v", test.policy, test.password, err, test.want)
+		}
+	}
+}
@@ -2,34 +2,238 @@ package web
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
+	"net"
 	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"golang.org/x/crypto/acme/autocert"
 )
 
+// Platform serves many apps, each at its own hosts, over HTTPS with certificates from Let's Encrypt.
+// It also listens for plain HTTP, where it answers ACME HTTP-01 challenges and redirects everything else to HTTPS.
+// Hosts are routed by Apps and by the config file at ConfigPath, which is watched and reloaded when it changes.
+// Requests for AdminHost are served the admin API, which changes the routing table at runtime:
+//   - GET /hosts lists the hosts and their apps
+//   - PUT /hosts/{host} with a HostConfig adds or changes a host
+//   - DELETE /hosts/{host} removes a host
+//   - POST /hosts/{host}/disable and /hosts/{host}/enable stop and resume serving a host
+//
+// The admin API takes a session or API key of an admin of AuthDB.
+//
+// Signals received on Signals control the Platform: SIGINT and SIGTERM shut it down gracefully, and a second
+// one cuts off the requests still in flight. SIGHUP restarts the Platform without dropping connections.
+// To handle the signals of the process, pass a channel that signal.Notify delivers them to.
+//
+// In dev mode, the Platform runs without network access to Let's Encrypt: certificates for its hosts are
+// issued by a local CA kept in CertDir, and the plain HTTP listener serves /{host}/... as requests for host
+// instead of redirecting, so the same Apps can be tried on a laptop or in integration tests.
 type Platform struct {
 	LetsEncryptEmail string
 	CertDir          string
-	Apps             map[string]http.Handler
-	CmdURL           string
+	// Apps are served at their hosts, unless the config routes those hosts elsewhere.
+	Apps   map[string]http.Handler
+	CmdURL string
+	// Handlers are the apps that the config can route hosts to, keyed by app name.
+	Handlers map[string]http.Handler
+	// ConfigPath is the JSON file holding the PlatformConfig.
+	// If it is empty, only Apps are served, and changes made through the admin API are not saved.
+	ConfigPath string
+	// ConfigPollInterval is how often the config file is checked for changes.
+	// If it is zero, DefaultConfigPollInterval is used.
+	ConfigPollInterval time.Duration
+	// OnConfigError is called when the config file changes but cannot be loaded.
+	// The routing table in use is kept.
+	OnConfigError func(err error)
+	// AdminHost is the host that serves the admin API, such as "platform.example.com".
+	// If it is empty, there is no admin API.
+	AdminHost string
+	// AuthDB authenticates requests to the admin API.
+	AuthDB *AuthDB
+	// HTTPAddr is the address of the plain HTTP listener. If it is empty, DefaultHTTPAddr is used.
+	HTTPAddr string
+	// HTTPSAddr is the address of the HTTPS listener. If it is empty, DefaultHTTPSAddr is used.
+	HTTPSAddr string
+	// HSTSMaxAge is the max-age of the Strict-Transport-Security header sent over HTTPS.
+	// If it is zero, the header is not sent.
+	HSTSMaxAge time.Duration
+	// HSTSIncludeSubdomains adds includeSubDomains to the Strict-Transport-Security header.
+	HSTSIncludeSubdomains bool
+	// DrainTimeout is how long requests in flight get to finish when the Platform is shut down by a signal
+	// or restarted. If it is zero, DefaultDrainTimeout is used.
+	DrainTimeout time.Duration
+	// RestartTimeout is how long Restart waits for the new process to start serving.
+	// If it is zero, DefaultRestartTimeout is used.
+	RestartTimeout time.Duration
+	// OnRestartError is called when a restart on SIGHUP fails. The Platform keeps serving.
+	OnRestartError func(err error)
+	// Dev turns on dev mode.
+	Dev bool
+	// Signals are the signals the Platform acts on while it is running.
+	// If it is nil, the Platform is only stopped and restarted by calling its methods.
+	Signals <-chan os.Signal
+	// HTTPSListener and HTTPListener, if set, are served instead of listening on HTTPSAddr and HTTPAddr.
+	// Start takes them over and closes them when it returns.
+	HTTPSListener net.Listener
+	HTTPListener  net.Listener
+
+	// routes is the routing table in use.
+	routes atomic.Pointer[platformRoutes]
+	// configLock serializes loading and changing the config.
+	configLock sync.Mutex
+	// configStamp is the version of the config file that was last loaded.
+	configStamp configStamp
+	// connections counts the requests in flight.
+	connections Counter
+	// serverLock guards server.
+	serverLock sync.Mutex
+	// server is what the Platform serves with while it is running.
+	server *platformServer
+	// startProcess starts the new process of a restart. If it is nil, execProcess is used.
+	startProcess func(files []*os.File) (restartedProcess, error)
 }
 
+// Start loads the config, starts the health checks of ProxyApps and serves HTTPS and plain HTTP
+// until the Platform is shut down or either listener fails.
+// After a graceful shutdown it returns nil, or the error of the shutdown if requests were cut off.
 func (p *Platform) Start() error {
+	if p.ConfigPath != "" {
+		err := p.LoadConfig()
+		if err != nil {
+			return err
+		}
+	}
+	tlsConfig, httpHandler, err := p.listenerConfig()
+	if err != nil {
+		return err
+	}
+	httpsListener, httpListener, err := p.listen()
+	if err != nil {
+		return err
+	}
+	ctx, stop := context.WithCancel(context.Background())
+	p.startHealthChecks(ctx)
+	if p.ConfigPath != "" {
+		go p.watchConfig(ctx)
+	}
+	forced, force := context.WithCancel(context.Background())
+	defer force()
+	s := &platformServer{
+		https:         &http.Server{Handler: p},
+		http:          &http.Server{Handler: httpHandler},
+		httpsListener: httpsListener,
+		httpListener:  httpListener,
+		stop:          stop,
+		forced:        forced,
+		force:         force,
+		done:          make(chan struct{}),
+	}
+	p.serverLock.Lock()
+	p.server = s
+	p.serverLock.Unlock()
+	errs := make(chan error, 2)
+	go func() {
+		errs <- s.https.Serve(tls.NewListener(httpsListener, tlsConfig))
+	}()
+	go func() {
+		errs <- s.http.Serve(httpListener)
+	}()
+	signalReady()
+	draining := false
+	for {
+		select {
+		case <-s.done:
+			return s.err
+		case err := <-errs:
+			if err == http.ErrServerClosed {
+				// A shutdown is under way. Keep handling signals until it is done, so that it can be forced.
+				continue
+			}
+			// One listener failed, so stop the other one too.
+			ctx, cancel := context.WithTimeout(context.Background(), p.drainTimeout())
+			p.shutdown(ctx, s)
+			cancel()
+			return err
+		case sig := <-p.Signals:
+			if sig == syscall.SIGHUP {
+				go p.restart()
+				continue
+			}
+			if draining {
+				// Asked again, so stop waiting for the requests in flight.
+				s.force()
+				continue
+			}
+			draining = true
+			go p.drain()
+		}
+	}
+}
+
+// listenerConfig returns the TLS config of the HTTPS listener and the handler of the plain HTTP listener.
+func (p *Platform) listenerConfig() (*tls.Config, http.Handler, error) {
+	if p.Dev {
+		ca, err := loadDevCA(p.CertDir, p.serves)
+		if err != nil {
+			return nil, nil, err
+		}
+		return ca.TLSConfig(), http.HandlerFunc(p.serveDevHTTP), nil
+	}
 	manager := p.certManager()
-	l := manager.Listener()
-	return http.Serve(l, p)
+	return manager.TLSConfig(), p.httpHandler(manager), nil
+}
+
+// httpHandler returns the handler of the plain HTTP listener.
+func (p *Platform) httpHandler(manager *autocert.Manager) http.Handler {
+	return manager.HTTPHandler(&HTTPSRedirect{Port: addrPort(p.httpsAddr())})
 }
 
-func (p *Platform) certManager() autocert.Manager {
-	return autocert.Manager{
+// serveDevHTTP serves plain HTTP in dev mode, taking the host from the first element of the path.
+func (p *Platform) serveDevHTTP(w http.ResponseWriter, r *http.Request) {
+	if !servePathHost(w, r, p.ServeHTTP) {
+		http.NotFound(w, r)
+	}
+}
+
+func (p *Platform) httpAddr() string {
+	if p.HTTPAddr == "" {
+		return DefaultHTTPAddr
+	}
+	return p.HTTPAddr
+}
+
+func (p *Platform) httpsAddr() string {
+	if p.HTTPSAddr == "" {
+		return DefaultHTTPSAddr
+	}
+	return p.HTTPSAddr
+}
+
+// startHealthChecks starts the health checks of the ProxyApps in Apps and Handlers.
+func (p *Platform) startHealthChecks(ctx context.Context) {
+	started := map[*ProxyApp]bool{}
+	for _, apps := range []map[string]http.Handler{p.Apps, p.Handlers} {
+		for _, app := range apps {
+			if proxy, ok := app.(*ProxyApp); ok && !started[proxy] {
+				proxy.StartHealthChecks(ctx)
+				started[proxy] = true
+			}
+		}
+	}
+}
+
+func (p *Platform) certManager() *autocert.Manager {
+	return &autocert.Manager{
 		Prompt: autocert.AcceptTOS,
 		Cache:  autocert.DirCache(p.CertDir),
 		HostPolicy: func(_ context.Context, host string) error {
-			for h := range p.Apps {
-				if h == host {
-					return nil
-				}
+			if p.serves(host) {
+				return nil
 			}
 			return fmt.Errorf("host %q not allowed", host)
 		},
@@ -37,9 +241,34 @@ func (p *Platform) certManager() autocert.Manager {
 	}
 }
 
+// serves returns true if requests for host are served, so that it may get a certificate.
+func (p *Platform) serves(host string) bool {
+	host = hostName(host)
+	if p.AdminHost != "" && host == p.AdminHost {
+		return true
+	}
+	_, ok := p.routeTable().hosts[host]
+	return ok
+}
+
 func (p *Platform) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	host, ok := p.Apps[r.Host]
+	p.connections.Inc()
+	defer p.connections.Dec()
+	writeHSTS(w, r, p.HSTSMaxAge, p.HSTSIncludeSubdomains)
+	// Hosts are configured without ports, but browsers send them when they are not the default,
+	// such as to the ports of Platform.Dev.
+	name := hostName(r.Host)
+	if p.AdminHost != "" && name == p.AdminHost {
+		p.admin().ServeHTTP(w, r)
+		return
+	}
+	routes := p.routeTable()
+	host, ok := routes.hosts[name]
 	if !ok {
+		if routes.disabled[name] {
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
 		http.NotFound(w, r)
 		return
 	}
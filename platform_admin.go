@@ -0,0 +1,69 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// admin returns the handler of the admin API.
+func (p *Platform) admin() http.Handler {
+	if p.AuthDB == nil {
+		return http.NotFoundHandler()
+	}
+	return &AuthMiddleware{
+		DB:       p.AuthDB,
+		Required: true,
+		Handler:  http.HandlerFunc(p.serveAdmin),
+	}
+}
+
+// serveAdmin serves the admin API to admins of AuthDB.
+func (p *Platform) serveAdmin(w http.ResponseWriter, r *http.Request) {
+	if !CheckCSRF(r) || !p.AuthDB.HasRole(UserIDFromContext(r.Context()), RoleAdmin) {
+		ServeForbidden(w, r)
+		return
+	}
+	path := ParsePath(r.URL.Path)
+	if len(path) == 0 || path[0] != "hosts" {
+		ServeNotFound(w, r)
+		return
+	}
+	if len(path) == 1 {
+		if r.Method != http.MethodGet {
+			ServeMethodNotAllowed(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(p.Hosts())
+		return
+	}
+	host := path[1]
+	var err error
+	switch {
+	case len(path) == 2 && r.Method == http.MethodPut:
+		var h HostConfig
+		err = json.NewDecoder(r.Body).Decode(&h)
+		if err != nil {
+			ServeBadRequest(w, r)
+			return
+		}
+		err = p.SetHost(host, h)
+	case len(path) == 2 && r.Method == http.MethodDelete:
+		err = p.RemoveHost(host)
+	case len(path) == 3 && path[2] == "disable" && r.Method == http.MethodPost:
+		err = p.SetHostDisabled(host, true)
+	case len(path) == 3 && path[2] == "enable" && r.Method == http.MethodPost:
+		err = p.SetHostDisabled(host, false)
+	default:
+		ServeMethodNotAllowed(w, r)
+		return
+	}
+	switch err {
+	case nil:
+	case ErrHostNotFound:
+		writeError(w, http.StatusNotFound, err)
+	case ErrUnknownApp:
+		writeError(w, http.StatusBadRequest, err)
+	default:
+		ServeInternalServerError(w, r)
+	}
+}
@@ -0,0 +1,85 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// PlatformConfig is the routing table of a Platform as kept in its config file.
+type PlatformConfig struct {
+	// Hosts route host names to apps.
+	Hosts map[string]*HostConfig `json:"hosts"`
+}
+
+// HostConfig routes a host to an app of a Platform.
+type HostConfig struct {
+	// App is the name of the app that serves the host.
+	// It names a handler in Platform.Handlers, or else one in Platform.Apps by its host.
+	App string `json:"app"`
+	// Disabled hosts are answered with 503 Service Unavailable and get no new certificates.
+	Disabled bool `json:"disabled"`
+}
+
+// ReadPlatformConfig reads a PlatformConfig from the JSON file at path.
+// Hosts set to null are an error rather than routes to nowhere.
+func ReadPlatformConfig(path string) (*PlatformConfig, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var c PlatformConfig
+	err = json.Unmarshal(b, &c)
+	if err != nil {
+		return nil, err
+	}
+	if c.Hosts == nil {
+		c.Hosts = map[string]*HostConfig{}
+	}
+	for host, h := range c.Hosts {
+		if h == nil {
+			return nil, fmt.Errorf("%s: host %q has no config", path, host)
+		}
+	}
+	return &c, nil
+}
+
+// WriteFile writes the config to the JSON file at path.
+// The file is replaced whole, so that a Platform watching it never reads half of it.
+func (c *PlatformConfig) WriteFile(path string) error {
+	b, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(append(b, '\n'))
+	if err != nil {
+		f.Close()
+		return err
+	}
+	err = f.Sync()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// clone returns a deep copy of the config.
+func (c *PlatformConfig) clone() *PlatformConfig {
+	hosts := map[string]*HostConfig{}
+	for host, h := range c.Hosts {
+		copied := *h
+		hosts[host] = &copied
+	}
+	return &PlatformConfig{Hosts: hosts}
+}
@@ -0,0 +1,299 @@
+package web
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// namedApp answers with its name.
+func namedApp(name string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, name)
+	})
+}
+
+// replaceFile replaces the file at path with content whole, as editors and deploy tools should.
+func replaceFile(t *testing.T, path, content string) {
+	tmp := path + ".tmp"
+	err := os.WriteFile(tmp, []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Rename(tmp, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newConfigPlatform returns a Platform routed by the config file in a temporary directory.
+func newConfigPlatform(t *testing.T) *Platform {
+	return &Platform{
+		Apps:       map[string]http.Handler{"static.test": namedApp("static")},
+		Handlers:   map[string]http.Handler{"alpha": namedApp("alpha"), "beta": namedApp("beta")},
+		ConfigPath: filepath.Join(t.TempDir(), "platform.json"),
+	}
+}
+
+// serveRequest serves r with h and returns the status and the body of the response.
+func serveRequest(h http.Handler, r *http.Request) (int, string) {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w.Code, w.Body.String()
+}
+
+// getHost makes a GET request to h for host.
+func getHost(h http.Handler, host string) (int, string) {
+	return serveRequest(h, httptest.NewRequest("GET", "http://"+host+"/", nil))
+}
+
+func TestReadPlatformConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "platform.json")
+	replaceFile(t, path, `{}`)
+	c, err := ReadPlatformConfig(path)
+	if err != nil || c.Hosts == nil || len(c.Hosts) != 0 {
+		t.Fatalf("%+v %v", c, err)
+	}
+	replaceFile(t, path, `{"hosts": {"a.test": {"app": "alpha"}, "b.test": null}}`)
+	_, err = ReadPlatformConfig(path)
+	if err == nil || !strings.Contains(err.Error(), `"b.test"`) {
+		t.Fatalf("null host: %v", err)
+	}
+	replaceFile(t, path, `{"hosts": `)
+	_, err = ReadPlatformConfig(path)
+	if err == nil {
+		t.Fatal("read a half-written file")
+	}
+}
+
+func TestPlatformConfigReload(t *testing.T) {
+	p := newConfigPlatform(t)
+	err := p.LoadConfig()
+	if err != nil {
+		t.Fatalf("missing file: %v", err)
+	}
+	if code, body := getHost(p, "static.test"); code != http.StatusOK || body != "static" {
+		t.Fatalf("%d %q", code, body)
+	}
+	replaceFile(t, p.ConfigPath, `{"hosts": {"a.test": {"app": "alpha"}}}`)
+	err = p.reloadChangedConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, body := getHost(p, "a.test"); body != "alpha" {
+		t.Fatalf("a.test: %q", body)
+	}
+	replaceFile(t, p.ConfigPath, `{"hosts": {"a.test": {"app": "beta"}, "static.test": {"app": "alpha"}}}`)
+	err = p.reloadChangedConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, body := getHost(p, "a.test"); body != "beta" {
+		t.Fatalf("a.test after the change: %q", body)
+	}
+	if _, body := getHost(p, "static.test"); body != "alpha" {
+		t.Fatalf("static.test routed by the config: %q", body)
+	}
+
+	for _, broken := range []string{
+		`{"hosts": {"a.test": {"app": "alph`,
+		`{"hosts": {"a.test": {"app": "gamma"}}}`,
+		`{"hosts": {"a.test": null}}`,
+	} {
+		replaceFile(t, p.ConfigPath, broken)
+		err = p.reloadChangedConfig()
+		if err == nil {
+			t.Fatalf("loaded %s", broken)
+		}
+		if _, body := getHost(p, "a.test"); body != "beta" {
+			t.Fatalf("routing table changed by %s: %q", broken, body)
+		}
+		// A broken file is reported once, not on every poll.
+		err = p.reloadChangedConfig()
+		if err != nil {
+			t.Fatalf("%s reported again: %v", broken, err)
+		}
+	}
+
+	err = os.Remove(p.ConfigPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.reloadChangedConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code, _ := getHost(p, "a.test"); code != http.StatusNotFound {
+		t.Fatalf("a.test after the file was removed: %d", code)
+	}
+	if _, body := getHost(p, "static.test"); body != "static" {
+		t.Fatalf("static.test after the file was removed: %q", body)
+	}
+}
+
+func TestPlatformHostChangesAreSaved(t *testing.T) {
+	p := newConfigPlatform(t)
+	err := p.LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.SetHost("a.test", HostConfig{App: "alpha"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.SetHost("b.test", HostConfig{App: "beta"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.SetHost("c.test", HostConfig{App: "gamma"})
+	if err != ErrUnknownApp {
+		t.Fatalf("unknown app: %v", err)
+	}
+	err = p.SetHostDisabled("b.test", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.SetHostDisabled("static.test", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.SetHostDisabled("c.test", true)
+	if err != ErrHostNotFound {
+		t.Fatalf("disable unknown host: %v", err)
+	}
+	err = p.SetHost("d.test", HostConfig{App: "alpha"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.RemoveHost("d.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.RemoveHost("d.test")
+	if err != ErrHostNotFound {
+		t.Fatalf("remove twice: %v", err)
+	}
+	if code, _ := getHost(p, "b.test"); code != http.StatusServiceUnavailable {
+		t.Fatalf("disabled host: %d", code)
+	}
+	if code, _ := getHost(p, "static.test"); code != http.StatusServiceUnavailable {
+		t.Fatalf("disabled app host: %d", code)
+	}
+
+	// A Platform started from the saved file routes the same way.
+	restarted := &Platform{Apps: p.Apps, Handlers: p.Handlers, ConfigPath: p.ConfigPath}
+	err = restarted.LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]HostConfig{
+		"a.test":      {App: "alpha"},
+		"b.test":      {App: "beta", Disabled: true},
+		"static.test": {App: "static.test", Disabled: true},
+	}
+	hosts := restarted.Hosts()
+	if len(hosts) != len(want) {
+		t.Fatalf("%+v", hosts)
+	}
+	for host, h := range want {
+		if hosts[host] != h {
+			t.Fatalf("%s: %+v", host, hosts[host])
+		}
+	}
+	// Changes do not count as changes to reload.
+	err = p.reloadChangedConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = restarted.SetHostDisabled("b.test", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.reloadChangedConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, body := getHost(p, "b.test"); body != "beta" {
+		t.Fatalf("b.test enabled by another Platform: %q", body)
+	}
+}
+
+func TestPlatformAdminAPI(t *testing.T) {
+	db := NewAuthDB("correct horse", "")
+	p := newConfigPlatform(t)
+	p.AdminHost = "admin.test"
+	p.AuthDB = db
+	err := p.LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	adminToken, err := db.Login("admin", "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin := &Session{UserID: "admin", Token: adminToken}
+	member := registerUser(t, db)
+	request := func(method, path, body string, s *Session) *http.Request {
+		r := httptest.NewRequest(method, "http://admin.test"+path, strings.NewReader(body))
+		if s != nil {
+			r.Header.Set("Authorization", "Bearer "+s.Credential())
+		}
+		return r
+	}
+
+	if code, _ := serveRequest(p, request("GET", "/hosts", "", nil)); code != http.StatusUnauthorized {
+		t.Fatalf("without a session: %d", code)
+	}
+	if code, _ := serveRequest(p, request("PUT", "/hosts/a.test", `{"app":"alpha"}`, member)); code != http.StatusForbidden {
+		t.Fatalf("member: %d", code)
+	}
+	code, body := serveRequest(p, request("GET", "/hosts", "", admin))
+	if code != http.StatusOK || !strings.Contains(body, `"static.test"`) {
+		t.Fatalf("list: %d %s", code, body)
+	}
+	if code, _ := serveRequest(p, request("PUT", "/hosts/a.test", `{"app":"alpha"}`, admin)); code != http.StatusOK {
+		t.Fatalf("put: %d", code)
+	}
+	if code, _ := serveRequest(p, request("PUT", "/hosts/b.test", `{"app":"gamma"}`, admin)); code != http.StatusBadRequest {
+		t.Fatalf("put unknown app: %d", code)
+	}
+	if code, _ := serveRequest(p, request("POST", "/hosts/b.test/disable", "", admin)); code != http.StatusNotFound {
+		t.Fatalf("disable unknown host: %d", code)
+	}
+
+	// A browser session must carry the CSRF token.
+	cookieRequest := func(method, path, token string) *http.Request {
+		r := httptest.NewRequest(method, "http://admin.test"+path, nil)
+		r.AddCookie(&http.Cookie{Name: DefaultSessionCookieName, Value: admin.Credential()})
+		r.AddCookie(&http.Cookie{Name: CSRFCookieName, Value: "csrf-token"})
+		if token != "" {
+			r.Header.Set(CSRFHeaderName, token)
+		}
+		return r
+	}
+	for _, token := range []string{"", "forged"} {
+		if code, _ := serveRequest(p, cookieRequest("POST", "/hosts/a.test/disable", token)); code != http.StatusForbidden {
+			t.Fatalf("disable with CSRF token %q: %d", token, code)
+		}
+	}
+	if _, body := getHost(p, "a.test"); body != "alpha" {
+		t.Fatalf("forged request disabled a.test: %q", body)
+	}
+	if code, _ := serveRequest(p, cookieRequest("POST", "/hosts/a.test/disable", "csrf-token")); code != http.StatusOK {
+		t.Fatalf("disable with the CSRF token: %d", code)
+	}
+	if code, _ := getHost(p, "a.test"); code != http.StatusServiceUnavailable {
+		t.Fatalf("disabled a.test: %d", code)
+	}
+	if code, _ := serveRequest(p, request("DELETE", "/hosts/a.test", "", admin)); code != http.StatusOK {
+		t.Fatalf("delete: %d", code)
+	}
+	saved, err := ReadPlatformConfig(p.ConfigPath)
+	if err != nil || len(saved.Hosts) != 0 {
+		t.Fatalf("%+v %v", saved, err)
+	}
+}
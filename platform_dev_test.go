@@ -0,0 +1,165 @@
+package web
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// startPlatform starts p in the background and waits until it is serving.
+// The returned channel receives the error returned by Start.
+func startPlatform(t *testing.T, p *Platform) chan error {
+	done := make(chan error, 1)
+	go func() {
+		done <- p.Start()
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for p.running() == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("platform did not start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return done
+}
+
+// echoHost answers with the host and path of the request.
+var echoHost = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	io.WriteString(w, r.Host+" "+r.URL.Path)
+})
+
+func getBody(t *testing.T, client *http.Client, u string) (int, string) {
+	res, err := client.Get(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res.StatusCode, string(b)
+}
+
+func TestPlatformDevMode(t *testing.T) {
+	dir := t.TempDir()
+	p := &Platform{
+		Dev:       true,
+		CertDir:   dir,
+		HTTPSAddr: "127.0.0.1:0",
+		HTTPAddr:  "127.0.0.1:0",
+		Apps:      map[string]http.Handler{"app.test": echoHost},
+	}
+	done := startPlatform(t, p)
+	httpsAddr := p.running().httpsListener.Addr().String()
+	httpAddr := p.running().httpListener.Addr().String()
+	_, httpsPort, _ := net.SplitHostPort(httpsAddr)
+
+	caPEM, err := os.ReadFile(filepath.Join(dir, DevCACertFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(filepath.Join(dir, DevCAKeyFile))
+	if err != nil || fi.Mode().Perm() != 0600 {
+		t.Fatalf("CA key: %v %v", fi, err)
+	}
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(caPEM)
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{RootCAs: roots},
+		DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, httpsAddr)
+		},
+	}}
+	// Browsers send the port in the Host header, as the dev ports are not the default ones.
+	code, body := getBody(t, client, "https://app.test:"+httpsPort+"/x")
+	if code != http.StatusOK || body != "app.test:"+httpsPort+" /x" {
+		t.Fatalf("%d %q", code, body)
+	}
+	_, err = client.Get("https://other.test:" + httpsPort + "/x")
+	if err == nil {
+		t.Fatal("got a certificate for a host that is not served")
+	}
+
+	code, body = getBody(t, http.DefaultClient, "http://"+httpAddr+"/app.test/y?z=1")
+	if code != http.StatusOK || body != "app.test /y" {
+		t.Fatalf("%d %q", code, body)
+	}
+	code, _ = getBody(t, http.DefaultClient, "http://"+httpAddr+"/")
+	if code != http.StatusNotFound {
+		t.Fatal(code)
+	}
+
+	err = p.Shutdown(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = <-done
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := loadDevCA(dir, p.serves)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, _ := os.ReadFile(filepath.Join(dir, DevCACertFile))
+	if string(again) != string(caPEM) {
+		t.Fatal("CA was created again")
+	}
+	cert, err := ca.getCertificate(&tls.ClientHelloInfo{ServerName: "app.test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = leaf.Verify(x509.VerifyOptions{Roots: roots, DNSName: "app.test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPlatformServesHostsWithPorts(t *testing.T) {
+	p := &Platform{
+		AdminHost: "admin.test",
+		Apps:      map[string]http.Handler{"app.test": echoHost},
+	}
+	for host, want := range map[string]bool{
+		"app.test":        true,
+		"app.test:8443":   true,
+		"admin.test:8443": true,
+		"other.test:8443": false,
+	} {
+		if p.serves(host) != want {
+			t.Errorf("serves(%q) = %v", host, !want)
+		}
+	}
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest("GET", "http://app.test:8443/x", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "app.test:8443 /x" {
+		t.Fatalf("%d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestDevServer(t *testing.T) {
+	d := &DevServer{Apps: map[string]http.Handler{"app.test": echoHost}}
+	w := httptest.NewRecorder()
+	d.ServeHTTP(w, httptest.NewRequest("GET", "http://localhost:8080/app.test/q", nil))
+	if w.Body.String() != "app.test /q" {
+		t.Fatal(w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	d.ServeHTTP(w, httptest.NewRequest("GET", "http://localhost:8080/other.test/q", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatal(w.Code)
+	}
+}
@@ -0,0 +1,250 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"time"
+)
+
+// DefaultConfigPollInterval is how often a Platform checks its config file for changes when ConfigPollInterval is zero.
+const DefaultConfigPollInterval = 2 * time.Second
+
+// platformRoutes is a routing table of a Platform.
+// Tables are never changed once they are in use; a new one replaces them whole.
+type platformRoutes struct {
+	// config is the config the table was built from.
+	config *PlatformConfig
+	// hosts are the handlers of the hosts that are served.
+	hosts map[string]http.Handler
+	// disabled are the hosts that are known but not served.
+	disabled map[string]bool
+}
+
+// buildRoutes returns the routing table for config.
+// Hosts in Apps are served as they are unless config routes them.
+// If it returns an error, it will be of type ErrUnknownApp.
+func (p *Platform) buildRoutes(config *PlatformConfig) (*platformRoutes, error) {
+	routes := &platformRoutes{
+		config:   config,
+		hosts:    map[string]http.Handler{},
+		disabled: map[string]bool{},
+	}
+	for host, app := range p.Apps {
+		routes.hosts[host] = app
+	}
+	for host, h := range config.Hosts {
+		app, ok := p.app(h.App)
+		if !ok {
+			return nil, ErrUnknownApp
+		}
+		if h.Disabled {
+			delete(routes.hosts, host)
+			routes.disabled[host] = true
+			continue
+		}
+		routes.hosts[host] = app
+	}
+	return routes, nil
+}
+
+// app returns the app with the given name.
+func (p *Platform) app(name string) (http.Handler, bool) {
+	if app, ok := p.Handlers[name]; ok {
+		return app, true
+	}
+	app, ok := p.Apps[name]
+	return app, ok
+}
+
+// routeTable returns the routing table in use.
+// Before a config is loaded, it only serves Apps.
+func (p *Platform) routeTable() *platformRoutes {
+	routes := p.routes.Load()
+	if routes != nil {
+		return routes
+	}
+	routes, _ = p.buildRoutes(&PlatformConfig{Hosts: map[string]*HostConfig{}})
+	p.routes.CompareAndSwap(nil, routes)
+	return p.routes.Load()
+}
+
+// LoadConfig reads the config file at ConfigPath and starts routing by it.
+// A missing file counts as an empty config. If the config is invalid, the routing table in use is kept.
+func (p *Platform) LoadConfig() error {
+	p.configLock.Lock()
+	defer p.configLock.Unlock()
+	return p.loadConfig()
+}
+
+// loadConfig is like LoadConfig.
+// The caller must hold p.configLock.
+func (p *Platform) loadConfig() error {
+	info, err := os.Stat(p.ConfigPath)
+	if os.IsNotExist(err) {
+		routes, err := p.buildRoutes(&PlatformConfig{Hosts: map[string]*HostConfig{}})
+		if err != nil {
+			return err
+		}
+		p.routes.Store(routes)
+		p.configStamp = configStamp{}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	config, err := ReadPlatformConfig(p.ConfigPath)
+	if err != nil {
+		return err
+	}
+	routes, err := p.buildRoutes(config)
+	if err != nil {
+		return err
+	}
+	p.routes.Store(routes)
+	p.configStamp = stampOf(info)
+	return nil
+}
+
+// watchConfig reloads the config file whenever it changes, until ctx is done.
+// Changes that cannot be loaded are reported to OnConfigError and leave the routing table as it was.
+func (p *Platform) watchConfig(ctx context.Context) {
+	ticker := time.NewTicker(p.configPollInterval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		err := p.reloadChangedConfig()
+		if err != nil && p.OnConfigError != nil {
+			p.OnConfigError(err)
+		}
+	}
+}
+
+// reloadChangedConfig loads the config file if it changed since it was last loaded.
+func (p *Platform) reloadChangedConfig() error {
+	p.configLock.Lock()
+	defer p.configLock.Unlock()
+	var stamp configStamp
+	info, err := os.Stat(p.ConfigPath)
+	if err == nil {
+		stamp = stampOf(info)
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	if stamp.equal(p.configStamp) {
+		return nil
+	}
+	err = p.loadConfig()
+	if err != nil {
+		// Remember the broken file so that it is reported once rather than on every poll.
+		p.configStamp = stamp
+	}
+	return err
+}
+
+// updateConfig applies change to a copy of the config in use, starts routing by it and saves it to ConfigPath.
+// If change or the new config fails, nothing changes.
+func (p *Platform) updateConfig(change func(config *PlatformConfig) error) error {
+	p.configLock.Lock()
+	defer p.configLock.Unlock()
+	config := p.routeTable().config.clone()
+	err := change(config)
+	if err != nil {
+		return err
+	}
+	routes, err := p.buildRoutes(config)
+	if err != nil {
+		return err
+	}
+	if p.ConfigPath != "" {
+		err = config.WriteFile(p.ConfigPath)
+		if err != nil {
+			return err
+		}
+		info, err := os.Stat(p.ConfigPath)
+		if err != nil {
+			return err
+		}
+		p.configStamp = stampOf(info)
+	}
+	p.routes.Store(routes)
+	return nil
+}
+
+// Hosts returns the routes of the config in use, keyed by host.
+// Hosts in Apps that the config does not route are listed as served by the app of that name.
+func (p *Platform) Hosts() map[string]HostConfig {
+	routes := p.routeTable()
+	hosts := map[string]HostConfig{}
+	for host := range p.Apps {
+		hosts[host] = HostConfig{App: host}
+	}
+	for host, h := range routes.config.Hosts {
+		hosts[host] = *h
+	}
+	return hosts
+}
+
+// SetHost routes host to the app named in h, adding the host if it is new.
+// If it returns an error, it may be of type ErrUnknownApp.
+func (p *Platform) SetHost(host string, h HostConfig) error {
+	return p.updateConfig(func(config *PlatformConfig) error {
+		config.Hosts[host] = &h
+		return nil
+	})
+}
+
+// RemoveHost stops serving host and removes it from the config.
+// Hosts in Apps cannot be removed, only disabled.
+// If it returns an error, it may be of type ErrHostNotFound.
+func (p *Platform) RemoveHost(host string) error {
+	return p.updateConfig(func(config *PlatformConfig) error {
+		if _, ok := config.Hosts[host]; !ok {
+			return ErrHostNotFound
+		}
+		delete(config.Hosts, host)
+		return nil
+	})
+}
+
+// SetHostDisabled stops or resumes serving host without forgetting its route.
+// If it returns an error, it may be of type ErrHostNotFound.
+func (p *Platform) SetHostDisabled(host string, disabled bool) error {
+	return p.updateConfig(func(config *PlatformConfig) error {
+		h, ok := config.Hosts[host]
+		if !ok {
+			if _, ok := p.Apps[host]; !ok {
+				return ErrHostNotFound
+			}
+			h = &HostConfig{App: host}
+			config.Hosts[host] = h
+		}
+		h.Disabled = disabled
+		return nil
+	})
+}
+
+// configStamp tells versions of the config file apart.
+type configStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+func stampOf(info os.FileInfo) configStamp {
+	return configStamp{modTime: info.ModTime(), size: info.Size()}
+}
+
+func (c configStamp) equal(other configStamp) bool {
+	return c.modTime.Equal(other.modTime) && c.size == other.size
+}
+
+func (p *Platform) configPollInterval() time.Duration {
+	if p.ConfigPollInterval == 0 {
+		return DefaultConfigPollInterval
+	}
+	return p.ConfigPollInterval
+}
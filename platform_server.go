@@ -0,0 +1,328 @@
+package web
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ListenFDsEnv is the environment variable that tells a Platform started by Restart that it inherited its listeners.
+// Its value is the number of inherited listeners, which start at file descriptor 3:
+// the HTTPS listener first, then the plain HTTP listener.
+const ListenFDsEnv = "PLATFORM_LISTEN_FDS"
+
+// ReadyFDEnv is the environment variable that tells a Platform started by Restart which inherited
+// file descriptor to write to once it is serving, so that the old process knows it can stop.
+const ReadyFDEnv = "PLATFORM_READY_FD"
+
+// DefaultDrainTimeout is how long requests in flight get to finish when DrainTimeout is zero.
+const DefaultDrainTimeout = 30 * time.Second
+
+// DefaultRestartTimeout is how long Restart waits for the new process when RestartTimeout is zero.
+const DefaultRestartTimeout = 30 * time.Second
+
+// platformServer is what a running Platform serves with.
+type platformServer struct {
+	https         *http.Server
+	http          *http.Server
+	httpsListener net.Listener
+	httpListener  net.Listener
+	// stop stops the config watcher and the health checks.
+	stop context.CancelFunc
+	// forced is done once draining must stop waiting for the requests in flight, and force makes it so.
+	forced context.Context
+	force  context.CancelFunc
+
+	shutdownOnce sync.Once
+	// done is closed when the shutdown has finished, leaving its result in err.
+	done chan struct{}
+	err  error
+}
+
+// running returns the server of the running Platform, or nil if it is not running.
+func (p *Platform) running() *platformServer {
+	p.serverLock.Lock()
+	defer p.serverLock.Unlock()
+	return p.server
+}
+
+// InFlight returns the number of requests being served, including upgraded connections such as WebSockets.
+func (p *Platform) InFlight() int {
+	return p.connections.Value()
+}
+
+// Shutdown stops the running Platform gracefully: it stops accepting connections, waits for the requests
+// in flight to finish and then makes Start return.
+// If ctx is done first, the remaining connections are closed and the error of ctx is returned.
+// It does nothing if the Platform is not running.
+func (p *Platform) Shutdown(ctx context.Context) error {
+	s := p.running()
+	if s == nil {
+		return nil
+	}
+	p.shutdown(ctx, s)
+	return s.err
+}
+
+// drain shuts the Platform down, giving the requests in flight DrainTimeout to finish,
+// or until the shutdown is forced.
+func (p *Platform) drain() error {
+	s := p.running()
+	if s == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(s.forced, p.drainTimeout())
+	defer cancel()
+	p.shutdown(ctx, s)
+	return s.err
+}
+
+// shutdown shuts s down once. Later calls wait for the first to finish.
+func (p *Platform) shutdown(ctx context.Context, s *platformServer) {
+	s.shutdownOnce.Do(func() {
+		defer close(s.done)
+		s.stop()
+		errs := make(chan error, 2)
+		go func() {
+			errs <- s.https.Shutdown(ctx)
+		}()
+		go func() {
+			errs <- s.http.Shutdown(ctx)
+		}()
+		for i := 0; i < 2; i++ {
+			err := <-errs
+			if err != nil && s.err == nil {
+				s.err = err
+			}
+		}
+		if s.err == nil {
+			// http.Server.Shutdown does not wait for hijacked connections, such as WebSockets passed on by a ProxyApp.
+			s.err = p.waitIdle(ctx)
+		}
+		if s.err != nil {
+			s.https.Close()
+			s.http.Close()
+		}
+		p.serverLock.Lock()
+		if p.server == s {
+			p.server = nil
+		}
+		p.serverLock.Unlock()
+	})
+}
+
+// waitIdle waits until no requests are in flight or ctx is done.
+func (p *Platform) waitIdle(ctx context.Context) error {
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for p.connections.Value() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// Restart upgrades the running Platform without dropping connections.
+// It starts the executable of the process again with the same arguments, handing it the listeners,
+// waits for the new process to start serving, and then shuts this Platform down gracefully while the
+// new process takes the new connections.
+// If the new process is not serving within RestartTimeout, it is killed and this Platform keeps serving.
+// Replace the executable before calling Restart to run a new version.
+func (p *Platform) Restart() error {
+	s := p.running()
+	if s == nil {
+		return ErrPlatformNotRunning
+	}
+	files := []*os.File{}
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for _, l := range []net.Listener{s.httpsListener, s.httpListener} {
+		fl, ok := l.(interface{ File() (*os.File, error) })
+		if !ok {
+			return fmt.Errorf("cannot hand off listener of type %T", l)
+		}
+		f, err := fl.File()
+		if err != nil {
+			return err
+		}
+		files = append(files, f)
+	}
+	ready, readyWriter, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer ready.Close()
+	files = append(files, readyWriter)
+	start := p.startProcess
+	if start == nil {
+		start = execProcess
+	}
+	process, err := start(files)
+	if err != nil {
+		return err
+	}
+	// Starting the process put the listeners in blocking mode, which would keep them from closing.
+	for _, f := range files[:2] {
+		syscall.SetNonblock(int(f.Fd()), true)
+	}
+	// Only the new process may hold the write end of ready, so that reading sees it exit.
+	for _, f := range files {
+		f.Close()
+	}
+	files = nil
+	err = waitReady(ready, p.restartTimeout())
+	if err != nil {
+		process.Kill()
+		process.Wait()
+		return err
+	}
+	process.Release()
+	go p.drain()
+	return nil
+}
+
+// restartedProcess is the new process started by Restart, such as an *os.Process.
+type restartedProcess interface {
+	Kill() error
+	Wait() (*os.ProcessState, error)
+	Release() error
+}
+
+// execProcess starts the executable of the process again with the same arguments,
+// handing it the listeners and the ready pipe in files from file descriptor 3 on.
+func execProcess(files []*os.File) (restartedProcess, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Env = append(os.Environ(),
+		ListenFDsEnv+"=2",
+		ReadyFDEnv+"="+strconv.Itoa(3+len(files)-1),
+	)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = files
+	err = cmd.Start()
+	if err != nil {
+		return nil, err
+	}
+	return cmd.Process, nil
+}
+
+// restart restarts the Platform on SIGHUP, reporting failures to OnRestartError.
+func (p *Platform) restart() {
+	err := p.Restart()
+	if err != nil && p.OnRestartError != nil {
+		p.OnRestartError(err)
+	}
+}
+
+// waitReady waits for the new process started by Restart to write to the other end of ready.
+func waitReady(ready *os.File, timeout time.Duration) error {
+	done := make(chan error, 1)
+	go func() {
+		_, err := ready.Read(make([]byte, 1))
+		done <- err
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("new process stopped before serving: %w", err)
+		}
+		return nil
+	case <-timer.C:
+		return fmt.Errorf("new process not serving after %v", timeout)
+	}
+}
+
+// signalReady tells the process that started this one by Restart that this one is serving.
+func signalReady() {
+	v, ok := os.LookupEnv(ReadyFDEnv)
+	if !ok {
+		return
+	}
+	os.Unsetenv(ReadyFDEnv)
+	fd, err := strconv.Atoi(v)
+	if err != nil {
+		return
+	}
+	f := os.NewFile(uintptr(fd), "ready")
+	f.Write([]byte{1})
+	f.Close()
+}
+
+// listen returns the HTTPS and plain HTTP listeners.
+// They are HTTPSListener and HTTPListener if those are set, inherited from the process that started this one
+// if ListenFDsEnv is set, and opened on HTTPSAddr and HTTPAddr otherwise.
+func (p *Platform) listen() (net.Listener, net.Listener, error) {
+	if p.HTTPSListener != nil && p.HTTPListener != nil {
+		return p.HTTPSListener, p.HTTPListener, nil
+	}
+	if v, ok := os.LookupEnv(ListenFDsEnv); ok {
+		// Processes started by this one must not take the variable as theirs.
+		os.Unsetenv(ListenFDsEnv)
+		if v != "2" {
+			return nil, nil, fmt.Errorf("%s is %q, want 2 listeners", ListenFDsEnv, v)
+		}
+		return inheritListeners()
+	}
+	httpsListener, err := net.Listen("tcp", p.httpsAddr())
+	if err != nil {
+		return nil, nil, err
+	}
+	httpListener, err := net.Listen("tcp", p.httpAddr())
+	if err != nil {
+		httpsListener.Close()
+		return nil, nil, err
+	}
+	return httpsListener, httpListener, nil
+}
+
+// inheritListeners returns the HTTPS and plain HTTP listeners at file descriptors 3 and 4.
+func inheritListeners() (net.Listener, net.Listener, error) {
+	listeners := []net.Listener{}
+	for i, name := range []string{"https", "http"} {
+		f := os.NewFile(uintptr(3+i), name)
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, nil, err
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners[0], listeners[1], nil
+}
+
+func (p *Platform) restartTimeout() time.Duration {
+	if p.RestartTimeout == 0 {
+		return DefaultRestartTimeout
+	}
+	return p.RestartTimeout
+}
+
+func (p *Platform) drainTimeout() time.Duration {
+	if p.DrainTimeout == 0 {
+		return DefaultDrainTimeout
+	}
+	return p.DrainTimeout
+}
@@ -0,0 +1,291 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+var noRedirect = &http.Client{
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// redirectLocation returns where the plain HTTP listener at addr redirects /x to.
+func redirectLocation(addr string) (string, error) {
+	res, err := noRedirect.Get("http://" + addr + "/x")
+	if err != nil {
+		return "", err
+	}
+	res.Body.Close()
+	return res.Header.Get("Location"), nil
+}
+
+func newTestPlatform(t *testing.T) *Platform {
+	return &Platform{
+		CertDir:      t.TempDir(),
+		HTTPSAddr:    "127.0.0.1:0",
+		HTTPAddr:     "127.0.0.1:0",
+		DrainTimeout: time.Minute,
+	}
+}
+
+func waitStopped(t *testing.T, done chan error) error {
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatal("platform did not stop")
+		return nil
+	}
+}
+
+// inProcess is a Platform started by a restart within the test process.
+type inProcess struct {
+	p    *Platform
+	done chan error
+	// ready is held open by a new process that hangs.
+	ready *os.File
+}
+
+func (n *inProcess) Kill() error {
+	if n.ready != nil {
+		n.ready.Close()
+	}
+	if n.p == nil {
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return n.p.Shutdown(ctx)
+}
+
+func (n *inProcess) Wait() (*os.ProcessState, error) {
+	if n.p != nil {
+		<-n.done
+	}
+	return nil, nil
+}
+
+func (n *inProcess) Release() error {
+	return nil
+}
+
+// restartInProcess returns a startProcess that starts the new Platform in the test process,
+// serving the listeners handed to it. The new Platform redirects to port 9999, so that its answers
+// can be told from the old one's. It is sent on started once it is serving.
+// With mode "exit" the new process stops at once, and with "hang" it never starts serving.
+func restartInProcess(t *testing.T, mode string, started chan *Platform) func(files []*os.File) (restartedProcess, error) {
+	certDir := t.TempDir()
+	return func(files []*os.File) (restartedProcess, error) {
+		switch mode {
+		case "exit":
+			return &inProcess{}, nil
+		case "hang":
+			fd, err := syscall.Dup(int(files[2].Fd()))
+			if err != nil {
+				return nil, err
+			}
+			return &inProcess{ready: os.NewFile(uintptr(fd), "ready")}, nil
+		}
+		httpsListener, err := net.FileListener(files[0])
+		if err != nil {
+			return nil, err
+		}
+		httpListener, err := net.FileListener(files[1])
+		if err != nil {
+			httpsListener.Close()
+			return nil, err
+		}
+		n := &inProcess{
+			p: &Platform{
+				CertDir:       certDir,
+				HTTPSAddr:     "127.0.0.1:9999",
+				HTTPSListener: httpsListener,
+				HTTPListener:  httpListener,
+			},
+			done: make(chan error, 1),
+		}
+		go func() {
+			n.done <- n.p.Start()
+		}()
+		deadline := time.Now().Add(5 * time.Second)
+		for n.p.running() == nil {
+			if time.Now().After(deadline) {
+				return nil, errors.New("new platform did not start")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		_, err = files[2].Write([]byte{1})
+		if err != nil {
+			return nil, err
+		}
+		if started != nil {
+			started <- n.p
+		}
+		return n, nil
+	}
+}
+
+func TestPlatformSignalDrains(t *testing.T) {
+	signals := make(chan os.Signal)
+	p := newTestPlatform(t)
+	p.Signals = signals
+	done := startPlatform(t, p)
+	addr := p.running().httpListener.Addr().String()
+	_, err := redirectLocation(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.connections.Inc()
+	// The channel is unbuffered, so the Platform has the signal once it is sent.
+	signals <- syscall.SIGTERM
+	if p.running() == nil {
+		t.Fatal("stopped without waiting for the request in flight")
+	}
+	p.connections.Dec()
+	err = waitStopped(t, done)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.running() != nil || p.InFlight() != 0 {
+		t.Fatal("still running")
+	}
+	_, err = redirectLocation(addr)
+	if err == nil {
+		t.Fatal("still listening")
+	}
+	if p.Restart() != ErrPlatformNotRunning {
+		t.Fatal("restarted a stopped platform")
+	}
+}
+
+func TestPlatformSecondSignalForcesShutdown(t *testing.T) {
+	signals := make(chan os.Signal)
+	p := newTestPlatform(t)
+	p.Signals = signals
+	done := startPlatform(t, p)
+	p.connections.Inc()
+	defer p.connections.Dec()
+	signals <- syscall.SIGINT
+	if p.running() == nil {
+		t.Fatal("stopped without waiting for the request in flight")
+	}
+	signals <- syscall.SIGINT
+	err := waitStopped(t, done)
+	if err != context.Canceled {
+		t.Fatal(err)
+	}
+}
+
+func TestPlatformShutdownDeadline(t *testing.T) {
+	p := newTestPlatform(t)
+	done := startPlatform(t, p)
+	p.connections.Inc()
+	defer p.connections.Dec()
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err := p.Shutdown(ctx)
+	if err != context.DeadlineExceeded {
+		t.Fatal(err)
+	}
+	err = waitStopped(t, done)
+	if err != context.DeadlineExceeded {
+		t.Fatal(err)
+	}
+}
+
+func TestPlatformServesGivenListeners(t *testing.T) {
+	httpsListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := newTestPlatform(t)
+	p.HTTPSListener = httpsListener
+	p.HTTPListener = httpListener
+	done := startPlatform(t, p)
+	location, err := redirectLocation(httpListener.Addr().String())
+	if err != nil || location == "" {
+		t.Fatalf("%q %v", location, err)
+	}
+	err = p.Shutdown(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = waitStopped(t, done)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = redirectLocation(httpListener.Addr().String())
+	if err == nil {
+		t.Fatal("listener was not closed")
+	}
+}
+
+func TestPlatformRestart(t *testing.T) {
+	signals := make(chan os.Signal)
+	started := make(chan *Platform, 1)
+	p := newTestPlatform(t)
+	p.Signals = signals
+	p.startProcess = restartInProcess(t, "", started)
+	p.OnRestartError = func(err error) {
+		t.Error(err)
+	}
+	done := startPlatform(t, p)
+	addr := p.running().httpListener.Addr().String()
+	signals <- syscall.SIGHUP
+	err := waitStopped(t, done)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restarted := <-started
+	location, err := redirectLocation(addr)
+	if err != nil || !strings.HasSuffix(location, ":9999/x") {
+		t.Fatalf("new platform redirected to %q: %v", location, err)
+	}
+	err = restarted.Shutdown(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = redirectLocation(addr)
+	if err == nil {
+		t.Fatal("new platform still listening")
+	}
+}
+
+func TestPlatformRestartFailureKeepsServing(t *testing.T) {
+	for _, mode := range []string{"exit", "hang"} {
+		p := newTestPlatform(t)
+		p.RestartTimeout = 100 * time.Millisecond
+		p.startProcess = restartInProcess(t, mode, nil)
+		done := startPlatform(t, p)
+		addr := p.running().httpListener.Addr().String()
+		err := p.Restart()
+		if err == nil {
+			t.Fatalf("%s: restart succeeded", mode)
+		}
+		location, err := redirectLocation(addr)
+		if err != nil || strings.HasSuffix(location, ":9999/x") {
+			t.Fatalf("%s: redirected to %q: %v", mode, location, err)
+		}
+		err = p.Shutdown(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = waitStopped(t, done)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
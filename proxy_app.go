@@ -0,0 +1,223 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultHealthCheckInterval is how often upstreams are checked when HealthCheckInterval is zero.
+const DefaultHealthCheckInterval = 10 * time.Second
+
+// DefaultMaxRetryBodySize is the largest request body kept for retries when MaxRetryBodySize is zero.
+const DefaultMaxRetryBodySize = 1 << 20
+
+// ProxyApp is an app that passes requests on to backend servers, such as processes listening on local ports.
+// Each request takes the ProxyRoute with the longest matching path prefix and goes to one of its upstreams.
+// The backends are told about the original request in the X-Forwarded-For, X-Forwarded-Host and
+// X-Forwarded-Proto headers. WebSocket and other upgraded connections are passed through.
+// Requests that find no healthy upstream are answered with 503 Service Unavailable,
+// and those that no upstream could serve with 502 Bad Gateway.
+type ProxyApp struct {
+	// Routes are the routes of the app.
+	Routes []*ProxyRoute
+	// HealthCheckInterval is how often StartHealthChecks checks the upstreams.
+	// If it is zero, DefaultHealthCheckInterval is used.
+	HealthCheckInterval time.Duration
+	// MaxRetryBodySize is the largest request body that is kept so that the request can be retried.
+	// Requests with larger bodies are only tried once.
+	// If it is zero, DefaultMaxRetryBodySize is used.
+	MaxRetryBodySize int64
+}
+
+func (p *ProxyApp) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	route := p.route(r.URL.Path)
+	if route == nil {
+		ServeNotFound(w, r)
+		return
+	}
+	attempts := 1
+	var body []byte
+	if isIdempotent(r.Method) && route.Retries > 0 {
+		var ok bool
+		body, ok = p.retryBody(r)
+		if ok {
+			attempts += route.Retries
+		}
+	}
+	tried := map[*Upstream]bool{}
+	for i := 0; i < attempts; i++ {
+		u := route.pick(tried)
+		if u == nil {
+			break
+		}
+		tried[u] = true
+		if body != nil {
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+		if p.forward(w, r, route, u, i == attempts-1) {
+			return
+		}
+	}
+	if len(tried) == 0 {
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return
+	}
+	http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+}
+
+// route returns the route with the longest prefix matching path, or nil if there is none.
+func (p *ProxyApp) route(path string) *ProxyRoute {
+	var best *ProxyRoute
+	for _, rt := range p.Routes {
+		if rt.matches(path) && (best == nil || len(rt.PathPrefix) > len(best.PathPrefix)) {
+			best = rt
+		}
+	}
+	return best
+}
+
+// retryBody reads the body of r so that it can be sent again.
+// It returns false, leaving r as it was, if the body is too large to keep.
+func (p *ProxyApp) retryBody(r *http.Request) ([]byte, bool) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, true
+	}
+	limit := p.MaxRetryBodySize
+	if limit == 0 {
+		limit = DefaultMaxRetryBodySize
+	}
+	if r.ContentLength > limit {
+		return nil, false
+	}
+	b, err := io.ReadAll(io.LimitReader(r.Body, limit+1))
+	if err != nil || int64(len(b)) > limit {
+		r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(b), r.Body))
+		return nil, false
+	}
+	return b, true
+}
+
+// forward passes r on to u and copies the response to w.
+// It returns false if u could not be reached and nothing was written, so that another upstream can be tried.
+// On the last attempt, failures are written to w as they are.
+func (p *ProxyApp) forward(w http.ResponseWriter, r *http.Request, route *ProxyRoute, u *Upstream, last bool) bool {
+	if u.init() != nil {
+		return false
+	}
+	atomic.AddInt64(&u.active, 1)
+	defer atomic.AddInt64(&u.active, -1)
+	failed := false
+	proxy := &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			path := req.URL.Path
+			if route.StripPrefix {
+				path = "/" + strings.TrimPrefix(strings.TrimPrefix(path, strings.TrimSuffix(route.PathPrefix, "/")), "/")
+			}
+			req.URL.Scheme = u.target.Scheme
+			req.URL.Host = u.target.Host
+			req.URL.Path = singleJoiningSlash(u.target.Path, path)
+			req.URL.RawPath = ""
+			if u.target.RawQuery != "" && req.URL.RawQuery != "" {
+				req.URL.RawQuery = u.target.RawQuery + "&" + req.URL.RawQuery
+			} else if u.target.RawQuery != "" {
+				req.URL.RawQuery = u.target.RawQuery
+			}
+			req.Header.Set("X-Forwarded-Host", r.Host)
+			proto := "http"
+			if isHTTPS(r) {
+				proto = "https"
+			}
+			req.Header.Set("X-Forwarded-Proto", proto)
+		},
+		Transport: u.transport,
+		ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+			if !last {
+				failed = true
+				return
+			}
+			status := http.StatusBadGateway
+			if req.Context().Err() == nil && isTimeout(err) {
+				status = http.StatusGatewayTimeout
+			}
+			http.Error(w, http.StatusText(status), status)
+		},
+	}
+	proxy.ServeHTTP(w, r)
+	return !failed
+}
+
+// StartHealthChecks checks the upstreams of every route with a HealthCheckPath now and then every
+// HealthCheckInterval, until ctx is done. It returns at once; the checks run in the background.
+func (p *ProxyApp) StartHealthChecks(ctx context.Context) {
+	interval := p.HealthCheckInterval
+	if interval == 0 {
+		interval = DefaultHealthCheckInterval
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			p.checkHealth(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// checkHealth checks every upstream that has a health check at once and waits for the results.
+func (p *ProxyApp) checkHealth(ctx context.Context) {
+	done := make(chan struct{})
+	n := 0
+	for _, rt := range p.Routes {
+		if rt.HealthCheckPath == "" {
+			continue
+		}
+		for _, u := range rt.Upstreams {
+			n++
+			go func(u *Upstream, path string) {
+				u.checkHealth(ctx, path)
+				done <- struct{}{}
+			}(u, rt.HealthCheckPath)
+		}
+	}
+	for i := 0; i < n; i++ {
+		<-done
+	}
+}
+
+// isIdempotent returns true if requests with the given method can safely be sent twice.
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// isTimeout returns true if err is a timeout.
+func isTimeout(err error) bool {
+	t, ok := err.(interface{ Timeout() bool })
+	return ok && t.Timeout()
+}
+
+// singleJoiningSlash joins two URL paths with exactly one slash between them.
+func singleJoiningSlash(a, b string) string {
+	aslash := strings.HasSuffix(a, "/")
+	bslash := strings.HasPrefix(b, "/")
+	switch {
+	case aslash && bslash:
+		return a + b[1:]
+	case !aslash && !bslash:
+		return a + "/" + b
+	}
+	return a + b
+}
@@ -0,0 +1,240 @@
+package web
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newNamedBackend starts a backend that answers with its name, the path of the request,
+// the forwarding headers and the body of the request.
+func newNamedBackend(t *testing.T, name string) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" {
+			return
+		}
+		b, _ := io.ReadAll(r.Body)
+		fmt.Fprintf(w, "%s %s %s %s %s %s", name, r.URL.Path, r.Header.Get("X-Forwarded-Host"),
+			r.Header.Get("X-Forwarded-Proto"), r.Header.Get("X-Forwarded-For"), b)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// deadUpstreamURL returns the URL of a server that is no longer listening.
+func deadUpstreamURL() string {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+	return srv.URL
+}
+
+// proxyRequest makes a request to app for the host site.test.
+func proxyRequest(t *testing.T, app *ProxyApp, method, path, body string) (int, string) {
+	r := httptest.NewRequest(method, "http://site.test"+path, strings.NewReader(body))
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, r)
+	return w.Code, w.Body.String()
+}
+
+func TestProxyAppRoundRobin(t *testing.T) {
+	a, b := newNamedBackend(t, "a"), newNamedBackend(t, "b")
+	app := &ProxyApp{Routes: []*ProxyRoute{{Upstreams: []*Upstream{{URL: a.URL}, {URL: b.URL}}}}}
+	seen := map[string]int{}
+	for i := 0; i < 4; i++ {
+		code, body := proxyRequest(t, app, "GET", "/x", "")
+		if code != http.StatusOK || !strings.Contains(body, " /x site.test http 192.0.2.1 ") {
+			t.Fatalf("%d %q", code, body)
+		}
+		seen[body[:1]]++
+	}
+	if seen["a"] != 2 || seen["b"] != 2 {
+		t.Fatal(seen)
+	}
+}
+
+func TestProxyAppRetries(t *testing.T) {
+	a := newNamedBackend(t, "a")
+	app := &ProxyApp{Routes: []*ProxyRoute{{
+		PathPrefix:  "/api",
+		StripPrefix: true,
+		Upstreams:   []*Upstream{{URL: deadUpstreamURL()}, {URL: a.URL + "/v1"}},
+		Retries:     1,
+	}}}
+	for i := 0; i < 3; i++ {
+		code, body := proxyRequest(t, app, "PUT", "/api/items/1", "payload")
+		if code != http.StatusOK || !strings.HasPrefix(body, "a /v1/items/1 ") || !strings.HasSuffix(body, " payload") {
+			t.Fatalf("%d %q", code, body)
+		}
+	}
+	// POST requests are not retried, so the ones that go to the dead upstream fail.
+	codes := map[int]int{}
+	for i := 0; i < 4; i++ {
+		code, _ := proxyRequest(t, app, "POST", "/api/x", "p")
+		codes[code]++
+	}
+	if codes[http.StatusOK] != 2 || codes[http.StatusBadGateway] != 2 {
+		t.Fatal(codes)
+	}
+}
+
+func TestProxyAppErrors(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(300 * time.Millisecond)
+	}))
+	defer slow.Close()
+	app := &ProxyApp{Routes: []*ProxyRoute{
+		{PathPrefix: "/dead", Upstreams: []*Upstream{{URL: deadUpstreamURL()}}},
+		{PathPrefix: "/slow", Upstreams: []*Upstream{{URL: slow.URL, Timeout: 50 * time.Millisecond}}},
+	}}
+	code, _ := proxyRequest(t, app, "GET", "/dead", "")
+	if code != http.StatusBadGateway {
+		t.Fatalf("dead upstream: %d", code)
+	}
+	code, _ = proxyRequest(t, app, "GET", "/slow", "")
+	if code != http.StatusGatewayTimeout {
+		t.Fatalf("slow upstream: %d", code)
+	}
+	code, _ = proxyRequest(t, app, "GET", "/other", "")
+	if code != http.StatusNotFound {
+		t.Fatalf("no route: %d", code)
+	}
+}
+
+func TestProxyAppHealthChecks(t *testing.T) {
+	b := newNamedBackend(t, "b")
+	dead := deadUpstreamURL()
+	app := &ProxyApp{
+		Routes: []*ProxyRoute{
+			{PathPrefix: "/hc", Upstreams: []*Upstream{{URL: dead}, {URL: b.URL}}, HealthCheckPath: "/health"},
+			{PathPrefix: "/dead", Upstreams: []*Upstream{{URL: dead}}, HealthCheckPath: "/health"},
+		},
+		HealthCheckInterval: 20 * time.Millisecond,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	app.StartHealthChecks(ctx)
+	deadline := time.Now().Add(5 * time.Second)
+	for app.Routes[0].Upstreams[0].Healthy() {
+		if time.Now().After(deadline) {
+			t.Fatal("dead upstream is still healthy")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !app.Routes[0].Upstreams[1].Healthy() {
+		t.Fatal("live upstream is unhealthy")
+	}
+	for i := 0; i < 4; i++ {
+		code, body := proxyRequest(t, app, "GET", "/hc", "")
+		if code != http.StatusOK || body[:1] != "b" {
+			t.Fatalf("%d %q", code, body)
+		}
+	}
+	code, _ := proxyRequest(t, app, "GET", "/dead", "")
+	if code != http.StatusServiceUnavailable {
+		t.Fatalf("no healthy upstream: %d", code)
+	}
+}
+
+func TestProxyAppLeastConnections(t *testing.T) {
+	var lock sync.Mutex
+	hits := map[string]int{}
+	block := make(chan struct{})
+	newBackend := func(name string) *httptest.Server {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			lock.Lock()
+			hits[name]++
+			lock.Unlock()
+			if r.URL.Query().Get("block") != "" {
+				<-block
+			}
+		}))
+		t.Cleanup(srv.Close)
+		return srv
+	}
+	l1, l2 := newBackend("l1"), newBackend("l2")
+	app := &ProxyApp{Routes: []*ProxyRoute{{
+		Balance:   ProxyLeastConnections,
+		Upstreams: []*Upstream{{URL: l1.URL}, {URL: l2.URL}},
+	}}}
+	blocked := make(chan struct{})
+	go func() {
+		proxyRequest(t, app, "GET", "/?block=1", "")
+		close(blocked)
+	}()
+	defer func() {
+		close(block)
+		<-blocked
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		lock.Lock()
+		n := hits["l1"] + hits["l2"]
+		lock.Unlock()
+		if n == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("blocking request did not arrive")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for i := 0; i < 3; i++ {
+		proxyRequest(t, app, "GET", "/", "")
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	// The upstream busy with the blocked request gets none of the others.
+	if hits["l1"]+hits["l2"] != 4 || (hits["l1"] != 1 && hits["l2"] != 1) {
+		t.Fatal(hits)
+	}
+}
+
+func TestProxyAppUpgrade(t *testing.T) {
+	ws := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "websocket" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		conn, brw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+		brw.Flush()
+		line, _ := brw.ReadString('\n')
+		brw.WriteString("echo:" + line)
+		brw.Flush()
+	}))
+	defer ws.Close()
+	srv := httptest.NewServer(&ProxyApp{Routes: []*ProxyRoute{{
+		Upstreams: []*Upstream{{URL: ws.URL, Timeout: 50 * time.Millisecond}},
+	}}})
+	defer srv.Close()
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "GET /ws HTTP/1.1\r\nHost: site.test\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+	br := bufio.NewReader(conn)
+	res, err := http.ReadResponse(br, nil)
+	if err != nil || res.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("%v %v", res, err)
+	}
+	// The upstream timeout does not apply to upgraded connections.
+	time.Sleep(100 * time.Millisecond)
+	fmt.Fprintf(conn, "hello\n")
+	line, err := br.ReadString('\n')
+	if err != nil || line != "echo:hello\n" {
+		t.Fatalf("%q %v", line, err)
+	}
+}
@@ -0,0 +1,66 @@
+package web
+
+import "sync/atomic"
+
+// Ways a ProxyRoute balances requests between its upstreams.
+const (
+	// ProxyRoundRobin sends requests to each upstream in turn.
+	ProxyRoundRobin = "round_robin"
+	// ProxyLeastConnections sends each request to the upstream serving the fewest requests.
+	ProxyLeastConnections = "least_connections"
+)
+
+// ProxyRoute passes the requests under a path prefix on to a set of upstreams.
+type ProxyRoute struct {
+	// PathPrefix is the path under which requests take the route, such as "/api".
+	// An empty PathPrefix takes every request. The route with the longest matching prefix is used.
+	PathPrefix string
+	// StripPrefix removes PathPrefix from the path before the request is passed on.
+	StripPrefix bool
+	// Upstreams are the backends that serve the route.
+	Upstreams []*Upstream
+	// Balance is ProxyRoundRobin or ProxyLeastConnections.
+	// If it is empty, ProxyRoundRobin is used.
+	Balance string
+	// Retries is how many more upstreams an idempotent request is tried on if an upstream cannot be reached
+	// or does not answer within its Timeout.
+	Retries int
+	// HealthCheckPath is requested from each upstream by ProxyApp.StartHealthChecks.
+	// Upstreams that do not answer it with a 2xx or 3xx status get no requests until they do.
+	// If it is empty, upstreams are not checked.
+	HealthCheckPath string
+
+	next uint32
+}
+
+// matches returns true if the route takes requests for path.
+func (rt *ProxyRoute) matches(path string) bool {
+	return hasPathPrefix(path, rt.PathPrefix)
+}
+
+// pick returns the upstream for the next attempt at a request, skipping those in tried and those that are down.
+// It returns nil if there is none left.
+func (rt *ProxyRoute) pick(tried map[*Upstream]bool) *Upstream {
+	candidates := []*Upstream{}
+	for _, u := range rt.Upstreams {
+		if !tried[u] && u.Healthy() {
+			candidates = append(candidates, u)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	start := int((atomic.AddUint32(&rt.next, 1) - 1) % uint32(len(candidates)))
+	if rt.Balance != ProxyLeastConnections {
+		return candidates[start]
+	}
+	// Start from the next upstream in turn so that ties are spread out too.
+	best := candidates[start]
+	for i := 1; i < len(candidates); i++ {
+		u := candidates[(start+i)%len(candidates)]
+		if atomic.LoadInt64(&u.active) < atomic.LoadInt64(&best.active) {
+			best = u
+		}
+	}
+	return best
+}
@@ -0,0 +1,16 @@
+package web
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+)
+
+// randomToken returns a URL-safe string encoding n random bytes.
+func randomToken(n int) string {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
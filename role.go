@@ -0,0 +1,12 @@
+package web
+
+// Roles understood by AuthDB.
+// Any other string may be used as a custom role; AuthDB only stores it.
+const (
+	// RoleAdmin may use every endpoint and implies every other role.
+	RoleAdmin = "admin"
+	// RoleRegistrar may create registration codes without the RegistrarKey.
+	RoleRegistrar = "registrar"
+	// RoleMember is given to every registered user.
+	RoleMember = "member"
+)
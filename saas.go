@@ -1,7 +1,169 @@
 package web
 
-import "net/http"
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+)
 
+// SaaS serves the data of many organizations from one app, keeping each organization's data in its own Org.
+// Requests for /orgs/{orgID}/... are passed to the Org with that ID with the prefix stripped.
+// If Domain is set, requests for {orgID}.{Domain} are passed to the Org whole as well.
+// Only members of the organization get through: GET and HEAD requests take ReadRole and the rest take WriteRole.
+// Users who are not members get a 404, so they cannot tell which organizations exist.
+// GET /orgs lists the user's organizations and POST /orgs with {"name": ...} creates one owned by the user.
+// The user is the one set by AuthMiddleware, which must wrap the SaaS.
 type SaaS[OrgDataType http.Handler] struct {
-	Orgs map[string]Org[OrgDataType]
+	// Orgs hold the data of each organization, keyed by org ID.
+	// Organizations that have no entry get one from NewData when they are first used.
+	Orgs map[string]*Org[OrgDataType]
+	// DB keeps the organizations and their members.
+	DB *AuthDB
+	// Domain is the domain under which each organization has its own subdomain, such as "example.com".
+	// If it is empty, organizations are only served under /orgs.
+	Domain string
+	// NewData returns the data of a new organization.
+	// If it is nil, the zero value of OrgDataType is used.
+	NewData func(orgID string) OrgDataType
+	// ReadRole is the org role needed for GET and HEAD requests.
+	// If it is empty, OrgRoleViewer is used.
+	ReadRole string
+	// WriteRole is the org role needed for other requests.
+	// If it is empty, OrgRoleEditor is used.
+	WriteRole string
+
+	lock sync.Mutex
+}
+
+func (s *SaaS[T]) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	userID := UserIDFromContext(r.Context())
+	if userID == "" {
+		ServeUnauthorized(w, r)
+		return
+	}
+	if orgID, ok := s.subdomainOrg(r); ok {
+		s.serveOrg(w, r, userID, orgID)
+		return
+	}
+	path := ParsePath(r.URL.Path)
+	if len(path) == 0 || path[0] != "orgs" {
+		ServeNotFound(w, r)
+		return
+	}
+	if len(path) == 1 {
+		s.serveOrgs(w, r, userID)
+		return
+	}
+	orgID := path[1]
+	http.StripPrefix("/orgs/"+orgID, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.serveOrg(w, r, userID, orgID)
+	})).ServeHTTP(w, r)
+}
+
+// subdomainOrg returns the ID of the organization whose subdomain r is for.
+func (s *SaaS[T]) subdomainOrg(r *http.Request) (string, bool) {
+	if s.Domain == "" {
+		return "", false
+	}
+	// Host names are case-insensitive and may be written fully qualified, with a trailing dot.
+	host := strings.TrimSuffix(strings.ToLower(hostName(r.Host)), ".")
+	domain := strings.TrimSuffix(strings.ToLower(s.Domain), ".")
+	label := strings.TrimSuffix(host, "."+domain)
+	if label == host || label == "" || strings.Contains(label, ".") {
+		return "", false
+	}
+	// Host names are lower case, but org IDs are ULIDs, which are upper case.
+	if _, err := s.DB.Org(label); err == ErrOrgNotFound {
+		label = strings.ToUpper(label)
+	}
+	return label, true
+}
+
+// serveOrg passes r to the data of the organization if the user has the role it needs.
+func (s *SaaS[T]) serveOrg(w http.ResponseWriter, r *http.Request, userID, orgID string) {
+	role := s.DB.OrgRole(orgID, userID)
+	if role == "" {
+		ServeNotFound(w, r)
+		return
+	}
+	need := s.writeRole()
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		need = s.readRole()
+	}
+	if orgRoleRank(role) < orgRoleRank(need) {
+		ServeForbidden(w, r)
+		return
+	}
+	s.org(orgID).ServeHTTP(w, r)
+}
+
+// serveOrgs lists the organizations of the user or creates a new one.
+func (s *SaaS[T]) serveOrgs(w http.ResponseWriter, r *http.Request, userID string) {
+	switch r.Method {
+	case http.MethodGet:
+		json.NewEncoder(w).Encode(s.DB.UserOrgs(userID))
+	case http.MethodPost:
+		if !CheckCSRF(r) {
+			ServeForbidden(w, r)
+			return
+		}
+		var req struct {
+			Name string `json:"name"`
+		}
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			ServeBadRequest(w, r)
+			return
+		}
+		org, err := s.DB.CreateOrg(userID, req.Name)
+		event := &AuditEvent{Type: AuditOrgCreated, ActorID: userID, UserID: userID}
+		if org != nil {
+			event.Detail = org.ID
+		}
+		s.DB.auditEvent(r, event, err)
+		if err != nil {
+			ServeInternalServerError(w, r)
+			return
+		}
+		s.org(org.ID)
+		w.Header().Set("Location", "/orgs/"+org.ID)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(org)
+	default:
+		ServeMethodNotAllowed(w, r)
+	}
+}
+
+// org returns the data of the organization with the given ID, creating it if there is none yet.
+func (s *SaaS[T]) org(orgID string) *Org[T] {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	org, ok := s.Orgs[orgID]
+	if ok {
+		return org
+	}
+	org = &Org[T]{}
+	if s.NewData != nil {
+		org.Data = s.NewData(orgID)
+	}
+	if s.Orgs == nil {
+		s.Orgs = map[string]*Org[T]{}
+	}
+	s.Orgs[orgID] = org
+	return org
+}
+
+func (s *SaaS[T]) readRole() string {
+	if s.ReadRole == "" {
+		return OrgRoleViewer
+	}
+	return s.ReadRole
+}
+
+func (s *SaaS[T]) writeRole() string {
+	if s.WriteRole == "" {
+		return OrgRoleEditor
+	}
+	return s.WriteRole
 }
@@ -0,0 +1,202 @@
+package web
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// testNotes is the data of an organization in the SaaS tests.
+type testNotes struct {
+	Notes []string
+}
+
+func (n *testNotes) ServeHTTP(w http.ResponseWriter, r *http.Request) {}
+
+func (n *testNotes) Add(w http.ResponseWriter, r *http.Request) {
+	n.Notes = append(n.Notes, r.URL.Query().Get("note"))
+}
+
+// registerUser registers a new user with db and logs them in.
+func registerUser(t *testing.T, db *AuthDB) *Session {
+	code, err := db.Invite()
+	if err != nil {
+		t.Fatal(err)
+	}
+	userID, err := db.Register(code, "battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := db.Login(userID, "battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Session{UserID: userID, Token: token}
+}
+
+// saasRequest makes a request to h as the user of session s, or without a session if s is nil.
+func saasRequest(t *testing.T, h http.Handler, s *Session, method, u, body string) (int, string) {
+	r := httptest.NewRequest(method, u, strings.NewReader(body))
+	if s != nil {
+		r.Header.Set("Authorization", "Bearer "+s.Credential())
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	b, err := io.ReadAll(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w.Code, string(b)
+}
+
+func createOrg(t *testing.T, h http.Handler, s *Session, name string) *Organization {
+	code, body := saasRequest(t, h, s, "POST", "/orgs", `{"name":"`+name+`"}`)
+	if code != http.StatusCreated {
+		t.Fatalf("create %s: %d %s", name, code, body)
+	}
+	var org Organization
+	err := json.Unmarshal([]byte(body), &org)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &org
+}
+
+func TestSaaS(t *testing.T) {
+	db := NewAuthDB("correct horse", "")
+	saas := &SaaS[*testNotes]{
+		DB:      db,
+		Domain:  "app.test",
+		NewData: func(string) *testNotes { return &testNotes{Notes: []string{}} },
+	}
+	h := &AuthMiddleware{DB: db, Handler: saas}
+	alice, bob := registerUser(t, db), registerUser(t, db)
+
+	code, _ := saasRequest(t, h, nil, "GET", "/orgs", "")
+	if code != http.StatusUnauthorized {
+		t.Fatalf("without a session: %d", code)
+	}
+	orgA := createOrg(t, h, alice, "A")
+	orgB := createOrg(t, h, bob, "B")
+	code, body := saasRequest(t, h, alice, "POST", "/orgs/"+orgA.ID+"?method=Add&note=a1", "")
+	if code != http.StatusOK {
+		t.Fatalf("add to own org: %d %s", code, body)
+	}
+	code, body = saasRequest(t, h, bob, "POST", "/orgs/"+orgB.ID+"?method=Add&note=b1", "")
+	if code != http.StatusOK {
+		t.Fatalf("add to own org: %d %s", code, body)
+	}
+
+	code, _ = saasRequest(t, h, bob, "GET", "/orgs/"+orgA.ID, "")
+	if code != http.StatusNotFound {
+		t.Fatalf("read by non-member: %d", code)
+	}
+	code, _ = saasRequest(t, h, bob, "POST", "/orgs/"+orgA.ID+"?method=Add&note=x", "")
+	if code != http.StatusNotFound {
+		t.Fatalf("write by non-member: %d", code)
+	}
+	code, body = saasRequest(t, h, alice, "GET", "/orgs/"+orgA.ID, "")
+	if code != http.StatusOK || !strings.Contains(body, "a1") || strings.Contains(body, "b1") {
+		t.Fatalf("org A: %d %s", code, body)
+	}
+
+	inv, err := db.InviteToOrg(alice.UserID, orgA.ID, &OrgInvitation{UserID: bob.UserID, Role: OrgRoleViewer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.AcceptOrgInvitation(bob.UserID, orgA.ID, inv.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, _ = saasRequest(t, h, bob, "GET", "/orgs/"+orgA.ID, "")
+	if code != http.StatusOK {
+		t.Fatalf("read by viewer: %d", code)
+	}
+	code, _ = saasRequest(t, h, bob, "POST", "/orgs/"+orgA.ID+"?method=Add&note=x", "")
+	if code != http.StatusForbidden {
+		t.Fatalf("write by viewer: %d", code)
+	}
+
+	code, body = saasRequest(t, h, alice, "GET", "http://"+strings.ToLower(orgA.ID)+".app.test/", "")
+	if code != http.StatusOK || !strings.Contains(body, "a1") {
+		t.Fatalf("subdomain of org A: %d %s", code, body)
+	}
+	code, _ = saasRequest(t, h, alice, "GET", "http://"+strings.ToLower(orgB.ID)+".app.test:8443/", "")
+	if code != http.StatusNotFound {
+		t.Fatalf("subdomain of another org: %d", code)
+	}
+
+	_, body = saasRequest(t, h, bob, "GET", "/orgs", "")
+	var orgs []Organization
+	err = json.Unmarshal([]byte(body), &orgs)
+	if err != nil || len(orgs) != 2 {
+		t.Fatalf("orgs of bob: %s", body)
+	}
+	if len(saas.Orgs) != 2 {
+		t.Fatalf("%d orgs", len(saas.Orgs))
+	}
+}
+
+// valueNotes is the data of an organization kept by value rather than behind a pointer.
+type valueNotes struct {
+	Notes []string
+}
+
+func (n valueNotes) ServeHTTP(w http.ResponseWriter, r *http.Request) {}
+
+func (n *valueNotes) Add(w http.ResponseWriter, r *http.Request) {
+	n.Notes = append(n.Notes, r.URL.Query().Get("note"))
+}
+
+func TestSaaSKeepsValueData(t *testing.T) {
+	db := NewAuthDB("correct horse", "")
+	saas := &SaaS[valueNotes]{DB: db}
+	h := &AuthMiddleware{DB: db, Handler: saas}
+	alice := registerUser(t, db)
+	org := createOrg(t, h, alice, "A")
+	for _, note := range []string{"a1", "a2"} {
+		code, body := saasRequest(t, h, alice, "POST", "/orgs/"+org.ID+"?method=Add&note="+note, "")
+		if code != http.StatusOK {
+			t.Fatalf("add %s: %d %s", note, code, body)
+		}
+	}
+	code, body := saasRequest(t, h, alice, "GET", "/orgs/"+org.ID, "")
+	if code != http.StatusOK || !strings.Contains(body, `["a1","a2"]`) {
+		t.Fatalf("%d %s", code, body)
+	}
+	if notes := saas.Orgs[org.ID].Data.Notes; len(notes) != 2 {
+		t.Fatalf("stored notes: %v", notes)
+	}
+}
+
+func TestSaaSSubdomainNames(t *testing.T) {
+	db := NewAuthDB("correct horse", "")
+	saas := &SaaS[*testNotes]{
+		DB:      db,
+		Domain:  "App.Test.",
+		NewData: func(string) *testNotes { return &testNotes{Notes: []string{"n"}} },
+	}
+	h := &AuthMiddleware{DB: db, Handler: saas}
+	alice := registerUser(t, db)
+	org := createOrg(t, h, alice, "A")
+	for _, host := range []string{
+		strings.ToLower(org.ID) + ".app.test",
+		org.ID + ".APP.TEST",
+		strings.ToLower(org.ID) + ".app.test.",
+		strings.ToLower(org.ID) + ".app.test.:8443",
+	} {
+		code, body := saasRequest(t, h, alice, "GET", "http://"+host+"/", "")
+		if code != http.StatusOK || !strings.Contains(body, `"n"`) {
+			t.Fatalf("%s: %d %s", host, code, body)
+		}
+	}
+	for _, host := range []string{"app.test", "other.test", "x." + strings.ToLower(org.ID) + ".app.test"} {
+		code, _ := saasRequest(t, h, alice, "GET", "http://"+host+"/", "")
+		if code != http.StatusNotFound {
+			t.Fatalf("%s: %d", host, code)
+		}
+	}
+}
@@ -12,6 +12,10 @@ func ServeAny(v any, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if r.Method == http.MethodPost {
+		if !CheckCSRF(r) {
+			ServeForbidden(w, r)
+			return
+		}
 		method := r.URL.Query().Get("method")
 		t := reflect.TypeOf(v)
 		for i := 0; i < t.NumMethod(); i++ {
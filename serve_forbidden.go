@@ -0,0 +1,12 @@
+package web
+
+import "net/http"
+
+func ServeForbidden(w http.ResponseWriter, r *http.Request) {
+	if IsHTML(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+	} else {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("{\"error\":\"forbidden\"}"))
+	}
+}
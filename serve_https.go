@@ -1,8 +1,32 @@
 package web
 
-import "net/http"
+import (
+	"context"
+	"net/http"
+)
 
+// ServeHTTPS serves h over HTTPS with certificates from Let's Encrypt.
+// It also serves plain HTTP, answering ACME HTTP-01 challenges and redirecting everything else to HTTPS.
+// It returns when either listener fails, after shutting the other one down.
 func ServeHTTPS(h http.Handler, acmeConfig ACMEConfig) error {
-	server := acmeConfig.HTTPSServer(h, acmeConfig.CertManager())
-	return server.ListenAndServeTLS("", "")
+	certManager := acmeConfig.CertManager()
+	if acmeConfig.HSTSMaxAge > 0 {
+		h = &HSTS{Handler: h, MaxAge: acmeConfig.HSTSMaxAge}
+	}
+	httpServer := acmeConfig.HTTPServer(&certManager)
+	server := acmeConfig.NewHTTPSServer(h, &certManager)
+	errs := make(chan error, 2)
+	go func() {
+		errs <- httpServer.ListenAndServe()
+	}()
+	go func() {
+		errs <- server.ListenAndServeTLS("", "")
+	}()
+	err := <-errs
+	// One listener failed, so stop the other one too.
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultDrainTimeout)
+	defer cancel()
+	httpServer.Shutdown(ctx)
+	server.Shutdown(ctx)
+	return err
 }
@@ -0,0 +1,43 @@
+package web
+
+import (
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestServeHTTPSStopsBothListeners(t *testing.T) {
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+	free, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpsAddr := free.Addr().String()
+	free.Close()
+	done := make(chan error, 1)
+	go func() {
+		done <- ServeHTTPS(http.NotFoundHandler(), ACMEConfig{
+			CertDir:   t.TempDir(),
+			HTTPAddr:  taken.Addr().String(),
+			HTTPSAddr: httpsAddr,
+		})
+	}()
+	select {
+	case err = <-done:
+		if err == nil {
+			t.Fatal("no error for a taken address")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ServeHTTPS kept running after a listener failed")
+	}
+	conn, err := net.Dial("tcp", httpsAddr)
+	if err == nil {
+		conn.Close()
+		t.Fatal("HTTPS listener still open")
+	}
+}
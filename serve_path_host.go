@@ -0,0 +1,19 @@
+package web
+
+import "net/http"
+
+// servePathHost passes a request for /{host}/... to serve as a request for host with the prefix stripped,
+// so that apps routed by host can be reached without DNS, such as at http://localhost/example.com/.
+// It returns false if the path has no host in it.
+func servePathHost(w http.ResponseWriter, r *http.Request, serve func(w http.ResponseWriter, r *http.Request)) bool {
+	path := ParsePath(r.URL.Path)
+	if len(path) == 0 {
+		return false
+	}
+	host := path[0]
+	http.StripPrefix("/"+host, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Host = host
+		serve(w, r)
+	})).ServeHTTP(w, r)
+	return true
+}
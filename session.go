@@ -1,6 +1,26 @@
 package web
 
+import "strings"
+
 type Session struct {
 	UserID string `json:"user_id"`
 	Token  string `json:"token"`
 }
+
+// Credential returns the session in the "<user_id>:<token>" form used in
+// bearer tokens and session cookies.
+func (s *Session) Credential() string {
+	return s.UserID + ":" + s.Token
+}
+
+// ParseCredential parses a credential returned by Session.Credential.
+func ParseCredential(credential string) (*Session, bool) {
+	userID, token, ok := strings.Cut(credential, ":")
+	if !ok || userID == "" || token == "" {
+		return nil, false
+	}
+	return &Session{
+		UserID: userID,
+		Token:  token,
+	}, true
+}
@@ -0,0 +1,36 @@
+package web
+
+import "time"
+
+// SessionInfo describes a session of a User.
+// It is stored on the User keyed by the session token.
+type SessionInfo struct {
+	// ID identifies the session without revealing its token.
+	ID string `json:"id"`
+	// CreatedAt is the Unix timestamp of when the session was created.
+	CreatedAt int64 `json:"created_at"`
+	// LastSeenAt is the Unix timestamp of when the session was last used.
+	LastSeenAt int64 `json:"last_seen_at"`
+	// IdleExpiresAt is the Unix timestamp after which the session expires unless it is used again.
+	// Zero means the session never goes idle.
+	IdleExpiresAt int64 `json:"idle_expires_at"`
+	// ExpiresAt is the Unix timestamp after which the session expires no matter what.
+	// Zero means the session has no absolute expiry.
+	ExpiresAt int64 `json:"expires_at"`
+	// IP is the address of the client that created the session.
+	IP string `json:"ip"`
+	// UserAgent is the User-Agent of the client that created the session.
+	UserAgent string `json:"user_agent"`
+}
+
+// Expired returns true if the session has expired at the given time.
+func (s *SessionInfo) Expired(now time.Time) bool {
+	t := now.Unix()
+	if s.ExpiresAt != 0 && t >= s.ExpiresAt {
+		return true
+	}
+	if s.IdleExpiresAt != 0 && t >= s.IdleExpiresAt {
+		return true
+	}
+	return false
+}
@@ -0,0 +1,20 @@
+package web
+
+// SessionRevocations lists the stateless session tokens that an AuthDB no longer accepts
+// although they have not expired.
+type SessionRevocations struct {
+	// Sessions maps the IDs of logged out sessions to when their tokens expire.
+	Sessions map[string]int64 `json:"sessions"`
+	// Users maps user IDs to a Unix time in milliseconds. Tokens issued to the user up to then are rejected,
+	// such as when every session of the user is ended.
+	Users map[string]int64 `json:"users"`
+}
+
+// revoked returns true if the token described by claims is revoked.
+func (r *SessionRevocations) revoked(claims *sessionClaims) bool {
+	if _, ok := r.Sessions[claims.SessionID]; ok {
+		return true
+	}
+	revokedAt, ok := r.Users[claims.Subject]
+	return ok && claims.IssuedAtMs <= revokedAt
+}
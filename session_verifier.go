@@ -0,0 +1,115 @@
+package web
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// sessionVerifierMinRefetch keeps tokens signed with unknown keys from making SessionVerifier refetch keys on every request.
+const sessionVerifierMinRefetch = 5 * time.Second
+
+// SessionVerifier checks stateless session tokens without asking the AuthDB about each one.
+// It fetches the AuthDB's public keys and revocation list through Client and refreshes them periodically.
+type SessionVerifier struct {
+	// Client fetches keys and revocations from the AuthDB.
+	Client *AuthClient
+	// ClientID and ClientSecret are the credentials of a confidential OAuth client of the AuthDB,
+	// which the revocation list is only given to.
+	ClientID     string
+	ClientSecret string
+	// RefreshInterval is how often keys and revocations are fetched again.
+	// A session that is logged out may keep working for this long.
+	// If it is zero, DefaultAuthCacheTTL is used.
+	RefreshInterval time.Duration
+
+	lock    sync.Mutex
+	fetched *verifierState
+}
+
+// verifierState is what a SessionVerifier fetched from the AuthDB.
+// It is not changed once fetched, so it can be used without holding the SessionVerifier's lock.
+type verifierState struct {
+	keys        map[string]*JWK
+	revocations *SessionRevocations
+	fetchedAt   time.Time
+}
+
+// Verify returns nil if s holds a valid stateless session token.
+// If the token is invalid, the error will be of type ErrInvalidSession;
+// other errors mean the keys or revocations could not be fetched.
+func (v *SessionVerifier) Verify(ctx context.Context, s *Session) error {
+	state := v.state()
+	if state == nil || time.Since(state.fetchedAt) >= v.refreshInterval() {
+		var err error
+		state, err = v.fetch(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	var claims sessionClaims
+	err := parseJWT(state.key, "session+jwt", s.Token, &claims)
+	if err != nil && time.Since(state.fetchedAt) >= sessionVerifierMinRefetch {
+		// The token may be signed with a key that was rotated in since the last fetch.
+		state, err = v.fetch(ctx)
+		if err != nil {
+			return err
+		}
+		err = parseJWT(state.key, "session+jwt", s.Token, &claims)
+	}
+	if err != nil || claims.Subject != s.UserID || time.Now().Unix() >= claims.ExpiresAt {
+		return ErrInvalidSession
+	}
+	if state.revocations.revoked(&claims) {
+		return ErrInvalidSession
+	}
+	return nil
+}
+
+// state returns what was last fetched, or nil if nothing has been.
+func (v *SessionVerifier) state() *verifierState {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	return v.fetched
+}
+
+// fetch gets the current keys and revocations and keeps them for the next calls to Verify.
+// The requests are made without holding v.lock, so that a slow AuthDB does not hold up
+// the verification of tokens while keys are still fresh.
+func (v *SessionVerifier) fetch(ctx context.Context) (*verifierState, error) {
+	keys, err := v.Client.JWKS(ctx)
+	if err != nil {
+		return nil, err
+	}
+	revocations, err := v.Client.SessionRevocations(ctx, v.ClientID, v.ClientSecret)
+	if err != nil {
+		return nil, err
+	}
+	state := &verifierState{
+		keys:        map[string]*JWK{},
+		revocations: revocations,
+		fetchedAt:   time.Now(),
+	}
+	for _, k := range keys {
+		state.keys[k.Kid] = k
+	}
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	if v.fetched == nil || v.fetched.fetchedAt.Before(state.fetchedAt) {
+		v.fetched = state
+	}
+	return state, nil
+}
+
+// key returns the public key with the given ID.
+func (s *verifierState) key(kid string) (jwtKey, bool) {
+	k, ok := s.keys[kid]
+	return k, ok
+}
+
+func (v *SessionVerifier) refreshInterval() time.Duration {
+	if v.RefreshInterval == 0 {
+		return DefaultAuthCacheTTL
+	}
+	return v.RefreshInterval
+}
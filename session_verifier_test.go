@@ -0,0 +1,135 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newStatelessAuthServer serves an AuthDB with stateless sessions and returns a SessionVerifier for it.
+// The handler of the server is wrapped by wrap if it is not nil.
+func newStatelessAuthServer(t *testing.T, wrap func(http.Handler) http.Handler) (*AuthDB, *AuthClient, *SessionVerifier) {
+	db := NewAuthDB("correct horse", "")
+	db.StatelessSessions = true
+	var h http.Handler = db
+	if wrap != nil {
+		h = wrap(db)
+	}
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	client, secret, err := db.CreateOAuthClient(&OAuthClient{Name: "api"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &AuthClient{AuthServerAddr: srv.URL}
+	v := &SessionVerifier{Client: c, ClientID: client.ID, ClientSecret: secret}
+	return db, c, v
+}
+
+func TestSessionVerifier(t *testing.T) {
+	ctx := context.Background()
+	db, c, v := newStatelessAuthServer(t, nil)
+	login, err := c.Login(ctx, "admin", "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = v.Verify(ctx, &login.Session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = v.Verify(ctx, &Session{UserID: "other", Token: login.Token})
+	if err != ErrInvalidSession {
+		t.Fatalf("token of another user: %v", err)
+	}
+	err = c.Logout(ctx, &login.Session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fresh := &SessionVerifier{Client: c, ClientID: v.ClientID, ClientSecret: v.ClientSecret}
+	err = fresh.Verify(ctx, &login.Session)
+	if err != ErrInvalidSession {
+		t.Fatalf("logged out session: %v", err)
+	}
+
+	public, _, err := db.CreateOAuthClient(&OAuthClient{Name: "spa", Public: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, creds := range [][2]string{{"", ""}, {v.ClientID, "wrong"}, {public.ID, ""}} {
+		_, err = c.SessionRevocations(ctx, creds[0], creds[1])
+		if err != ErrInvalidClient {
+			t.Errorf("revocations for %q/%q: %v", creds[0], creds[1], err)
+		}
+	}
+}
+
+func TestSessionVerifierFetchesWithoutBlocking(t *testing.T) {
+	ctx := context.Background()
+	var blocking int32
+	release := make(chan struct{})
+	_, c, v := newStatelessAuthServer(t, func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/jwks" && atomic.LoadInt32(&blocking) == 1 {
+				<-release
+			}
+			h.ServeHTTP(w, r)
+		})
+	})
+	v.RefreshInterval = time.Hour
+	login, err := c.Login(ctx, "admin", "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = v.Verify(ctx, &login.Session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _, _ := newStatelessAuthServer(t, nil)
+	other.lock.Lock()
+	foreign, err := other.issueSessionToken("admin", "session", time.Now().Unix())
+	other.lock.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Make the keys old enough for an unknown key to trigger a refetch, and hold that refetch up.
+	state := *v.state()
+	state.fetchedAt = state.fetchedAt.Add(-time.Minute)
+	v.fetched = &state
+	atomic.StoreInt32(&blocking, 1)
+	refetched := make(chan error)
+	go func() {
+		refetched <- v.Verify(ctx, &Session{UserID: "admin", Token: foreign})
+	}()
+
+	verified := make(chan error)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		verified <- v.Verify(ctx, &login.Session)
+	}()
+	select {
+	case err := <-verified:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Verify waited for another call's fetch")
+	}
+	close(release)
+	err = <-refetched
+	if err != ErrInvalidSession {
+		t.Fatalf("token signed by another AuthDB: %v", err)
+	}
+}
+
+func TestSessionRevocationsEndpointNeedsClient(t *testing.T) {
+	db := NewAuthDB("correct horse", "")
+	w := httptest.NewRecorder()
+	db.ServeHTTP(w, httptest.NewRequest("POST", "/session-revocations", strings.NewReader("{}")))
+	if w.Code != http.StatusUnauthorized {
+		t.Fatal(w.Code)
+	}
+}
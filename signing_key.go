@@ -0,0 +1,123 @@
+package web
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+)
+
+// Signing algorithms for ID tokens and access tokens.
+const (
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+)
+
+// SigningKey is a key that AuthDB signs tokens with.
+type SigningKey struct {
+	// ID is the key ID, sent as "kid" in token headers.
+	ID string `json:"id"`
+	// Alg is AlgRS256 or AlgEdDSA.
+	Alg string `json:"alg"`
+	// PrivateKey is the PKCS #8 encoded private key.
+	PrivateKey []byte `json:"private_key"`
+	// CreatedAt is the Unix timestamp of when the key was created.
+	CreatedAt int64 `json:"created_at"`
+	// RetiredAt is the Unix timestamp of when a newer key replaced this one.
+	// Retired keys no longer sign tokens but still verify them until they are dropped.
+	RetiredAt int64 `json:"retired_at,omitempty"`
+}
+
+// newSigningKey generates a key for the given algorithm.
+func newSigningKey(id, alg string, createdAt int64) (*SigningKey, error) {
+	var key any
+	var err error
+	switch alg {
+	case AlgRS256:
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+	case AlgEdDSA:
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, ErrUnsupportedAlgorithm
+	}
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return &SigningKey{
+		ID:         id,
+		Alg:        alg,
+		PrivateKey: der,
+		CreatedAt:  createdAt,
+	}, nil
+}
+
+// Sign returns the signature of data.
+func (k *SigningKey) Sign(data []byte) ([]byte, error) {
+	key, err := x509.ParsePKCS8PrivateKey(k.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		sum := sha256.Sum256(data)
+		return rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+	case ed25519.PrivateKey:
+		return ed25519.Sign(key, data), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %T", key)
+}
+
+// Verify returns true if sig is a valid signature of data.
+func (k *SigningKey) Verify(data, sig []byte) bool {
+	key, err := x509.ParsePKCS8PrivateKey(k.PrivateKey)
+	if err != nil {
+		return false
+	}
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		sum := sha256.Sum256(data)
+		return rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, sum[:], sig) == nil
+	case ed25519.PrivateKey:
+		return ed25519.Verify(key.Public().(ed25519.PublicKey), data, sig)
+	}
+	return false
+}
+
+func (k *SigningKey) algorithm() string {
+	return k.Alg
+}
+
+// JWK returns the public half of the key as a JSON Web Key.
+func (k *SigningKey) JWK() (*JWK, error) {
+	key, err := x509.ParsePKCS8PrivateKey(k.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	jwk := &JWK{
+		Kid: k.ID,
+		Alg: k.Alg,
+		Use: "sig",
+	}
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		jwk.Kty = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(key.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes())
+	case ed25519.PrivateKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(key.Public().(ed25519.PublicKey))
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", key)
+	}
+	return jwk, nil
+}
@@ -0,0 +1,42 @@
+package web
+
+import (
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+)
+
+// SMTPMailSender is a MailSender that delivers through an SMTP server.
+type SMTPMailSender struct {
+	// Addr is the host:port of the SMTP server.
+	Addr string
+	// Username and Password are used for PLAIN authentication.
+	// If Username is empty, no authentication is done.
+	Username string
+	Password string
+	// From is the sender address.
+	From string
+}
+
+// SendMail sends m as a plain text email.
+func (s *SMTPMailSender) SendMail(m *Mail) error {
+	var auth smtp.Auth
+	if s.Username != "" {
+		host, _, err := net.SplitHostPort(s.Addr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+	if strings.ContainsAny(m.To+m.Subject, "\r\n") {
+		return fmt.Errorf("invalid mail header")
+	}
+	msg := "From: " + s.From + "\r\n" +
+		"To: " + m.To + "\r\n" +
+		"Subject: " + m.Subject + "\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"\r\n" +
+		m.Body
+	return smtp.SendMail(s.Addr, auth, s.From, []string{m.To}, []byte(msg))
+}
@@ -0,0 +1,123 @@
+package web
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TOTP parameters, as in RFC 6238.
+// They are the defaults understood by every authenticator app.
+const (
+	totpPeriod = 30
+	totpDigits = 6
+	// totpSkew is the number of periods before and after the current one in which a code is still accepted.
+	totpSkew = 1
+)
+
+// recoveryCodeCount is the number of recovery codes issued when a second factor is enabled.
+const recoveryCodeCount = 10
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// newTOTPSecret returns a new random base32 encoded TOTP secret.
+func newTOTPSecret() string {
+	b := make([]byte, 20)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(err)
+	}
+	return totpEncoding.EncodeToString(b)
+}
+
+// totpStep returns the TOTP time step for the given time.
+func totpStep(t time.Time) int64 {
+	return t.Unix() / totpPeriod
+}
+
+// totpCode returns the code for the given secret and time step.
+func totpCode(secret string, step int64) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	n := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, n%1000000), nil
+}
+
+// matchTOTP returns the time step at which code is valid for the secret,
+// looking up to totpSkew steps either side of now.
+// Steps at or before lastStep are rejected so that a code can only be used once.
+func matchTOTP(secret, code string, now time.Time, lastStep int64) (int64, bool) {
+	step := totpStep(now)
+	for i := step - totpSkew; i <= step+totpSkew; i++ {
+		if i <= lastStep {
+			continue
+		}
+		c, err := totpCode(secret, i)
+		if err != nil {
+			return 0, false
+		}
+		if hmac.Equal([]byte(c), []byte(code)) {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// totpURI returns the otpauth:// URI that authenticator apps use to enroll a secret.
+func totpURI(issuer, account, secret string) string {
+	label := url.PathEscape(account)
+	if issuer != "" {
+		label = url.PathEscape(issuer) + ":" + label
+	}
+	q := url.Values{
+		"secret":    {secret},
+		"algorithm": {"SHA1"},
+		"digits":    {fmt.Sprint(totpDigits)},
+		"period":    {fmt.Sprint(totpPeriod)},
+	}
+	if issuer != "" {
+		q.Set("issuer", issuer)
+	}
+	return "otpauth://totp/" + label + "?" + q.Encode()
+}
+
+// newRecoveryCodes returns a new set of recovery codes and their hashes.
+func newRecoveryCodes() ([]string, []string) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 10)
+		_, err := rand.Read(b)
+		if err != nil {
+			panic(err)
+		}
+		code := strings.ToLower(totpEncoding.EncodeToString(b))
+		codes[i] = code[:8] + "-" + code[8:]
+		hashes[i] = recoveryCodeHash(codes[i])
+	}
+	return codes, hashes
+}
+
+// recoveryCodeHash returns the hash under which a recovery code is stored.
+// Recovery codes are random, so a fast hash is enough.
+func recoveryCodeHash(code string) string {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
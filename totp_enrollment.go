@@ -0,0 +1,9 @@
+package web
+
+// TOTPEnrollment is what a user needs to add their account to an authenticator app.
+type TOTPEnrollment struct {
+	// Secret is the base32 encoded secret, for manual entry.
+	Secret string `json:"secret"`
+	// URI is the otpauth:// URI, usually shown as a QR code.
+	URI string `json:"uri"`
+}
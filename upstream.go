@@ -0,0 +1,82 @@
+package web
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultHealthCheckTimeout is how long a health check waits for an upstream without a Timeout.
+const DefaultHealthCheckTimeout = 5 * time.Second
+
+// Upstream is a backend server that a ProxyRoute passes requests on to.
+type Upstream struct {
+	// URL is the base URL of the backend, such as "http://127.0.0.1:8080".
+	// Request paths are appended to its path.
+	URL string
+	// Timeout is how long the backend has to connect and send the headers of its response.
+	// The body, and WebSocket connections, may take longer. Zero means no limit.
+	Timeout time.Duration
+
+	once      sync.Once
+	target    *url.URL
+	err       error
+	transport *http.Transport
+	// active is the number of requests the backend is serving.
+	active int64
+	// down is set while the backend fails its health checks.
+	down atomic.Bool
+}
+
+// Healthy returns false while the upstream fails its health checks.
+func (u *Upstream) Healthy() bool {
+	return !u.down.Load()
+}
+
+// init parses URL and sets up the transport for the upstream.
+func (u *Upstream) init() error {
+	u.once.Do(func() {
+		u.target, u.err = url.Parse(u.URL)
+		dialer := &net.Dialer{Timeout: u.Timeout}
+		u.transport = &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			DialContext:           dialer.DialContext,
+			ResponseHeaderTimeout: u.Timeout,
+			MaxIdleConnsPerHost:   32,
+			IdleConnTimeout:       90 * time.Second,
+		}
+	})
+	return u.err
+}
+
+// checkHealth requests path from the upstream and marks it down unless it answers with a 2xx or 3xx status.
+func (u *Upstream) checkHealth(ctx context.Context, path string) {
+	if u.init() != nil {
+		u.down.Store(true)
+		return
+	}
+	timeout := u.Timeout
+	if timeout == 0 {
+		timeout = DefaultHealthCheckTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	target := *u.target
+	target.Path = singleJoiningSlash(target.Path, path)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		u.down.Store(true)
+		return
+	}
+	res, err := u.transport.RoundTrip(req)
+	if err != nil {
+		u.down.Store(true)
+		return
+	}
+	res.Body.Close()
+	u.down.Store(res.StatusCode >= 400)
+}
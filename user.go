@@ -1,9 +1,44 @@
 package web
 
+import "encoding/json"
+
 type User struct {
-	PasswordHash  string          `json:"password_hash"`
-	SessionTokens map[string]bool `json:"session_tokens"`
-	Emails        []string        `json:"emails"`
+	PasswordHash string `json:"password_hash"`
+	// SessionTokens are the session tokens of the user.
+	// Deprecated: Use Sessions instead. Tokens found here are moved to Sessions when the user is loaded.
+	SessionTokens map[string]bool `json:"session_tokens,omitempty"`
+	// Sessions are the sessions of the user keyed by session token.
+	Sessions map[string]*SessionInfo `json:"sessions"`
+	Emails   []string                `json:"emails"`
+	// VerifiedEmails are the addresses in Emails that the user has proven to own.
+	VerifiedEmails map[string]bool `json:"verified_emails,omitempty"`
+	// OneTimeTokens are the nonces of the unused tokens mailed to the user, mapped to when they expire.
+	OneTimeTokens map[string]int64 `json:"one_time_tokens,omitempty"`
+	// TOTPSecret is the base32 encoded secret of the user's authenticator app.
+	// It is set on enrollment and only used once TOTPEnabled is true.
+	TOTPSecret string `json:"totp_secret,omitempty"`
+	// TOTPEnabled is true once the user has confirmed enrollment with a valid code.
+	// Logging in then requires a second factor.
+	TOTPEnabled bool `json:"totp_enabled"`
+	// TOTPLastStep is the time step of the last accepted code, so that no code is accepted twice.
+	TOTPLastStep int64 `json:"totp_last_step,omitempty"`
+	// RecoveryCodes are the hashes of the unused recovery codes.
+	// Each one can stand in for a TOTP code once.
+	RecoveryCodes []string `json:"recovery_codes,omitempty"`
+	// APIKeys are the user's API keys keyed by key ID.
+	APIKeys map[string]*APIKey `json:"api_keys,omitempty"`
+	// Passkeys are the WebAuthn credentials the user can log in with, keyed by credential ID.
+	Passkeys map[string]*Passkey `json:"passkeys,omitempty"`
+	// Roles are the roles of the user, such as RoleAdmin or RoleMember.
+	Roles []string `json:"roles"`
+	// Identities are the accounts at identity providers that the user can log in with.
+	Identities []*ExternalIdentity `json:"identities,omitempty"`
+	// Consents are the scopes the user has allowed each OAuth client to see, keyed by client ID.
+	Consents map[string][]string `json:"consents,omitempty"`
+	// Orgs are the IDs of the organizations the user belongs to.
+	Orgs []string `json:"orgs,omitempty"`
+	// Disabled users cannot log in and their sessions and API keys are not accepted.
+	Disabled bool `json:"disabled"`
 }
 
 // PrimaryEmail returns the primary email address of the user.
@@ -11,3 +46,57 @@ type User struct {
 func (u *User) PrimaryEmail() string {
 	return u.Emails[0]
 }
+
+// verifiedEmail returns the user's first verified email address, or "" if there is none.
+func (u *User) verifiedEmail() string {
+	for _, e := range u.Emails {
+		if u.VerifiedEmails[e] {
+			return e
+		}
+	}
+	return ""
+}
+
+// HasRole returns true if the user has the given role.
+// Admins have every role.
+func (u *User) HasRole(role string) bool {
+	for _, r := range u.Roles {
+		if r == role || r == RoleAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// redacted returns a copy of the user without secrets, fit to show to the user.
+func (u *User) redacted() *User {
+	c := u.clone()
+	c.PasswordHash = ""
+	c.Sessions = nil
+	c.TOTPSecret = ""
+	c.TOTPLastStep = 0
+	c.RecoveryCodes = nil
+	c.OneTimeTokens = nil
+	for _, k := range c.APIKeys {
+		k.Hash = ""
+	}
+	return c
+}
+
+// clone returns a deep copy of the user.
+// AuthDB changes a copy and commits it so the live user is only replaced once the change is logged.
+func (u *User) clone() *User {
+	b, err := json.Marshal(u)
+	if err != nil {
+		panic(err)
+	}
+	var c User
+	err = json.Unmarshal(b, &c)
+	if err != nil {
+		panic(err)
+	}
+	if c.Sessions == nil {
+		c.Sessions = map[string]*SessionInfo{}
+	}
+	return &c
+}
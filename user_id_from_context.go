@@ -0,0 +1,18 @@
+package web
+
+import "context"
+
+// userIDKey is the context key for the authenticated user's ID.
+type userIDKey struct{}
+
+// ContextWithUserID returns a copy of ctx carrying the given user ID.
+func ContextWithUserID(ctx context.Context, userID string) context.Context {
+	return context.WithValue(ctx, userIDKey{}, userID)
+}
+
+// UserIDFromContext returns the ID of the authenticated user, as set by AuthMiddleware.
+// It returns "" if the request is not authenticated.
+func UserIDFromContext(ctx context.Context) string {
+	userID, _ := ctx.Value(userIDKey{}).(string)
+	return userID
+}
@@ -0,0 +1,14 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// writeError writes err as a JSON encoded Error with the given status code.
+// Clients can decode the body back into an Error and compare it with the package's Err values.
+func writeError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(NewError(err.Error()))
+}
@@ -0,0 +1,16 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// writeOAuthError writes an OAuth 2.0 error response with the given status and error code.
+// The token and introspection endpoints answer in this form so that standard client libraries understand them.
+func writeOAuthError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{
+		"error": code,
+	})
+}